@@ -0,0 +1,312 @@
+package bidder
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/kchristidis/island/crypto"
+	"github.com/kchristidis/island/stats"
+)
+
+// Behavior is the way a bidder acts on the market. The zero value is the
+// honest bidder; the rest attack the market in one way each, so that we can
+// see how it holds up. Every transaction of an adversarial bidder is reported
+// with its behavior, so that its effects can be told apart.
+type Behavior string
+
+// Supported behaviors.
+const (
+	Honest Behavior = ""
+	// Withhold keeps the key of a bid to itself when, having decrypted the
+	// bids of the others, it sees that the bid cannot be matched. Experiments
+	// 1 and 3 only.
+	Withhold Behavior = "withhold"
+	// WrongKey encrypts its bids with a key pair of its own, so that the key
+	// it posts, or the one the regulator holds, cannot open them.
+	WrongKey Behavior = "wrong-key"
+	// Garbage posts random bytes instead of encrypted bids.
+	Garbage Behavior = "garbage"
+	// Spam posts `SpamBids` copies of every bid it places, so as to cause
+	// contention.
+	Spam Behavior = "spam"
+	// Late bids for a slot once the market has marked its end.
+	Late Behavior = "late"
+	// Replay posts the key of another bidder's bid, after posting its own.
+	// Experiments 1 and 3 only.
+	Replay Behavior = "replay"
+)
+
+// Behaviors lists the adversarial behaviors.
+var Behaviors = []Behavior{Withhold, WrongKey, Garbage, Spam, Late, Replay}
+
+// ParseBehavior returns the behavior with the given name.
+func ParseBehavior(name string) (Behavior, error) {
+	for _, b := range Behaviors {
+		if string(b) == strings.ToLower(name) {
+			return b, nil
+		}
+	}
+	return Honest, fmt.Errorf("unknown bidder behavior: %q", name)
+}
+
+const (
+	// SpamBids is the number of copies that a spamming bidder posts of each bid.
+	SpamBids = 10
+	// LatePolls is the number of times a late bidder checks whether a slot is
+	// marked before it bids anyway.
+	LatePolls = 100
+	// StatusWithheld is the status of a 'postKey' call that was never made.
+	StatusWithheld = "withheld"
+)
+
+// The key pair that bidders of the WrongKey behavior encrypt their bids with
+var (
+	rogueKey     *rsa.PrivateKey
+	rogueKeyErr  error
+	rogueKeyOnce sync.Once
+)
+
+// seal encrypts a bid, the way the bidder's behavior has it.
+func (b *Bidder) seal(bidInputValB []byte) ([]byte, error) {
+	switch b.Behavior {
+	case Garbage:
+		res := make([]byte, b.pubKey.Size())
+		_, err := b.rand.adversary.Read(res)
+		return res, err
+	case WrongKey:
+		rogueKeyOnce.Do(func() {
+			rogueKey, rogueKeyErr = crypto.Generate()
+		})
+		if rogueKeyErr != nil {
+			return nil, rogueKeyErr
+		}
+		return crypto.Encrypt(bidInputValB, &rogueKey.PublicKey)
+	default:
+		return crypto.Encrypt(bidInputValB, b.pubKey)
+	}
+}
+
+// spam posts copies of a bid that went through. The copies are not retried,
+// and their keys are never posted.
+func (b *Bidder) spam(args schema.OpContextInput) {
+	for seq := 1; seq <= SpamBids; seq++ {
+		args.EventID = b.eventID(args.Slot, args.Action, seq, 1)
+
+		timeStart := time.Now()
+		_, txInfo, err := b.Invoker.Invoke(args)
+		elapsed := int64(time.Since(timeStart) / time.Millisecond)
+
+		status := "success"
+		if err != nil {
+			status = err.Error()
+		}
+		b.report(stats.Transaction{
+			ID:              args.EventID,
+			Type:            args.Action,
+			Status:          status,
+			LatencyInMillis: elapsed,
+			Attempt:         1,
+			TxID:            txInfo.ID,
+			BlockNumber:     txInfo.BlockNumber,
+		})
+		b.Logger.Debug(fmt.Sprintf("spammed '%s' bid: %s", args.Action, status), logging.EventID(args.EventID), logging.Slot(args.Slot))
+	}
+}
+
+// awaitMarked blocks until the end of the given slot is marked, so that the
+// bid that follows misses the deadline. It gives up after `LatePolls` checks,
+// or once the context is canceled.
+func (b *Bidder) awaitMarked(ctx context.Context, slot int) {
+	if b.Querier == nil {
+		return
+	}
+	interval := b.BlockDuration
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+
+	for i := 1; i <= LatePolls; i++ {
+		respB, err := b.Querier.Query(schema.OpContextInput{
+			EventID: b.eventID(slot, "marked", 0, i),
+			Action:  "marked",
+			Slot:    slot,
+		})
+		var markedOutputVal schema.MarkedOutput
+		if err == nil && json.Unmarshal(respB, &markedOutputVal) == nil && markedOutputVal.Marked {
+			return
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+	b.Logger.Warn("slot still not marked, bidding anyway", logging.Slot(slot))
+}
+
+// postedBid is a bid that was read off the ledger, and decrypted with the key
+// pair that all agents share in this PoC.
+type postedBid struct {
+	schema.PostedBid
+	Action string
+	Bid    schema.BidInput
+}
+
+// postedBids returns the bids that were posted for the given slot. The ones
+// that cannot be decrypted are skipped.
+func (b *Bidder) postedBids(slot int) ([]postedBid, error) {
+	if b.Querier == nil {
+		return nil, fmt.Errorf("no querier to read the bids with")
+	}
+	respB, err := b.Querier.Query(schema.OpContextInput{
+		EventID: b.eventID(slot, "bids", 0, 1),
+		Action:  "bids",
+		Slot:    slot,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var bidsOutputVal schema.BidsOutput
+	if err := json.Unmarshal(respB, &bidsOutputVal); err != nil {
+		return nil, err
+	}
+
+	var res []postedBid
+	for action, bids := range map[string][]schema.PostedBid{
+		"buy":  bidsOutputVal.Buys,
+		"sell": bidsOutputVal.Sells,
+	} {
+		for _, bid := range bids {
+			bidInputValB, err := crypto.Decrypt(bid.Data, b.privKey)
+			if err != nil {
+				continue
+			}
+			var bidInputVal schema.BidInput
+			if err := json.Unmarshal(bidInputValB, &bidInputVal); err != nil {
+				continue
+			}
+			res = append(res, postedBid{PostedBid: bid, Action: action, Bid: bidInputVal})
+		}
+	}
+	// In a fixed order, so that the draws from the random number generator are reproducible
+	sort.Slice(res, func(i, j int) bool {
+		ki := strings.Join(res[i].WriteKeyAttrs, "") + res[i].BidEventID
+		kj := strings.Join(res[j].WriteKeyAttrs, "") + res[j].BidEventID
+		return ki < kj
+	})
+	return res, nil
+}
+
+// withheld returns the event IDs of the bids, out of the given ones, whose keys
+// a withholding bidder keeps to itself: the buys that no other bidder sells at
+// or below, and the sells that no other bidder buys at or above. If the bids
+// cannot be read, all keys are posted.
+func (b *Bidder) withheld(slot int, bidKeys map[string][]string) map[string]bool {
+	bids, err := b.postedBids(slot)
+	if err != nil {
+		b.Logger.Warn(fmt.Sprintf("cannot read the bids, posting all keys: %s", err), logging.Slot(slot))
+		return nil
+	}
+
+	// Our best price per action, and the best prices of the others
+	own := make(map[string]float64)
+	var bestBuy, bestSell float64 // Highest buy, lowest sell
+	var anyBuy, anySell bool
+	for _, bid := range bids {
+		price := bid.Bid.PricePerUnitInCents
+		if bid.Bid.BidderID == b.ID {
+			if p, ok := own[bid.Action]; !ok || (bid.Action == "buy" && price > p) || (bid.Action == "sell" && price < p) {
+				own[bid.Action] = price
+			}
+			continue
+		}
+		switch {
+		case bid.Action == "buy" && (!anyBuy || price > bestBuy):
+			bestBuy, anyBuy = price, true
+		case bid.Action == "sell" && (!anySell || price < bestSell):
+			bestSell, anySell = price, true
+		}
+	}
+
+	res := make(map[string]bool)
+	for bidEventID, writeKeyAttrs := range bidKeys {
+		action := writeKeyAttrs[2] // <slot>-<action>...
+		price, ok := own[action]
+		if !ok {
+			continue // Not one we can tell apart; post its key
+		}
+		switch {
+		case action == "buy" && !(anySell && bestSell <= price):
+			res[bidEventID] = true
+		case action == "sell" && !(anyBuy && bestBuy >= price):
+			res[bidEventID] = true
+		}
+	}
+	return res
+}
+
+// replay posts the key of a bid that another bidder posted for the given slot,
+// as if it were its own. It is not retried.
+func (b *Bidder) replay(slot int, seq int) {
+	bids, err := b.postedBids(slot)
+	if err != nil {
+		b.Logger.Warn(fmt.Sprintf("cannot read the bids to replay: %s", err), logging.Slot(slot))
+		return
+	}
+	var victims []postedBid
+	for _, bid := range bids {
+		if bid.Bid.BidderID != b.ID {
+			victims = append(victims, bid)
+		}
+	}
+	if len(victims) == 0 {
+		return
+	}
+	victim := victims[b.rand.adversary.Intn(len(victims))]
+
+	// All agents share a key pair, so this is the very payload the victim posts
+	postKeyInputValB, err := json.Marshal(schema.PostKeyInput{
+		ReadKeyAttrs: victim.WriteKeyAttrs,
+		PrivKey:      b.PrivKeyBytes,
+		BidEventID:   victim.BidEventID,
+	})
+	if err != nil {
+		b.Logger.Error(fmt.Sprintf("cannot encode the 'postKey' payload to replay: %s", err), logging.Slot(slot))
+		return
+	}
+	args := schema.OpContextInput{
+		EventID: b.eventID(slot, "postKey", seq, 1),
+		Action:  "postKey",
+		Slot:    slot,
+		Data:    postKeyInputValB,
+	}
+
+	timeStart := time.Now()
+	_, txInfo, err := b.Invoker.Invoke(args)
+	elapsed := int64(time.Since(timeStart) / time.Millisecond)
+
+	status := "success"
+	if err != nil {
+		status = err.Error()
+	}
+	b.report(stats.Transaction{
+		ID:              args.EventID,
+		Type:            "postKey",
+		Status:          status,
+		LatencyInMillis: elapsed,
+		Attempt:         1,
+		TxID:            txInfo.ID,
+		BlockNumber:     txInfo.BlockNumber,
+	})
+	msg := fmt.Sprintf("replayed 'postKey' for bid w/ key attributes %s of bidder %d: %s", victim.WriteKeyAttrs, victim.Bid.BidderID, status)
+	b.Logger.Info(msg, logging.EventID(args.EventID), logging.Slot(slot))
+}
@@ -1,6 +1,8 @@
 package bidder
 
 import (
+	"bytes"
+	"context"
 	"crypto/rsa"
 	"encoding/json"
 	"errors"
@@ -8,37 +10,27 @@ import (
 	"io"
 	"math"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/kchristidis/island/chaincode/logging"
 	"github.com/kchristidis/island/chaincode/schema"
 	"github.com/kchristidis/island/cmap"
 	"github.com/kchristidis/island/crypto"
 	"github.com/kchristidis/island/stats"
-)
-
-// The column names in the trace
-const (
-	Gen = iota
-	Grid
-	Use
-	Lo
-	Hi
+	"github.com/kchristidis/island/trace"
 )
 
 // ToKWh is a multiplier that converts the trace values into KWh units.
-const ToKWh = 0.25
+// The trace carries average power over a slot, so this is the slot
+// duration in hours.
+const ToKWh = float64(schema.SlotDuration) / float64(time.Hour)
 
 // BufferLen sets the buffer length for the slot/task channels, and the
 // bid-keys cmap.
 const BufferLen = 100
 
-// Used for the exponential backoff delay calculations in experiment 1.
-var (
-	s rand.Source
-	r *rand.Rand
-)
-
 // RecentBidKeysKV is a type we create for the channel that will be used
 // to feed the goroutine that updates the RecentBidKeys cmap.
 type RecentBidKeysKV struct {
@@ -52,7 +44,15 @@ type RecentBidKeysKV struct {
 // Invoker is an interface that encapsulates the
 // peer calls that are relevant to the bidder.
 type Invoker interface {
-	Invoke(args schema.OpContextInput) ([]byte, error)
+	Invoke(args schema.OpContextInput) ([]byte, schema.TxInfo, error)
+}
+
+//go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . Querier
+
+// Querier is an interface that encapsulates the
+// ledger queries that are relevant to the bidder.
+type Querier interface {
+	Query(args schema.OpContextInput) ([]byte, error)
 }
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . Notifier
@@ -63,16 +63,76 @@ type Notifier interface {
 	Register(id int, queue chan int) bool
 }
 
+// Rows is an interface that encapsulates the household
+// trace that the bidder reads, one slot at a time.
+type Rows interface {
+	Next() (trace.Row, error)
+}
+
+// Tariff is an interface that encapsulates the grid
+// prices that bound the bidder's bids.
+type Tariff interface {
+	Prices(slot int) (feedIn, retail float64)
+}
+
+// Tracker is an interface that encapsulates the calls with which
+// the bidder reports the work it takes on, and finishes, in
+// response to a slot notification. See the `vclock` package.
+type Tracker interface {
+	Add(delta int)
+	Done()
+}
+
+type nopTracker struct{}
+
+func (nopTracker) Add(int) {}
+func (nopTracker) Done()   {}
+
+// streams holds the random number generators of a bidder. Buys, sells, and
+// key postings are placed concurrently, so each gets a stream of its own.
+// Prices are drawn from streams of their own, so that the bids of a run do
+// not depend on how many retries it takes.
+type streams struct {
+	buyPrice, sellPrice *rand.Rand
+	buy, sell, postKey  *rand.Rand // For backoff delays
+	adversary           *rand.Rand // For the choices of an adversarial bidder; see `Behavior`
+}
+
+// newStreams derives the streams of a bidder from its random number generator.
+func newStreams(rng *rand.Rand) streams {
+	derive := func() *rand.Rand {
+		return rand.New(rand.NewSource(rng.Int63()))
+	}
+	return streams{
+		buyPrice:  derive(),
+		sellPrice: derive(),
+		buy:       derive(),
+		sell:      derive(),
+		postKey:   derive(),
+		adversary: derive(), // Derived last, so that the other streams are the same for every behavior
+	}
+}
+
 // Bidder issues bidding calls to the peer
 // upon receiving slot notifications.
 type Bidder struct {
 	Invoker   Invoker
 	Notifiers []Notifier
+	Tracker   Tracker // Set it when running in virtual time; a no-op by default
+	Querier   Querier // Set it for the behaviors that read the ledger; see `Behavior`
+
+	// Honest by default; set it to have the bidder attack the market
+	Behavior Behavior
 
 	ID           int
-	Trace        [][]float64
+	Rows         Rows
+	Tariff       Tariff // Bids are priced between the tariff's feed-in and retail prices
 	PrivKeyBytes []byte // The bidder's key pair
 
+	// How long a block lasts, for the exponential backoff in experiment 1. Set to
+	// zero to skip the backoff delays, e.g. when running in virtual time.
+	BlockDuration time.Duration
+
 	// Used to feed the stats collector
 	SlotChan        chan stats.Slot
 	TransactionChan chan stats.Transaction
@@ -91,19 +151,30 @@ type Bidder struct {
 	// keys, so that we can associate the posted key with the right bid.
 	RecentBidKeys      *cmap.Container
 	RecentBidKeysQueue chan RecentBidKeysKV
+	// Maps a rowIdx to its trace row. The main thread reads the rows off
+	// the trace as slots come in, and the buy/sell goroutines read them
+	// from this map.
+	RecentRows *cmap.Container
 
-	Writer io.Writer // For logging
+	Logger *logging.Logger
 
-	// An external kill switch. Signals to all threads in this package
-	// that they should return.
-	DoneChan chan struct{}
-	// An internal kill switch. It can only be closed by this package,
-	// and it signals to the package's goroutines that they should exit.
-	killChan chan struct{}
 	// Ensures that the main thread in this package doesn't return
 	// before the goroutines it spawned have.
 	waitGroup *sync.WaitGroup
 
+	// The first slot that the bidder acts on; set by Restore when resuming a run.
+	// The notifications for earlier slots are ignored.
+	FirstSlot int
+	// The types of the bids that were posted for `FirstSlot` before the run was
+	// cut short; see Restore.
+	restoredBids map[string]bool
+
+	// The last slot whose row was read off the trace
+	lastSlot int
+
+	// All of the bidder's randomness comes from these
+	rand streams
+
 	// Handy references to the private and public keys
 	privKey *rsa.PrivateKey
 	pubKey  *rsa.PublicKey
@@ -111,9 +182,9 @@ type Bidder struct {
 
 // New returns a new bidder.
 func New(invoker Invoker, slotBidNotifier Notifier, slotPostKeyNotifier Notifier,
-	id int, privKeyBytes []byte, trace [][]float64,
+	id int, privKeyBytes []byte, rows Rows, tariff Tariff, rng *rand.Rand,
 	slotC chan stats.Slot, transactionC chan stats.Transaction,
-	writer io.Writer, donec chan struct{}) *Bidder {
+	logger *logging.Logger) *Bidder {
 
 	notifiers := []Notifier{slotBidNotifier}
 	// Detecting whether that second notifier is nil is actually tricky.
@@ -142,18 +213,20 @@ func New(invoker Invoker, slotBidNotifier Notifier, slotPostKeyNotifier Notifier
 	}
 
 	cmapKeys, _ := cmap.New(BufferLen)
-
-	s = rand.NewSource(time.Now().UnixNano())
-	r = rand.New(s)
+	cmapRows, _ := cmap.New(BufferLen)
 
 	return &Bidder{
 		Invoker:   invoker,
 		Notifiers: notifiers,
+		Tracker:   nopTracker{},
 
 		ID:           id,
-		Trace:        trace[:schema.TraceLength],
+		Rows:         rows,
+		Tariff:       tariff,
 		PrivKeyBytes: privKeyBytes,
 
+		BlockDuration: schema.BatchTimeout,
+
 		SlotChan:        slotC,
 		TransactionChan: transactionC,
 
@@ -163,11 +236,14 @@ func New(invoker Invoker, slotBidNotifier Notifier, slotPostKeyNotifier Notifier
 		PostKeyQueue:       make(chan int, BufferLen),
 		RecentBidKeys:      cmapKeys,
 		RecentBidKeysQueue: make(chan RecentBidKeysKV, BufferLen),
+		RecentRows:         cmapRows,
+
+		lastSlot: -1,
+
+		rand: newStreams(rng),
 
-		Writer: writer,
+		Logger: logger.With(logging.Component("bidder"), logging.Bidder(id)),
 
-		DoneChan:  donec,
-		killChan:  make(chan struct{}),
 		waitGroup: new(sync.WaitGroup),
 
 		privKey: privKey,
@@ -175,93 +251,86 @@ func New(invoker Invoker, slotBidNotifier Notifier, slotPostKeyNotifier Notifier
 	}
 }
 
-// Run executes the bidder logic.
-func (b *Bidder) Run() error {
-	msg := fmt.Sprintf("bidder:%04d • exited", b.ID)
-	defer fmt.Fprintln(b.Writer, msg)
+// Run executes the bidder logic until the context is canceled, or the
+// bidder is done with its trace. The calls that are in flight are seen
+// through before Run returns; pending retries are abandoned.
+func (b *Bidder) Run(ctx context.Context) error {
+	defer b.Logger.Info("exited")
 
+	// Canceled when Run returns, so that the goroutines spawned here exit. The
+	// calls they make are bound by the caller's context only: a bidder that is
+	// done with its trace still sees its last bids through.
+	loopCtx, cancel := context.WithCancel(ctx)
 	defer func() {
-		close(b.killChan)
+		cancel()
 		b.waitGroup.Wait()
 	}()
 
 	for i := range b.Notifiers {
 		if ok := b.Notifiers[i].Register(b.ID, b.SlotQueues[i]); !ok {
-			msg := fmt.Sprintf("bidder:%04d • cannot register with slot notifier %d", b.ID, i)
-			fmt.Fprintln(b.Writer, msg)
+			msg := fmt.Sprintf("cannot register with slot notifier %d", i)
+			b.Logger.Error(msg)
 			return errors.New(msg)
 		}
 
-		if schema.StagingLevel <= schema.Debug {
-			msg := fmt.Sprintf("bidder:%04d • registered with slot notifier %d", b.ID, i)
-			fmt.Fprintln(b.Writer, msg)
-		}
+		b.Logger.Debug(fmt.Sprintf("registered with slot notifier %d", i))
 	}
 
-	b.waitGroup.Add(1)
-	go func() {
-		defer b.waitGroup.Done()
-		for {
-			select {
-			case <-b.killChan:
-				return
-			case rowIdx := <-b.BuyQueue:
-				b.Buy(rowIdx) // Nobody's consuming the returned error for now - that's OK
-			case <-b.DoneChan:
-				return
-			}
-		}
-	}()
-
-	b.waitGroup.Add(1)
-	go func() {
-		defer b.waitGroup.Done()
-		for {
-			select {
-			case <-b.killChan:
-				return
-			case rowIdx := <-b.SellQueue:
-				b.Sell(rowIdx) // Nobody's consuming the returned error for now - that's OK
-			case <-b.DoneChan:
-				return
-			}
-		}
-	}()
-
-	if len(b.Notifiers) == 2 {
+	// The workers that drain the buy, sell, and postKey queues. Once the bidder
+	// is done with its trace, it closes the queues, and waits for the workers
+	// to see the queued work through; see `finish` below.
+	var workers sync.WaitGroup
+	work := func(queue chan int, fn func(context.Context, int) error) {
+		workers.Add(1)
 		b.waitGroup.Add(1)
 		go func() {
 			defer b.waitGroup.Done()
+			defer workers.Done()
 			for {
 				select {
-				case <-b.killChan:
-					return
-				case rowIdx := <-b.PostKeyQueue:
-					b.PostKey(rowIdx) // Nobody's consuming the returned error for now - that's OK
-				case <-b.DoneChan:
+				case rowIdx, ok := <-queue:
+					if !ok {
+						return
+					}
+					fn(ctx, rowIdx) // Nobody's consuming the returned error for now - that's OK
+					b.Tracker.Done()
+				case <-loopCtx.Done():
 					return
 				}
 			}
 		}()
+	}
+	finish := func() {
+		close(b.BuyQueue)
+		close(b.SellQueue)
+		close(b.PostKeyQueue)
+		workers.Wait()
+	}
+
+	work(b.BuyQueue, b.Buy)
+	work(b.SellQueue, b.Sell)
+
+	if len(b.Notifiers) == 2 {
+		work(b.PostKeyQueue, b.PostKey)
 
 		b.waitGroup.Add(1)
 		go func() {
 			defer b.waitGroup.Done()
 			for {
 				select {
-				case <-b.killChan:
-					return
 				case newVal := <-b.RecentBidKeysQueue:
 					oldVals, ok := b.RecentBidKeys.Get(newVal.Slot)
 					if !ok {
 						b.RecentBidKeys.Put(newVal.Slot, map[string][]string{
 							newVal.BidEventID: newVal.WriteKeyAttrs,
 						})
+						b.Tracker.Done()
 						continue
 					}
 					oldVals.(map[string][]string)[newVal.BidEventID] = newVal.WriteKeyAttrs
 					b.RecentBidKeys.Put(newVal.Slot, oldVals)
-				case <-b.DoneChan:
+					b.Tracker.Done()
+				case <-loopCtx.Done():
 					return
 				}
 			}
@@ -277,41 +346,77 @@ func (b *Bidder) Run() error {
 		case bidSlot := <-b.SlotQueues[0]:
 			rowIdx := int(bidSlot)
 
-			if schema.StagingLevel <= schema.Debug {
-				msg := fmt.Sprintf("bidder:%04d slot:%012d • new slot! processing row %d for bidding: %v", b.ID, bidSlot, rowIdx, b.Trace[rowIdx])
-				fmt.Fprintln(b.Writer, msg)
+			// The slots go on until the market clears the last one of the trace
+			if rowIdx < b.FirstSlot || rowIdx >= schema.TraceLength {
+				b.Tracker.Done() // For the slot notification
+				continue
 			}
 
-			select {
-			case b.BuyQueue <- rowIdx:
-			default:
-				msg := fmt.Sprintf("bidder:%04d slot:%012d • cannot push row to'buy' queue (size: %d)", b.ID, rowIdx, len(b.BuyQueue))
-				fmt.Fprintln(b.Writer, msg)
+			row, err := b.row(rowIdx)
+			if err == io.EOF {
+				msg := "trace ended before this slot! exiting"
+				b.Logger.Info(msg, logging.Slot(rowIdx))
+				finish()
+				return nil
+			}
+			if err != nil {
+				msg := fmt.Sprintf("cannot read trace row: %s", err)
+				b.Logger.Error(msg, logging.Slot(rowIdx))
 				return errors.New(msg)
 			}
+			b.RecentRows.Put(rowIdx, row)
 
-			select {
-			case b.SellQueue <- rowIdx:
-			default:
-				msg := fmt.Sprintf("bidder:%04d slot:%012d • cannot push row to 'sell' queue (size: %d)", b.ID, rowIdx, len(b.BuyQueue))
-				fmt.Fprintln(b.Writer, msg)
-				return errors.New(msg)
+			b.Logger.Debug(fmt.Sprintf("new slot! processing row %d for bidding: %v", rowIdx, row), logging.Slot(rowIdx))
+
+			// A late bidder bids for the slot that the market is clearing
+			bidIdx := rowIdx
+			if b.Behavior == Late {
+				bidIdx--
 			}
 
-			// Return when you're done processing your trace
-			if rowIdx == len(b.Trace)-1 {
-				msg := fmt.Sprintf("bidder:%04d slot:%012d • done processing the trace! exiting", b.ID, rowIdx)
-				fmt.Fprintln(b.Writer, msg)
+			if bidIdx >= b.FirstSlot {
+				b.Tracker.Add(2) // For the buy and the sell
+
+				select {
+				case b.BuyQueue <- bidIdx:
+				default:
+					msg := fmt.Sprintf("cannot push row to'buy' queue (size: %d)", len(b.BuyQueue))
+					b.Logger.Error(msg, logging.Slot(bidIdx))
+					return errors.New(msg)
+				}
+
+				select {
+				case b.SellQueue <- bidIdx:
+				default:
+					msg := fmt.Sprintf("cannot push row to 'sell' queue (size: %d)", len(b.BuyQueue))
+					b.Logger.Error(msg, logging.Slot(bidIdx))
+					return errors.New(msg)
+				}
+			}
+			b.Tracker.Done() // For the slot notification
+
+			// Return when you're done processing your trace. If there is a
+			// 'postKey' phase, that is once the keys for the last slot are posted.
+			if rowIdx == schema.TraceLength-1 && len(b.Notifiers) == 1 {
+				msg := "done processing the trace! exiting"
+				b.Logger.Info(msg, logging.Slot(rowIdx))
+				finish()
 				return nil
 			}
 		case postKeySlot := <-b.SlotQueues[1]:
 			rowIdx := int(postKeySlot)
 
-			if schema.StagingLevel <= schema.Debug {
-				msg := fmt.Sprintf("bidder:%04d slot:%012d • new slot! processing row %d for key posting: %v", b.ID, postKeySlot, rowIdx, b.Trace[rowIdx])
-				fmt.Fprintln(b.Writer, msg)
+			if rowIdx < b.FirstSlot {
+				b.Tracker.Done() // For the slot notification
+				continue
+			}
+
+			if b.Logger.Enabled(logging.DebugLevel) {
+				row, _ := b.RecentRows.Get(rowIdx)
+				b.Logger.Debug(fmt.Sprintf("new slot! processing row %d for key posting: %v", rowIdx, row), logging.Slot(rowIdx))
 			}
 
+			b.Tracker.Add(1)
 			select {
 			// For exp1:
 			// A side-effect of calling for a 'postKey' call like this is that we may ask to
@@ -319,48 +424,90 @@ func (b *Bidder) Run() error {
 			// We could probably mitigate this by tighter synchronization between the buy/sell
 			// goroutines and the postkey one, but this would complicate the code significantly.
 			case b.PostKeyQueue <- rowIdx:
+				b.Tracker.Done() // For the slot notification
 			default:
-				msg := fmt.Sprintf("bidder:%04d slot:%012d • cannot push row to 'postKey' queue (size: %d)", b.ID, rowIdx, len(b.PostKeyQueue))
-				fmt.Fprintln(b.Writer, msg)
+				msg := fmt.Sprintf("cannot push row to 'postKey' queue (size: %d)", len(b.PostKeyQueue))
+				b.Logger.Error(msg, logging.Slot(rowIdx))
 				return errors.New(msg)
 			}
-		case <-b.DoneChan:
+
+			if rowIdx == schema.TraceLength-1 {
+				msg := "done processing the trace! exiting"
+				b.Logger.Info(msg, logging.Slot(rowIdx))
+				finish()
+				return nil
+			}
+		case <-ctx.Done():
 			return nil
 		}
 	}
 }
 
+// row reads the trace up to the given slot, and returns the row for that slot.
+// Slots should be requested in increasing order. It returns io.EOF if the trace
+// ends before the given slot.
+func (b *Bidder) row(slot int) (trace.Row, error) {
+	if slot <= b.lastSlot {
+		return trace.Row{}, fmt.Errorf("the row for slot %d has already been read", slot)
+	}
+
+	var row trace.Row
+	for b.lastSlot < slot {
+		var err error
+		if row, err = b.Rows.Next(); err != nil {
+			return trace.Row{}, err
+		}
+		b.lastSlot++
+	}
+
+	return row, nil
+}
+
 // Buy allows a bidder place a buy offer.
-func (b *Bidder) Buy(rowIdx int) error {
-	eventID := fmt.Sprintf("%013d", rand.Intn(1E12))
-	row := b.Trace[rowIdx]
+func (b *Bidder) Buy(ctx context.Context, rowIdx int) error {
+	eventID := b.eventID(rowIdx, "buy", 0, 1)
+	val, ok := b.RecentRows.Get(rowIdx)
+	if !ok {
+		msg := "no trace row for this slot"
+		b.Logger.Error(msg, logging.EventID(eventID), logging.Slot(rowIdx))
+		return errors.New(msg)
+	}
+	row := val.(trace.Row)
 
-	if row[Use] > 0 {
-		ppu := row[Lo] + (row[Hi]-row[Lo])*(1.0-rand.Float64())
+	if rowIdx == b.FirstSlot && b.restoredBids["buy"] {
+		msg := "'buy' bid posted before the run was resumed, skipping"
+		b.Logger.Info(msg, logging.Slot(rowIdx))
+		return nil
+	}
+
+	if row.Use > 0 {
+		feedIn, retail := b.Tariff.Prices(rowIdx)
+		ppu := feedIn + (retail-feedIn)*(1.0-b.rand.buyPrice.Float64())
 
 		bidInputVal := schema.BidInput{
+			BidderID:            b.ID,
 			PricePerUnitInCents: ppu,
-			QuantityInKWh:       row[Use] * ToKWh,
+			QuantityInKWh:       row.Use * ToKWh,
 		}
 
 		bidInputValB, err := json.Marshal(bidInputVal)
 		if err != nil {
-			msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d • cannot encode 'buy' bid to JSON: %s", b.ID, eventID, rowIdx, err.Error())
-			fmt.Fprintln(b.Writer, msg)
+			msg := fmt.Sprintf("cannot encode 'buy' bid to JSON: %s", err.Error())
+			b.Logger.Error(msg, logging.EventID(eventID), logging.Slot(rowIdx))
 			return errors.New(msg)
 		}
 
-		encBidInputValB, err := crypto.Encrypt(bidInputValB, b.pubKey)
+		encBidInputValB, err := b.seal(bidInputValB)
 		if err != nil {
-			msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d • cannot encrypt 'buy' bid: %s", b.ID, eventID, rowIdx, err)
-			fmt.Fprintln(b.Writer, msg)
+			msg := fmt.Sprintf("cannot encrypt 'buy' bid: %s", err)
+			b.Logger.Error(msg, logging.EventID(eventID), logging.Slot(rowIdx))
 			return errors.New(msg)
 		}
 
 		b.SlotChan <- stats.Slot{
 			Number:    rowIdx,
 			EnergyUse: bidInputVal.QuantityInKWh,
-			PricePaid: row[Hi],
+			PricePaid: retail,
 		}
 
 		args := schema.OpContextInput{
@@ -370,46 +517,66 @@ func (b *Bidder) Buy(rowIdx int) error {
 			Data:    encBidInputValB,
 		}
 
+		if b.Behavior == Late {
+			b.awaitMarked(ctx, rowIdx)
+		}
+
 		var respB []byte
+		var txInfo schema.TxInfo
 		var elapsed int64
 		var attempt int
 		var delayBlocks int
+		var landed *schema.PostedBid // Set if a failed attempt turns out to have been written
 
-		// A side-effect of the way this is written is that the same transaction ID
-		// might be reported multiple times when we collect metrics. That is OK; the
-		// attempt value changes.
+		// Every attempt gets an event ID of its own. The one that was written is
+		// the one that the 'postKey' call refers to.
 		for i := 0; i <= schema.RetryCount; i++ {
+			// An attempt that failed may have been written all the same, e.g. if
+			// its caller timed out; the bid is then kept, and not posted twice
+			if i > 0 {
+				if landed = b.posted(rowIdx, args.Data); landed != nil {
+					break
+				}
+			}
+
 			if schema.ExpNum == 1 {
-				delayBlocks = r.Intn(int(schema.Alpha * math.Exp2(float64(i))))
-				delayTimer := time.NewTimer(time.Duration(delayBlocks) * schema.BatchTimeout)
-				<-delayTimer.C
+				delayBlocks = b.rand.buy.Intn(int(schema.Alpha * math.Exp2(float64(i))))
+				if err := b.backoff(ctx, delayBlocks); err != nil {
+					msg := fmt.Sprintf("giving up on 'buy': %s", err)
+					b.Logger.Warn(msg, logging.EventID(eventID), logging.Slot(rowIdx))
+					return errors.New(msg)
+				}
 			}
 
 			attempt = i + 1
-			msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d attempt:%d blocks_waited:%02d • about to invoke 'buy' for %.6f kWh (%.6f kW) at %.6f ç/kWh", b.ID, eventID, rowIdx, attempt, delayBlocks, row[Use]*ToKWh, row[Use], ppu)
-			fmt.Fprintln(b.Writer, msg)
+			eventID = b.eventID(rowIdx, "buy", 0, attempt)
+			args.EventID = eventID
+			msg := fmt.Sprintf("about to invoke 'buy' for %.6f kWh (%.6f kW) at %.6f ç/kWh", row.Use*ToKWh, row.Use, ppu)
+			b.Logger.Info(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.Attempt(attempt), logging.F("blocks_waited", delayBlocks))
 
 			timeStart := time.Now()
 
-			respB, err = b.Invoker.Invoke(args)
+			respB, txInfo, err = b.Invoker.Invoke(args)
 
 			// Update stats
 			timeEnd := time.Now()
 			elapsed = int64(timeEnd.Sub(timeStart) / time.Millisecond)
 
 			if err != nil {
-				b.TransactionChan <- stats.Transaction{
+				b.report(stats.Transaction{
 					ID:              eventID,
 					Type:            "buy",
 					Status:          err.Error(),
 					LatencyInMillis: elapsed,
 					Attempt:         attempt,
-				}
-				msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d attempt:%d blocks_waited:%02d • failure! cannot invoke 'buy': %s", b.ID, eventID, rowIdx, attempt, delayBlocks, err)
-				fmt.Fprintln(b.Writer, msg)
+				})
+				msg := fmt.Sprintf("failure! cannot invoke 'buy': %s", err)
+				b.Logger.Warn(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.Attempt(attempt), logging.F("blocks_waited", delayBlocks))
 
 				if i == schema.RetryCount {
-					return errors.New(msg)
+					if landed = b.posted(rowIdx, args.Data); landed == nil {
+						return errors.New(msg)
+					}
 				}
 			} else {
 				break
@@ -418,33 +585,49 @@ func (b *Bidder) Buy(rowIdx int) error {
 
 		// Extract the write-key
 		var bidOutputVal schema.BidOutput
-		if err := json.Unmarshal(respB, &bidOutputVal); err != nil {
-			b.TransactionChan <- stats.Transaction{
+		if landed != nil {
+			bidOutputVal.WriteKeyAttrs = landed.WriteKeyAttrs
+			eventID = landed.BidEventID
+			msg := fmt.Sprintf("found 'buy' bid of an earlier attempt at key w/ attributes %s, not posting it again", bidOutputVal.WriteKeyAttrs)
+			b.Logger.Info(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.Attempt(attempt))
+		} else {
+			if err := json.Unmarshal(respB, &bidOutputVal); err != nil {
+				b.report(stats.Transaction{
+					ID:              eventID,
+					Type:            "buy",
+					Status:          err.Error(),
+					LatencyInMillis: elapsed,
+					Attempt:         attempt,
+					TxID:            txInfo.ID,
+					BlockNumber:     txInfo.BlockNumber,
+				})
+				msg := fmt.Sprintf("cannot decode JSON response to 'buy' invocation: %s", err.Error())
+				b.Logger.Error(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.Attempt(attempt), logging.F("blocks_waited", delayBlocks))
+				return errors.New(msg)
+			}
+
+			b.report(stats.Transaction{
 				ID:              eventID,
 				Type:            "buy",
-				Status:          err.Error(),
+				Status:          "success",
 				LatencyInMillis: elapsed,
 				Attempt:         attempt,
-			}
-			msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d attempt:%d blocks_waited:%02d • cannot decode JSON response to 'buy' invocation: %s", b.ID, eventID, rowIdx, attempt, delayBlocks, err.Error())
-			fmt.Fprintln(b.Writer, msg)
-			return errors.New(msg)
-		}
+				TxID:            txInfo.ID,
+				BlockNumber:     txInfo.BlockNumber,
+			})
 
-		b.TransactionChan <- stats.Transaction{
-			ID:              eventID,
-			Type:            "buy",
-			Status:          "success",
-			LatencyInMillis: elapsed,
-			Attempt:         attempt,
+			msg := fmt.Sprintf("success! wrote 'buy' bid to key w/ attributes %s", bidOutputVal.WriteKeyAttrs)
+			b.Logger.Info(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.Attempt(attempt), logging.F("blocks_waited", delayBlocks))
 		}
 
-		msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d attempt:%d blocks_waited:%02d • success! wrote 'buy' bid to key w/ attributes %s", b.ID, eventID, rowIdx, attempt, delayBlocks, bidOutputVal.WriteKeyAttrs)
-		fmt.Fprintln(b.Writer, msg)
+		if b.Behavior == Spam {
+			b.spam(args)
+		}
 
 		// Update the cmap for post-key calls
 		switch schema.ExpNum {
 		case 1, 3:
+			b.Tracker.Add(1)
 			b.RecentBidKeysQueue <- RecentBidKeysKV{
 				Slot:          rowIdx,
 				BidEventID:    eventID,
@@ -458,36 +641,50 @@ func (b *Bidder) Buy(rowIdx int) error {
 }
 
 // Sell allows a bidder to place a sell offer.
-func (b *Bidder) Sell(rowIdx int) error {
-	eventID := fmt.Sprintf("%013d", rand.Intn(1E12))
-	row := b.Trace[rowIdx]
+func (b *Bidder) Sell(ctx context.Context, rowIdx int) error {
+	eventID := b.eventID(rowIdx, "sell", 0, 1)
+	val, ok := b.RecentRows.Get(rowIdx)
+	if !ok {
+		msg := "no trace row for this slot"
+		b.Logger.Error(msg, logging.EventID(eventID), logging.Slot(rowIdx))
+		return errors.New(msg)
+	}
+	row := val.(trace.Row)
+
+	if rowIdx == b.FirstSlot && b.restoredBids["sell"] {
+		msg := "'sell' bid posted before the run was resumed, skipping"
+		b.Logger.Info(msg, logging.Slot(rowIdx))
+		return nil
+	}
 
-	if row[Gen] > 0 {
-		ppu := row[Lo] + (row[Hi]-row[Lo])*(1.0-rand.Float64())
+	if row.Gen > 0 {
+		feedIn, retail := b.Tariff.Prices(rowIdx)
+		ppu := feedIn + (retail-feedIn)*(1.0-b.rand.sellPrice.Float64())
 
 		bidInputVal := schema.BidInput{
+			BidderID:            b.ID,
 			PricePerUnitInCents: ppu,
-			QuantityInKWh:       row[Gen] * ToKWh,
+			QuantityInKWh:       row.Gen * ToKWh,
 		}
 
 		bidInputValB, err := json.Marshal(bidInputVal)
 		if err != nil {
-			msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d • cannot encode 'buy' bid to JSON: %s", b.ID, eventID, rowIdx, err.Error())
-			fmt.Fprintln(b.Writer, msg)
+			msg := fmt.Sprintf("cannot encode 'buy' bid to JSON: %s", err.Error())
+			b.Logger.Error(msg, logging.EventID(eventID), logging.Slot(rowIdx))
 			return errors.New(msg)
 		}
 
-		encBidInputValB, err := crypto.Encrypt(bidInputValB, b.pubKey)
+		encBidInputValB, err := b.seal(bidInputValB)
 		if err != nil {
-			msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d • cannot encrypt 'buy' bid: %s", b.ID, eventID, rowIdx, err)
-			fmt.Fprintln(b.Writer, msg)
+			msg := fmt.Sprintf("cannot encrypt 'buy' bid: %s", err)
+			b.Logger.Error(msg, logging.EventID(eventID), logging.Slot(rowIdx))
 			return errors.New(msg)
 		}
 
 		b.SlotChan <- stats.Slot{
 			Number:    rowIdx,
 			EnergyGen: bidInputVal.QuantityInKWh,
-			PriceSold: row[Lo],
+			PriceSold: feedIn,
 		}
 
 		args := schema.OpContextInput{
@@ -497,42 +694,63 @@ func (b *Bidder) Sell(rowIdx int) error {
 			Data:    encBidInputValB,
 		}
 
+		if b.Behavior == Late {
+			b.awaitMarked(ctx, rowIdx)
+		}
+
 		var respB []byte
+		var txInfo schema.TxInfo
 		var elapsed int64
 		var attempt int
 		var delayBlocks int
+		var landed *schema.PostedBid // Set if a failed attempt turns out to have been written
 
 		for i := 0; i <= schema.RetryCount; i++ {
+			// An attempt that failed may have been written all the same, e.g. if
+			// its caller timed out; the bid is then kept, and not posted twice
+			if i > 0 {
+				if landed = b.posted(rowIdx, args.Data); landed != nil {
+					break
+				}
+			}
+
 			if schema.ExpNum == 1 {
-				delayBlocks = r.Intn(int(schema.Alpha * math.Exp2(float64(i))))
-				delayTimer := time.NewTimer(time.Duration(delayBlocks) * schema.BatchTimeout)
-				<-delayTimer.C
+				delayBlocks = b.rand.sell.Intn(int(schema.Alpha * math.Exp2(float64(i))))
+				if err := b.backoff(ctx, delayBlocks); err != nil {
+					msg := fmt.Sprintf("giving up on 'sell': %s", err)
+					b.Logger.Warn(msg, logging.EventID(eventID), logging.Slot(rowIdx))
+					return errors.New(msg)
+				}
 			}
 
 			attempt = i + 1
-			msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d attempt:%d blocks_waited:%02d • about to invoke 'sell' for %.6f kWh (%.6f kW) at %.6f ç/kWh @ slot %d", b.ID, eventID, rowIdx, attempt, delayBlocks, row[Gen]*ToKWh, row[Gen], ppu, rowIdx)
-			fmt.Fprintln(b.Writer, msg)
+			eventID = b.eventID(rowIdx, "sell", 0, attempt)
+			args.EventID = eventID
+			msg := fmt.Sprintf("about to invoke 'sell' for %.6f kWh (%.6f kW) at %.6f ç/kWh @ slot %d", row.Gen*ToKWh, row.Gen, ppu, rowIdx)
+			b.Logger.Info(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.Attempt(attempt), logging.F("blocks_waited", delayBlocks))
 
 			timeStart := time.Now()
 
-			respB, err = b.Invoker.Invoke(args)
+			respB, txInfo, err = b.Invoker.Invoke(args)
 
 			// Update stats
 			timeEnd := time.Now()
 			elapsed = int64(timeEnd.Sub(timeStart) / time.Millisecond)
 
 			if err != nil {
-				b.TransactionChan <- stats.Transaction{
+				b.report(stats.Transaction{
 					ID:              eventID,
 					Type:            "sell",
 					Status:          err.Error(),
 					LatencyInMillis: elapsed,
 					Attempt:         attempt,
-				}
-				msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d attempt:%d blocks_waited:%02d • failure! cannot invoke 'sell': %s", b.ID, eventID, rowIdx, attempt, delayBlocks, err)
-				fmt.Fprintln(b.Writer, msg)
+				})
+				msg := fmt.Sprintf("failure! cannot invoke 'sell': %s", err)
+				b.Logger.Warn(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.Attempt(attempt), logging.F("blocks_waited", delayBlocks))
 				if i == schema.RetryCount {
-					return errors.New(msg)
+					if landed = b.posted(rowIdx, args.Data); landed == nil {
+						return errors.New(msg)
+					}
 				}
 			} else {
 				break
@@ -541,33 +759,49 @@ func (b *Bidder) Sell(rowIdx int) error {
 
 		// Extract the write-key
 		var bidOutputVal schema.BidOutput
-		if err := json.Unmarshal(respB, &bidOutputVal); err != nil {
-			b.TransactionChan <- stats.Transaction{
+		if landed != nil {
+			bidOutputVal.WriteKeyAttrs = landed.WriteKeyAttrs
+			eventID = landed.BidEventID
+			msg := fmt.Sprintf("found 'sell' bid of an earlier attempt at key w/ attributes %s, not posting it again", bidOutputVal.WriteKeyAttrs)
+			b.Logger.Info(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.Attempt(attempt))
+		} else {
+			if err := json.Unmarshal(respB, &bidOutputVal); err != nil {
+				b.report(stats.Transaction{
+					ID:              eventID,
+					Type:            "sell",
+					Status:          err.Error(),
+					LatencyInMillis: elapsed,
+					Attempt:         attempt,
+					TxID:            txInfo.ID,
+					BlockNumber:     txInfo.BlockNumber,
+				})
+				msg := fmt.Sprintf("cannot decode JSON response to 'sell' invocation: %s", err.Error())
+				b.Logger.Error(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.Attempt(attempt), logging.F("blocks_waited", delayBlocks))
+				return errors.New(msg)
+			}
+
+			b.report(stats.Transaction{
 				ID:              eventID,
 				Type:            "sell",
-				Status:          err.Error(),
+				Status:          "success",
 				LatencyInMillis: elapsed,
 				Attempt:         attempt,
-			}
-			msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d attempt:%d blocks_waited:%02d • cannot decode JSON response to 'sell' invocation: %s", b.ID, eventID, rowIdx, attempt, delayBlocks, err.Error())
-			fmt.Fprintln(b.Writer, msg)
-			return errors.New(msg)
-		}
+				TxID:            txInfo.ID,
+				BlockNumber:     txInfo.BlockNumber,
+			})
 
-		b.TransactionChan <- stats.Transaction{
-			ID:              eventID,
-			Type:            "sell",
-			Status:          "success",
-			LatencyInMillis: elapsed,
-			Attempt:         attempt,
+			msg := fmt.Sprintf("success! wrote 'sell' bid to key w/ attributes %s", bidOutputVal.WriteKeyAttrs)
+			b.Logger.Info(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.Attempt(attempt), logging.F("blocks_waited", delayBlocks))
 		}
 
-		msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d attempt:%d blocks_waited:%02d • success! wrote 'sell' bid to key w/ attributes %s", b.ID, eventID, rowIdx, attempt, delayBlocks, bidOutputVal.WriteKeyAttrs)
-		fmt.Fprintln(b.Writer, msg)
+		if b.Behavior == Spam {
+			b.spam(args)
+		}
 
 		// Update the cmap for post-key calls
 		switch schema.ExpNum {
 		case 1, 3:
+			b.Tracker.Add(1)
 			b.RecentBidKeysQueue <- RecentBidKeysKV{
 				Slot:          rowIdx,
 				BidEventID:    eventID,
@@ -579,40 +813,209 @@ func (b *Bidder) Sell(rowIdx int) error {
 	return nil
 }
 
+// posted returns the bid w/ the given payload, if it was posted for the given
+// slot already: the one that an attempt wrote, although its caller did not hear
+// back from it. In experiments 2 and 3, the bid goes by its tx ID rather than by
+// the event ID of the attempt, as in `Restore`. It returns nil if there is no
+// such bid, or if the bids cannot be read.
+func (b *Bidder) posted(slot int, data []byte) *schema.PostedBid {
+	if b.Querier == nil {
+		return nil
+	}
+
+	eventID := b.eventID(slot, "bids", 0, 1)
+	respB, err := b.Querier.Query(schema.OpContextInput{
+		EventID: eventID,
+		Action:  "bids",
+		Slot:    slot,
+	})
+	if err != nil {
+		msg := fmt.Sprintf("cannot query the bids posted for this slot: %s", err)
+		b.Logger.Warn(msg, logging.EventID(eventID), logging.Slot(slot))
+		return nil
+	}
+	var bidsOutputVal schema.BidsOutput
+	if err := json.Unmarshal(respB, &bidsOutputVal); err != nil {
+		msg := fmt.Sprintf("cannot decode JSON response to 'bids' query: %s", err)
+		b.Logger.Warn(msg, logging.EventID(eventID), logging.Slot(slot))
+		return nil
+	}
+
+	// Every encryption is randomized, so the payload gives away the bid
+	for _, bid := range append(bidsOutputVal.Buys, bidsOutputVal.Sells...) {
+		if !bytes.Equal(bid.Data, data) {
+			continue
+		}
+		if bid.BidEventID == "" {
+			bid.BidEventID = bid.WriteKeyAttrs[len(bid.WriteKeyAttrs)-1]
+		}
+		return &bid
+	}
+	return nil
+}
+
+// Restore prepares the bidder to pick up a run that was cut short, from the
+// given slot on, i.e. the first slot that the market has not cleared. The bids
+// that the bidder posted for that slot are read off the ledger, so that they
+// are not posted twice, and so that their keys are posted in experiments 1 and
+// 3. Call it before Run.
+func (b *Bidder) Restore(querier Querier, slot int) error {
+	b.FirstSlot = slot
+
+	eventID := b.eventID(slot, "bids", 0, 1)
+	args := schema.OpContextInput{
+		EventID: eventID,
+		Action:  "bids",
+		Slot:    slot,
+	}
+	respB, err := querier.Query(args)
+	if err != nil {
+		msg := fmt.Sprintf("cannot query the bids posted for this slot: %s", err)
+		b.Logger.Error(msg, logging.EventID(eventID), logging.Slot(slot))
+		return errors.New(msg)
+	}
+	var bidsOutputVal schema.BidsOutput
+	if err := json.Unmarshal(respB, &bidsOutputVal); err != nil {
+		msg := fmt.Sprintf("cannot decode JSON response to 'bids' query: %s", err)
+		b.Logger.Error(msg, logging.EventID(eventID), logging.Slot(slot))
+		return errors.New(msg)
+	}
+
+	b.restoredBids = make(map[string]bool)
+	for action, bids := range map[string][]schema.PostedBid{
+		"buy":  bidsOutputVal.Buys,
+		"sell": bidsOutputVal.Sells,
+	} {
+		for _, bid := range bids {
+			// All agents share a key pair in this PoC, so we tell our bids apart
+			// by the bidder ID in them.
+			bidInputValB, err := crypto.Decrypt(bid.Data, b.privKey)
+			if err != nil {
+				continue
+			}
+			var bidInputVal schema.BidInput
+			if err := json.Unmarshal(bidInputValB, &bidInputVal); err != nil || bidInputVal.BidderID != b.ID {
+				continue
+			}
+			b.restoredBids[action] = true
+
+			// In experiments 2 and 3, the bid is told apart by its tx ID instead
+			bidEventID := bid.BidEventID
+			if bidEventID == "" {
+				bidEventID = bid.WriteKeyAttrs[len(bid.WriteKeyAttrs)-1]
+			}
+
+			msg := fmt.Sprintf("restored '%s' bid w/ key attributes %s from the ledger", action, bid.WriteKeyAttrs)
+			b.Logger.Info(msg, logging.EventID(bidEventID), logging.Slot(slot))
+
+			switch schema.ExpNum {
+			case 1, 3:
+				vals, ok := b.RecentBidKeys.Get(slot)
+				if !ok {
+					vals = make(map[string][]string)
+				}
+				vals.(map[string][]string)[bidEventID] = bid.WriteKeyAttrs
+				b.RecentBidKeys.Put(slot, vals)
+			default:
+			}
+		}
+	}
+
+	return nil
+}
+
+// backoff waits for the given number of blocks, or until the context is
+// canceled, in which case it returns the context's error.
+func (b *Bidder) backoff(ctx context.Context, blocks int) error {
+	timer := time.NewTimer(time.Duration(blocks) * b.BlockDuration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Agent returns the name of the bidder with the given ID, which is also the
+// identity that it signs its calls with, e.g. `bidder0042`.
+func Agent(id int) string {
+	return fmt.Sprintf("bidder%04d", id)
+}
+
+// eventID returns the ID of the event for an attempt at an action of this
+// bidder's; see schema.EventID.
+func (b *Bidder) eventID(slot int, action string, seq, attempt int) string {
+	return schema.EventID(Agent(b.ID), slot, action, seq, attempt)
+}
+
+// report feeds a transaction to the stats collector, tagged with the bidder's
+// behavior.
+func (b *Bidder) report(tx stats.Transaction) {
+	tx.Behavior = string(b.Behavior)
+	b.TransactionChan <- tx
+}
+
 // PostKey allows a bidder to post the private key corresponding to the
 // public key with which they posted an encrypted bid on the ledger.
-func (b *Bidder) PostKey(rowIdx int) error {
-	if schema.StagingLevel <= schema.Debug {
-		msg := fmt.Sprintf("bidder:%04d slot:%012d • about to invoke 'postKey'", b.ID, rowIdx)
-		fmt.Fprintln(b.Writer, msg)
+func (b *Bidder) PostKey(ctx context.Context, rowIdx int) error {
+	if b.Behavior == Late {
+		return nil // Its bids miss the deadline, so it has no keys to post
 	}
 
+	b.Logger.Debug("about to invoke 'postKey'", logging.Slot(rowIdx))
+
 	valMap, ok := b.RecentBidKeys.Get(rowIdx)
 	if !ok {
-		msg := fmt.Sprintf("bidder:%04d slot:%012d • cannot find any bids to post keys for", b.ID, rowIdx)
-		fmt.Fprintln(b.Writer, msg)
+		msg := "cannot find any bids to post keys for"
+		b.Logger.Warn(msg, logging.Slot(rowIdx))
 
-		b.TransactionChan <- stats.Transaction{
-			ID:              fmt.Sprintf("%013d", rand.Intn(1E12)),
+		b.report(stats.Transaction{
+			ID:              b.eventID(rowIdx, "postKey", 0, 0),
 			Type:            "postKey",
 			Status:          "failure: no_bids",
 			LatencyInMillis: -1, // We give an invalid value here on purpose
 			Attempt:         0,  // As above
-		}
+		})
 
 		return errors.New(msg)
 	}
 
-	if schema.StagingLevel <= schema.Debug {
-		msg := fmt.Sprintf("bidder:%04d slot:%012d • found %d bids to post keys for", b.ID, rowIdx, len(valMap.(map[string][]string)))
-		fmt.Fprintln(b.Writer, msg)
+	b.Logger.Debug(fmt.Sprintf("found %d bids to post keys for", len(valMap.(map[string][]string))), logging.Slot(rowIdx))
+
+	// Go through the bids in a fixed order, so that the draws from the
+	// random number generator are reproducible
+	bidKeys := valMap.(map[string][]string)
+	bidEventIDs := make([]string, 0, len(bidKeys))
+	for k := range bidKeys {
+		bidEventIDs = append(bidEventIDs, k)
+	}
+	sort.Strings(bidEventIDs)
+
+	var withheld map[string]bool
+	if b.Behavior == Withhold {
+		withheld = b.withheld(rowIdx, bidKeys)
 	}
 
 	mapIdx := 0
-	mapLen := len(valMap.(map[string][]string))
-	for k, v := range valMap.(map[string][]string) {
-		eventID := fmt.Sprintf("%013d", rand.Intn(1E12))
+	mapLen := len(bidKeys)
+	for _, k := range bidEventIDs {
+		v := bidKeys[k]
 		mapIdx++
+		eventID := b.eventID(rowIdx, "postKey", mapIdx, 1)
+
+		if withheld[k] {
+			b.report(stats.Transaction{
+				ID:              b.eventID(rowIdx, "postKey", mapIdx, 0),
+				Type:            "postKey",
+				Status:          StatusWithheld,
+				LatencyInMillis: -1, // Nothing was invoked
+				Attempt:         0,  // As above
+			})
+			msg := fmt.Sprintf("withholding the key for bid w/ event_id %s", k)
+			b.Logger.Info(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.F("bid_idx", mapIdx), logging.F("bid_count", mapLen))
+			continue
+		}
 
 		postKeyInputVal := schema.PostKeyInput{
 			ReadKeyAttrs: v,
@@ -622,8 +1025,8 @@ func (b *Bidder) PostKey(rowIdx int) error {
 
 		postKeyInputValB, err := json.Marshal(postKeyInputVal)
 		if err != nil {
-			msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d bid_idx:%d bid_count:%d• cannot encode to JSON the payload for 'postKey' call on bid w/ event_id %s: %s", b.ID, eventID, rowIdx, mapIdx, mapLen, k, err.Error())
-			fmt.Fprintln(b.Writer, msg)
+			msg := fmt.Sprintf("cannot encode to JSON the payload for 'postKey' call on bid w/ event_id %s: %s", k, err.Error())
+			b.Logger.Error(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.F("bid_idx", mapIdx), logging.F("bid_count", mapLen))
 			return errors.New(msg)
 		}
 
@@ -635,39 +1038,45 @@ func (b *Bidder) PostKey(rowIdx int) error {
 		}
 
 		var respB []byte
+		var txInfo schema.TxInfo
 		var elapsed int64
 		var attempt int
 		var delayBlocks int
 
 		for i := 0; i <= schema.RetryCount; i++ {
 			if schema.ExpNum == 1 {
-				delayBlocks = r.Intn(int(schema.Alpha * math.Exp2(float64(i))))
-				delayTimer := time.NewTimer(time.Duration(delayBlocks) * schema.BatchTimeout)
-				<-delayTimer.C
+				delayBlocks = b.rand.postKey.Intn(int(schema.Alpha * math.Exp2(float64(i))))
+				if err := b.backoff(ctx, delayBlocks); err != nil {
+					msg := fmt.Sprintf("giving up on 'postKey': %s", err)
+					b.Logger.Warn(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.F("bid_idx", mapIdx), logging.F("bid_count", mapLen))
+					return errors.New(msg)
+				}
 			}
 
 			attempt = i + 1
-			msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d bid_idx:%d bid_count:%d attempt:%d blocks_waited:%02d • about to 'postKey' for bid w/ event_id %s and key %s", b.ID, eventID, rowIdx, mapIdx, mapLen, attempt, delayBlocks, k, v)
-			fmt.Fprintln(b.Writer, msg)
+			eventID = b.eventID(rowIdx, "postKey", mapIdx, attempt)
+			args.EventID = eventID
+			msg := fmt.Sprintf("about to 'postKey' for bid w/ event_id %s and key %s", k, v)
+			b.Logger.Info(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.F("bid_idx", mapIdx), logging.F("bid_count", mapLen), logging.Attempt(attempt), logging.F("blocks_waited", delayBlocks))
 
 			timeStart := time.Now()
 
-			respB, err = b.Invoker.Invoke(args)
+			respB, txInfo, err = b.Invoker.Invoke(args)
 
 			// Update stats
 			timeEnd := time.Now()
 			elapsed = int64(timeEnd.Sub(timeStart) / time.Millisecond)
 
 			if err != nil {
-				b.TransactionChan <- stats.Transaction{
+				b.report(stats.Transaction{
 					ID:              eventID,
 					Type:            "postKey",
 					Status:          err.Error(),
 					LatencyInMillis: elapsed,
 					Attempt:         attempt,
-				}
-				msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d bid_idx:%d bid_count:%d attempt:%d blocks_waited:%02d • failure! cannot invoke 'postKey' for bid w/ event_id %s: %s", b.ID, eventID, rowIdx, mapIdx, mapLen, attempt, delayBlocks, k, err.Error())
-				fmt.Fprintln(b.Writer, msg)
+				})
+				msg := fmt.Sprintf("failure! cannot invoke 'postKey' for bid w/ event_id %s: %s", k, err.Error())
+				b.Logger.Warn(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.F("bid_idx", mapIdx), logging.F("bid_count", mapLen), logging.Attempt(attempt), logging.F("blocks_waited", delayBlocks))
 
 				if i == schema.RetryCount {
 					return errors.New(msg)
@@ -680,29 +1089,37 @@ func (b *Bidder) PostKey(rowIdx int) error {
 		// Extract the write-key
 		var postKeyOutputVal schema.PostKeyOutput
 		if err := json.Unmarshal(respB, &postKeyOutputVal); err != nil {
-			b.TransactionChan <- stats.Transaction{
+			b.report(stats.Transaction{
 				ID:              eventID,
 				Type:            "postKey",
 				Status:          err.Error(),
 				LatencyInMillis: elapsed,
 				Attempt:         attempt,
-			}
-			msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d bid_idx:%d bid_count:%d attempt:%d blocks_waited:%02d • cannot decode JSON response to 'postKey' invocation for bid w/ event_id %s: %s", b.ID, eventID, rowIdx, mapIdx, mapLen, attempt, delayBlocks, k, err.Error())
-			fmt.Fprintln(b.Writer, msg)
+				TxID:            txInfo.ID,
+				BlockNumber:     txInfo.BlockNumber,
+			})
+			msg := fmt.Sprintf("cannot decode JSON response to 'postKey' invocation for bid w/ event_id %s: %s", k, err.Error())
+			b.Logger.Error(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.F("bid_idx", mapIdx), logging.F("bid_count", mapLen), logging.Attempt(attempt), logging.F("blocks_waited", delayBlocks))
 			return errors.New(msg)
 		}
 
 		// The green path
-		b.TransactionChan <- stats.Transaction{
+		b.report(stats.Transaction{
 			ID:              eventID,
 			Type:            "postKey",
 			Status:          "success",
 			LatencyInMillis: elapsed,
 			Attempt:         attempt,
-		}
+			TxID:            txInfo.ID,
+			BlockNumber:     txInfo.BlockNumber,
+		})
+
+		msg := fmt.Sprintf("success! wrote 'postKey' for bid w/ event_id %s to key w/ attributes %s", k, postKeyOutputVal.WriteKeyAttrs)
+		b.Logger.Info(msg, logging.EventID(eventID), logging.Slot(rowIdx), logging.F("bid_idx", mapIdx), logging.F("bid_count", mapLen), logging.Attempt(attempt), logging.F("blocks_waited", delayBlocks))
+	}
 
-		msg := fmt.Sprintf("bidder:%04d event_id:%s slot:%012d bid_idx:%d bid_count:%d attempt:%d blocks_waited:%02d • success! wrote 'postKey' for bid w/ event_id %s to key w/ attributes %s", b.ID, eventID, rowIdx, mapIdx, mapLen, attempt, delayBlocks, k, postKeyOutputVal.WriteKeyAttrs)
-		fmt.Fprintln(b.Writer, msg)
+	if b.Behavior == Replay {
+		b.replay(rowIdx, mapLen+1)
 	}
 
 	return nil
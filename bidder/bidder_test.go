@@ -1,26 +1,56 @@
 package bidder_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/kchristidis/island/bidder"
 	"github.com/kchristidis/island/bidder/bidderfakes"
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
 	"github.com/kchristidis/island/crypto"
 	"github.com/kchristidis/island/stats"
+	"github.com/kchristidis/island/tariff"
 	"github.com/kchristidis/island/trace"
+	"github.com/kchristidis/island/tracegen"
 	"github.com/onsi/gomega/gbytes"
 	"github.com/stretchr/testify/require"
 
 	. "github.com/onsi/gomega"
 )
 
+func rows(t *testing.T, tr *trace.Trace) trace.Cursor {
+	c, err := tr.Cursor(tr.IDs[0])
+	require.NoError(t, err)
+	return c
+}
+
 func TestBidder(t *testing.T) {
 	g := NewGomegaWithT(t)
 
-	path := filepath.Join("..", "trace", trace.Filename)
-	m, err := trace.Load(path)
+	// Run on a synthetic trace, so that we don't depend on the downloaded one
+	dir, err := ioutil.TempDir("", "bidder")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := tracegen.DefaultConfig()
+	cfg.Households = 1
+	cfg.Slots = schema.TraceLength
+	var buf bytes.Buffer
+	require.NoError(t, tracegen.Generate(cfg, &buf))
+	path := filepath.Join(dir, "trace.csv")
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+
+	tr, err := trace.Load(path)
 	require.NoError(t, err)
 
 	privkeypath := filepath.Join("..", "crypto", "priv.pem")
@@ -40,26 +70,26 @@ func TestBidder(t *testing.T) {
 		slotnotifier1.RegisterReturns(false)
 
 		bfr := gbytes.NewBuffer()
-		donec := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
 
-		b := bidder.New(invoker, slotnotifier0, slotnotifier1, trace.IDs[0], privkeybytes, m[trace.IDs[0]], slotc, transactionc, bfr, donec)
+		b := bidder.New(invoker, slotnotifier0, slotnotifier1, tr.IDs[0], privkeybytes, rows(t, tr), tariff.Trace{Rows: tr.Households[tr.IDs[0]]}, rand.New(rand.NewSource(1)), slotc, transactionc, logging.New(bfr, logging.DebugLevel, logging.Text))
 
 		var err error
 		deadc := make(chan struct{})
 		go func() {
-			err = b.Run()
+			err = b.Run(ctx)
 			close(deadc)
 		}()
 
 		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say("cannot register with slot notifier"))
 		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say("exited"))
 
-		close(donec)
+		cancel()
 		<-deadc
 		g.Expect(err).To(HaveOccurred())
 	})
 
-	t.Run("done chan closes", func(t *testing.T) {
+	t.Run("context is canceled", func(t *testing.T) {
 		invoker := new(bidderfakes.FakeInvoker)
 
 		slotnotifier0 := new(bidderfakes.FakeNotifier)
@@ -68,18 +98,18 @@ func TestBidder(t *testing.T) {
 		slotnotifier1.RegisterReturns(true)
 
 		bfr := gbytes.NewBuffer()
-		donec := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
 
-		b := bidder.New(invoker, slotnotifier0, slotnotifier1, trace.IDs[0], privkeybytes, m[trace.IDs[0]], slotc, transactionc, bfr, donec)
+		b := bidder.New(invoker, slotnotifier0, slotnotifier1, tr.IDs[0], privkeybytes, rows(t, tr), tariff.Trace{Rows: tr.Households[tr.IDs[0]]}, rand.New(rand.NewSource(1)), slotc, transactionc, logging.New(bfr, logging.DebugLevel, logging.Text))
 
 		var err error
 		deadc := make(chan struct{})
 		go func() {
-			err = b.Run()
+			err = b.Run(ctx)
 			close(deadc)
 		}()
 
-		close(donec)
+		cancel()
 		<-deadc
 
 		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say("exited"))
@@ -97,22 +127,299 @@ func TestBidder(t *testing.T) {
 		slot := 1
 
 		bfr := gbytes.NewBuffer()
-		donec := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
 
-		b := bidder.New(invoker, slotnotifier0, slotnotifier1, trace.IDs[0], privkeybytes, m[trace.IDs[0]], slotc, transactionc, bfr, donec)
+		b := bidder.New(invoker, slotnotifier0, slotnotifier1, tr.IDs[0], privkeybytes, rows(t, tr), tariff.Trace{Rows: tr.Households[tr.IDs[0]]}, rand.New(rand.NewSource(1)), slotc, transactionc, logging.New(bfr, logging.DebugLevel, logging.Text))
 
 		deadc := make(chan struct{})
 		go func() {
-			err = b.Run()
+			err = b.Run(ctx)
 			close(deadc)
 		}()
 
-		invoker.InvokeReturns(nil, nil)
+		invoker.InvokeReturns(nil, schema.TxInfo{}, nil)
 		b.SlotQueues[0] <- slot
 
-		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say(fmt.Sprintf("slot:%012d", slot)))
+		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say(fmt.Sprintf("slot=%d ", slot)))
 
-		close(donec)
+		cancel()
 		<-deadc
 	})
+
+	t.Run("returns once done with the trace", func(t *testing.T) {
+		invoker := new(bidderfakes.FakeInvoker)
+		invoker.InvokeReturns([]byte(`{"WriteKeyAttrs":["0","-","buy"]}`), schema.TxInfo{}, nil)
+
+		slotnotifier0 := new(bidderfakes.FakeNotifier)
+		slotnotifier0.RegisterReturns(true)
+		slotnotifier1 := new(bidderfakes.FakeNotifier)
+		slotnotifier1.RegisterReturns(true)
+
+		slotc := make(chan stats.Slot, 10)
+		transactionc := make(chan stats.Transaction, 10)
+		b := bidder.New(invoker, slotnotifier0, slotnotifier1, tr.IDs[0], privkeybytes, rows(t, tr), tariff.Trace{Rows: tr.Households[tr.IDs[0]]}, rand.New(rand.NewSource(1)), slotc, transactionc, logging.Nop())
+		b.BlockDuration = 0
+
+		errc := make(chan error, 1)
+		go func() {
+			errc <- b.Run(context.Background())
+		}()
+
+		last := schema.TraceLength - 1
+		b.SlotQueues[0] <- last
+		wantAction := "buy"
+		if schema.ExpNum != 2 {
+			// The bidder sticks around to post the keys for the last slot
+			g.Consistently(errc, "100ms", "10ms").ShouldNot(Receive())
+			b.SlotQueues[1] <- last
+			wantAction = "postKey"
+		}
+		g.Eventually(errc, "1s", "10ms").Should(Receive(BeNil()))
+
+		// The work that was queued has been seen through
+		var actions []string
+		for i := 0; i < invoker.InvokeCallCount(); i++ {
+			actions = append(actions, invoker.InvokeArgsForCall(i).Action)
+		}
+		g.Expect(actions).To(ContainElement(wantAction))
+	})
+
+	t.Run("same seed places the same bids", func(t *testing.T) {
+		// buy places a buy bid for the first slot, and returns the bid.
+		buy := func(seed int64) schema.BidInput {
+			invoker := new(bidderfakes.FakeInvoker)
+			invoker.InvokeReturns([]byte(`{"WriteKeyAttrs":["0","-","buy"]}`), schema.TxInfo{}, nil)
+
+			b := bidder.New(invoker, new(bidderfakes.FakeNotifier), new(bidderfakes.FakeNotifier), tr.IDs[0], privkeybytes, rows(t, tr), tariff.Trace{Rows: tr.Households[tr.IDs[0]]}, rand.New(rand.NewSource(seed)), slotc, transactionc, logging.Nop())
+			b.BlockDuration = 0
+			b.RecentRows.Put(0, tr.Households[tr.IDs[0]][0])
+			require.NoError(t, b.Buy(context.Background(), 0))
+			<-slotc
+			<-transactionc
+
+			require.Equal(t, 1, invoker.InvokeCallCount())
+			args := invoker.InvokeArgsForCall(0)
+			bidB, err := crypto.Decrypt(args.Data, privkey)
+			require.NoError(t, err)
+			var bid schema.BidInput
+			require.NoError(t, json.Unmarshal(bidB, &bid))
+			return bid
+		}
+
+		bid1, bid2, bid3 := buy(7), buy(7), buy(8)
+		require.Equal(t, bid1, bid2)
+		require.NotEqual(t, bid1.PricePerUnitInCents, bid3.PricePerUnitInCents)
+	})
+
+	t.Run("every attempt gets an event ID of its own", func(t *testing.T) {
+		invoker := new(bidderfakes.FakeInvoker)
+		invoker.InvokeReturnsOnCall(0, nil, schema.TxInfo{}, errors.New("failure: mvcc_read_conflict"))
+		invoker.InvokeReturnsOnCall(1, []byte(`{"WriteKeyAttrs":["0","-","buy"]}`), schema.TxInfo{ID: "abc", BlockNumber: 42}, nil)
+
+		slotc := make(chan stats.Slot, 10)
+		transactionc := make(chan stats.Transaction, 10)
+		b := bidder.New(invoker, new(bidderfakes.FakeNotifier), new(bidderfakes.FakeNotifier), tr.IDs[0], privkeybytes, rows(t, tr), tariff.Trace{Rows: tr.Households[tr.IDs[0]]}, rand.New(rand.NewSource(1)), slotc, transactionc, logging.Nop())
+		b.BlockDuration = 0
+		b.RecentRows.Put(0, tr.Households[tr.IDs[0]][0])
+		require.NoError(t, b.Buy(context.Background(), 0))
+
+		agent := fmt.Sprintf("bidder%04d", tr.IDs[0])
+		require.Equal(t, schema.EventID(agent, 0, "buy", 0, 1), invoker.InvokeArgsForCall(0).EventID)
+		require.Equal(t, schema.EventID(agent, 0, "buy", 0, 2), invoker.InvokeArgsForCall(1).EventID)
+
+		failed, succeeded := <-transactionc, <-transactionc
+		require.Equal(t, invoker.InvokeArgsForCall(0).EventID, failed.ID)
+		require.Empty(t, failed.TxID)
+		require.Equal(t, invoker.InvokeArgsForCall(1).EventID, succeeded.ID)
+		require.Equal(t, "abc", succeeded.TxID)
+		require.EqualValues(t, 42, succeeded.BlockNumber)
+
+		kv := <-b.RecentBidKeysQueue
+		require.Equal(t, succeeded.ID, kv.BidEventID)
+	})
+	t.Run("does not post the bid of a failed attempt again", func(t *testing.T) {
+		invoker := new(bidderfakes.FakeInvoker)
+		invoker.InvokeReturnsOnCall(0, nil, schema.TxInfo{}, errors.New("timeout"))
+
+		// The attempt timed out, but the bid was written all the same
+		querier := new(bidderfakes.FakeQuerier)
+		querier.QueryStub = func(schema.OpContextInput) ([]byte, error) {
+			args := invoker.InvokeArgsForCall(0)
+			bid := schema.PostedBid{WriteKeyAttrs: []string{"0", "-", "buy", "-", args.EventID}, Data: args.Data}
+			if schema.ExpNum != 1 {
+				bid.WriteKeyAttrs[4] = "tx1"
+			} else {
+				bid.BidEventID = args.EventID
+			}
+			return json.Marshal(schema.BidsOutput{Buys: []schema.PostedBid{bid}})
+		}
+
+		slotc := make(chan stats.Slot, 10)
+		transactionc := make(chan stats.Transaction, 10)
+		b := bidder.New(invoker, new(bidderfakes.FakeNotifier), new(bidderfakes.FakeNotifier), tr.IDs[0], privkeybytes, rows(t, tr), tariff.Trace{Rows: tr.Households[tr.IDs[0]]}, rand.New(rand.NewSource(1)), slotc, transactionc, logging.Nop())
+		b.BlockDuration = 0
+		b.Querier = querier
+		b.RecentRows.Put(0, tr.Households[tr.IDs[0]][0])
+		require.NoError(t, b.Buy(context.Background(), 0))
+
+		require.Equal(t, 1, invoker.InvokeCallCount())
+		require.Equal(t, "bids", querier.QueryArgsForCall(0).Action)
+
+		if schema.ExpNum != 2 {
+			kv := <-b.RecentBidKeysQueue
+			if schema.ExpNum == 1 {
+				require.Equal(t, invoker.InvokeArgsForCall(0).EventID, kv.BidEventID)
+			} else {
+				require.Equal(t, "tx1", kv.BidEventID)
+			}
+		}
+	})
+	t.Run("restores its bids from the ledger", func(t *testing.T) {
+		slot := 5
+		encrypt := func(bidderID int) []byte {
+			bidB, err := json.Marshal(schema.BidInput{BidderID: bidderID, PricePerUnitInCents: 10, QuantityInKWh: 1})
+			require.NoError(t, err)
+			encBidB, err := crypto.Encrypt(bidB, &privkey.PublicKey)
+			require.NoError(t, err)
+			return encBidB
+		}
+		bidsOutputValB, err := json.Marshal(schema.BidsOutput{
+			Buys: []schema.PostedBid{
+				{WriteKeyAttrs: []string{"5", "-", "buy", "-", "tx1"}, Data: encrypt(tr.IDs[0])},
+				{WriteKeyAttrs: []string{"5", "-", "buy", "-", "tx2"}, Data: encrypt(tr.IDs[0] + 1)},
+			},
+		})
+		require.NoError(t, err)
+		querier := new(bidderfakes.FakeQuerier)
+		querier.QueryReturns(bidsOutputValB, nil)
+
+		invoker := new(bidderfakes.FakeInvoker)
+		slotc := make(chan stats.Slot, 10)
+		transactionc := make(chan stats.Transaction, 10)
+		b := bidder.New(invoker, new(bidderfakes.FakeNotifier), new(bidderfakes.FakeNotifier), tr.IDs[0], privkeybytes, rows(t, tr), tariff.Trace{Rows: tr.Households[tr.IDs[0]]}, rand.New(rand.NewSource(1)), slotc, transactionc, logging.Nop())
+		require.NoError(t, b.Restore(querier, slot))
+		require.Equal(t, slot, b.FirstSlot)
+		require.Equal(t, "bids", querier.QueryArgsForCall(0).Action)
+
+		// The buy bid is not posted twice
+		row := tr.Households[tr.IDs[0]][slot]
+		row.Use = 1
+		b.RecentRows.Put(slot, row)
+		require.NoError(t, b.Buy(context.Background(), slot))
+		require.Zero(t, invoker.InvokeCallCount())
+
+		if schema.ExpNum != 2 {
+			// Only our bid gets its key posted
+			vals, ok := b.RecentBidKeys.Get(slot)
+			require.True(t, ok)
+			require.Equal(t, map[string][]string{"tx1": {"5", "-", "buy", "-", "tx1"}}, vals)
+		}
+	})
+
+	t.Run("canceled context abandons the backoff", func(t *testing.T) {
+		if schema.ExpNum != 1 {
+			t.Skip("only experiment 1 backs off")
+		}
+
+		invoker := new(bidderfakes.FakeInvoker)
+		slotc := make(chan stats.Slot, 10)
+		transactionc := make(chan stats.Transaction, 10)
+		b := bidder.New(invoker, new(bidderfakes.FakeNotifier), new(bidderfakes.FakeNotifier), tr.IDs[0], privkeybytes, rows(t, tr), tariff.Trace{Rows: tr.Households[tr.IDs[0]]}, rand.New(rand.NewSource(1)), slotc, transactionc, logging.Nop())
+		b.BlockDuration = time.Hour
+		b.RecentRows.Put(0, tr.Households[tr.IDs[0]][0])
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := b.Buy(ctx, 0)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "giving up on 'buy'")
+		require.Zero(t, invoker.InvokeCallCount())
+	})
+
+	t.Run("adversarial bids are reported with their behavior", func(t *testing.T) {
+		buy := func(behavior bidder.Behavior) (*bidderfakes.FakeInvoker, []stats.Transaction) {
+			invoker := new(bidderfakes.FakeInvoker)
+			invoker.InvokeReturns([]byte(`{"WriteKeyAttrs":["0","-","buy"]}`), schema.TxInfo{}, nil)
+			transactionc := make(chan stats.Transaction, bidder.SpamBids+1)
+			b := bidder.New(invoker, new(bidderfakes.FakeNotifier), new(bidderfakes.FakeNotifier), tr.IDs[0], privkeybytes, rows(t, tr), tariff.Trace{Rows: tr.Households[tr.IDs[0]]}, rand.New(rand.NewSource(1)), make(chan stats.Slot, 1), transactionc, logging.Nop())
+			b.BlockDuration = 0
+			b.Behavior = behavior
+			row := tr.Households[tr.IDs[0]][0]
+			row.Use = 1
+			b.RecentRows.Put(0, row)
+			require.NoError(t, b.Buy(context.Background(), 0))
+			close(transactionc)
+			var txs []stats.Transaction
+			for tx := range transactionc {
+				txs = append(txs, tx)
+			}
+			return invoker, txs
+		}
+
+		invoker, txs := buy(bidder.Garbage)
+		require.Equal(t, 1, invoker.InvokeCallCount())
+		_, err := crypto.Decrypt(invoker.InvokeArgsForCall(0).Data, privkey)
+		require.Error(t, err)
+		require.Len(t, txs, 1)
+		require.Equal(t, "garbage", txs[0].Behavior)
+		again, _ := buy(bidder.Garbage) // The garbage is drawn from the seed, too
+		require.Equal(t, invoker.InvokeArgsForCall(0).Data, again.InvokeArgsForCall(0).Data)
+
+		invoker, txs = buy(bidder.Spam)
+		require.Equal(t, 1+bidder.SpamBids, invoker.InvokeCallCount())
+		agent := fmt.Sprintf("bidder%04d", tr.IDs[0])
+		last := invoker.InvokeArgsForCall(bidder.SpamBids)
+		require.Equal(t, schema.EventID(agent, 0, "buy", bidder.SpamBids, 1), last.EventID)
+		require.Equal(t, invoker.InvokeArgsForCall(0).Data, last.Data)
+		require.Len(t, txs, 1+bidder.SpamBids)
+		for _, tx := range txs {
+			require.Equal(t, "spam", tx.Behavior)
+		}
+
+		_, txs = buy(bidder.Honest)
+		require.Empty(t, txs[0].Behavior)
+	})
+
+	t.Run("withholds the keys of bids that cannot be matched", func(t *testing.T) {
+		if schema.ExpNum == 2 {
+			t.Skip("there are no keys to withhold in experiment 2")
+		}
+
+		encrypt := func(bidderID int, price float64) []byte {
+			bidB, err := json.Marshal(schema.BidInput{BidderID: bidderID, PricePerUnitInCents: price, QuantityInKWh: 1})
+			require.NoError(t, err)
+			encBidB, err := crypto.Encrypt(bidB, &privkey.PublicKey)
+			require.NoError(t, err)
+			return encBidB
+		}
+		postKey := func(sellPrice float64) (*bidderfakes.FakeInvoker, stats.Transaction) {
+			bidsOutputValB, err := json.Marshal(schema.BidsOutput{
+				Buys:  []schema.PostedBid{{WriteKeyAttrs: []string{"5", "-", "buy"}, BidEventID: "ours", Data: encrypt(tr.IDs[0], 10)}},
+				Sells: []schema.PostedBid{{WriteKeyAttrs: []string{"5", "-", "sell"}, BidEventID: "theirs", Data: encrypt(tr.IDs[0]+1, sellPrice)}},
+			})
+			require.NoError(t, err)
+			querier := new(bidderfakes.FakeQuerier)
+			querier.QueryReturns(bidsOutputValB, nil)
+			invoker := new(bidderfakes.FakeInvoker)
+			invoker.InvokeReturns([]byte(`{}`), schema.TxInfo{}, nil)
+
+			transactionc := make(chan stats.Transaction, 1)
+			b := bidder.New(invoker, new(bidderfakes.FakeNotifier), new(bidderfakes.FakeNotifier), tr.IDs[0], privkeybytes, rows(t, tr), tariff.Trace{Rows: tr.Households[tr.IDs[0]]}, rand.New(rand.NewSource(1)), slotc, transactionc, logging.Nop())
+			b.BlockDuration = 0
+			b.Behavior = bidder.Withhold
+			b.Querier = querier
+			b.RecentBidKeys.Put(5, map[string][]string{"ours": {"5", "-", "buy"}})
+			require.NoError(t, b.PostKey(context.Background(), 5))
+			return invoker, <-transactionc
+		}
+
+		invoker, tx := postKey(20) // Nobody sells at our price
+		require.Zero(t, invoker.InvokeCallCount())
+		require.Equal(t, bidder.StatusWithheld, tx.Status)
+		require.Equal(t, "withhold", tx.Behavior)
+
+		invoker, tx = postKey(5)
+		require.Equal(t, 1, invoker.InvokeCallCount())
+		require.Equal(t, "success", tx.Status)
+	})
 }
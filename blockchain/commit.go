@@ -0,0 +1,56 @@
+package blockchain
+
+import (
+	"fmt"
+
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel/invoke"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/errors/status"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+)
+
+// commitHandler mirrors the SDK's CommitTxHandler, but also records the
+// number of the block that the transaction was committed in. The SDK's
+// handler drops it.
+type commitHandler struct {
+	blockNumber uint64
+}
+
+// Handle implements the invoke.Handler interface.
+func (h *commitHandler) Handle(requestContext *invoke.RequestContext, clientContext *invoke.ClientContext) {
+	txID := string(requestContext.Response.TransactionID)
+
+	reg, statusc, err := clientContext.EventService.RegisterTxStatusEvent(txID)
+	if err != nil {
+		requestContext.Error = fmt.Errorf("error registering for TxStatus event: %s", err)
+		return
+	}
+	defer clientContext.EventService.Unregister(reg)
+
+	tx, err := clientContext.Transactor.CreateTransaction(fab.TransactionRequest{
+		Proposal:          requestContext.Response.Proposal,
+		ProposalResponses: requestContext.Response.Responses,
+	})
+	if err != nil {
+		requestContext.Error = fmt.Errorf("CreateTransaction failed: %s", err)
+		return
+	}
+	if _, err := clientContext.Transactor.SendTransaction(tx); err != nil {
+		requestContext.Error = fmt.Errorf("SendTransaction failed: %s", err)
+		return
+	}
+
+	select {
+	case txStatus := <-statusc:
+		requestContext.Response.TxValidationCode = txStatus.TxValidationCode
+		if txStatus.TxValidationCode != pb.TxValidationCode_VALID {
+			requestContext.Error = status.New(status.EventServerStatus, int32(txStatus.TxValidationCode),
+				"received invalid transaction", nil)
+			return
+		}
+		h.blockNumber = txStatus.BlockNumber
+	case <-requestContext.Ctx.Done():
+		requestContext.Error = status.New(status.ClientStatus, status.Timeout.ToInt32(),
+			"Execute didn't receive block event", nil)
+	}
+}
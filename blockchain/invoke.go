@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel/invoke"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
 	"github.com/kchristidis/island/chaincode/schema"
 )
@@ -14,11 +15,17 @@ import (
 // InvokeTimeout ...
 const InvokeTimeout = 20 * time.Second
 
-// Invoke ...
-func (sc *SDKContext) Invoke(args schema.OpContextInput) ([]byte, error) {
+// Invoke ... Along with the payload, it returns the ID of the transaction and
+// the number of the block it was committed in. It signs as UserName; see `As`
+// for the calls of an agent.
+func (sc *SDKContext) Invoke(args schema.OpContextInput) ([]byte, schema.TxInfo, error) {
+	return sc.invoke(sc.ChannelClient, args)
+}
+
+func (sc *SDKContext) invoke(ccl *channel.Client, args schema.OpContextInput) ([]byte, schema.TxInfo, error) {
 	argsB, err := json.Marshal(args)
 	if err != nil {
-		return nil, err
+		return nil, schema.TxInfo{}, err
 	}
 
 	var reg fab.Registration
@@ -26,24 +33,36 @@ func (sc *SDKContext) Invoke(args schema.OpContextInput) ([]byte, error) {
 	if schema.EnableEvents {
 		reg, notifier, err = sc.EventClient.RegisterChaincodeEvent(sc.ChaincodeID, args.EventID)
 		if err != nil {
-			return nil, err
+			return nil, schema.TxInfo{}, err
 		}
 		defer sc.EventClient.Unregister(reg)
 	}
 
-	// Create a request (proposal) and send it
-	resp, err := sc.ChannelClient.Execute(channel.Request{
-		ChaincodeID: sc.ChaincodeID,
-		Fcn:         "invoke",
-		Args:        [][]byte{argsB}})
+	// Create a request (proposal) and send it. This is what `Execute` does,
+	// except that we use our own commit handler to get a hold of the block
+	// number.
+	commit := new(commitHandler)
+	resp, err := ccl.InvokeHandler(
+		invoke.NewSelectAndEndorseHandler(
+			invoke.NewEndorsementValidationHandler(
+				invoke.NewSignatureValidationHandler(commit),
+			),
+		),
+		channel.Request{
+			ChaincodeID: sc.ChaincodeID,
+			Fcn:         "invoke",
+			Args:        [][]byte{argsB}})
 	if err != nil {
 		var msg string
-		if strings.Contains(err.Error(), " MVCC_READ_CONFLICT") {
+		switch {
+		case strings.Contains(err.Error(), " MVCC_READ_CONFLICT"):
 			msg = "failure: mvcc_read_conflict"
-		} else {
+		case strings.Contains(err.Error(), " PHANTOM_READ_CONFLICT"):
+			msg = "failure: phantom_read_conflict"
+		default:
 			msg = err.Error()
 		}
-		return nil, fmt.Errorf("%s", msg)
+		return nil, schema.TxInfo{}, fmt.Errorf("%s", msg)
 	}
 
 	if schema.EnableEvents {
@@ -52,9 +71,14 @@ func (sc *SDKContext) Invoke(args schema.OpContextInput) ([]byte, error) {
 		case <-notifier:
 			// fmt.Fprintf(os.Stdout, "received update for event ID %s", ccEvent.EventName)
 		case <-time.After(InvokeTimeout):
-			return nil, fmt.Errorf("event_id:%s • did not hear back on event in time: %s", args.EventID, err.Error())
+			return nil, schema.TxInfo{}, fmt.Errorf("event_id:%s • did not hear back on event in time: %s", args.EventID, err.Error())
 		}
 	}
 
-	return resp.Payload, nil
+	txInfo := schema.TxInfo{
+		ID:          string(resp.TransactionID),
+		BlockNumber: commit.blockNumber,
+	}
+
+	return resp.Payload, txInfo, nil
 }
@@ -8,14 +8,18 @@ import (
 	"github.com/kchristidis/island/chaincode/schema"
 )
 
-// Query ...
+// Query ... It signs as UserName; see `As` for the calls of an agent.
 func (sc *SDKContext) Query(args schema.OpContextInput) ([]byte, error) {
+	return sc.query(sc.ChannelClient, args)
+}
+
+func (sc *SDKContext) query(ccl *channel.Client, args schema.OpContextInput) ([]byte, error) {
 	argsB, err := json.Marshal(args)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := sc.ChannelClient.Query(channel.Request{
+	resp, err := ccl.Query(channel.Request{
 		ChaincodeID: sc.ChaincodeID,
 		Fcn:         "query",
 		Args:        [][]byte{argsB},
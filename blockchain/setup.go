@@ -2,7 +2,7 @@ package blockchain
 
 import (
 	"fmt"
-	"os"
+	"sync"
 
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/event"
@@ -15,7 +15,9 @@ import (
 	packager "github.com/hyperledger/fabric-sdk-go/pkg/fab/ccpackager/gopackager"
 	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
 	"github.com/hyperledger/fabric-sdk-go/third_party/github.com/hyperledger/fabric/common/cauthdsl"
+	"github.com/kchristidis/island/chaincode/logging"
 	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/kchristidis/island/identity"
 )
 
 // SDKContext ...
@@ -34,12 +36,30 @@ type SDKContext struct {
 	ChaincodeGoPath     string
 	ChaincodeSourcePath string
 
+	// The arguments passed to the chaincode's Init method during instantiation.
+	// If nil, we pass `init`.
+	InitArgs [][]byte
+
+	// If set, the channel and the chaincode are taken to exist already, as is
+	// the case when resuming a run; Setup and Install only create the clients.
+	Resume bool
+
+	// If set, every agent signs its calls with an identity of its own, issued
+	// by this issuer; see `As`. Otherwise, all sign as UserName.
+	Identities *identity.Issuer
+
+	Logger *logging.Logger
+
 	SDK *fabsdk.FabricSDK
 
 	RMClient      *resmgmt.Client
+	MSPClient     *mspclient.Client
 	ChannelClient *channel.Client
 	EventClient   *event.Client
 	LedgerClient  *ledger.Client
+
+	mu      sync.Mutex
+	clients map[string]*channel.Client // The channel clients of the agents, by agent
 }
 
 // Setup ...
@@ -49,7 +69,7 @@ func (sc *SDKContext) Setup() error {
 		return fmt.Errorf("Failed to initialize SDK: %s", err)
 	}
 	sc.SDK = sdk
-	fmt.Fprintln(os.Stdout, "SDK initialized")
+	sc.Logger.Info("SDK initialized")
 
 	// The resource management client is responsible for managing channels
 	// (create/update channel).
@@ -59,7 +79,7 @@ func (sc *SDKContext) Setup() error {
 		return fmt.Errorf("Failed to create resource management client: %s", err)
 	}
 	sc.RMClient = rmClient
-	fmt.Fprintln(os.Stdout, "Resource management client created")
+	sc.Logger.Info("Resource management client created")
 
 	// The MSP client allow us to retrieve user information from their
 	// identity, like its signing identity which we will need to save
@@ -68,13 +88,19 @@ func (sc *SDKContext) Setup() error {
 	if err != nil {
 		return fmt.Errorf("Failed to create MSP client: %s", err)
 	}
-	fmt.Fprintln(os.Stdout, "MSP client created")
+	sc.MSPClient = mspClient
+	sc.Logger.Info("MSP client created")
 
 	adminID, err := mspClient.GetSigningIdentity(sc.OrgAdmin)
 	if err != nil {
 		return fmt.Errorf("Failed to get admin signing identity: %s", err)
 	}
-	fmt.Fprintln(os.Stdout, "Admin signing identity created")
+	sc.Logger.Info("Admin signing identity created")
+
+	if sc.Resume {
+		sc.Logger.Info("Setup completed (resuming, channel left as is)")
+		return nil
+	}
 
 	req := resmgmt.SaveChannelRequest{
 		ChannelID:         sc.ChannelID,
@@ -86,7 +112,7 @@ func (sc *SDKContext) Setup() error {
 	if err != nil || txID.TransactionID == "" {
 		return fmt.Errorf("Failed to save channel: %s", err)
 	}
-	fmt.Fprintln(os.Stdout, "Channel created")
+	sc.Logger.Info("Channel created")
 
 	// Make admin user join the previously created channel
 	if err = sc.RMClient.JoinChannel(
@@ -96,20 +122,61 @@ func (sc *SDKContext) Setup() error {
 	); err != nil {
 		return fmt.Errorf("Failed to make admin join channel: %s", err)
 	}
-	fmt.Fprintln(os.Stdout, "Channel joined")
+	sc.Logger.Info("Channel joined")
 
-	fmt.Fprintln(os.Stdout, "Setup completed")
+	sc.Logger.Info("Setup completed")
 
 	return nil
 }
 
 // Install ...
 func (sc *SDKContext) Install() error {
+	if !sc.Resume {
+		if err := sc.instantiate(); err != nil {
+			return err
+		}
+	}
+
+	cc := sc.SDK.ChannelContext(sc.ChannelID, fabsdk.WithUser(sc.UserName))
+	ccl, err := channel.New(cc)
+	if err != nil {
+		return fmt.Errorf("Failed to create channel client: %s", err)
+	}
+	sc.ChannelClient = ccl
+	sc.Logger.Info("Channel client created")
+
+	if schema.EnableEvents {
+		ec, err := event.New(cc)
+		if err != nil {
+			return fmt.Errorf("Failed to create event client: %s", err)
+		}
+		sc.EventClient = ec
+		sc.Logger.Info("Event client created")
+	}
+
+	lc, err := ledger.New(cc)
+	if err != nil {
+		return fmt.Errorf("Failed to create ledger client: %s", err)
+	}
+	sc.LedgerClient = lc
+	sc.Logger.Info("Ledger client created")
+
+	sc.Logger.Info("Chaincode installation & instantiation completed")
+
+	println()
+	println()
+	println()
+
+	return nil
+}
+
+// instantiate installs and instantiates the chaincode.
+func (sc *SDKContext) instantiate() error {
 	pkg, err := packager.NewCCPackage(sc.ChaincodeSourcePath, sc.ChaincodeGoPath)
 	if err != nil {
 		return fmt.Errorf("Failed to create chaincode package: %s", err)
 	}
-	fmt.Fprintln(os.Stdout, "Chaincode package created")
+	sc.Logger.Info("Chaincode package created")
 
 	req := resmgmt.InstallCCRequest{
 		Name:    sc.ChaincodeID,
@@ -121,52 +188,79 @@ func (sc *SDKContext) Install() error {
 	if _, err := sc.RMClient.InstallCC(req, resmgmt.WithRetry(retry.DefaultResMgmtOpts)); err != nil {
 		return fmt.Errorf("Failed to install chaincode: %s", err)
 	}
-	fmt.Fprintln(os.Stdout, "Chaincode installed")
+	sc.Logger.Info("Chaincode installed")
 
 	// Set up chaincode policy
 	pol := cauthdsl.SignedByAnyMember([]string{"clark.example.com"})
 
+	initArgs := sc.InitArgs
+	if initArgs == nil {
+		initArgs = [][]byte{[]byte("init")}
+	}
+
 	resp, err := sc.RMClient.InstantiateCC(sc.ChannelID, resmgmt.InstantiateCCRequest{
 		Name:    sc.ChaincodeID,
 		Path:    sc.ChaincodeGoPath,
 		Version: "0",
-		Args:    [][]byte{[]byte("init")},
+		Args:    initArgs,
 		Policy:  pol,
 	})
 	if err != nil || resp.TransactionID == "" {
 		return fmt.Errorf("Failed to instantiate the chaincode: %s", err)
 	}
-	fmt.Fprintln(os.Stdout, "Chaincode instantiated")
+	sc.Logger.Info("Chaincode instantiated")
 
-	cc := sc.SDK.ChannelContext(sc.ChannelID, fabsdk.WithUser(sc.UserName))
-	ccl, err := channel.New(cc)
-	if err != nil {
-		return fmt.Errorf("Failed to create channel client: %s", err)
+	return nil
+}
+
+// As returns a client of the channel whose calls are all signed by the
+// identity of the given agent, whatever their event IDs say. The client of an
+// agent is created on first use. If the context issues no identities, the
+// client signs as UserName.
+func (sc *SDKContext) As(agent string) (*Client, error) {
+	if sc.Identities == nil {
+		return &Client{sc: sc, ccl: sc.ChannelClient}, nil
 	}
-	sc.ChannelClient = ccl
-	fmt.Fprintln(os.Stdout, "Channel client created")
 
-	if schema.EnableEvents {
-		ec, err := event.New(cc)
-		if err != nil {
-			return fmt.Errorf("Failed to create event client: %s", err)
-		}
-		sc.EventClient = ec
-		fmt.Fprintln(os.Stdout, "Event client created")
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if ccl, ok := sc.clients[agent]; ok {
+		return &Client{sc: sc, ccl: ccl}, nil
 	}
 
-	lc, err := ledger.New(cc)
+	id, err := sc.Identities.Issue(agent)
 	if err != nil {
-		return fmt.Errorf("Failed to create ledger client: %s", err)
+		return nil, fmt.Errorf("Failed to issue identity for %s: %s", agent, err)
+	}
+	si, err := sc.MSPClient.CreateSigningIdentity(msp.WithCert(id.CertPEM), msp.WithPrivateKey(id.KeyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create signing identity for %s: %s", agent, err)
+	}
+	ccl, err := channel.New(sc.SDK.ChannelContext(sc.ChannelID, fabsdk.WithIdentity(si)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create channel client for %s: %s", agent, err)
 	}
-	sc.LedgerClient = lc
-	fmt.Fprintln(os.Stdout, "Ledger client created")
 
-	fmt.Fprintln(os.Stdout, "Chaincode installation & instantiation completed")
+	if sc.clients == nil {
+		sc.clients = make(map[string]*channel.Client)
+	}
+	sc.clients[agent] = ccl
+	return &Client{sc: sc, ccl: ccl}, nil
+}
 
-	println()
-	println()
-	println()
+// Client executes calls against the channel on behalf of an agent; see `As`.
+type Client struct {
+	sc  *SDKContext
+	ccl *channel.Client
+}
 
-	return nil
+// Invoke is as `SDKContext.Invoke`, but signed by the agent of the client.
+func (c *Client) Invoke(args schema.OpContextInput) ([]byte, schema.TxInfo, error) {
+	return c.sc.invoke(c.ccl, args)
+}
+
+// Query is as `SDKContext.Query`, but signed by the agent of the client.
+func (c *Client) Query(args schema.OpContextInput) ([]byte, error) {
+	return c.sc.query(c.ccl, args)
 }
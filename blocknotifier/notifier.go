@@ -1,10 +1,8 @@
 package blocknotifier
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"math/rand"
-	"strconv"
 	"sync"
 	"time"
 
@@ -12,6 +10,7 @@ import (
 	"github.com/hyperledger/fabric-protos-go/common"
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/ledger"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	"github.com/kchristidis/island/chaincode/logging"
 	"github.com/kchristidis/island/chaincode/schema"
 	"github.com/kchristidis/island/stats"
 )
@@ -24,7 +23,7 @@ const BufferLen = 100
 // Invoker is an interface that encapsulates the
 // peer calls that are relevant to the notifier.
 type Invoker interface {
-	Invoke(args schema.OpContextInput) ([]byte, error)
+	Invoke(args schema.OpContextInput) ([]byte, schema.TxInfo, error)
 }
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . Querier
@@ -59,10 +58,8 @@ type Notifier struct {
 	Invoker Invoker
 	Querier Querier
 
-	Writer io.Writer // Used for logging
+	Logger *logging.Logger
 
-	DoneChan  chan struct{}   // An external kill switch. Signals to all threads in this package that they should return.
-	killChan  chan struct{}   // An internal kill switch. It can only be closed by this package, and it signals to the package's goroutines that they should exit.
 	waitGroup *sync.WaitGroup // Ensures that the main thread in this package doesn't return before the goroutines it spawned have.
 }
 
@@ -70,7 +67,7 @@ type Notifier struct {
 func New(blocksperslot int, clockperiod time.Duration, sleepduration time.Duration, startfromblock uint64,
 	blockc chan stats.Block, slotc chan int,
 	invoker Invoker, querier Querier,
-	writer io.Writer, donec chan struct{}) *Notifier {
+	logger *logging.Logger) *Notifier {
 	return &Notifier{
 		BlocksPerSlot:  blocksperslot,
 		ClockPeriod:    clockperiod,
@@ -89,26 +86,22 @@ func New(blocksperslot int, clockperiod time.Duration, sleepduration time.Durati
 		Invoker: invoker,
 		Querier: querier,
 
-		Writer: writer,
+		Logger: logger.With(logging.Component("block-notifier"), logging.F("notifier", startfromblock)),
 
-		DoneChan:  donec,
-		killChan:  make(chan struct{}),
 		waitGroup: new(sync.WaitGroup),
 	}
 }
 
-// Run executes the notifier logic.
-func (n *Notifier) Run() error {
-	defer func() {
-		msg := fmt.Sprintf("block-notifier:%02d • exited", n.StartFromBlock)
-		fmt.Fprintln(n.Writer, msg)
-	}()
+// Run executes the notifier logic until the context is canceled.
+func (n *Notifier) Run(ctx context.Context) error {
+	defer n.Logger.Info("exited")
 
-	msg := fmt.Sprintf("block-notifier:%02d • running", n.StartFromBlock)
-	fmt.Fprintln(n.Writer, msg)
+	n.Logger.Info("running")
 
+	// Canceled when Run returns, so that the goroutines spawned here exit
+	ctx, cancel := context.WithCancel(ctx)
 	defer func() {
-		close(n.killChan)
+		cancel()
 		n.waitGroup.Wait()
 	}()
 
@@ -116,17 +109,17 @@ func (n *Notifier) Run() error {
 	go func() {
 		defer n.waitGroup.Done()
 		ticker := time.NewTicker(n.ClockPeriod)
-		for {
+		agent := fmt.Sprintf("block-notifier%02d", n.StartFromBlock)
+		for tick := 1; ; tick++ {
 			select {
-			case <-n.killChan:
-				return
 			case <-ticker.C:
+				// Clock calls are not tied to a slot, so they are told apart by the tick.
 				args := schema.OpContextInput{
-					EventID: strconv.Itoa(rand.Intn(1E12)),
+					EventID: schema.EventID(agent, 0, "clock", tick, 1),
 					Action:  "clock",
 				}
 				n.Invoker.Invoke(args)
-			case <-n.DoneChan:
+			case <-ctx.Done():
 				return
 			}
 		}
@@ -137,11 +130,9 @@ func (n *Notifier) Run() error {
 		defer n.waitGroup.Done()
 		for {
 			select {
-			case <-n.killChan:
-				return
 			case blockNumber := <-n.BlockNumberChan:
 				n.GetBlock(blockNumber)
-			case <-n.DoneChan:
+			case <-ctx.Done():
 				return
 			}
 		}
@@ -149,13 +140,12 @@ func (n *Notifier) Run() error {
 
 	for {
 		select {
-		case <-n.DoneChan:
+		case <-ctx.Done():
 			return nil
 		default:
 			resp, err := n.Querier.QueryInfo()
 			if err != nil {
-				msg = fmt.Sprintf("block-notifier:%02d • cannot query info: %s", n.StartFromBlock, err.Error())
-				fmt.Fprintln(n.Writer, msg)
+				n.Logger.Error("cannot query info", logging.Err(err))
 				return err
 			}
 
@@ -170,9 +160,12 @@ func (n *Notifier) Run() error {
 					slot := int((n.LargestBlockNumberObserved - int64(n.StartFromBlock)) / int64(n.BlocksPerSlot))
 					if slot > n.LargestSlotNumberTriggered {
 						n.LargestSlotNumberTriggered = slot
-						msg = fmt.Sprintf("block-notifier:%02d block:%012d • new slot! block triggered slot %012d", n.StartFromBlock, n.LargestBlockNumberObserved, n.LargestSlotNumberTriggered)
-						fmt.Fprintln(n.Writer, msg)
-						n.SlotChan <- n.LargestSlotNumberTriggered
+						n.Logger.Info("new slot! block triggered slot", logging.F("block", n.LargestBlockNumberObserved), logging.Slot(slot))
+						select {
+						case n.SlotChan <- n.LargestSlotNumberTriggered:
+						case <-ctx.Done():
+							return nil
+						}
 					}
 				}
 			}
@@ -184,18 +177,15 @@ func (n *Notifier) Run() error {
 
 // GetBlock gets all blocks in the (n.LargestBlockNumberQueried, blockNumber] interval and feeds them to the stats collector.
 func (n *Notifier) GetBlock(blockNumber int64) {
-	var msg string
 	for i := n.LargestBlockNumberQueried + 1; i <= blockNumber; i++ {
 		block, err := n.Querier.QueryBlock(uint64(i))
 		if err != nil {
-			msg = fmt.Sprintf("block-notifier:%02d block:%012d • cannot query block: %s", n.StartFromBlock, i, err.Error())
-			fmt.Fprintln(n.Writer, msg)
+			n.Logger.Error("cannot query block", logging.F("block", i), logging.Err(err))
 			continue
 		}
 		blockB, err := proto.Marshal(block)
 		if err != nil {
-			msg = fmt.Sprintf("block-notifier:%02d • cannot marshal block %d: %s", n.StartFromBlock, i, err.Error())
-			fmt.Fprintln(n.Writer, msg)
+			n.Logger.Error("cannot marshal block", logging.F("block", i), logging.Err(err))
 			continue
 		}
 		blockStat := stats.Block{
@@ -204,10 +194,7 @@ func (n *Notifier) GetBlock(blockNumber int64) {
 		}
 		select {
 		case n.BlockChan <- blockStat:
-			if schema.StagingLevel <= schema.Debug {
-				msg = fmt.Sprintf("block-notifier:%02d block:%012d • pushed block to the stats collector (chlen:%d)", n.StartFromBlock, i, len(n.BlockChan))
-				fmt.Fprintln(n.Writer, msg)
-			}
+			n.Logger.Debug(fmt.Sprintf("pushed block to the stats collector (chlen:%d)", len(n.BlockChan)), logging.F("block", i))
 		default:
 		}
 	}
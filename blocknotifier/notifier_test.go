@@ -1,6 +1,7 @@
 package blocknotifier_test
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -9,6 +10,8 @@ import (
 	"github.com/hyperledger/fabric-protos-go/common"
 	"github.com/kchristidis/island/blocknotifier"
 	"github.com/kchristidis/island/blocknotifier/blocknotifierfakes"
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
 	"github.com/kchristidis/island/stats"
 	"github.com/onsi/gomega/gbytes"
 
@@ -22,10 +25,11 @@ func TestNotifier(t *testing.T) {
 	slotc := make(chan int)
 
 	invoker := new(blocknotifierfakes.FakeInvoker)
-	invoker.InvokeReturns(nil, nil)
+	invoker.InvokeReturns(nil, schema.TxInfo{}, nil)
 	querier := new(blocknotifierfakes.FakeQuerier)
 	querier.QueryBlockReturns(new(common.Block), nil)
 	bfr := gbytes.NewBuffer()
+	logger := logging.New(bfr, logging.DebugLevel, logging.Text)
 
 	resp := new(fab.BlockchainInfoResponse)
 	resp.BCI = new(common.BlockchainInfo)
@@ -36,9 +40,9 @@ func TestNotifier(t *testing.T) {
 	startfromblock := uint64(10)
 
 	t.Run("early block received", func(t *testing.T) {
-		donec := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
 
-		n := blocknotifier.New(blocksperslot, clockperiod, sleepduration, startfromblock, blockc, slotc, invoker, querier, bfr, donec)
+		n := blocknotifier.New(blocksperslot, clockperiod, sleepduration, startfromblock, blockc, slotc, invoker, querier, logger)
 
 		resp.BCI.Height = n.StartFromBlock // ATTN: This corresponds to BlocnkNumber = n.StartFromBlock - 1
 		querier.QueryInfoReturns(resp, nil)
@@ -46,7 +50,7 @@ func TestNotifier(t *testing.T) {
 		var err error
 		deadc := make(chan struct{})
 		go func() {
-			err = n.Run()
+			err = n.Run(ctx)
 			close(deadc)
 		}()
 
@@ -59,7 +63,7 @@ func TestNotifier(t *testing.T) {
 			}
 		}, "1s", "50ms").Should(Equal(-1))
 
-		close(donec)
+		cancel()
 		<-deadc
 
 		g.Expect(err).ToNot(HaveOccurred())
@@ -67,9 +71,9 @@ func TestNotifier(t *testing.T) {
 	})
 
 	t.Run("start block received", func(t *testing.T) {
-		donec := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
 
-		n := blocknotifier.New(blocksperslot, clockperiod, sleepduration, startfromblock, blockc, slotc, invoker, querier, bfr, donec)
+		n := blocknotifier.New(blocksperslot, clockperiod, sleepduration, startfromblock, blockc, slotc, invoker, querier, logger)
 
 		resp.BCI.Height = n.StartFromBlock + 1
 		querier.QueryInfoReturns(resp, nil)
@@ -77,7 +81,7 @@ func TestNotifier(t *testing.T) {
 		var err error
 		deadc := make(chan struct{})
 		go func() {
-			err = n.Run()
+			err = n.Run(ctx)
 			close(deadc)
 		}()
 
@@ -90,7 +94,7 @@ func TestNotifier(t *testing.T) {
 			}
 		}, "1s", "50ms").Should(Equal(0))
 
-		close(donec)
+		cancel()
 		<-deadc
 
 		g.Expect(err).ToNot(HaveOccurred())
@@ -98,30 +102,30 @@ func TestNotifier(t *testing.T) {
 	})
 
 	t.Run("query fails", func(t *testing.T) {
-		donec := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
 
-		n := blocknotifier.New(blocksperslot, clockperiod, sleepduration, startfromblock, blockc, slotc, invoker, querier, bfr, donec)
+		n := blocknotifier.New(blocksperslot, clockperiod, sleepduration, startfromblock, blockc, slotc, invoker, querier, logger)
 
 		querier.QueryInfoReturns(nil, errors.New("foo"))
 
 		var err error
 		deadc := make(chan struct{})
 		go func() {
-			err = n.Run()
+			err = n.Run(ctx)
 			close(deadc)
 		}()
 
 		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say("cannot query"))
 
-		close(donec)
+		cancel()
 		<-deadc
 		g.Expect(err).To(HaveOccurred())
 	})
 
 	t.Run("non-period block received", func(t *testing.T) {
-		donec := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
 
-		n := blocknotifier.New(blocksperslot, clockperiod, sleepduration, startfromblock, blockc, slotc, invoker, querier, bfr, donec)
+		n := blocknotifier.New(blocksperslot, clockperiod, sleepduration, startfromblock, blockc, slotc, invoker, querier, logger)
 
 		resp.BCI.Height = n.StartFromBlock + 2*uint64(blocksperslot)
 		querier.QueryInfoReturns(resp, nil)
@@ -129,7 +133,7 @@ func TestNotifier(t *testing.T) {
 		var err error
 		deadc := make(chan struct{})
 		go func() {
-			err = n.Run()
+			err = n.Run(ctx)
 			close(deadc)
 		}()
 
@@ -142,7 +146,7 @@ func TestNotifier(t *testing.T) {
 			}
 		}, "1s", "50ms").Should(Equal(1))
 
-		close(donec)
+		cancel()
 		<-deadc
 
 		g.Expect(err).ToNot(HaveOccurred())
@@ -150,9 +154,9 @@ func TestNotifier(t *testing.T) {
 	})
 
 	t.Run("period block received", func(t *testing.T) {
-		donec := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
 
-		n := blocknotifier.New(blocksperslot, clockperiod, sleepduration, startfromblock, blockc, slotc, invoker, querier, bfr, donec)
+		n := blocknotifier.New(blocksperslot, clockperiod, sleepduration, startfromblock, blockc, slotc, invoker, querier, logger)
 
 		resp.BCI.Height = n.StartFromBlock + uint64(blocksperslot) + 1
 		querier.QueryInfoReturns(resp, nil)
@@ -160,7 +164,7 @@ func TestNotifier(t *testing.T) {
 		var err error
 		deadc := make(chan struct{})
 		go func() {
-			err = n.Run()
+			err = n.Run(ctx)
 			close(deadc)
 		}()
 
@@ -173,7 +177,7 @@ func TestNotifier(t *testing.T) {
 			}
 		}, "1s", "50ms").Should(Equal(1))
 
-		close(donec)
+		cancel()
 		<-deadc
 
 		g.Expect(err).ToNot(HaveOccurred())
@@ -1,7 +1,6 @@
-package main
+package contract
 
 import (
-	"fmt"
 	"strconv"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
@@ -9,7 +8,7 @@ import (
 	"github.com/kchristidis/island/chaincode/schema"
 )
 
-// - Looks up read-key <slot_number>-<markend> and returns error if read-key is found
+// - Looks up partial read-key <slot_number>-<markend> and returns error if read-key is found
 // - Experiment 1:
 //		a. If write-key <slot_number>-<action> is not found, creates map[string][]byte
 //			as value for that write-key, and persists encrypted bid (encrypted JSON
@@ -20,14 +19,16 @@ import (
 // - Experiments 2, 3:
 //		a. Creates write-key <slot_number>-<action>-<tx_id> for experiments 2, 3
 //		b. Persists encrypted bid (encrypted JSON `BidInput` object) to write-key
+// - Records the transaction that posted the bid; see `putSubmission`
+// - Experiments 1, 3: Locks a deposit for the bid, if bids lock deposits; see `lockDeposit`
 func (oc *opContext) bid() pp.Response {
-	valB, err := oc.Get([]string{strconv.Itoa(oc.args.Slot), "-", "markEnd"})
+	marked, err := oc.marked()
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	if valB != nil {
-		msg := fmt.Sprintf("tx_id:%s event_id:%s slot:%012d action:%s • slot marked already, aborting 'bid' 🛑", oc.txID, oc.args.EventID, oc.args.Slot, oc.args.Action)
-		fmt.Fprintln(w, msg)
+	if marked {
+		msg := "slot marked already, aborting 'bid' 🛑"
+		oc.log.Warn(msg)
 		metricsOutputVal.LateTXsCount[oc.args.Slot]++
 		switch oc.args.Action {
 		case "buy":
@@ -35,7 +36,7 @@ func (oc *opContext) bid() pp.Response {
 		case "sell":
 			metricsOutputVal.LateSellsCount[oc.args.Slot]++
 		}
-		return shim.Error(msg)
+		return shim.Error(oc.describe(msg))
 	}
 
 	// The slot has not been marked
@@ -43,7 +44,19 @@ func (oc *opContext) bid() pp.Response {
 
 	var keyAttrs []string
 
-	switch schema.ExpNum {
+	// The ID that the bid goes by in the bid collections
+	bidID := oc.txID
+	if expNum == 1 {
+		bidID = oc.args.EventID
+	}
+	if err := oc.putSubmission(bidID); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := oc.lockDeposit(bidID); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	switch expNum {
 	case 1:
 		// Does the key to which we wish to write exist already?
 		keyAttrs = []string{strconv.Itoa(oc.args.Slot), "-", oc.args.Action}
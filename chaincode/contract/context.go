@@ -1,10 +1,11 @@
-package main
+package contract
 
 import (
 	"encoding/json"
 	"errors"
 	"fmt"
 
+	"github.com/kchristidis/island/chaincode/logging"
 	"github.com/kchristidis/island/chaincode/schema"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
@@ -17,6 +18,10 @@ type opContext struct {
 	fn   string // Basically: "invoke" or "query"
 
 	args schema.OpContextInput
+	log  *logging.Logger // Carries the op-context as fields
+
+	entries  int                             // The statement entries that this op has written
+	accounts map[string]schema.BalanceOutput // The running balances that this op has read or written; see `funds`
 }
 
 func newOpContext(stub shim.ChaincodeStubInterface) (*opContext, error) {
@@ -25,7 +30,7 @@ func newOpContext(stub shim.ChaincodeStubInterface) (*opContext, error) {
 	var OpContextInputVal schema.OpContextInput
 	if err := json.Unmarshal(args[1], &OpContextInputVal); err != nil {
 		msg := fmt.Sprintf("cannot decode JSON op-context: %s", err.Error())
-		fmt.Fprintln(w, msg)
+		logger.Error(msg, logging.TxID(stub.GetTxID()))
 		return nil, errors.New(msg)
 	}
 
@@ -34,14 +39,19 @@ func newOpContext(stub shim.ChaincodeStubInterface) (*opContext, error) {
 		txID: stub.GetTxID(),
 		fn:   string(args[0]),
 		args: OpContextInputVal,
-	}
 
-	var msg string
+		accounts: make(map[string]schema.BalanceOutput),
+	}
+	oc.log = logger.With(
+		logging.TxID(oc.txID),
+		logging.EventID(oc.args.EventID),
+		logging.Slot(oc.args.Slot),
+		logging.Action(oc.args.Action),
+	)
 
 	switch oc.args.Action {
 	case "buy", "sell", "postKey", "markEnd":
-		msg = fmt.Sprintf("tx_id:%s event_id:%s slot:%012d action:%s • incoming action!", oc.txID, oc.args.EventID, oc.args.Slot, oc.args.Action)
-		fmt.Fprintln(w, msg)
+		oc.log.Info("incoming action!")
 	default:
 		// Do not error on other actions, as they may correspond to valid queries.
 	}
@@ -56,8 +66,14 @@ func (oc *opContext) run() pp.Response {
 	case "query":
 		return oc.query()
 	default:
-		msg := fmt.Sprintf("tx_id:%s event_id:%s slot:%012d action:%s • invalid function: %s", oc.txID, oc.args.EventID, oc.args.Slot, oc.args.Action, oc.fn)
-		fmt.Fprintln(w, msg)
-		return shim.Error(msg)
+		msg := fmt.Sprintf("invalid function: %s", oc.fn)
+		oc.log.Error(msg)
+		return shim.Error(oc.describe(msg))
 	}
 }
+
+// describe prefixes the message with the op-context, so that the errors that
+// the contract returns can be traced back to the transaction that caused them.
+func (oc *opContext) describe(msg string) string {
+	return fmt.Sprintf("tx_id:%s event_id:%s slot:%012d action:%s • %s", oc.txID, oc.args.EventID, oc.args.Slot, oc.args.Action, msg)
+}
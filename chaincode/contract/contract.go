@@ -0,0 +1,133 @@
+// Package contract implements the chaincode logic of the market. The chaincode
+// binary in the parent directory hands it to the peer; the simulator can also
+// run it in-process, see the `memledger` package.
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pp "github.com/hyperledger/fabric/protos/peer"
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
+)
+
+// SetLogger sets the logger that the contract logs to. It defaults to JSON
+// entries at the info level on stdout.
+func SetLogger(l *logging.Logger) {
+	logger = l
+}
+
+// Contract satisfies the shim.Chaincode interface
+// so that it is a valid Fabric chaincode.
+type Contract struct{}
+
+// Init carries initialization logic for the chaincode.
+// It is automatically invoked during chaincode instantiation.
+// - If a JSON-encoded `schema.InitInput` is passed as the second argument:
+//		a. If it carries a network topology, persists the topology to write-key
+//			<topology>
+//		b. If it carries deposit rules, persists JSON-encoded `schema.DepositRules`
+//			to write-key <deposits>
+//		c. If it carries payment rules, persists JSON-encoded `schema.PaymentRules`
+//			to write-key <payments>; the utility defaults to the identity that
+//			instantiates the chaincode
+//		d. If it carries bid rules, persists JSON-encoded `schema.BidRules` to
+//			write-key <bidrules>; otherwise, the rules in const.go apply
+func (c *Contract) Init(stub shim.ChaincodeStubInterface) pp.Response {
+	args := stub.GetArgs()
+	if len(args) < 2 {
+		return shim.Success(nil)
+	}
+
+	var initInputVal schema.InitInput
+	if err := json.Unmarshal(args[1], &initInputVal); err != nil {
+		msg := fmt.Sprintf("cannot decode JSON init input: %s", err.Error())
+		logger.Error(msg, logging.TxID(stub.GetTxID()))
+		return shim.Error(fmt.Sprintf("tx_id:%s • %s", stub.GetTxID(), msg))
+	}
+
+	if initInputVal.Topology != nil {
+		if err := putState(stub, schema.TopologyKey, args[1]); err != nil {
+			return shim.Error(err.Error())
+		}
+		msg := fmt.Sprintf("persisted network topology w/ %d feeders", len(initInputVal.Topology.Feeders))
+		logger.Info(msg, logging.TxID(stub.GetTxID()))
+	}
+
+	if d := initInputVal.Deposits; d != nil {
+		if d.AmountInCents < 0 || d.OpeningBalanceInCents < 0 {
+			msg := "deposit rules cannot carry negative amounts"
+			logger.Error(msg, logging.TxID(stub.GetTxID()))
+			return shim.Error(fmt.Sprintf("tx_id:%s • %s", stub.GetTxID(), msg))
+		}
+		depositRulesB, err := json.Marshal(d)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := putState(stub, schema.DepositsKey, depositRulesB); err != nil {
+			return shim.Error(err.Error())
+		}
+		msg := fmt.Sprintf("persisted deposit rules: %.3f ç per bid, %.3f ç opening balance", d.AmountInCents, d.OpeningBalanceInCents)
+		logger.Info(msg, logging.TxID(stub.GetTxID()))
+	}
+
+	if p := initInputVal.Payments; p != nil {
+		if p.Utility == "" {
+			id, err := creatorOf(stub)
+			if err != nil {
+				logger.Error(err.Error(), logging.TxID(stub.GetTxID()))
+				return shim.Error(fmt.Sprintf("tx_id:%s • %s", stub.GetTxID(), err.Error()))
+			}
+			p.Utility = id
+		}
+		paymentRulesB, err := json.Marshal(p)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := putState(stub, schema.PaymentsKey, paymentRulesB); err != nil {
+			return shim.Error(err.Error())
+		}
+		msg := fmt.Sprintf("persisted payment rules: the grid settles against account %s", p.Utility)
+		logger.Info(msg, logging.TxID(stub.GetTxID()))
+	}
+
+	if r := initInputVal.BidRules; r != nil {
+		if r.MinPricePerUnitInCents < 0 || r.MaxPricePerUnitInCents < 0 || r.MaxQuantityInKWh < 0 || r.MaxBidsPerIdentity < 0 || r.TickSizeInCents < 0 {
+			msg := "bid rules cannot carry negative values"
+			logger.Error(msg, logging.TxID(stub.GetTxID()))
+			return shim.Error(fmt.Sprintf("tx_id:%s • %s", stub.GetTxID(), msg))
+		}
+		bidRulesB, err := json.Marshal(r)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := putState(stub, schema.BidRulesKey, bidRulesB); err != nil {
+			return shim.Error(err.Error())
+		}
+		msg := fmt.Sprintf("persisted bid rules: prices in [%.3f, %.3f] ç/kWh, at most %d bids and %.3f kWh per household", r.MinPricePerUnitInCents, r.MaxPricePerUnitInCents, r.MaxBidsPerIdentity, r.MaxQuantityInKWh)
+		logger.Info(msg, logging.TxID(stub.GetTxID()))
+	}
+
+	return shim.Success(nil)
+}
+
+// putState persists the value to the composite key that consists of the given attribute.
+func putState(stub shim.ChaincodeStubInterface, attr string, valB []byte) error {
+	key, err := stub.CreateCompositeKey("", []string{attr})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, valB)
+}
+
+// Invoke is used whenever we wish to interact with the chaincode.
+func (c *Contract) Invoke(stub shim.ChaincodeStubInterface) pp.Response {
+	op, err := newOpContext(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return op.run()
+}
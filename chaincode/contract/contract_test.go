@@ -0,0 +1,691 @@
+package contract
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pp "github.com/hyperledger/fabric/protos/peer"
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/kchristidis/island/identity"
+	"github.com/stretchr/testify/require"
+)
+
+// The rules in effect before any test overrides them.
+var defaultRules = rules
+
+// harness drives the contract through a mock stub for a given experiment.
+type harness struct {
+	t       *testing.T
+	stub    *shim.MockStub
+	privKey *rsa.PrivateKey
+	txCount int
+
+	issuer *identity.Issuer
+	caller string // The identity that signs the calls; see `as`
+
+	gridPrices *schema.GridPrices // Passed to `markEnd`, if set
+
+	reads [][]string // The attributes of the keys, or key ranges, that the calls read
+}
+
+func newHarness(t *testing.T, exp int) *harness {
+	privKey, err := LoadPrivate(filepath.Join("..", "..", "crypto", Private))
+	require.NoError(t, err)
+	issuer, err := identity.NewIssuer("clark.example.com")
+	require.NoError(t, err)
+
+	// Reset the package state
+	expNum = exp
+	rules = defaultRules
+	metricsOutputVal = schema.MetricsOutput{}
+	logger = logging.Nop()
+
+	h := &harness{
+		t:       t,
+		privKey: privKey,
+		issuer:  issuer,
+		caller:  "admin",
+	}
+	h.stub = shim.NewMockStub(fmt.Sprintf("exp%d", exp), signedContract{h})
+	return h
+}
+
+// as has the calls that follow signed by the given identity.
+func (h *harness) as(caller string) *harness {
+	h.caller = caller
+	return h
+}
+
+// signedContract hands the contract a stub that reports the caller of the
+// harness as the creator of the transaction; a `shim.MockStub` reports none.
+type signedContract struct {
+	h *harness
+}
+
+func (c signedContract) Init(stub shim.ChaincodeStubInterface) pp.Response {
+	return new(Contract).Init(signedStub{stub, c.h, c.h.creator()})
+}
+
+func (c signedContract) Invoke(stub shim.ChaincodeStubInterface) pp.Response {
+	return new(Contract).Invoke(signedStub{stub, c.h, c.h.creator()})
+}
+
+// signedStub also records what the contract reads; see `harness.reads`.
+type signedStub struct {
+	shim.ChaincodeStubInterface
+	h       *harness
+	creator []byte
+}
+
+func (s signedStub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s signedStub) GetState(key string) ([]byte, error) {
+	_, keyAttrs, err := s.SplitCompositeKey(key)
+	require.NoError(s.h.t, err)
+	s.h.reads = append(s.h.reads, keyAttrs)
+	return s.ChaincodeStubInterface.GetState(key)
+}
+
+func (s signedStub) GetStateByPartialCompositeKey(objectType string, keyAttrs []string) (shim.StateQueryIteratorInterface, error) {
+	s.h.reads = append(s.h.reads, keyAttrs)
+	return s.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, keyAttrs)
+}
+
+// creator returns the serialized identity of the caller.
+func (h *harness) creator() []byte {
+	id, err := h.issuer.Issue(h.caller)
+	require.NoError(h.t, err)
+	creatorB, err := proto.Marshal(&msp.SerializedIdentity{Mspid: id.MSPID, IdBytes: id.CertPEM})
+	require.NoError(h.t, err)
+	return creatorB
+}
+
+func (h *harness) call(fn string, args schema.OpContextInput) pp.Response {
+	argsB, err := json.Marshal(args)
+	require.NoError(h.t, err)
+	return h.raw(fn, argsB)
+}
+
+func (h *harness) raw(fn string, argsB []byte) pp.Response {
+	h.txCount++
+	return h.stub.MockInvoke(fmt.Sprintf("tx%04d", h.txCount), [][]byte{[]byte(fn), argsB})
+}
+
+func (h *harness) txID() string {
+	return fmt.Sprintf("tx%04d", h.txCount)
+}
+
+func (h *harness) encrypt(val interface{}) []byte {
+	valB, err := json.Marshal(val)
+	require.NoError(h.t, err)
+	encValB, err := Encrypt(valB, &h.privKey.PublicKey)
+	require.NoError(h.t, err)
+	return encValB
+}
+
+// bid posts a bid and returns the attributes of the key it was written to.
+func (h *harness) bid(action string, slot int, eventID string, data []byte) []string {
+	resp := h.call("invoke", schema.OpContextInput{
+		EventID: eventID,
+		Action:  action,
+		Slot:    slot,
+		Data:    data,
+	})
+	require.Equal(h.t, int32(shim.OK), resp.Status, resp.Message)
+
+	var bidOutputVal schema.BidOutput
+	require.NoError(h.t, json.Unmarshal(resp.Payload, &bidOutputVal))
+	return bidOutputVal.WriteKeyAttrs
+}
+
+func (h *harness) postKey(slot int, bidEventID string, readKeyAttrs []string) pp.Response {
+	postKeyInputValB, err := json.Marshal(schema.PostKeyInput{
+		ReadKeyAttrs: readKeyAttrs,
+		PrivKey:      SerializePrivate(h.privKey),
+		BidEventID:   bidEventID,
+	})
+	require.NoError(h.t, err)
+	return h.call("invoke", schema.OpContextInput{
+		EventID: "pk-" + bidEventID,
+		Action:  "postKey",
+		Slot:    slot,
+		Data:    postKeyInputValB,
+	})
+}
+
+func (h *harness) markEnd(slot int) schema.MarkEndOutput {
+	args := schema.OpContextInput{
+		EventID: "me-" + strconv.Itoa(slot),
+		Action:  "markEnd",
+		Slot:    slot,
+	}
+	if expNum == 2 || h.gridPrices != nil {
+		markEndInputVal := schema.MarkEndInput{GridPrices: h.gridPrices}
+		if expNum == 2 {
+			markEndInputVal.PrivKey = SerializePrivate(h.privKey)
+		}
+		markEndInputValB, err := json.Marshal(markEndInputVal)
+		require.NoError(h.t, err)
+		args.Data = markEndInputValB
+	}
+
+	resp := h.call("invoke", args)
+	require.Equal(h.t, int32(shim.OK), resp.Status, resp.Message)
+
+	var markEndOutputVal schema.MarkEndOutput
+	require.NoError(h.t, json.Unmarshal(resp.Payload, &markEndOutputVal))
+	return markEndOutputVal
+}
+
+func (h *harness) metrics() schema.MetricsOutput {
+	resp := h.call("query", schema.OpContextInput{EventID: "metrics", Action: "metrics"})
+	require.Equal(h.t, int32(shim.OK), resp.Status, resp.Message)
+
+	var metricsOutputVal schema.MetricsOutput
+	require.NoError(h.t, json.Unmarshal(resp.Payload, &metricsOutputVal))
+	return metricsOutputVal
+}
+
+func (h *harness) marked(slot int) bool {
+	resp := h.call("query", schema.OpContextInput{EventID: "marked", Action: "marked", Slot: slot})
+	require.Equal(h.t, int32(shim.OK), resp.Status, resp.Message)
+
+	var markedOutputVal schema.MarkedOutput
+	require.NoError(h.t, json.Unmarshal(resp.Payload, &markedOutputVal))
+	require.Equal(h.t, slot, markedOutputVal.Slot)
+	return markedOutputVal.Marked
+}
+
+func (h *harness) bids(slot int) schema.BidsOutput {
+	resp := h.call("query", schema.OpContextInput{EventID: "bids", Action: "bids", Slot: slot})
+	require.Equal(h.t, int32(shim.OK), resp.Status, resp.Message)
+
+	var bidsOutputVal schema.BidsOutput
+	require.NoError(h.t, json.Unmarshal(resp.Payload, &bidsOutputVal))
+	return bidsOutputVal
+}
+
+// reveal posts the keys for the given bids in the experiments that need them.
+func (h *harness) reveal(slot int, bids map[string][]string) {
+	if expNum == 2 {
+		return
+	}
+	for bidEventID, writeKeyAttrs := range bids {
+		resp := h.postKey(slot, bidEventID, writeKeyAttrs)
+		require.Equal(h.t, int32(shim.OK), resp.Status, resp.Message)
+	}
+}
+
+func TestContract(t *testing.T) {
+	slot := 3
+
+	for _, exp := range []int{1, 2, 3} {
+		t.Run(fmt.Sprintf("exp%d", exp), func(t *testing.T) {
+			t.Run("happy path", func(t *testing.T) {
+				h := newHarness(t, exp)
+
+				bids := map[string][]string{
+					"b1": h.as("bidder0001").bid("buy", slot, "b1", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2})),
+					"s1": h.as("bidder0003").bid("sell", slot, "s1", h.encrypt(schema.BidInput{BidderID: 3, PricePerUnitInCents: 6, QuantityInKWh: 1.5})),
+				}
+				h.reveal(slot, bids)
+
+				markEndOutputVal := h.markEnd(slot)
+				require.Equal(t, slot, markEndOutputVal.Slot)
+				require.Equal(t, 8.0, markEndOutputVal.PricePerUnitInCents)
+				require.Equal(t, 1.5, markEndOutputVal.QuantityInKWh)
+				require.Len(t, markEndOutputVal.BuyerFills, 1)
+				require.Equal(t, 1.5, markEndOutputVal.BuyerFills[0].FilledInKWh)
+				require.Len(t, markEndOutputVal.SellerFills, 1)
+				require.Equal(t, 1.5, markEndOutputVal.SellerFills[0].FilledInKWh)
+
+				metricsOutputVal := h.metrics()
+				require.Equal(t, schema.MetricsOutput{}, metricsOutputVal)
+			})
+
+			t.Run("no market", func(t *testing.T) {
+				h := newHarness(t, exp)
+
+				bids := map[string][]string{
+					"b1": h.as("bidder0001").bid("buy", slot, "b1", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2})),
+				}
+				h.reveal(slot, bids)
+
+				markEndOutputVal := h.markEnd(slot)
+				require.Equal(t, 0.0, markEndOutputVal.QuantityInKWh)
+				require.Contains(t, markEndOutputVal.Message, "no market")
+			})
+
+			t.Run("late bid", func(t *testing.T) {
+				h := newHarness(t, exp)
+
+				h.markEnd(slot)
+
+				resp := h.call("invoke", schema.OpContextInput{
+					EventID: "b1",
+					Action:  "buy",
+					Slot:    slot,
+					Data:    h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2}),
+				})
+				require.Equal(t, int32(shim.ERROR), resp.Status)
+				require.Contains(t, resp.Message, "slot marked already")
+
+				resp = h.call("invoke", schema.OpContextInput{
+					EventID: "s1",
+					Action:  "sell",
+					Slot:    slot,
+					Data:    h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2}),
+				})
+				require.Equal(t, int32(shim.ERROR), resp.Status)
+
+				resp = h.postKey(slot, "b1", []string{strconv.Itoa(slot), "-", "buy"})
+				require.Equal(t, int32(shim.ERROR), resp.Status)
+
+				metricsOutputVal := h.metrics()
+				require.Equal(t, 3, metricsOutputVal.LateTXsCount[slot])
+				require.Equal(t, 1, metricsOutputVal.LateBuysCount[slot])
+				require.Equal(t, 1, metricsOutputVal.LateSellsCount[slot])
+				require.Equal(t, 1, metricsOutputVal.LateDecryptsCount[slot])
+			})
+
+			t.Run("corrupted ciphertext", func(t *testing.T) {
+				h := newHarness(t, exp)
+
+				bids := map[string][]string{
+					"b1": h.as("bidder0001").bid("buy", slot, "b1", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2})),
+					"b2": h.bid("buy", slot, "b2", []byte("garbage")),
+					"s1": h.as("bidder0003").bid("sell", slot, "s1", h.encrypt(schema.BidInput{BidderID: 3, PricePerUnitInCents: 6, QuantityInKWh: 1})),
+				}
+				h.reveal(slot, bids)
+
+				markEndOutputVal := h.markEnd(slot)
+				require.Equal(t, 1.0, markEndOutputVal.QuantityInKWh)
+				require.Len(t, markEndOutputVal.BuyerFills, 1)
+
+				metricsOutputVal := h.metrics()
+				require.Equal(t, 1, metricsOutputVal.ProblematicDecryptCount[slot])
+			})
+
+			t.Run("bid that is not JSON", func(t *testing.T) {
+				h := newHarness(t, exp)
+
+				encValB, err := Encrypt([]byte("not json"), &h.privKey.PublicKey)
+				require.NoError(t, err)
+
+				bids := map[string][]string{
+					"b1": h.bid("buy", slot, "b1", encValB),
+					"s1": h.as("bidder0003").bid("sell", slot, "s1", h.encrypt(schema.BidInput{BidderID: 3, PricePerUnitInCents: 6, QuantityInKWh: 1})),
+				}
+				h.reveal(slot, bids)
+
+				markEndOutputVal := h.markEnd(slot)
+				require.Contains(t, markEndOutputVal.Message, "no market")
+
+				metricsOutputVal := h.metrics()
+				require.Equal(t, 1, metricsOutputVal.ProblematicDecryptCount[slot])
+				require.Equal(t, 1, metricsOutputVal.ProblematicMarshalCount[slot])
+			})
+
+			t.Run("resume queries", func(t *testing.T) {
+				h := newHarness(t, exp)
+
+				buyB := h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2})
+				bids := map[string][]string{
+					"b1": h.bid("buy", slot, "b1", buyB),
+				}
+				h.reveal(slot, bids)
+
+				bidsOutputVal := h.bids(slot)
+				require.Len(t, bidsOutputVal.Buys, 1) // The posted key is not a bid
+				require.Empty(t, bidsOutputVal.Sells)
+				require.Equal(t, bids["b1"], bidsOutputVal.Buys[0].WriteKeyAttrs)
+				require.Equal(t, buyB, bidsOutputVal.Buys[0].Data)
+				if exp == 1 {
+					require.Equal(t, "b1", bidsOutputVal.Buys[0].BidEventID)
+				}
+
+				require.False(t, h.marked(slot))
+				h.markEnd(slot)
+				require.True(t, h.marked(slot))
+				require.False(t, h.marked(slot+1))
+			})
+		})
+	}
+
+	// Experiment 2 does not have a 'postKey' phase, so the tests below only
+	// apply to experiments 1 and 3.
+
+	for _, exp := range []int{1, 3} {
+		t.Run(fmt.Sprintf("exp%d", exp), func(t *testing.T) {
+			t.Run("missing key", func(t *testing.T) {
+				h := newHarness(t, exp)
+
+				bids := map[string][]string{
+					"b1": h.as("bidder0001").bid("buy", slot, "b1", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2})),
+					"s1": h.as("bidder0003").bid("sell", slot, "s1", h.encrypt(schema.BidInput{BidderID: 3, PricePerUnitInCents: 6, QuantityInKWh: 1})),
+				}
+				h.as("bidder0002").bid("buy", slot, "b2", h.encrypt(schema.BidInput{BidderID: 2, PricePerUnitInCents: 12, QuantityInKWh: 1}))
+				h.reveal(slot, bids) // ATTN: No key for b2
+
+				markEndOutputVal := h.markEnd(slot)
+				require.Equal(t, 1.0, markEndOutputVal.QuantityInKWh)
+				require.Len(t, markEndOutputVal.BuyerFills, 1)
+				require.Equal(t, 8.0, markEndOutputVal.PricePerUnitInCents)
+
+				metricsOutputVal := h.metrics()
+				require.Equal(t, 1, metricsOutputVal.ProblematicDecryptCount[slot])
+			})
+
+			t.Run("wrong key", func(t *testing.T) {
+				h := newHarness(t, exp)
+
+				writeKeyAttrs := h.as("bidder0001").bid("buy", slot, "b1", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2}))
+
+				postKeyInputValB, err := json.Marshal(schema.PostKeyInput{
+					ReadKeyAttrs: writeKeyAttrs,
+					PrivKey:      []byte("not a key"),
+					BidEventID:   "b1",
+				})
+				require.NoError(t, err)
+				resp := h.call("invoke", schema.OpContextInput{
+					EventID: "pk-b1",
+					Action:  "postKey",
+					Slot:    slot,
+					Data:    postKeyInputValB,
+				})
+				require.Equal(t, int32(shim.OK), resp.Status, resp.Message)
+
+				h.markEnd(slot)
+
+				metricsOutputVal := h.metrics()
+				require.Equal(t, 1, metricsOutputVal.ProblematicDecryptCount[slot])
+			})
+
+			t.Run("postKey w/ bad JSON", func(t *testing.T) {
+				h := newHarness(t, exp)
+
+				resp := h.call("invoke", schema.OpContextInput{
+					EventID: "pk-b1",
+					Action:  "postKey",
+					Slot:    slot,
+					Data:    []byte("{"),
+				})
+				require.Equal(t, int32(shim.ERROR), resp.Status)
+			})
+		})
+	}
+
+	t.Run("exp2", func(t *testing.T) {
+		t.Run("markEnd w/ bad key", func(t *testing.T) {
+			h := newHarness(t, 2)
+
+			markEndInputValB, err := json.Marshal(schema.MarkEndInput{PrivKey: []byte("not a key")})
+			require.NoError(t, err)
+			resp := h.call("invoke", schema.OpContextInput{
+				EventID: "me",
+				Action:  "markEnd",
+				Slot:    slot,
+				Data:    markEndInputValB,
+			})
+			require.Equal(t, int32(shim.ERROR), resp.Status)
+
+			metricsOutputVal := h.metrics()
+			require.Equal(t, 1, metricsOutputVal.ProblematicDecryptCount[slot])
+		})
+
+		t.Run("markEnd w/ bad JSON", func(t *testing.T) {
+			h := newHarness(t, 2)
+
+			resp := h.call("invoke", schema.OpContextInput{
+				EventID: "me",
+				Action:  "markEnd",
+				Slot:    slot,
+				Data:    []byte("{"),
+			})
+			require.Equal(t, int32(shim.ERROR), resp.Status)
+
+			metricsOutputVal := h.metrics()
+			require.Equal(t, 1, metricsOutputVal.ProblematicMarshalCount[slot])
+		})
+	})
+}
+
+func TestInvoke(t *testing.T) {
+	t.Run("op-context is not JSON", func(t *testing.T) {
+		h := newHarness(t, 1)
+		resp := h.raw("invoke", []byte("{"))
+		require.Equal(t, int32(shim.ERROR), resp.Status)
+		require.Contains(t, resp.Message, "cannot decode JSON op-context")
+	})
+
+	t.Run("invalid function", func(t *testing.T) {
+		h := newHarness(t, 1)
+		resp := h.call("foo", schema.OpContextInput{EventID: "foo", Action: "clock"})
+		require.Equal(t, int32(shim.ERROR), resp.Status)
+		require.Contains(t, resp.Message, "invalid function")
+	})
+
+	t.Run("invalid action", func(t *testing.T) {
+		h := newHarness(t, 1)
+		resp := h.call("invoke", schema.OpContextInput{EventID: "foo", Action: "foo"})
+		require.Equal(t, int32(shim.ERROR), resp.Status)
+		resp = h.call("query", schema.OpContextInput{EventID: "foo", Action: "foo"})
+		require.Equal(t, int32(shim.ERROR), resp.Status)
+	})
+
+	t.Run("clock", func(t *testing.T) {
+		h := newHarness(t, 1)
+		resp := h.call("invoke", schema.OpContextInput{EventID: "foo", Action: "clock"})
+		require.Equal(t, int32(shim.OK), resp.Status, resp.Message)
+
+		var clockOutputVal schema.ClockOutput
+		require.NoError(t, json.Unmarshal(resp.Payload, &clockOutputVal))
+		require.Equal(t, []string{"0", "-", "clock", "-", h.txID()}, clockOutputVal.WriteKeyAttrs)
+	})
+}
+
+func (h *harness) initBidRules(bidRules schema.BidRules) pp.Response {
+	initInputValB, err := json.Marshal(schema.InitInput{BidRules: &bidRules})
+	require.NoError(h.t, err)
+	return h.stub.MockInit("init", [][]byte{[]byte("init"), initInputValB})
+}
+
+func TestValidation(t *testing.T) {
+	slot := 4
+
+	for _, exp := range []int{1, 2, 3} {
+		t.Run(fmt.Sprintf("exp%d", exp), func(t *testing.T) {
+			h := newHarness(t, exp)
+			resp := h.initBidRules(schema.BidRules{
+				MinPricePerUnitInCents: 1,
+				MaxPricePerUnitInCents: 20,
+				MaxQuantityInKWh:       5,
+				MaxBidsPerIdentity:     2,
+				TickSizeInCents:        0.5,
+			})
+			require.Equal(t, int32(shim.OK), resp.Status, resp.Message)
+
+			bids := map[string][]string{
+				"b1": h.as("bidder0001").bid("buy", slot, "b1", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2})),
+				"b2": h.as("bidder0001").bid("buy", slot, "b2", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2})),
+				"b3": h.as("bidder0001").bid("buy", slot, "b3", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 0.5})), // Too many bids
+				"b4": h.as("bidder0002").bid("buy", slot, "b4", h.encrypt(schema.BidInput{BidderID: 2, PricePerUnitInCents: 25, QuantityInKWh: 1})),   // Price too high
+				"b5": h.as("bidder0003").bid("buy", slot, "b5", h.encrypt(schema.BidInput{BidderID: 3, PricePerUnitInCents: 10, QuantityInKWh: -1})),  // Negative quantity
+				"b6": h.as("bidder0004").bid("buy", slot, "b6", h.encrypt(schema.BidInput{BidderID: 4, PricePerUnitInCents: 10, QuantityInKWh: 4})),
+				"b7": h.as("bidder0004").bid("buy", slot, "b7", h.encrypt(schema.BidInput{BidderID: 4, PricePerUnitInCents: 10, QuantityInKWh: 2})),   // Over the cap
+				"s1": h.as("bidder0005").bid("sell", slot, "s1", h.encrypt(schema.BidInput{BidderID: 5, PricePerUnitInCents: 6.2, QuantityInKWh: 1})), // Off tick
+				"s2": h.as("bidder0006").bid("sell", slot, "s2", h.encrypt(schema.BidInput{BidderID: 6, PricePerUnitInCents: 0.5, QuantityInKWh: 1})), // Price too low
+				"s3": h.as("bidder0007").bid("sell", slot, "s3", h.encrypt(schema.BidInput{BidderID: 7, PricePerUnitInCents: 6, QuantityInKWh: 5})),
+			}
+			h.reveal(slot, bids)
+
+			markEndOutputVal := h.markEnd(slot)
+			require.Equal(t, 5.0, markEndOutputVal.QuantityInKWh)
+			require.Len(t, markEndOutputVal.BuyerFills, 3)
+			require.Len(t, markEndOutputVal.SellerFills, 1)
+
+			var rejected []string
+			for _, r := range markEndOutputVal.Rejections {
+				rejected = append(rejected, r.BidID+":"+r.Reason)
+			}
+			if exp == 1 { // Bid IDs are event IDs only in exp 1
+				require.ElementsMatch(t, []string{
+					"b3:" + schema.RejectExcess,
+					"b4:" + schema.RejectPrice,
+					"b5:" + schema.RejectQuantity,
+					"b7:" + schema.RejectCap,
+					"s1:" + schema.RejectTick,
+					"s2:" + schema.RejectPrice,
+				}, rejected)
+			} else {
+				require.Len(t, rejected, 6)
+			}
+
+			metricsOutputVal := h.metrics()
+			require.Equal(t, 2, metricsOutputVal.RejectedPriceCount[slot])
+			require.Equal(t, 1, metricsOutputVal.RejectedQuantityCount[slot])
+			require.Equal(t, 1, metricsOutputVal.RejectedCapCount[slot])
+			require.Equal(t, 1, metricsOutputVal.RejectedExcessCount[slot])
+			require.Equal(t, 1, metricsOutputVal.RejectedTickCount[slot])
+		})
+	}
+
+	t.Run("negative values", func(t *testing.T) {
+		h := newHarness(t, 1)
+		resp := h.initBidRules(schema.BidRules{MaxBidsPerIdentity: -1})
+		require.Equal(t, int32(shim.ERROR), resp.Status)
+	})
+}
+
+func TestBidderIdentity(t *testing.T) {
+	slot := 4
+
+	for _, exp := range []int{1, 2, 3} {
+		t.Run(fmt.Sprintf("exp%d", exp), func(t *testing.T) {
+			h := newHarness(t, exp)
+			rules = BidRules{MaxBidsPerBidder: 1}
+
+			// The rules go by the identity that signs the bid, and not by the
+			// bidder ID that the bid claims
+			bids := map[string][]string{
+				"b1": h.as("bidder0001").bid("buy", slot, "b1", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 1})),
+				"b2": h.as("bidder0001").bid("buy", slot, "b2", h.encrypt(schema.BidInput{BidderID: 2, PricePerUnitInCents: 10, QuantityInKWh: 1})),
+				"b3": h.as("bidder0003").bid("buy", slot, "b3", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 1})),
+			}
+			h.reveal(slot, bids)
+
+			markEndOutputVal := h.markEnd(slot)
+			require.Len(t, markEndOutputVal.Rejections, 1)
+			require.Equal(t, schema.RejectExcess, markEndOutputVal.Rejections[0].Reason)
+			require.Equal(t, 2, markEndOutputVal.Rejections[0].BidderID)
+
+			// A bid that is not signed is refused
+			h.stub = shim.NewMockStub("unsigned", new(Contract))
+			resp := h.call("invoke", schema.OpContextInput{EventID: "b4", Action: "buy", Slot: slot, Data: h.encrypt(schema.BidInput{BidderID: 4})})
+			require.Equal(t, int32(shim.ERROR), resp.Status)
+			require.Contains(t, resp.Message, "cannot tell the identity of the caller")
+		})
+	}
+}
+
+func TestAdversaries(t *testing.T) {
+	slot := 4
+
+	for _, exp := range []int{1, 3} {
+		t.Run(fmt.Sprintf("exp%d", exp), func(t *testing.T) {
+			h := newHarness(t, exp)
+			h.initDeposits(schema.DepositRules{AmountInCents: 10, OpeningBalanceInCents: 100})
+			rules = BidRules{MaxBidsPerBidder: 1}
+
+			// bidder0001 bids, but does not post its keys
+			bids := map[string][]string{
+				"b1": h.as("bidder0001").bid("buy", slot, "b1", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 1})),
+				"s1": h.as("bidder0001").bid("sell", slot, "s1", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 12, QuantityInKWh: 1})),
+			}
+
+			// bidder0002 spams copies of a sell in the name of bidder0001, under
+			// event IDs that name it too, and posts the keys of all but the last
+			spam := []string{
+				schema.EventID("bidder0001", slot, "sell", 1, 1),
+				schema.EventID("bidder0001", slot, "sell", 2, 1),
+				schema.EventID("bidder0001", slot, "sell", 3, 1),
+			}
+			for _, eventID := range spam {
+				bids[eventID] = h.as("bidder0002").bid("sell", slot, eventID, h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 6, QuantityInKWh: 1}))
+			}
+
+			// It also replays the keys of bidder0001, which all agents share
+			for bidEventID, writeKeyAttrs := range bids {
+				if bidEventID == spam[2] {
+					continue
+				}
+				resp := h.as("bidder0002").postKey(slot, bidEventID, writeKeyAttrs)
+				require.Equal(t, int32(shim.OK), resp.Status, resp.Message)
+			}
+
+			markEndOutputVal := h.markEnd(slot)
+
+			// The cap goes by bidder0002: its second copy is rejected, while the
+			// sell of bidder0001 is not
+			require.Len(t, markEndOutputVal.Rejections, 1)
+			require.Equal(t, schema.RejectExcess, markEndOutputVal.Rejections[0].Reason)
+			require.Equal(t, 1.0, markEndOutputVal.QuantityInKWh)
+			require.Equal(t, 8.0, markEndOutputVal.PricePerUnitInCents)
+
+			// The replayed keys reveal the bids of bidder0001, which stay its
+			// own, and the copy that was not revealed costs bidder0002
+			require.Equal(t, 4, markEndOutputVal.Deposits.RefundedCount)
+			require.Len(t, markEndOutputVal.Deposits.Forfeits, 1)
+			require.Equal(t, "bidder0002", markEndOutputVal.Deposits.Forfeits[0].Account)
+			require.Equal(t, []schema.BalanceOutput{
+				{Account: "bidder0001", AvailableInCents: 100},
+				{Account: "bidder0002", AvailableInCents: 90, ForfeitedInCents: 10},
+			}, h.balances())
+		})
+	}
+}
+
+func TestSubmissionOrder(t *testing.T) {
+	slot := 4
+
+	for _, exp := range []int{1, 2, 3} {
+		t.Run(fmt.Sprintf("exp%d", exp), func(t *testing.T) {
+			h := newHarness(t, exp)
+			rules = BidRules{MaxBidsPerBidder: 1}
+
+			// The bid that was submitted first is kept, even though its ID is higher
+			bids := map[string][]string{"z1": h.as("bidder0001").bid("buy", slot, "z1", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 1}))}
+			firstTxID := h.txID()
+			bids["a1"] = h.as("bidder0001").bid("buy", slot, "a1", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 1}))
+			secondTxID := h.txID()
+			h.reveal(slot, bids)
+
+			kept, rejected := "z1", "a1"
+			if exp != 1 { // Bid IDs are event IDs only in exp 1
+				kept, rejected = firstTxID, secondTxID
+			}
+			markEndOutputVal := h.markEnd(slot)
+			require.Len(t, markEndOutputVal.Rejections, 1)
+			require.Equal(t, rejected, markEndOutputVal.Rejections[0].BidID)
+
+			key, err := h.stub.CreateCompositeKey("", []string{strconv.Itoa(slot), "-", schema.SubmissionKey, "-", kept})
+			require.NoError(t, err)
+			submissionOutputValB, err := h.stub.GetState(key)
+			require.NoError(t, err)
+			var submissionOutputVal schema.SubmissionOutput
+			require.NoError(t, json.Unmarshal(submissionOutputValB, &submissionOutputVal))
+			require.Equal(t, firstTxID, submissionOutputVal.TxID)
+			require.NotZero(t, submissionOutputVal.TimestampInNanos)
+		})
+	}
+}
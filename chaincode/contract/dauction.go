@@ -0,0 +1,223 @@
+package contract
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ErrNoPrice is returned when no equilibrium price can be found.
+var ErrNoPrice = errors.New("cannot find a price that clears the market")
+
+// Group is used to sort bidders into buyers and sellers.
+type Group int
+
+const (
+	// Buyers place bids with a maximum buy price per unit.
+	Buyers Group = iota
+	// Sellers place bids with a minimum sell price per unit.
+	Sellers
+)
+
+// Bid defines the number of units and the maximum/minimum
+// per-unit price a buyer/seller is willing to pay/receive.
+type Bid struct {
+	ID           string // The bid's event ID or tx ID
+	BidderID     int    // As reported by the bidder
+	Creator      string // The identity that posted the bid; see `opContext.creator`
+	PricePerUnit float64
+	Units        float64
+
+	// Used to break ties between bids w/ the same price; see `Before`
+	SubmittedAt int64  // The timestamp of the tx that posted the bid, in nanoseconds since the Unix epoch
+	TxID        string // The ID of the tx that posted the bid
+}
+
+// Before reports whether the bid was submitted before the given one: the bid
+// w/ the earlier timestamp was, and failing that, the one w/ the lower tx ID
+// or, failing that, ID. A bid ID gives away the bidder in experiment 1, and so
+// goes last, so that ties do not favor some bidders over others.
+func (b Bid) Before(o Bid) bool {
+	switch {
+	case b.SubmittedAt != o.SubmittedAt:
+		return b.SubmittedAt < o.SubmittedAt
+	case b.TxID != o.TxID:
+		return b.TxID < o.TxID
+	default:
+		return b.ID < o.ID
+	}
+}
+
+// Bid satisfies the fmt.Stringer interface.
+func (b Bid) String() string {
+	return fmt.Sprintf("%f units at a price of %f per unit", b.Units, b.PricePerUnit)
+}
+
+// BidCollection collects all the Bids placed by a group (buyers or sellers).
+type BidCollection []Bid
+
+// BidCollection satisfies the sort.Interface interface.
+func (bc BidCollection) Len() int {
+	return len(bc)
+}
+
+// BidCollection satisfies the sort.Interface interface.
+func (bc BidCollection) Swap(i, j int) {
+	bc[i], bc[j] = bc[j], bc[i]
+}
+
+// BidCollection satisfies the sort.Interface interface.
+// Bids with the same price are ordered by submission, so that the order
+// does not depend on the order in which the bids were read.
+func (bc BidCollection) Less(i, j int) bool {
+	if bc[i].PricePerUnit == bc[j].PricePerUnit {
+		return bc[i].Before(bc[j])
+	}
+	return bc[i].PricePerUnit < bc[j].PricePerUnit
+}
+
+// Fill records how many of a bid's units were allocated at clearing. The units
+// that a buy bid is filled for across feeders cost it the losses of the lines
+// they flow through, and the network fee; see `Network.Constrain`.
+type Fill struct {
+	Bid
+	Filled     float64
+	Lost       float64 // Of the fill, what the lines lost on the way to the buyer
+	FeeInCents float64 // Charged to the buyer for the use of the lines
+}
+
+// Result captures the outcome of a clearing: the uniform clearing price,
+// the number of units traded, and the allocation for every bid on each side.
+// The Units value is the sum of the fills on either side.
+type Result struct {
+	PricePerUnit float64
+	Units        float64
+	BuyerFills   []Fill // In order of priority, i.e. decreasing price
+	SellerFills  []Fill // In order of priority, i.e. increasing price
+}
+
+// Settle determines the clearing price and the number of units that can be
+// traded, given a collection of bids from buyers and sellers, and allocates
+// these units to the bids. It returns an error if no equilibrium price can be
+// found.
+//
+// Buyers are served in decreasing order of price, sellers in increasing order
+// of price. Bids that are strictly in the money are filled fully. If the
+// bids at the marginal price point cannot all be filled, the remaining units
+// are rationed among them pro-rata to their size. Ties are broken by submission.
+func Settle(buyers, sellers BidCollection) (Result, error) {
+	sb := Order(buyers, Buyers)
+	ss := Order(sellers, Sellers)
+
+	// If the highest buying price point is lower than the lowest selling price point, return error.
+	if len(sb) == 0 || len(ss) == 0 || sb[0].PricePerUnit < ss[0].PricePerUnit {
+		return Result{}, ErrNoPrice
+	}
+
+	// Walk down the demand curve and up the supply curve for as long as
+	// the buyer is willing to pay at least what the seller is asking for.
+	var volume float64
+	var lastBuyPrice, lastSellPrice float64
+	remB, remS := sb[0].Units, ss[0].Units
+	for i, j := 0, 0; i < len(sb) && j < len(ss) && sb[i].PricePerUnit >= ss[j].PricePerUnit; {
+		qty := math.Min(remB, remS)
+		volume += qty
+		lastBuyPrice, lastSellPrice = sb[i].PricePerUnit, ss[j].PricePerUnit
+		remB -= qty
+		remS -= qty
+		if remB <= 0 {
+			if i++; i < len(sb) {
+				remB = sb[i].Units
+			}
+		}
+		if remS <= 0 {
+			if j++; j < len(ss) {
+				remS = ss[j].Units
+			}
+		}
+	}
+
+	if volume <= 0 {
+		return Result{}, ErrNoPrice
+	}
+
+	res := Result{
+		// Split the surplus of the marginal trade evenly between the two sides.
+		PricePerUnit: (lastBuyPrice + lastSellPrice) / 2,
+		BuyerFills:   Allocate(sb, volume),
+		SellerFills:  Allocate(ss, volume),
+	}
+
+	for _, f := range res.BuyerFills {
+		res.Units += f.Filled
+	}
+
+	return res, nil
+}
+
+// Order returns a copy of the bid collection, sorted in order of priority:
+// decreasing price for buyers, increasing price for sellers. Bids with the
+// same price are ordered by submission.
+func Order(bc BidCollection, groupType Group) BidCollection {
+	result := make(BidCollection, len(bc))
+	copy(result, bc)
+
+	switch groupType {
+	case Buyers:
+		sort.SliceStable(result, func(i, j int) bool {
+			if result[i].PricePerUnit == result[j].PricePerUnit {
+				return result[i].Before(result[j])
+			}
+			return result[i].PricePerUnit > result[j].PricePerUnit
+		})
+	case Sellers:
+		sort.Stable(result)
+	}
+
+	return result
+}
+
+// Allocate distributes `volume` units among the bids of an ordered bid
+// collection (see `Order`). Price points are served in order; the bids at the
+// marginal price point share whatever is left pro-rata to their size. The
+// fills are returned in the order of the collection and add up to `volume`.
+func Allocate(ordered BidCollection, volume float64) []Fill {
+	fills := make([]Fill, len(ordered))
+	remaining := volume
+
+	for i := 0; i < len(ordered); {
+		// Collect the bids at this price point.
+		j, levelUnits := i, 0.0
+		for ; j < len(ordered) && ordered[j].PricePerUnit == ordered[i].PricePerUnit; j++ {
+			levelUnits += ordered[j].Units
+		}
+
+		switch {
+		case remaining <= 0:
+			for k := i; k < j; k++ {
+				fills[k] = Fill{Bid: ordered[k]}
+			}
+		case levelUnits <= remaining:
+			for k := i; k < j; k++ {
+				fills[k] = Fill{Bid: ordered[k], Filled: ordered[k].Units}
+			}
+			remaining -= levelUnits
+		default:
+			// This is the marginal price point; ration pro-rata. The last bid
+			// at this price point gets the remainder, so that the fills add up
+			// to the cleared volume.
+			share, allocated := remaining/levelUnits, 0.0
+			for k := i; k < j-1; k++ {
+				fills[k] = Fill{Bid: ordered[k], Filled: ordered[k].Units * share}
+				allocated += fills[k].Filled
+			}
+			fills[j-1] = Fill{Bid: ordered[j-1], Filled: remaining - allocated}
+			remaining = 0
+		}
+
+		i = j
+	}
+
+	return fills
+}
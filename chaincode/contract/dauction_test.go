@@ -0,0 +1,138 @@
+package contract
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sumFills(fills []Fill) float64 {
+	var sum float64
+	for _, f := range fills {
+		sum += f.Filled
+	}
+	return sum
+}
+
+func TestSettle(t *testing.T) {
+	t.Run("no price", func(t *testing.T) {
+		buyers := BidCollection{{ID: "b1", PricePerUnit: 5, Units: 1}}
+		sellers := BidCollection{{ID: "s1", PricePerUnit: 6, Units: 1}}
+		_, err := Settle(buyers, sellers)
+		require.Equal(t, ErrNoPrice, err)
+	})
+
+	t.Run("empty side", func(t *testing.T) {
+		buyers := BidCollection{{ID: "b1", PricePerUnit: 5, Units: 1}}
+		_, err := Settle(buyers, nil)
+		require.Equal(t, ErrNoPrice, err)
+	})
+
+	t.Run("single trade", func(t *testing.T) {
+		buyers := BidCollection{{ID: "b1", PricePerUnit: 10, Units: 2}}
+		sellers := BidCollection{{ID: "s1", PricePerUnit: 6, Units: 3}}
+		res, err := Settle(buyers, sellers)
+		require.NoError(t, err)
+		require.Equal(t, 8.0, res.PricePerUnit)
+		require.Equal(t, 2.0, res.Units)
+		require.Equal(t, 2.0, res.BuyerFills[0].Filled)
+		require.Equal(t, 2.0, res.SellerFills[0].Filled)
+	})
+
+	t.Run("out of the money bids get nothing", func(t *testing.T) {
+		buyers := BidCollection{
+			{ID: "b1", PricePerUnit: 10, Units: 1},
+			{ID: "b2", PricePerUnit: 4, Units: 1},
+		}
+		sellers := BidCollection{
+			{ID: "s1", PricePerUnit: 5, Units: 1},
+			{ID: "s2", PricePerUnit: 11, Units: 1},
+		}
+		res, err := Settle(buyers, sellers)
+		require.NoError(t, err)
+		require.Equal(t, 1.0, res.Units)
+		require.Equal(t, 7.5, res.PricePerUnit)
+		require.Equal(t, []Fill{
+			{Bid: buyers[0], Filled: 1},
+			{Bid: buyers[1], Filled: 0},
+		}, res.BuyerFills)
+		require.Equal(t, []Fill{
+			{Bid: sellers[0], Filled: 1},
+			{Bid: sellers[1], Filled: 0},
+		}, res.SellerFills)
+	})
+
+	t.Run("marginal bids are rationed pro-rata", func(t *testing.T) {
+		buyers := BidCollection{
+			{ID: "b3", PricePerUnit: 8, Units: 3},
+			{ID: "b1", PricePerUnit: 12, Units: 2},
+			{ID: "b2", PricePerUnit: 8, Units: 1},
+		}
+		sellers := BidCollection{{ID: "s1", PricePerUnit: 4, Units: 4}}
+		res, err := Settle(buyers, sellers)
+		require.NoError(t, err)
+		require.Equal(t, 4.0, res.Units)
+		require.Equal(t, 6.0, res.PricePerUnit)
+
+		// Ordered by price, then ID
+		require.Equal(t, "b1", res.BuyerFills[0].ID)
+		require.Equal(t, "b2", res.BuyerFills[1].ID)
+		require.Equal(t, "b3", res.BuyerFills[2].ID)
+
+		// The in-the-money bid is filled fully, the marginal ones share the 2 units left.
+		require.Equal(t, 2.0, res.BuyerFills[0].Filled)
+		require.InDelta(t, 0.5, res.BuyerFills[1].Filled, 1e-9)
+		require.InDelta(t, 1.5, res.BuyerFills[2].Filled, 1e-9)
+		require.Equal(t, 4.0, res.SellerFills[0].Filled)
+	})
+
+	t.Run("fills add up on both sides", func(t *testing.T) {
+		buyers := BidCollection{
+			{ID: "b1", PricePerUnit: 9.7, Units: 0.31},
+			{ID: "b2", PricePerUnit: 8.1, Units: 0.77},
+			{ID: "b3", PricePerUnit: 8.1, Units: 0.13},
+			{ID: "b4", PricePerUnit: 5.3, Units: 1.9},
+		}
+		sellers := BidCollection{
+			{ID: "s1", PricePerUnit: 3.2, Units: 0.4},
+			{ID: "s2", PricePerUnit: 6.6, Units: 0.55},
+			{ID: "s3", PricePerUnit: 6.6, Units: 0.25},
+			{ID: "s4", PricePerUnit: 9.9, Units: 2},
+		}
+		res, err := Settle(buyers, sellers)
+		require.NoError(t, err)
+		require.InDelta(t, 1.2, res.Units, 1e-9)
+		require.Equal(t, res.Units, sumFills(res.BuyerFills))
+		require.InDelta(t, res.Units, sumFills(res.SellerFills), 1e-12)
+		for _, f := range append(res.BuyerFills, res.SellerFills...) {
+			require.True(t, f.Filled >= 0 && f.Filled <= f.Units+1e-12, "bad fill: %v", f)
+		}
+	})
+
+	t.Run("ties are broken by submission", func(t *testing.T) {
+		buyers := BidCollection{
+			{ID: "b1", PricePerUnit: 8, Units: 1, SubmittedAt: 2, TxID: "tx1"},
+			{ID: "b2", PricePerUnit: 8, Units: 1, SubmittedAt: 1, TxID: "tx2"},
+			{ID: "b3", PricePerUnit: 8, Units: 1, SubmittedAt: 1, TxID: "tx0"},
+		}
+		sellers := BidCollection{{ID: "s1", PricePerUnit: 4, Units: 2}}
+		res, err := Settle(buyers, sellers)
+		require.NoError(t, err)
+		require.Equal(t, "b3", res.BuyerFills[0].ID)
+		require.Equal(t, "b2", res.BuyerFills[1].ID)
+		require.Equal(t, "b1", res.BuyerFills[2].ID)
+	})
+
+	t.Run("input order does not matter", func(t *testing.T) {
+		buyers := BidCollection{
+			{ID: "b1", PricePerUnit: 8, Units: 1},
+			{ID: "b2", PricePerUnit: 8, Units: 1},
+		}
+		sellers := BidCollection{{ID: "s1", PricePerUnit: 4, Units: 1}}
+		res1, err := Settle(buyers, sellers)
+		require.NoError(t, err)
+		res2, err := Settle(BidCollection{buyers[1], buyers[0]}, sellers)
+		require.NoError(t, err)
+		require.Equal(t, res1, res2)
+	})
+}
@@ -0,0 +1,401 @@
+package contract
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pp "github.com/hyperledger/fabric/protos/peer"
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
+)
+
+// depositRules returns the deposit rules that were passed to the chaincode
+// during instantiation, or nil if bids lock no deposits. Deposits apply to
+// experiments 1 and 3 only, as there is no key for a bidder to withhold in
+// experiment 2.
+func (oc *opContext) depositRules() (*schema.DepositRules, error) {
+	if expNum == 2 {
+		return nil, nil
+	}
+
+	valB, err := oc.Get([]string{schema.DepositsKey})
+	if err != nil || valB == nil {
+		return nil, err
+	}
+
+	var depositRulesVal schema.DepositRules
+	if err := oc.Unmarshal(valB, &depositRulesVal); err != nil {
+		return nil, err
+	}
+
+	return &depositRulesVal, nil
+}
+
+// funds returns the running balance of the given account, as persisted by
+// `putFunds`, or its opening balance if there is none on record: that of the
+// deposit rules or, if the market settles payments, nothing. A transaction does
+// not read its own writes, so the balances that the op has written are cached.
+func (oc *opContext) funds(id string) (schema.BalanceOutput, error) {
+	if balanceOutputVal, ok := oc.accounts[id]; ok {
+		return balanceOutputVal, nil
+	}
+
+	balanceOutputVal := schema.BalanceOutput{Account: id}
+
+	valB, err := oc.Get([]string{schema.AccountKey, "-", id})
+	if err != nil {
+		return balanceOutputVal, err
+	}
+	if valB != nil {
+		err := oc.Unmarshal(valB, &balanceOutputVal)
+		return balanceOutputVal, err
+	}
+
+	paymentRules, err := oc.paymentRules()
+	if err != nil || paymentRules != nil {
+		return balanceOutputVal, err
+	}
+	depositRules, err := oc.depositRules()
+	if err != nil || depositRules == nil {
+		return balanceOutputVal, err
+	}
+	balanceOutputVal.AvailableInCents = depositRules.OpeningBalanceInCents
+
+	return balanceOutputVal, nil
+}
+
+// putFunds persists the running balance of an account to write-key
+// <account>-<account_id>. Only the calls that settle funds, i.e. `mint` and
+// `markEnd`, write it, so that no bid has to read a key that they rewrite.
+func (oc *opContext) putFunds(balanceOutputVal schema.BalanceOutput) error {
+	balanceOutputVal.LockedInCents = 0 // Folded in on lookup; see `account`
+
+	balanceOutputValB, err := oc.Marshal(balanceOutputVal)
+	if err != nil {
+		return err
+	}
+	if err := oc.Put([]string{schema.AccountKey, "-", balanceOutputVal.Account}, balanceOutputValB); err != nil {
+		return err
+	}
+
+	oc.accounts[balanceOutputVal.Account] = balanceOutputVal
+	return nil
+}
+
+// account returns the balance of the given account: its running balance, less
+// the deposits that it has locked. The deposits are folded in from their own
+// keys (see `lockDeposit`), so that a bid does not have to rewrite the balance.
+func (oc *opContext) account(id string) (schema.BalanceOutput, error) {
+	balanceOutputVal, err := oc.funds(id)
+	if err != nil {
+		return balanceOutputVal, err
+	}
+
+	keyAttrs := []string{schema.DepositKey, "-", id}
+	iter, err := oc.Iter(keyAttrs)
+	if err != nil {
+		return balanceOutputVal, err
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		depositKV, err := iter.Next()
+		if err != nil {
+			msg := fmt.Sprintf("failed during iteration on deposit-key w/ attributes %s: %s", keyAttrs, err.Error())
+			oc.log.Error(msg)
+			return balanceOutputVal, errors.New(oc.describe(msg))
+		}
+		var depositOutputVal schema.DepositOutput
+		if err := oc.Unmarshal(depositKV.GetValue(), &depositOutputVal); err != nil {
+			return balanceOutputVal, err
+		}
+		if depositOutputVal.Status == schema.DepositLocked {
+			balanceOutputVal.LockedInCents += depositOutputVal.AmountInCents
+		}
+	}
+	balanceOutputVal.AvailableInCents -= balanceOutputVal.LockedInCents
+
+	return balanceOutputVal, nil
+}
+
+// depositKeyAttrs returns the attributes of the key that the deposit of the
+// bid with the given ID is persisted to. The key leads with the account, so
+// that the deposits of an account can be iterated over.
+func (oc *opContext) depositKeyAttrs(id, bidID string) []string {
+	return []string{schema.DepositKey, "-", id, "-", fmt.Sprintf("%012d", oc.args.Slot), "-", bidID}
+}
+
+// - Looks up the deposit rules, and returns if bids lock no deposits
+// - Writes JSON-encoded `schema.DepositOutput` to write-key
+//		<deposit>-<account_id>-<slot_number>-<bid_id>, which locks the amount
+//		against the account of the identity that signed the bid
+//
+// The bid does not check the balance of the account: `settleDeposits` does, when
+// the slot is marked. A bid thus reads no key that another bid of the account,
+// or the `markEnd` call that settles funds, writes.
+func (oc *opContext) lockDeposit(bidID string) error {
+	depositRules, err := oc.depositRules()
+	if err != nil || depositRules == nil {
+		return err
+	}
+
+	id, err := oc.creator()
+	if err != nil {
+		return err
+	}
+
+	depositOutputValB, err := oc.Marshal(schema.DepositOutput{
+		BidID:         bidID,
+		Account:       id,
+		AmountInCents: depositRules.AmountInCents,
+		Status:        schema.DepositLocked,
+	})
+	if err != nil {
+		return err
+	}
+
+	return oc.Put(oc.depositKeyAttrs(id, bidID), depositOutputValB)
+}
+
+// - Looks up the deposit rules, and returns nil if bids lock no deposits
+// - Iterates over partial key <slot_number>-<submission>, and looks up the
+//		deposit of every bid posted in the slot
+// - Goes over the deposits in order of submission, and voids those that the
+//		running balance of their account cannot cover on top of the deposits
+//		that the account locked before them in the slot
+// - Refunds the deposits of the bids that were revealed, i.e. those in the
+//		given bid collection, and forfeits the rest
+// - Updates the JSON-encoded `schema.DepositOutput` of every deposit it settles
+// - Takes the forfeits off the running balances of the accounts and, if the
+//		market settles payments, pays them to the utility account, and writes a
+//		statement entry for each to either account
+// - Returns `schema.DepositsOutput` with the outcome; see `rejectUnfunded` for
+//		the bids whose deposits were void
+func (oc *opContext) settleDeposits(revealed BidCollection) (*schema.DepositsOutput, error) {
+	depositRules, err := oc.depositRules()
+	if err != nil || depositRules == nil {
+		return nil, err
+	}
+
+	revealedIDs := make(map[string]bool, len(revealed))
+	for _, bid := range revealed {
+		revealedIDs[bid.ID] = true
+	}
+
+	keyAttrs := []string{strconv.Itoa(oc.args.Slot), "-", schema.SubmissionKey}
+	iter, err := oc.Iter(keyAttrs)
+	if err != nil {
+		metricsOutputVal.ProblematicIterCount[oc.args.Slot]++
+		return nil, err
+	}
+	defer iter.Close()
+
+	var submitted BidCollection
+	for iter.HasNext() {
+		submissionKV, err := iter.Next()
+		if err != nil {
+			msg := fmt.Sprintf("failed during iteration on submission-key w/ attributes %s: %s", keyAttrs, err.Error())
+			oc.log.Error(msg)
+			metricsOutputVal.ProblematicIterCount[oc.args.Slot]++
+			return nil, errors.New(oc.describe(msg))
+		}
+		var submissionOutputVal schema.SubmissionOutput
+		if err := oc.Unmarshal(submissionKV.GetValue(), &submissionOutputVal); err != nil {
+			return nil, err
+		}
+		submitted = append(submitted, Bid{
+			ID:          submissionOutputVal.BidID,
+			Creator:     submissionOutputVal.Creator,
+			TxID:        submissionOutputVal.TxID,
+			SubmittedAt: submissionOutputVal.TimestampInNanos,
+		})
+	}
+
+	// In order of submission, so that every peer voids the same deposits, and
+	// an account that bids past its balance loses its latest bids
+	sort.SliceStable(submitted, func(i, j int) bool {
+		return submitted[i].Before(submitted[j])
+	})
+
+	depositsOutputVal := new(schema.DepositsOutput)
+	locked := make(map[string]float64) // By account, across the deposits covered so far
+	for _, bid := range submitted {
+		depositKeyAttrs := oc.depositKeyAttrs(bid.Creator, bid.ID)
+		valB, err := oc.Get(depositKeyAttrs)
+		if err != nil {
+			return nil, err
+		}
+		if valB == nil {
+			continue
+		}
+		var d schema.DepositOutput
+		if err := oc.Unmarshal(valB, &d); err != nil {
+			return nil, err
+		}
+		if d.Status != schema.DepositLocked {
+			continue
+		}
+
+		balanceOutputVal, err := oc.funds(d.Account)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case balanceOutputVal.AvailableInCents < locked[d.Account]+d.AmountInCents:
+			d.Status = schema.DepositUnfunded
+			depositsOutputVal.Unfunded = append(depositsOutputVal.Unfunded, d)
+			msg := fmt.Sprintf("voided deposit of %.3f ç for bid w/ id %s: %.3f ç available, %.3f ç locked", d.AmountInCents, d.BidID, balanceOutputVal.AvailableInCents, locked[d.Account])
+			oc.log.Warn(msg, logging.F("account", d.Account))
+		case revealedIDs[d.BidID]:
+			locked[d.Account] += d.AmountInCents
+			d.Status = schema.DepositRefunded
+			depositsOutputVal.RefundedCount++
+		default:
+			locked[d.Account] += d.AmountInCents
+			d.Status = schema.DepositForfeited
+			depositsOutputVal.Forfeits = append(depositsOutputVal.Forfeits, d)
+			depositsOutputVal.ForfeitedInCents += d.AmountInCents
+			msg := fmt.Sprintf("forfeited deposit of %.3f ç for bid w/ id %s", d.AmountInCents, d.BidID)
+			oc.log.Info(msg, logging.F("account", d.Account))
+		}
+
+		depositOutputValB, err := oc.Marshal(d)
+		if err != nil {
+			return nil, err
+		}
+		if err := oc.Put(depositKeyAttrs, depositOutputValB); err != nil {
+			return nil, err
+		}
+	}
+
+	// The forfeits come off the running balance of the account and, if the
+	// market settles payments, are paid to the utility
+	paymentRules, err := oc.paymentRules()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range depositsOutputVal.Forfeits {
+		balanceOutputVal, err := oc.funds(d.Account)
+		if err != nil {
+			return nil, err
+		}
+		balanceOutputVal.ForfeitedInCents += d.AmountInCents
+		if paymentRules == nil {
+			balanceOutputVal.AvailableInCents -= d.AmountInCents
+		}
+		if err := oc.putFunds(balanceOutputVal); err != nil {
+			return nil, err
+		}
+
+		if paymentRules == nil {
+			continue
+		}
+		entry := schema.StatementEntry{Kind: schema.StatementForfeit, BidID: d.BidID, AmountInCents: -d.AmountInCents}
+		if err := oc.putStatementEntry(d.Account, entry); err != nil {
+			return nil, err
+		}
+		entry.AmountInCents = d.AmountInCents
+		if err := oc.putStatementEntry(paymentRules.Utility, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	return depositsOutputVal, nil
+}
+
+// rejectUnfunded excludes from the collection the bids whose deposits were
+// void, as their accounts could not cover them; see `settleDeposits`.
+func (oc *opContext) rejectUnfunded(bc BidCollection, depositsOutputVal *schema.DepositsOutput) (BidCollection, []schema.RejectionOutput) {
+	if depositsOutputVal == nil || len(depositsOutputVal.Unfunded) == 0 {
+		return bc, nil
+	}
+
+	unfunded := make(map[string]bool, len(depositsOutputVal.Unfunded))
+	for _, d := range depositsOutputVal.Unfunded {
+		unfunded[d.BidID] = true
+	}
+
+	var resp BidCollection
+	var rejections []schema.RejectionOutput
+	for _, bid := range bc {
+		if !unfunded[bid.ID] {
+			resp = append(resp, bid)
+			continue
+		}
+		msg := fmt.Sprintf("rejected bid [%s] w/ id %s from bidder %d: %s", bid, bid.ID, bid.BidderID, schema.RejectUnfunded)
+		oc.log.Warn(msg, logging.Bidder(bid.BidderID), logging.F("creator", bid.Creator))
+		rejections = append(rejections, schema.RejectionOutput{
+			BidID:    bid.ID,
+			BidderID: bid.BidderID,
+			Reason:   schema.RejectUnfunded,
+		})
+	}
+
+	return resp, rejections
+}
+
+// - Iterates over partial keys <account> and <deposit> for the accounts on
+//		the ledger, or looks up the accounts in the optional JSON-encoded
+//		`schema.BalancesInput`
+// - Returns JSON-encoded `schema.BalancesOutput`
+func (oc *opContext) balances() pp.Response {
+	var balancesInputVal schema.BalancesInput
+	if len(oc.args.Data) > 0 {
+		if err := oc.Unmarshal(oc.args.Data, &balancesInputVal); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	ids := balancesInputVal.Accounts
+	if len(ids) == 0 {
+		seen := make(map[string]bool)
+		for _, key := range []string{schema.AccountKey, schema.DepositKey} {
+			iter, err := oc.Iter([]string{key})
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			for iter.HasNext() {
+				kv, err := iter.Next()
+				if err != nil {
+					iter.Close()
+					return shim.Error(err.Error())
+				}
+				// Both keys name the account right after their prefix
+				keyAttrs, err := oc.Split(kv.GetKey())
+				if err != nil {
+					iter.Close()
+					return shim.Error(err.Error())
+				}
+				if id := keyAttrs[2]; !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+			iter.Close()
+		}
+	}
+
+	var balancesOutputVal schema.BalancesOutput
+	for _, id := range ids {
+		balanceOutputVal, err := oc.account(id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		balancesOutputVal.Accounts = append(balancesOutputVal.Accounts, balanceOutputVal)
+	}
+
+	sort.Slice(balancesOutputVal.Accounts, func(i, j int) bool {
+		return balancesOutputVal.Accounts[i].Account < balancesOutputVal.Accounts[j].Account
+	})
+
+	balancesOutputValB, err := oc.Marshal(balancesOutputVal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(balancesOutputValB)
+}
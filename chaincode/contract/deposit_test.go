@@ -0,0 +1,152 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func (h *harness) initDeposits(depositRules schema.DepositRules) {
+	initInputValB, err := json.Marshal(schema.InitInput{Deposits: &depositRules})
+	require.NoError(h.t, err)
+	resp := h.stub.MockInit("init", [][]byte{[]byte("init"), initInputValB})
+	require.Equal(h.t, int32(shim.OK), resp.Status, resp.Message)
+}
+
+func (h *harness) balances(accounts ...string) []schema.BalanceOutput {
+	args := schema.OpContextInput{EventID: "balances", Action: "balances"}
+	if len(accounts) > 0 {
+		balancesInputValB, err := json.Marshal(schema.BalancesInput{Accounts: accounts})
+		require.NoError(h.t, err)
+		args.Data = balancesInputValB
+	}
+	resp := h.call("query", args)
+	require.Equal(h.t, int32(shim.OK), resp.Status, resp.Message)
+
+	var balancesOutputVal schema.BalancesOutput
+	require.NoError(h.t, json.Unmarshal(resp.Payload, &balancesOutputVal))
+	return balancesOutputVal.Accounts
+}
+
+func TestDeposits(t *testing.T) {
+	slot := 4
+	depositRules := schema.DepositRules{AmountInCents: 10, OpeningBalanceInCents: 25}
+
+	for _, exp := range []int{1, 3} {
+		t.Run(fmt.Sprintf("exp%d", exp), func(t *testing.T) {
+			h := newHarness(t, exp)
+			h.initDeposits(depositRules)
+
+			buy1 := schema.EventID("bidder0001", slot, "buy", 0, 1)
+			sell1 := schema.EventID("bidder0001", slot, "sell", 0, 1)
+			sell2 := schema.EventID("bidder0002", slot, "sell", 0, 1)
+			bids := map[string][]string{
+				buy1:  h.as("bidder0001").bid("buy", slot, buy1, h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2})),
+				sell2: h.as("bidder0002").bid("sell", slot, sell2, h.encrypt(schema.BidInput{BidderID: 2, PricePerUnitInCents: 6, QuantityInKWh: 1})),
+				sell1: h.as("bidder0001").bid("sell", slot, sell1, h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 7, QuantityInKWh: 1})),
+			}
+
+			// The third bid of bidder0001 is not covered by its balance, but the
+			// bid does not check it; `markEnd` does
+			spam := schema.EventID("bidder0001", slot, "buy", 1, 1)
+			bids[spam] = h.as("bidder0001").bid("buy", slot, spam, h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 9, QuantityInKWh: 1}))
+
+			require.Equal(t, []schema.BalanceOutput{
+				{Account: "bidder0001", AvailableInCents: -5, LockedInCents: 30},
+				{Account: "bidder0002", AvailableInCents: 15, LockedInCents: 10},
+			}, h.balances())
+
+			delete(bids, sell1) // The key for the sell of bidder0001 is not posted
+			h.reveal(slot, bids)
+
+			markEndOutputVal := h.markEnd(slot)
+			require.Equal(t, 1.0, markEndOutputVal.QuantityInKWh)
+			require.NotNil(t, markEndOutputVal.Deposits)
+			require.Equal(t, 2, markEndOutputVal.Deposits.RefundedCount)
+			require.Len(t, markEndOutputVal.Deposits.Forfeits, 1)
+			require.Equal(t, "bidder0001", markEndOutputVal.Deposits.Forfeits[0].Account)
+			require.Equal(t, schema.DepositForfeited, markEndOutputVal.Deposits.Forfeits[0].Status)
+			require.Equal(t, 10.0, markEndOutputVal.Deposits.ForfeitedInCents)
+			require.Len(t, markEndOutputVal.Deposits.Unfunded, 1)
+			require.Equal(t, schema.DepositUnfunded, markEndOutputVal.Deposits.Unfunded[0].Status)
+
+			// The spam bid was revealed, but goes no further
+			require.Len(t, markEndOutputVal.Rejections, 1)
+			require.Equal(t, schema.RejectUnfunded, markEndOutputVal.Rejections[0].Reason)
+			require.Equal(t, markEndOutputVal.Deposits.Unfunded[0].BidID, markEndOutputVal.Rejections[0].BidID)
+
+			require.Equal(t, []schema.BalanceOutput{
+				{Account: "bidder0001", AvailableInCents: 15, ForfeitedInCents: 10},
+			}, h.balances("bidder0001"))
+			require.Equal(t, []schema.BalanceOutput{
+				{Account: "bidder0003", AvailableInCents: 25},
+			}, h.balances("bidder0003"))
+		})
+	}
+
+	t.Run("exp2", func(t *testing.T) {
+		h := newHarness(t, 2)
+		h.initDeposits(depositRules)
+
+		h.as("bidder0001").bid("buy", slot, "b1", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2}))
+		require.Nil(t, h.markEnd(slot).Deposits)
+		require.Empty(t, h.balances())
+	})
+
+	t.Run("deposits are locked against the signer", func(t *testing.T) {
+		h := newHarness(t, 1)
+		h.initDeposits(depositRules)
+
+		// The event ID names bidder0001, but bidder0002 signs the bid
+		buy1 := schema.EventID("bidder0001", slot, "buy", 0, 1)
+		h.as("bidder0002").bid("buy", slot, buy1, h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2}))
+		require.Equal(t, []schema.BalanceOutput{
+			{Account: "bidder0002", AvailableInCents: 15, LockedInCents: 10},
+		}, h.balances())
+	})
+
+	t.Run("a buy and a sell of one identity in the same slot", func(t *testing.T) {
+		h := newHarness(t, 3)
+		h.initDeposits(depositRules)
+
+		// Fabric does not let a transaction read what another one in the same
+		// block writes, so neither bid may read what the other, or the `markEnd`
+		// call that settles funds, writes
+		h.reads = nil
+		buy1 := schema.EventID("bidder0001", slot, "buy", 0, 1)
+		sell1 := schema.EventID("bidder0001", slot, "sell", 0, 1)
+		bids := map[string][]string{
+			buy1:  h.as("bidder0001").bid("buy", slot, buy1, h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2})),
+			sell1: h.as("bidder0001").bid("sell", slot, sell1, h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 12, QuantityInKWh: 1})),
+		}
+		require.NotEmpty(t, h.reads)
+		for _, keyAttrs := range h.reads {
+			for _, attr := range keyAttrs {
+				require.NotContains(t, []string{schema.DepositKey, schema.AccountKey, schema.StatementKey, schema.SubmissionKey}, attr, "%v", keyAttrs)
+			}
+		}
+		require.Equal(t, []schema.BalanceOutput{
+			{Account: "bidder0001", AvailableInCents: 5, LockedInCents: 20},
+		}, h.balances())
+
+		h.reveal(slot, bids)
+		markEndOutputVal := h.markEnd(slot)
+		require.Equal(t, 2, markEndOutputVal.Deposits.RefundedCount)
+		require.Empty(t, markEndOutputVal.Deposits.Unfunded)
+		require.Equal(t, []schema.BalanceOutput{
+			{Account: "bidder0001", AvailableInCents: 25},
+		}, h.balances())
+	})
+
+	t.Run("negative amounts", func(t *testing.T) {
+		h := newHarness(t, 1)
+		initInputValB, err := json.Marshal(schema.InitInput{Deposits: &schema.DepositRules{AmountInCents: -1}})
+		require.NoError(t, err)
+		resp := h.stub.MockInit("init", [][]byte{[]byte("init"), initInputValB})
+		require.Equal(t, int32(shim.ERROR), resp.Status)
+	})
+}
@@ -1,4 +1,4 @@
-package main
+package contract
 
 import (
 	"fmt"
@@ -17,9 +17,11 @@ func (oc *opContext) invoke() pp.Response {
 		return oc.markEnd()
 	case "clock":
 		return oc.clock()
+	case "mint":
+		return oc.mint()
 	default:
-		msg := fmt.Sprintf("tx_id:%s\tevent_id:%s\tslot:%012d\t• invalid action: %s", oc.txID, oc.args.EventID, oc.args.Slot, oc.args.Action)
-		fmt.Fprintln(w, msg)
-		return shim.Error(msg)
+		msg := fmt.Sprintf("invalid action: %s", oc.args.Action)
+		oc.log.Error(msg)
+		return shim.Error(oc.describe(msg))
 	}
 }
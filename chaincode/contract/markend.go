@@ -0,0 +1,503 @@
+package contract
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pp "github.com/hyperledger/fabric/protos/peer"
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
+)
+
+// Marks the end of a slot.
+// - In case of experiment 2, deserializes the private key in `oc.args.Data`
+// - In case of experiments 1 or 3, retrieves the private keys for `oc.args.Slot`
+// 	 	posted in the chaincode's KV store
+// - Decodes the posted bids, and rejects those whose keys cannot be parsed
+// - Looks up when every bid was submitted; see `stamp`
+// - In case of experiments 1 or 3, refunds the deposits of the bids that were
+//		revealed and forfeits the rest, if bids lock deposits, and excludes the
+//		bids whose deposits their accounts cannot cover; see `settleDeposits`
+// - Excludes the bids that violate the bid rules
+// - Creates a bid collection for buyers and sellers for slot`oc.args.Slot`
+// - Calculates the MCP for `oc.args.Slot`
+// - Curtails the fills that the network topology cannot accommodate, if there is a topology
+// - Settles the payments for the valid bids, if the market settles payments;
+//		see `settlePayments`
+// - Creates write-key <slot_number>-<markend>-<tx_id>
+// - Writes JSON-encoded `schema.MarkEndOutput` to write-key
+func (oc *opContext) markEnd() pp.Response {
+	keyAttrs := []string{strconv.Itoa(oc.args.Slot), "-", oc.args.Action, "-", oc.txID}
+
+	markEndOutputVal := schema.MarkEndOutput{
+		WriteKeyAttrs: keyAttrs,
+		Slot:          oc.args.Slot,
+	}
+
+	var keyPair *rsa.PrivateKey
+	var err error
+
+	// In experiments 1 and 3, the input only carries the grid prices, if any
+	var markEndInputVal schema.MarkEndInput
+	if expNum == 2 || len(oc.args.Data) > 0 {
+		if err := oc.Unmarshal(oc.args.Data, &markEndInputVal); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	if expNum == 2 {
+		// Retrieve the markend regulator's private key
+		markEndOutputVal.PrivKey = markEndInputVal.PrivKey
+		keyPair, err = DeserializePrivate(markEndOutputVal.PrivKey)
+		if err != nil {
+			msg := fmt.Sprintf("cannot load key pair: %s", err.Error())
+			oc.log.Error(msg)
+			metricsOutputVal.ProblematicDecryptCount[oc.args.Slot]++
+			return shim.Error(oc.describe(msg))
+		}
+	}
+
+	// Decrypt the bids using the private key and calculate the MCP.
+	// ATTN: In a non-POC setting, we would just persist this key to the ledger, and
+	// have the clients do the decryption and calculate the clearing price locally.
+	// In this POC we both persist the key to the ledger, *and* have the chaincode
+	// calculate the MCP.
+
+	// Create the bid collections corresponding to that slot_number. The bids
+	// whose keys cannot be parsed are rejected there and then.
+	var buyerBids, sellerBids BidCollection
+	var buyerRejections, sellerRejections []schema.RejectionOutput
+
+	switch expNum {
+	case 1:
+		buyerBids, err = oc.newBidCollection1("buy")
+		if err != nil {
+			metricsOutputVal.ProblematicBidCalcCount[oc.args.Slot]++
+			return shim.Error(err.Error())
+		}
+		sellerBids, err = oc.newBidCollection1("sell")
+		if err != nil {
+			metricsOutputVal.ProblematicBidCalcCount[oc.args.Slot]++
+			return shim.Error(err.Error())
+		}
+	case 2:
+		buyerBids, buyerRejections, err = oc.newBidCollection2("buy", keyPair)
+		if err != nil {
+			metricsOutputVal.ProblematicBidCalcCount[oc.args.Slot]++
+			return shim.Error(err.Error())
+		}
+		sellerBids, sellerRejections, err = oc.newBidCollection2("sell", keyPair)
+		if err != nil {
+			metricsOutputVal.ProblematicBidCalcCount[oc.args.Slot]++
+			return shim.Error(err.Error())
+		}
+	case 3:
+		buyerBids, buyerRejections, err = oc.newBidCollection3("buy")
+		if err != nil {
+			metricsOutputVal.ProblematicBidCalcCount[oc.args.Slot]++
+			return shim.Error(err.Error())
+		}
+		sellerBids, sellerRejections, err = oc.newBidCollection3("sell")
+		if err != nil {
+			metricsOutputVal.ProblematicBidCalcCount[oc.args.Slot]++
+			return shim.Error(err.Error())
+		}
+	}
+
+	// Bids at the same price are ordered by submission
+	if err := oc.stamp(buyerBids); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := oc.stamp(sellerBids); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// The bids that made it into the collections were revealed
+	markEndOutputVal.Deposits, err = oc.settleDeposits(append(append(BidCollection(nil), buyerBids...), sellerBids...))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var buyerUnfunded, sellerUnfunded []schema.RejectionOutput
+	buyerBids, buyerUnfunded = oc.rejectUnfunded(buyerBids, markEndOutputVal.Deposits)
+	sellerBids, sellerUnfunded = oc.rejectUnfunded(sellerBids, markEndOutputVal.Deposits)
+
+	// Exclude the bids that violate the bid rules
+	var buyerViolations, sellerViolations []schema.RejectionOutput
+	buyerBids, buyerViolations, err = oc.validate(buyerBids)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	sellerBids, sellerViolations, err = oc.validate(sellerBids)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	buyerRejections = append(append(buyerRejections, buyerUnfunded...), buyerViolations...)
+	sellerRejections = append(append(sellerRejections, sellerUnfunded...), sellerViolations...)
+	markEndOutputVal.Rejections = append(buyerRejections, sellerRejections...)
+
+	oc.log.Info(fmt.Sprintf("valid bids: %d buyer, %d seller", len(buyerBids), len(sellerBids)))
+	for i, v := range buyerBids {
+		oc.log.Debug(fmt.Sprintf("buyer bid %d: %s", i, v))
+	}
+	for i, v := range sellerBids {
+		oc.log.Debug(fmt.Sprintf("seller bid %d: %s", i, v))
+	}
+
+	// Settle the market for that slot
+	var cleared *Result // Left nil if nothing was cleared
+	if len(sellerBids) > 0 && len(buyerBids) > 0 {
+		res, err := Settle(buyerBids, sellerBids)
+		if err != nil {
+			msg := fmt.Sprintf("cannot find clearing price: %s", err.Error())
+			oc.log.Warn(msg)
+
+			markEndOutputVal.Message = oc.describe(msg)
+		} else { // This is our happy path
+			// Respect the capacity of the distribution network, if there is one
+			topology, err := oc.topology()
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if topology != nil {
+				var networkOutputVal schema.NetworkOutput
+				res, networkOutputVal = NewNetwork(topology).Constrain(res)
+				markEndOutputVal.Network = &networkOutputVal
+				if len(networkOutputVal.CongestedFeeders) > 0 {
+					msg := fmt.Sprintf("congestion on feeders %v curtailed %.6f kWh across %d bids", networkOutputVal.CongestedFeeders, networkOutputVal.CurtailedInKWh, networkOutputVal.CurtailedBidsCount)
+					oc.log.Info(msg)
+				}
+			}
+
+			cleared = &res
+			markEndOutputVal.PricePerUnitInCents = res.PricePerUnit
+			markEndOutputVal.QuantityInKWh = res.Units
+			markEndOutputVal.BuyerFills = fillOutputs(res.BuyerFills)
+			markEndOutputVal.SellerFills = fillOutputs(res.SellerFills)
+			msg := fmt.Sprintf("%.6f kWh were cleared at %.3f ç/kWh ✅", markEndOutputVal.QuantityInKWh, markEndOutputVal.PricePerUnitInCents)
+			oc.log.Info(msg)
+
+			markEndOutputVal.Message = oc.describe(msg)
+		}
+	} else {
+		msg := fmt.Sprintf("no market (buyer bids: %d, seller bids: %d) 😔", len(buyerBids), len(sellerBids))
+		oc.log.Info(msg)
+
+		markEndOutputVal.Message = oc.describe(msg)
+	}
+
+	markEndOutputVal.Payments, err = oc.settlePayments(buyerBids, sellerBids, cleared, markEndInputVal.GridPrices)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	markEndOutputValB, err := oc.Marshal(&markEndOutputVal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := oc.Put(keyAttrs, markEndOutputValB); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := oc.Event(); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(markEndOutputValB)
+}
+
+// topology returns the network topology that was passed to the chaincode
+// during instantiation, or nil if no topology was passed.
+func (oc *opContext) topology() (*schema.Topology, error) {
+	valB, err := oc.Get([]string{schema.TopologyKey})
+	if err != nil || valB == nil {
+		return nil, err
+	}
+
+	var initInputVal schema.InitInput
+	if err := oc.Unmarshal(valB, &initInputVal); err != nil {
+		return nil, err
+	}
+
+	return initInputVal.Topology, nil
+}
+
+// marked returns whether `oc.args.Slot` has been marked as over. A `markEnd`
+// call writes to <slot_number>-<markend>-<tx_id>, so we look for any key with
+// the partial key <slot_number>-<markend>.
+func (oc *opContext) marked() (bool, error) {
+	iter, err := oc.Iter([]string{strconv.Itoa(oc.args.Slot), "-", "markEnd"})
+	if err != nil {
+		metricsOutputVal.ProblematicIterCount[oc.args.Slot]++
+		return false, err
+	}
+	defer iter.Close()
+
+	return iter.HasNext(), nil
+}
+
+func (oc *opContext) newBidCollection1(bidType string) (BidCollection, error) {
+	var resp BidCollection
+
+	keyAttrs := []string{strconv.Itoa(oc.args.Slot), "-", bidType}
+
+	// Populate the encrypted bids and corresponding private keys maps.
+	var encBidVal, postKeyVal map[string][]byte
+	encBidValB, err := oc.Get(keyAttrs)
+	if err != nil {
+		return nil, err
+	}
+
+	if encBidValB == nil {
+		return nil, nil
+	}
+
+	if err := oc.Unmarshal(encBidValB, &encBidVal); err != nil {
+		msg := "cannot unmarshal encoded bids map"
+		oc.log.Debug(msg)
+		return nil, err
+	}
+
+	postKeyValB, err := oc.Get(append(keyAttrs, "-", schema.PostKeySuffix))
+	if err != nil {
+		return nil, err
+	}
+	if err := oc.Unmarshal(postKeyValB, &postKeyVal); err != nil {
+		msg := "cannot unmarshal postKey map"
+		oc.log.Debug(msg)
+		return nil, err
+	}
+
+	// - Iterate over the items in encBidVal
+	// - Retrieve the corresponding private key from postKeyVal
+	// - Decrypt and add to bid collection
+
+	for bidEventID, encBidInputValB := range encBidVal {
+		postKeyInputValB, ok := postKeyVal[bidEventID]
+		if !ok {
+			metricsOutputVal.ProblematicDecryptCount[oc.args.Slot]++
+			continue // ATTN: We do not return
+		}
+		var postKeyInputVal schema.PostKeyInput
+		if err := oc.Unmarshal(postKeyInputValB, &postKeyInputVal); err != nil {
+			msg := fmt.Sprintf("cannot unmarshal 'postKey' value corresponding to event_id: %s", bidEventID)
+			oc.log.Debug(msg)
+			metricsOutputVal.ProblematicDecryptCount[oc.args.Slot]++
+			continue
+		}
+		keyPair, err := DeserializePrivate(postKeyInputVal.PrivKey)
+		if err != nil {
+			msg := fmt.Sprintf("cannot retrieve key-pair from 'postKey' key: %s", err.Error())
+			oc.log.Warn(msg)
+			metricsOutputVal.ProblematicDecryptCount[oc.args.Slot]++
+			continue
+		}
+
+		// Decrypt the bid
+		bidInputValB, err := Decrypt(encBidInputValB, keyPair)
+		if err != nil {
+			msg := fmt.Sprintf("cannot decrypt encoded payload for 'bid' call: %s", err.Error())
+			oc.log.Warn(msg)
+			metricsOutputVal.ProblematicDecryptCount[oc.args.Slot]++
+			continue // ATTN: We do not return
+		}
+
+		var bidInputVal schema.BidInput
+		if err := oc.Unmarshal(bidInputValB, &bidInputVal); err != nil {
+			msg := fmt.Sprintf("cannot unmarshal 'bid' value corresponding to event_id: %s", bidEventID)
+			oc.log.Debug(msg)
+			metricsOutputVal.ProblematicDecryptCount[oc.args.Slot]++
+			continue // ATTN: We do not return
+		}
+
+		// Add bid to bid collection
+		bid := Bid{
+			ID:           bidEventID,
+			BidderID:     bidInputVal.BidderID,
+			PricePerUnit: bidInputVal.PricePerUnitInCents,
+			Units:        bidInputVal.QuantityInKWh,
+		}
+		resp = append(resp, bid)
+		msg := fmt.Sprintf("added bid [%s] to the collection", bid)
+		oc.log.Debug(msg, logging.Bidder(bid.BidderID))
+	}
+
+	return resp, nil
+}
+
+func (oc *opContext) newBidCollection2(bidType string, keyPair *rsa.PrivateKey) (BidCollection, []schema.RejectionOutput, error) {
+	var resp BidCollection
+	var rejections []schema.RejectionOutput
+
+	keyAttrs := []string{strconv.Itoa(oc.args.Slot), "-", bidType}
+	iter, err := oc.Iter([]string{strconv.Itoa(oc.args.Slot), "-", bidType})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iter.Close()
+
+	if !iter.HasNext() {
+		msg := fmt.Sprintf("no values exist for partial bid-key w/ attributes %s", keyAttrs)
+		oc.log.Info(msg)
+		return resp, nil, nil
+	}
+
+	for iter.HasNext() {
+		bidKV, err := iter.Next() // This holds a bid
+		if err != nil {
+			msg := fmt.Sprintf("failed during iteration on bid-key w/ attributes %s: %s", keyAttrs, err.Error())
+			oc.log.Error(msg)
+			metricsOutputVal.ProblematicIterCount[oc.args.Slot]++
+			return nil, nil, errors.New(oc.describe(msg))
+		}
+
+		// The tx ID of the bid is the last attribute of its key
+		bidKeyAttrs, err := oc.Split(bidKV.GetKey())
+		if err != nil {
+			rejections = append(rejections, oc.rejectKey(bidKV.GetKey()))
+			continue // ATTN: We do not return
+		}
+
+		encBidInputValB := bidKV.GetValue()
+		bidInputValB, err := Decrypt(encBidInputValB, keyPair)
+		if err != nil {
+			msg := fmt.Sprintf("cannot decrypt encoded payload for 'bid' call: %s", err.Error())
+			oc.log.Warn(msg)
+			metricsOutputVal.ProblematicDecryptCount[oc.args.Slot]++
+			continue // ATTN: We do not return
+		}
+
+		var bidInputVal schema.BidInput
+		if err := oc.Unmarshal(bidInputValB, &bidInputVal); err != nil {
+			metricsOutputVal.ProblematicDecryptCount[oc.args.Slot]++
+			continue
+		}
+
+		// Add bid to bid collection
+		bid := Bid{
+			ID:           bidKeyAttrs[len(bidKeyAttrs)-1],
+			BidderID:     bidInputVal.BidderID,
+			PricePerUnit: bidInputVal.PricePerUnitInCents,
+			Units:        bidInputVal.QuantityInKWh,
+		}
+		resp = append(resp, bid)
+		msg := fmt.Sprintf("added bid [%s] to the collection", bid)
+		oc.log.Debug(msg, logging.Bidder(bid.BidderID))
+	}
+
+	return resp, rejections, nil
+}
+
+func (oc *opContext) newBidCollection3(bidType string) (BidCollection, []schema.RejectionOutput, error) {
+	var resp BidCollection
+	var rejections []schema.RejectionOutput
+
+	keyAttrs := []string{strconv.Itoa(oc.args.Slot), "-", bidType}
+	iter, err := oc.Iter(keyAttrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iter.Close()
+
+	if !iter.HasNext() {
+		msg := fmt.Sprintf("no values exist for partial bid-key w/ attributes %s", keyAttrs)
+		oc.log.Info(msg)
+		return resp, nil, nil
+	}
+
+	for iter.HasNext() {
+		bidKV, err := iter.Next() // This holds a bid
+		if err != nil {
+			msg := fmt.Sprintf("failed during iteration on bid-key w/ attributes %s: %s", keyAttrs, err.Error())
+			oc.log.Error(msg)
+			metricsOutputVal.ProblematicIterCount[oc.args.Slot]++
+			return nil, nil, errors.New(oc.describe(msg))
+		}
+
+		// Get the private key corresponding to this bid
+		keyPrefixAttrs, err := oc.Split(bidKV.GetKey())
+		if err != nil {
+			rejections = append(rejections, oc.rejectKey(bidKV.GetKey()))
+			continue // ATTN: We do not return
+		}
+
+		// Is this maybe a key holding a private key?
+		// If so, we should ignore it.
+		if len(keyPrefixAttrs) > 0 && (keyPrefixAttrs[len(keyPrefixAttrs)-1] == schema.PostKeySuffix) {
+			continue
+		}
+
+		postKeyOutputValB, err := oc.Get(append(keyPrefixAttrs, "-", schema.PostKeySuffix))
+		if err != nil {
+			metricsOutputVal.ProblematicDecryptCount[oc.args.Slot]++
+			continue
+		}
+		var postKeyOutputVal schema.PostKeyOutput
+		if err := oc.Unmarshal(postKeyOutputValB, &postKeyOutputVal); err != nil {
+			metricsOutputVal.ProblematicDecryptCount[oc.args.Slot]++
+			continue
+		}
+		keyPair, err := DeserializePrivate(postKeyOutputVal.PrivKey)
+		if err != nil {
+			msg := fmt.Sprintf("cannot retrieve key-pair from 'postKey' key: %s", err.Error())
+			oc.log.Warn(msg)
+			metricsOutputVal.ProblematicDecryptCount[oc.args.Slot]++
+			continue
+		}
+
+		// Decrypt the bid
+		encBidInputValB := bidKV.GetValue()
+		bidInputValB, err := Decrypt(encBidInputValB, keyPair)
+		if err != nil {
+			msg := fmt.Sprintf("cannot decrypt encoded payload for 'bid' call: %s", err.Error())
+			oc.log.Warn(msg)
+			metricsOutputVal.ProblematicDecryptCount[oc.args.Slot]++
+			continue
+		}
+
+		var bidInputVal schema.BidInput
+		if err := oc.Unmarshal(bidInputValB, &bidInputVal); err != nil {
+			metricsOutputVal.ProblematicDecryptCount[oc.args.Slot]++
+			continue
+		}
+
+		// Add bid to bid collection
+		bid := Bid{
+			ID:           keyPrefixAttrs[len(keyPrefixAttrs)-1],
+			BidderID:     bidInputVal.BidderID,
+			PricePerUnit: bidInputVal.PricePerUnitInCents,
+			Units:        bidInputVal.QuantityInKWh,
+		}
+		resp = append(resp, bid)
+		msg := fmt.Sprintf("added bid [%s] to the collection", bid)
+		oc.log.Debug(msg, logging.Bidder(bid.BidderID))
+	}
+
+	return resp, rejections, nil
+}
+
+// rejectKey logs a bid whose key cannot be split into its attributes, and
+// returns its rejection. `Split` counts it in the metrics already. We cannot
+// tell the bid's ID, so the rejection carries none.
+func (oc *opContext) rejectKey(key string) schema.RejectionOutput {
+	msg := fmt.Sprintf("rejected bid w/ key %q: %s", key, schema.RejectKey)
+	oc.log.Warn(msg)
+	return schema.RejectionOutput{Reason: schema.RejectKey}
+}
+
+// fillOutputs converts the fills of a clearing into their schema representation.
+func fillOutputs(fills []Fill) []schema.FillOutput {
+	resp := make([]schema.FillOutput, len(fills))
+	for i, f := range fills {
+		resp[i] = schema.FillOutput{
+			BidID:               f.ID,
+			PricePerUnitInCents: f.PricePerUnit,
+			QuantityInKWh:       f.Units,
+			FilledInKWh:         f.Filled,
+		}
+	}
+	return resp
+}
@@ -0,0 +1,40 @@
+package contract
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarked(t *testing.T) {
+	slot := 7
+	stub := shim.NewMockStub("marked", new(Contract))
+	oc := &opContext{stub: stub, args: schema.OpContextInput{Slot: slot}}
+
+	marked, err := oc.marked()
+	require.NoError(t, err)
+	require.False(t, marked)
+
+	// A `markEnd` call writes to <slot_number>-<markend>-<tx_id>, which a
+	// lookup of <slot_number>-<markend> alone would not find
+	key, err := stub.CreateCompositeKey("", []string{strconv.Itoa(slot), "-", "markEnd", "-", "tx1"})
+	require.NoError(t, err)
+	stub.MockTransactionStart("tx1")
+	require.NoError(t, stub.PutState(key, []byte("{}")))
+	stub.MockTransactionEnd("tx1")
+
+	marked, err = oc.marked()
+	require.NoError(t, err)
+	require.True(t, marked)
+
+	// The slot number is an attribute of its own, so slot 70 does not match 7
+	for _, other := range []int{slot - 1, slot + 1, slot * 10} {
+		oc.args.Slot = other
+		marked, err = oc.marked()
+		require.NoError(t, err)
+		require.False(t, marked, "slot %d", other)
+	}
+}
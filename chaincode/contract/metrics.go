@@ -1,4 +1,4 @@
-package main
+package contract
 
 import (
 	"encoding/json"
@@ -13,9 +13,9 @@ import (
 func (oc *opContext) metrics() pp.Response {
 	metricsOutputValB, err := json.Marshal(&metricsOutputVal)
 	if err != nil {
-		msg := fmt.Sprintf("tx_id:%s\tevent_id:%s\tslot:%012d\t• cannot encode response to JSON: %s", oc.txID, oc.args.EventID, oc.args.Slot, err.Error())
-		fmt.Fprintln(w, msg)
-		return shim.Error(msg)
+		msg := fmt.Sprintf("cannot encode response to JSON: %s", err.Error())
+		oc.log.Error(msg)
+		return shim.Error(oc.describe(msg))
 	}
 
 	return shim.Success(metricsOutputValB)
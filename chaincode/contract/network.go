@@ -0,0 +1,170 @@
+package contract
+
+import (
+	"math"
+
+	"github.com/kchristidis/island/chaincode/schema"
+)
+
+// substation is the feeder index for households that connect to the
+// substation directly. Their trades are not subject to line constraints.
+const substation = -1
+
+// Network applies the constraints of a distribution network to a clearing.
+type Network struct {
+	feeders  []schema.Feeder
+	feederOf map[int]int // Maps a bidder ID to the index of its feeder
+	fee      float64
+}
+
+// NewNetwork returns the network that corresponds to a topology.
+func NewNetwork(topology *schema.Topology) *Network {
+	n := &Network{
+		feeders:  topology.Feeders,
+		feederOf: make(map[int]int),
+		fee:      topology.NetworkFeeInCents,
+	}
+	for i, f := range topology.Feeders {
+		for _, id := range f.Households {
+			n.feederOf[id] = i
+		}
+	}
+	return n
+}
+
+func (n *Network) feeder(bidderID int) int {
+	if i, ok := n.feederOf[bidderID]; ok {
+		return i
+	}
+	return substation
+}
+
+// Constrain takes the result of an unconstrained clearing and reduces the
+// fills so that the net flow through every feeder line stays within its
+// capacity. Trades within a feeder are matched first, as they do not use
+// any lines. Trades across feeders are then matched in order of priority,
+// for as long as the lines along their path have capacity left.
+//
+// The clearing price is left as is. Energy traded across feeders incurs the
+// losses of the two lines it flows through, and the network fee, both of which
+// are charged to the buyer: the buyer pays the seller for the energy that it
+// sent, but is delivered it less the losses, and pays the fee on top.
+func (n *Network) Constrain(res Result) (Result, schema.NetworkOutput) {
+	var out schema.NetworkOutput
+
+	bRem := make([]float64, len(res.BuyerFills))
+	bFill := make([]float64, len(res.BuyerFills))
+	bLost := make([]float64, len(res.BuyerFills))
+	bFee := make([]float64, len(res.BuyerFills))
+	for i, f := range res.BuyerFills {
+		bRem[i] = f.Filled
+	}
+	sRem := make([]float64, len(res.SellerFills))
+	sFill := make([]float64, len(res.SellerFills))
+	for j, f := range res.SellerFills {
+		sRem[j] = f.Filled
+	}
+
+	netFlow := make([]float64, len(n.feeders)) // Positive when a feeder exports to the substation
+	congested := make([]bool, len(n.feeders))
+
+	// headroom returns how much more can flow out of (direction 1) or
+	// into (direction -1) a feeder.
+	headroom := func(k int, direction float64) float64 {
+		if k == substation {
+			return math.Inf(1)
+		}
+		return math.Max(n.feeders[k].CapacityInKWh-direction*netFlow[k], 0)
+	}
+
+	// match trades up to `limit` units between buyer i and seller j.
+	match := func(i, j int, limit float64) float64 {
+		qty := math.Min(math.Min(bRem[i], sRem[j]), limit)
+		bRem[i] -= qty
+		sRem[j] -= qty
+		bFill[i] += qty
+		sFill[j] += qty
+		return qty
+	}
+
+	// Trades within a feeder
+	for i := range res.BuyerFills {
+		for j := range res.SellerFills {
+			if bRem[i] <= 0 {
+				break
+			}
+			if sRem[j] <= 0 || n.feeder(res.BuyerFills[i].BidderID) != n.feeder(res.SellerFills[j].BidderID) {
+				continue
+			}
+			match(i, j, math.Inf(1))
+		}
+	}
+
+	// Trades across feeders
+	for i := range res.BuyerFills {
+		to := n.feeder(res.BuyerFills[i].BidderID)
+		for j := range res.SellerFills {
+			if bRem[i] <= 0 {
+				break
+			}
+			from := n.feeder(res.SellerFills[j].BidderID)
+			if sRem[j] <= 0 || from == to {
+				continue
+			}
+
+			want := math.Min(bRem[i], sRem[j])
+			hFrom, hTo := headroom(from, 1), headroom(to, -1)
+			if hFrom < want {
+				congested[from] = true
+			}
+			if hTo < want {
+				congested[to] = true
+			}
+
+			qty := match(i, j, math.Min(hFrom, hTo))
+			if qty <= 0 {
+				continue
+			}
+
+			if from != substation {
+				netFlow[from] += qty
+				bLost[i] += qty * n.feeders[from].LossFactor
+			}
+			if to != substation {
+				netFlow[to] -= qty
+				bLost[i] += qty * n.feeders[to].LossFactor
+			}
+			bFee[i] += qty * n.fee
+		}
+	}
+
+	for k, c := range congested {
+		if c {
+			out.CongestedFeeders = append(out.CongestedFeeders, n.feeders[k].ID)
+		}
+	}
+
+	constrained := Result{
+		PricePerUnit: res.PricePerUnit,
+		BuyerFills:   make([]Fill, len(res.BuyerFills)),
+		SellerFills:  make([]Fill, len(res.SellerFills)),
+	}
+	for i, f := range res.BuyerFills {
+		constrained.BuyerFills[i] = Fill{Bid: f.Bid, Filled: bFill[i], Lost: bLost[i], FeeInCents: bFee[i]}
+		constrained.Units += bFill[i]
+		out.LossesInKWh += bLost[i]
+		out.NetworkFeesInCents += bFee[i]
+		if bFill[i] < f.Filled {
+			out.CurtailedBidsCount++
+		}
+	}
+	for j, f := range res.SellerFills {
+		constrained.SellerFills[j] = Fill{Bid: f.Bid, Filled: sFill[j]}
+		if sFill[j] < f.Filled {
+			out.CurtailedBidsCount++
+		}
+	}
+	out.CurtailedInKWh = res.Units - constrained.Units
+
+	return constrained, out
+}
@@ -0,0 +1,179 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/stretchr/testify/require"
+)
+
+// Two feeders: A with households 1 and 2, B with households 3 and 4.
+func testTopology(capacityA float64) *schema.Topology {
+	return &schema.Topology{
+		Feeders: []schema.Feeder{
+			{ID: "A", Households: []int{1, 2}, CapacityInKWh: capacityA, LossFactor: 0.05},
+			{ID: "B", Households: []int{3, 4}, CapacityInKWh: 10, LossFactor: 0.02},
+		},
+		NetworkFeeInCents: 0.5,
+	}
+}
+
+// The unconstrained clearing of the bids in `testBids`.
+func testResult() Result {
+	return Result{
+		PricePerUnit: 8.5,
+		Units:        5,
+		BuyerFills: []Fill{
+			{Bid: Bid{ID: "b1", BidderID: 1, PricePerUnit: 12, Units: 3}, Filled: 3},
+			{Bid: Bid{ID: "b3", BidderID: 3, PricePerUnit: 11, Units: 2}, Filled: 2},
+		},
+		SellerFills: []Fill{
+			{Bid: Bid{ID: "s2", BidderID: 2, PricePerUnit: 5, Units: 1}, Filled: 1},
+			{Bid: Bid{ID: "s4", BidderID: 4, PricePerUnit: 6, Units: 5}, Filled: 4},
+		},
+	}
+}
+
+func filled(fills []Fill) []float64 {
+	var resp []float64
+	for _, f := range fills {
+		resp = append(resp, f.Filled)
+	}
+	return resp
+}
+
+func TestConstrain(t *testing.T) {
+	t.Run("copper plate", func(t *testing.T) {
+		res, out := NewNetwork(&schema.Topology{}).Constrain(testResult())
+		require.Equal(t, testResult(), res)
+		require.Empty(t, out.CongestedFeeders)
+		require.Zero(t, out.CurtailedInKWh)
+		require.Zero(t, out.LossesInKWh)
+	})
+
+	t.Run("within capacity", func(t *testing.T) {
+		res, out := NewNetwork(testTopology(2)).Constrain(testResult())
+		require.Equal(t, 8.5, res.PricePerUnit)
+		require.Equal(t, 5.0, res.Units)
+		require.Equal(t, []float64{3, 2}, filled(res.BuyerFills))
+		require.Equal(t, []float64{1, 4}, filled(res.SellerFills))
+		require.Empty(t, out.CongestedFeeders)
+		require.Zero(t, out.CurtailedBidsCount)
+		require.InDelta(t, 2*0.02+2*0.05, out.LossesInKWh, 1e-9) // Only s4 -> b1 crosses feeders
+		require.InDelta(t, 2*0.5, out.NetworkFeesInCents, 1e-9)
+		require.InDelta(t, out.LossesInKWh, res.BuyerFills[0].Lost, 1e-9) // Charged to b1
+		require.InDelta(t, out.NetworkFeesInCents, res.BuyerFills[0].FeeInCents, 1e-9)
+		require.Zero(t, res.BuyerFills[1].Lost)
+		require.Zero(t, res.BuyerFills[1].FeeInCents)
+	})
+
+	t.Run("congestion", func(t *testing.T) {
+		res, out := NewNetwork(testTopology(1.5)).Constrain(testResult())
+		require.Equal(t, 8.5, res.PricePerUnit)
+		require.InDelta(t, 4.5, res.Units, 1e-9)
+		require.Equal(t, []float64{2.5, 2}, filled(res.BuyerFills))
+		require.Equal(t, []float64{1, 3.5}, filled(res.SellerFills))
+		require.Equal(t, []string{"A"}, out.CongestedFeeders)
+		require.InDelta(t, 0.5, out.CurtailedInKWh, 1e-9)
+		require.Equal(t, 2, out.CurtailedBidsCount)
+		require.InDelta(t, 1.5*0.02+1.5*0.05, out.LossesInKWh, 1e-9)
+	})
+
+	t.Run("substation", func(t *testing.T) {
+		topology := testTopology(0)
+		topology.Feeders = topology.Feeders[1:] // Households 1 and 2 are now on the substation
+		res, out := NewNetwork(topology).Constrain(testResult())
+		require.Equal(t, 5.0, res.Units)
+		require.Empty(t, out.CongestedFeeders)
+		require.InDelta(t, 2*0.02, out.LossesInKWh, 1e-9)
+	})
+}
+
+func TestNetwork(t *testing.T) {
+	slot := 5
+
+	for _, exp := range []int{1, 2, 3} {
+		t.Run(fmt.Sprintf("exp%d", exp), func(t *testing.T) {
+			h := newHarness(t, exp)
+			initInputValB, err := json.Marshal(schema.InitInput{Topology: testTopology(1.5)})
+			require.NoError(t, err)
+			resp := h.stub.MockInit("init", [][]byte{[]byte("init"), initInputValB})
+			require.Equal(t, int32(shim.OK), resp.Status, resp.Message)
+
+			bids := map[string][]string{
+				"b1": h.as("bidder0001").bid("buy", slot, "b1", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 12, QuantityInKWh: 3})),
+				"b3": h.as("bidder0003").bid("buy", slot, "b3", h.encrypt(schema.BidInput{BidderID: 3, PricePerUnitInCents: 11, QuantityInKWh: 2})),
+				"s2": h.as("bidder0002").bid("sell", slot, "s2", h.encrypt(schema.BidInput{BidderID: 2, PricePerUnitInCents: 5, QuantityInKWh: 1})),
+				"s4": h.as("bidder0004").bid("sell", slot, "s4", h.encrypt(schema.BidInput{BidderID: 4, PricePerUnitInCents: 6, QuantityInKWh: 5})),
+			}
+			h.reveal(slot, bids)
+
+			markEndOutputVal := h.markEnd(slot)
+			require.InDelta(t, 4.5, markEndOutputVal.QuantityInKWh, 1e-9)
+			require.NotNil(t, markEndOutputVal.Network)
+			require.Equal(t, []string{"A"}, markEndOutputVal.Network.CongestedFeeders)
+			require.InDelta(t, 0.5, markEndOutputVal.Network.CurtailedInKWh, 1e-9)
+			require.Equal(t, 2, markEndOutputVal.Network.CurtailedBidsCount)
+		})
+	}
+
+	t.Run("losses and fees are charged to the buyer", func(t *testing.T) {
+		h := newHarness(t, 1)
+		h.initPayments(schema.InitInput{Topology: testTopology(2)})
+		h.gridPrices = &schema.GridPrices{RetailInCents: 20, FeedInInCents: 4}
+
+		b1 := schema.EventID("bidder0001", slot, "buy", 0, 1)
+		bids := map[string][]string{
+			b1:   h.as("bidder0001").bid("buy", slot, b1, h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 12, QuantityInKWh: 3})),
+			"b3": h.as("bidder0003").bid("buy", slot, "b3", h.encrypt(schema.BidInput{BidderID: 3, PricePerUnitInCents: 11, QuantityInKWh: 2})),
+			"s2": h.as("bidder0002").bid("sell", slot, "s2", h.encrypt(schema.BidInput{BidderID: 2, PricePerUnitInCents: 5, QuantityInKWh: 1})),
+			"s4": h.as("bidder0004").bid("sell", slot, "s4", h.encrypt(schema.BidInput{BidderID: 4, PricePerUnitInCents: 6, QuantityInKWh: 5})),
+		}
+		h.reveal(slot, bids)
+
+		// b1 buys 2 kWh across feeders: it pays s4 for them, but is delivered
+		// them less 7% in losses, which it imports, and pays the fee on top
+		markEndOutputVal := h.markEnd(slot)
+		require.Equal(t, 5.0, markEndOutputVal.QuantityInKWh)
+		require.InDelta(t, 0.14, markEndOutputVal.Network.LossesInKWh, 1e-9)
+		require.InDelta(t, 1.0, markEndOutputVal.Payments.NetworkFeesInCents, 1e-9)
+		require.InDelta(t, 0.14*20, markEndOutputVal.Payments.GridImportsInCents, 1e-9)
+
+		entries := h.statement("bidder0001", &slot)
+		require.Len(t, entries, 3)
+		require.Equal(t, schema.StatementBuy, entries[0].Kind)
+		require.InDelta(t, -3*8.5, entries[0].AmountInCents, 1e-9)
+		require.Equal(t, schema.StatementNetworkFee, entries[1].Kind)
+		require.InDelta(t, -1.0, entries[1].AmountInCents, 1e-9)
+		require.Equal(t, schema.StatementImport, entries[2].Kind)
+		require.InDelta(t, 0.14, entries[2].QuantityInKWh, 1e-9)
+		require.InDelta(t, -0.14*20, entries[2].AmountInCents, 1e-9)
+
+		require.Len(t, h.statement("bidder0003", &slot), 1) // b3 buys within its feeder
+	})
+
+	t.Run("no topology", func(t *testing.T) {
+		h := newHarness(t, 1)
+		resp := h.stub.MockInit("init", [][]byte{[]byte("init")})
+		require.Equal(t, int32(shim.OK), resp.Status, resp.Message)
+
+		bids := map[string][]string{
+			"b1": h.as("bidder0001").bid("buy", slot, "b1", h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 12, QuantityInKWh: 3})),
+			"s4": h.as("bidder0004").bid("sell", slot, "s4", h.encrypt(schema.BidInput{BidderID: 4, PricePerUnitInCents: 6, QuantityInKWh: 5})),
+		}
+		h.reveal(slot, bids)
+
+		markEndOutputVal := h.markEnd(slot)
+		require.Equal(t, 3.0, markEndOutputVal.QuantityInKWh)
+		require.Nil(t, markEndOutputVal.Network)
+	})
+
+	t.Run("bad init input", func(t *testing.T) {
+		h := newHarness(t, 1)
+		resp := h.stub.MockInit("init", [][]byte{[]byte("init"), []byte("foo")})
+		require.Equal(t, int32(shim.ERROR), resp.Status)
+	})
+}
@@ -0,0 +1,303 @@
+package contract
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pp "github.com/hyperledger/fabric/protos/peer"
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
+)
+
+// paymentRules returns the payment rules that were passed to the chaincode
+// during instantiation, or nil if the market does not settle payments.
+func (oc *opContext) paymentRules() (*schema.PaymentRules, error) {
+	valB, err := oc.Get([]string{schema.PaymentsKey})
+	if err != nil || valB == nil {
+		return nil, err
+	}
+
+	var paymentRulesVal schema.PaymentRules
+	if err := oc.Unmarshal(valB, &paymentRulesVal); err != nil {
+		return nil, err
+	}
+
+	return &paymentRulesVal, nil
+}
+
+// owner returns the account that posted the given bid, i.e. the identity that
+// signed it, as recorded by `putSubmission` and set by `stamp`.
+func (oc *opContext) owner(bid Bid) (string, error) {
+	if bid.Creator == "" {
+		msg := fmt.Sprintf("no account on record for bid w/ id %s", bid.ID)
+		oc.log.Warn(msg)
+		return "", errors.New(oc.describe(msg))
+	}
+	return bid.Creator, nil
+}
+
+// putStatementEntry persists a statement entry of the given account to write-key
+// <statement>-<account_id>-<slot_number>-<tx_id>-<seq>, and adds its amount to
+// the running balance of the account; see `putFunds`.
+func (oc *opContext) putStatementEntry(id string, entry schema.StatementEntry) error {
+	entry.Slot = oc.args.Slot
+	entry.TxID = oc.txID
+
+	entryB, err := oc.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	keyAttrs := []string{schema.StatementKey, "-", id, "-", fmt.Sprintf("%012d", oc.args.Slot), "-", oc.txID, "-", fmt.Sprintf("%06d", oc.entries)}
+	if err := oc.Put(keyAttrs, entryB); err != nil {
+		return err
+	}
+	oc.entries++
+
+	balanceOutputVal, err := oc.funds(id)
+	if err != nil {
+		return err
+	}
+	balanceOutputVal.AvailableInCents += entry.AmountInCents
+	return oc.putFunds(balanceOutputVal)
+}
+
+// - Looks up the payment rules, and returns an error if the market does not
+//		settle payments, or if the identity that signed the call is not the utility
+// - Decodes the JSON-encoded `schema.MintInput` in `oc.args.Data`
+// - Credits `schema.MintInput.AmountInCents` to the running balance of every
+//		account listed, and writes a statement entry for each
+// - Returns JSON-encoded `schema.BalancesOutput` with the running balances of
+//		the accounts listed
+func (oc *opContext) mint() pp.Response {
+	paymentRules, err := oc.paymentRules()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if paymentRules == nil {
+		msg := "the market does not settle payments, nothing to mint"
+		oc.log.Warn(msg)
+		return shim.Error(oc.describe(msg))
+	}
+
+	id, err := oc.creator()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if id != paymentRules.Utility {
+		msg := fmt.Sprintf("only account %s may mint", paymentRules.Utility)
+		oc.log.Warn(msg, logging.F("account", id))
+		return shim.Error(oc.describe(msg))
+	}
+
+	var mintInputVal schema.MintInput
+	if err := oc.Unmarshal(oc.args.Data, &mintInputVal); err != nil {
+		return shim.Error(err.Error())
+	}
+	if mintInputVal.AmountInCents <= 0 || len(mintInputVal.Accounts) == 0 {
+		msg := "nothing to mint: the amount should be positive, and at least one account listed"
+		oc.log.Warn(msg)
+		return shim.Error(oc.describe(msg))
+	}
+
+	var balancesOutputVal schema.BalancesOutput
+	for _, account := range mintInputVal.Accounts {
+		if err := oc.putStatementEntry(account, schema.StatementEntry{
+			Kind:          schema.StatementMint,
+			AmountInCents: mintInputVal.AmountInCents,
+		}); err != nil {
+			return shim.Error(err.Error())
+		}
+		balanceOutputVal, err := oc.funds(account)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		balancesOutputVal.Accounts = append(balancesOutputVal.Accounts, balanceOutputVal)
+	}
+
+	msg := fmt.Sprintf("minted %.3f ç to each of %d accounts", mintInputVal.AmountInCents, len(mintInputVal.Accounts))
+	oc.log.Info(msg)
+
+	balancesOutputValB, err := oc.Marshal(balancesOutputVal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := oc.Event(); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(balancesOutputValB)
+}
+
+// - Looks up the payment rules, and returns nil if the market does not settle payments
+// - Debits every buy bid for its fill at the clearing price, and credits every
+//		sell bid likewise; a nil clearing result means no fills
+// - Debits every buy bid for the network fee of its fill, if any, and credits
+//		the utility with it
+// - If grid prices are given, debits every buy bid for what it was not
+//		delivered at the retail price, i.e. what it was not filled for and what
+//		the lines lost of its fill, and credits every sell bid for what it was
+//		not filled for at the feed-in price; either is settled against the utility
+// - Writes a statement entry for every payment to either side, and updates the
+//		running balances of both
+// - Returns `schema.PaymentsOutput` with the outcome
+func (oc *opContext) settlePayments(buyerBids, sellerBids BidCollection, res *Result, gridPrices *schema.GridPrices) (*schema.PaymentsOutput, error) {
+	paymentRules, err := oc.paymentRules()
+	if err != nil || paymentRules == nil {
+		return nil, err
+	}
+
+	var price float64
+	fills := make(map[string]Fill)
+	if res != nil {
+		price = res.PricePerUnit
+		for _, f := range append(append([]Fill(nil), res.BuyerFills...), res.SellerFills...) {
+			fills[f.ID] = f
+		}
+	}
+
+	// Every payment is a statement entry of its own, and moves the running
+	// balances of either side; see `putStatementEntry`
+	paymentsOutputVal := new(schema.PaymentsOutput)
+	pay := func(id string, entry schema.StatementEntry) error {
+		if entry.AmountInCents == 0 {
+			return nil
+		}
+		return oc.putStatementEntry(id, entry)
+	}
+
+	// The bids pay (sign -1) or get paid (sign 1) for their fills at the
+	// clearing price, and for the rest at the grid's price
+	settle := func(bc BidCollection, kind, gridKind string, sign float64, gridPricePerUnit float64) error {
+		// In a fixed order, so that every peer adds up the same
+		bids := append(BidCollection(nil), bc...)
+		sort.Slice(bids, func(i, j int) bool { return bids[i].ID < bids[j].ID })
+
+		for _, bid := range bids {
+			id, err := oc.owner(bid)
+			if err != nil {
+				return err
+			}
+
+			fill := fills[bid.ID]
+			qty := fill.Filled
+			if err := pay(id, schema.StatementEntry{
+				Kind:                kind,
+				BidID:               bid.ID,
+				QuantityInKWh:       qty,
+				PricePerUnitInCents: price,
+				AmountInCents:       sign * qty * price,
+			}); err != nil {
+				return err
+			}
+			if sign < 0 {
+				paymentsOutputVal.TradedInCents += qty * price
+			}
+
+			// Only buy bids are charged for the network; see `Network.Constrain`
+			if fill.FeeInCents > 0 {
+				entry := schema.StatementEntry{Kind: schema.StatementNetworkFee, BidID: bid.ID, AmountInCents: -fill.FeeInCents}
+				if err := pay(id, entry); err != nil {
+					return err
+				}
+				entry.AmountInCents = fill.FeeInCents
+				if err := pay(paymentRules.Utility, entry); err != nil {
+					return err
+				}
+				paymentsOutputVal.NetworkFeesInCents += fill.FeeInCents
+			}
+
+			delivered := qty - fill.Lost
+			if gridPrices == nil || bid.Units <= delivered {
+				continue
+			}
+			entry := schema.StatementEntry{
+				Kind:                gridKind,
+				BidID:               bid.ID,
+				QuantityInKWh:       bid.Units - delivered,
+				PricePerUnitInCents: gridPricePerUnit,
+				AmountInCents:       sign * (bid.Units - delivered) * gridPricePerUnit,
+			}
+			if err := pay(id, entry); err != nil {
+				return err
+			}
+			entry.AmountInCents = -entry.AmountInCents
+			if err := pay(paymentRules.Utility, entry); err != nil {
+				return err
+			}
+			if sign < 0 {
+				paymentsOutputVal.GridImportsInCents += entry.AmountInCents
+			} else {
+				paymentsOutputVal.GridExportsInCents -= entry.AmountInCents
+			}
+		}
+		return nil
+	}
+
+	var retail, feedIn float64
+	if gridPrices != nil {
+		retail, feedIn = gridPrices.RetailInCents, gridPrices.FeedInInCents
+	}
+	if err := settle(buyerBids, schema.StatementBuy, schema.StatementImport, -1, retail); err != nil {
+		return nil, err
+	}
+	if err := settle(sellerBids, schema.StatementSell, schema.StatementExport, 1, feedIn); err != nil {
+		return nil, err
+	}
+
+	paymentsOutputVal.EntriesCount = oc.entries
+
+	msg := fmt.Sprintf("settled payments: %.3f ç traded, %.3f ç in grid imports, %.3f ç in grid exports, %.3f ç in network fees", paymentsOutputVal.TradedInCents, paymentsOutputVal.GridImportsInCents, paymentsOutputVal.GridExportsInCents, paymentsOutputVal.NetworkFeesInCents)
+	oc.log.Info(msg)
+
+	return paymentsOutputVal, nil
+}
+
+// - Decodes the JSON-encoded `schema.StatementInput` in `oc.args.Data`
+// - Iterates over partial key <statement>-<account_id>, or
+//		<statement>-<account_id>-<slot_number> if a slot is given
+// - Returns JSON-encoded `schema.StatementOutput`
+func (oc *opContext) statement() pp.Response {
+	var statementInputVal schema.StatementInput
+	if err := oc.Unmarshal(oc.args.Data, &statementInputVal); err != nil {
+		return shim.Error(err.Error())
+	}
+	if statementInputVal.Account == "" {
+		msg := "no account to return the statement of"
+		oc.log.Warn(msg)
+		return shim.Error(oc.describe(msg))
+	}
+
+	keyAttrs := []string{schema.StatementKey, "-", statementInputVal.Account}
+	if statementInputVal.Slot != nil {
+		keyAttrs = append(keyAttrs, "-", fmt.Sprintf("%012d", *statementInputVal.Slot))
+	}
+	iter, err := oc.Iter(keyAttrs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer iter.Close()
+
+	statementOutputVal := schema.StatementOutput{Account: statementInputVal.Account}
+	for iter.HasNext() {
+		entryKV, err := iter.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var entry schema.StatementEntry
+		if err := oc.Unmarshal(entryKV.GetValue(), &entry); err != nil {
+			return shim.Error(err.Error())
+		}
+		statementOutputVal.Entries = append(statementOutputVal.Entries, entry)
+	}
+
+	statementOutputValB, err := oc.Marshal(statementOutputVal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(statementOutputValB)
+}
@@ -0,0 +1,177 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pp "github.com/hyperledger/fabric/protos/peer"
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func (h *harness) initPayments(initInputVal schema.InitInput) {
+	initInputVal.Payments = &schema.PaymentRules{Utility: schema.UtilityAccount}
+	initInputValB, err := json.Marshal(initInputVal)
+	require.NoError(h.t, err)
+	resp := h.stub.MockInit("init", [][]byte{[]byte("init"), initInputValB})
+	require.Equal(h.t, int32(shim.OK), resp.Status, resp.Message)
+}
+
+func (h *harness) mint(agent string, amount float64, accounts ...string) pp.Response {
+	mintInputValB, err := json.Marshal(schema.MintInput{Accounts: accounts, AmountInCents: amount})
+	require.NoError(h.t, err)
+	return h.as(agent).call("invoke", schema.OpContextInput{
+		EventID: schema.EventID(agent, 0, "mint", 0, 1),
+		Action:  "mint",
+		Data:    mintInputValB,
+	})
+}
+
+func (h *harness) statement(account string, slot *int) []schema.StatementEntry {
+	statementInputValB, err := json.Marshal(schema.StatementInput{Account: account, Slot: slot})
+	require.NoError(h.t, err)
+	resp := h.call("query", schema.OpContextInput{EventID: "statement", Action: "statement", Data: statementInputValB})
+	require.Equal(h.t, int32(shim.OK), resp.Status, resp.Message)
+
+	var statementOutputVal schema.StatementOutput
+	require.NoError(h.t, json.Unmarshal(resp.Payload, &statementOutputVal))
+	require.Equal(h.t, account, statementOutputVal.Account)
+	return statementOutputVal.Entries
+}
+
+func TestPayments(t *testing.T) {
+	slot := 5
+
+	for _, exp := range []int{1, 2, 3} {
+		t.Run(fmt.Sprintf("exp%d", exp), func(t *testing.T) {
+			h := newHarness(t, exp)
+			h.initPayments(schema.InitInput{})
+			h.gridPrices = &schema.GridPrices{RetailInCents: 20, FeedInInCents: 4}
+
+			resp := h.mint("bidder0001", 100, "bidder0001")
+			require.Equal(t, int32(shim.ERROR), resp.Status)
+			require.Contains(t, resp.Message, "only account utility may mint")
+			resp = h.mint(schema.UtilityAccount, 100, "bidder0001", "bidder0002", "bidder0003")
+			require.Equal(t, int32(shim.OK), resp.Status, resp.Message)
+
+			buy1 := schema.EventID("bidder0001", slot, "buy", 0, 1)
+			sell2 := schema.EventID("bidder0002", slot, "sell", 0, 1)
+			sell3 := schema.EventID("bidder0003", slot, "sell", 0, 1)
+			bids := map[string][]string{
+				buy1:  h.as("bidder0001").bid("buy", slot, buy1, h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2})),
+				sell2: h.as("bidder0002").bid("sell", slot, sell2, h.encrypt(schema.BidInput{BidderID: 2, PricePerUnitInCents: 6, QuantityInKWh: 1.5})),
+				sell3: h.as("bidder0003").bid("sell", slot, sell3, h.encrypt(schema.BidInput{BidderID: 3, PricePerUnitInCents: 50, QuantityInKWh: 1})),
+			}
+			h.reveal(slot, bids)
+
+			// 1.5 kWh clear at 8 ç/kWh; bidder0001 imports the other 0.5 kWh
+			// at 20 ç/kWh, and bidder0003 exports its 1 kWh at 4 ç/kWh
+			markEndOutputVal := h.markEnd(slot)
+			require.Equal(t, 8.0, markEndOutputVal.PricePerUnitInCents)
+			require.NotNil(t, markEndOutputVal.Payments)
+			require.Equal(t, 12.0, markEndOutputVal.Payments.TradedInCents)
+			require.Equal(t, 10.0, markEndOutputVal.Payments.GridImportsInCents)
+			require.Equal(t, 4.0, markEndOutputVal.Payments.GridExportsInCents)
+			require.Equal(t, 6, markEndOutputVal.Payments.EntriesCount)
+
+			require.Equal(t, []schema.BalanceOutput{
+				{Account: "bidder0001", AvailableInCents: 78},
+				{Account: "bidder0002", AvailableInCents: 112},
+				{Account: "bidder0003", AvailableInCents: 104},
+				{Account: schema.UtilityAccount, AvailableInCents: 6},
+			}, h.balances())
+
+			// The running balances add up to the statements
+			for _, balanceOutputVal := range h.balances() {
+				var sum float64
+				for _, entry := range h.statement(balanceOutputVal.Account, nil) {
+					sum += entry.AmountInCents
+				}
+				require.InDelta(t, sum, balanceOutputVal.AvailableInCents, 1e-9, balanceOutputVal.Account)
+			}
+
+			entries := h.statement("bidder0001", nil)
+			require.Len(t, entries, 3)
+			require.Equal(t, schema.StatementMint, entries[0].Kind)
+			require.Equal(t, 100.0, entries[0].AmountInCents)
+			require.Equal(t, schema.StatementBuy, entries[1].Kind)
+			require.Equal(t, 1.5, entries[1].QuantityInKWh)
+			require.Equal(t, -12.0, entries[1].AmountInCents)
+			require.Equal(t, schema.StatementImport, entries[2].Kind)
+			require.Equal(t, 0.5, entries[2].QuantityInKWh)
+			require.Equal(t, -10.0, entries[2].AmountInCents)
+			require.Equal(t, entries[1:], h.statement("bidder0001", &slot))
+
+			entries = h.statement(schema.UtilityAccount, &slot)
+			require.Len(t, entries, 2)
+			require.Equal(t, 10.0, entries[0].AmountInCents)
+			require.Equal(t, -4.0, entries[1].AmountInCents)
+		})
+	}
+
+	t.Run("forfeits go to the utility", func(t *testing.T) {
+		h := newHarness(t, 3)
+		h.initPayments(schema.InitInput{Deposits: &schema.DepositRules{AmountInCents: 10, OpeningBalanceInCents: 25}})
+
+		// Accounts are funded by minting only
+		buy1 := schema.EventID("bidder0001", slot, "buy", 0, 1)
+		h.as("bidder0001").bid("buy", slot, buy1, h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2}))
+		markEndOutputVal := h.markEnd(slot)
+		require.Len(t, markEndOutputVal.Deposits.Unfunded, 1)
+		require.Zero(t, markEndOutputVal.Deposits.ForfeitedInCents)
+
+		next := slot + 1
+		resp := h.mint(schema.UtilityAccount, 30, "bidder0001")
+		require.Equal(t, int32(shim.OK), resp.Status, resp.Message)
+		buy1 = schema.EventID("bidder0001", next, "buy", 0, 1)
+		h.as("bidder0001").bid("buy", next, buy1, h.encrypt(schema.BidInput{BidderID: 1, PricePerUnitInCents: 10, QuantityInKWh: 2}))
+
+		markEndOutputVal = h.markEnd(next) // The key was never posted
+		require.Empty(t, markEndOutputVal.Deposits.Unfunded)
+		require.Equal(t, 10.0, markEndOutputVal.Deposits.ForfeitedInCents)
+		require.Equal(t, []schema.BalanceOutput{
+			{Account: "bidder0001", AvailableInCents: 20, ForfeitedInCents: 10},
+			{Account: schema.UtilityAccount, AvailableInCents: 10},
+		}, h.balances())
+
+		entries := h.statement("bidder0001", &next)
+		require.Len(t, entries, 1)
+		require.Equal(t, schema.StatementForfeit, entries[0].Kind)
+		require.Equal(t, -10.0, entries[0].AmountInCents)
+	})
+
+	t.Run("the utility defaults to the identity that instantiates the contract", func(t *testing.T) {
+		h := newHarness(t, 3)
+		initInputValB, err := json.Marshal(schema.InitInput{Payments: new(schema.PaymentRules)})
+		require.NoError(t, err)
+		resp := h.as("operator").stub.MockInit("init", [][]byte{[]byte("init"), initInputValB})
+		require.Equal(t, int32(shim.OK), resp.Status, resp.Message)
+
+		// The event ID of the call does not make it the utility's
+		mintInputValB, err := json.Marshal(schema.MintInput{Accounts: []string{"bidder0001"}, AmountInCents: 100})
+		require.NoError(t, err)
+		resp = h.as("bidder0001").call("invoke", schema.OpContextInput{
+			EventID: schema.EventID("operator", 0, "mint", 0, 1),
+			Action:  "mint",
+			Data:    mintInputValB,
+		})
+		require.Equal(t, int32(shim.ERROR), resp.Status)
+		require.Contains(t, resp.Message, "only account operator may mint")
+
+		resp = h.mint("operator", 100, "bidder0001")
+		require.Equal(t, int32(shim.OK), resp.Status, resp.Message)
+		require.Equal(t, []schema.BalanceOutput{
+			{Account: "bidder0001", AvailableInCents: 100},
+		}, h.balances())
+	})
+
+	t.Run("off", func(t *testing.T) {
+		h := newHarness(t, 1)
+
+		resp := h.mint(schema.UtilityAccount, 100, "bidder0001")
+		require.Equal(t, int32(shim.ERROR), resp.Status)
+		require.Nil(t, h.markEnd(slot).Payments)
+	})
+}
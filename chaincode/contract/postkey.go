@@ -1,16 +1,14 @@
-package main
+package contract
 
 import (
 	"encoding/json"
-	"fmt"
-	"strconv"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pp "github.com/hyperledger/fabric/protos/peer"
 	"github.com/kchristidis/island/chaincode/schema"
 )
 
-// - Looks up read-key <slot_number>-<markend> and returns error if read-key is found
+// - Looks up partial read-key <slot_number>-<markend> and returns error if read-key is found
 // - Experiment 1:
 //		a. If write-key <PostKeyInput.ReadKey>-<PostKeySuffix> is not found, creates
 //			map[string][]byte as value for that write-key, and writes JSON-encoded
@@ -22,16 +20,16 @@ import (
 //		a. Creates write-key <PostKeyInput.ReadKey>-<PostKeySuffix>
 //		b. Writes JSON-encoded `schema.PostKeyOutput` to write-key
 func (oc *opContext) postKey() pp.Response {
-	valB, err := oc.Get([]string{strconv.Itoa(oc.args.Slot), "-", "markEnd"})
+	marked, err := oc.marked()
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	if valB != nil {
-		msg := fmt.Sprintf("tx_id:%s\tevent_id:%s\tslot:%012d\t• slot marked already, aborting 'postKey' 🛑", oc.txID, oc.args.EventID, oc.args.Slot)
-		fmt.Fprintln(w, msg)
+	if marked {
+		msg := "slot marked already, aborting 'postKey' 🛑"
+		oc.log.Warn(msg)
 		metricsOutputVal.LateTXsCount[oc.args.Slot]++
 		metricsOutputVal.LateDecryptsCount[oc.args.Slot]++
-		return shim.Error(msg)
+		return shim.Error(oc.describe(msg))
 	}
 
 	// The slot has not been marked
@@ -60,7 +58,7 @@ func (oc *opContext) postKey() pp.Response {
 		return shim.Error(err.Error())
 	}
 
-	switch schema.ExpNum {
+	switch expNum {
 	case 1:
 		// Does the key to which we wish to write exist already?
 		var val map[string][]byte
@@ -0,0 +1,29 @@
+package contract
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pp "github.com/hyperledger/fabric/protos/peer"
+)
+
+func (oc *opContext) query() pp.Response {
+	switch oc.args.Action {
+	case "metrics":
+		return oc.metrics()
+	case "slotValues":
+		return oc.slotvalues()
+	case "marked":
+		return oc.slotMarked()
+	case "bids":
+		return oc.slotBids()
+	case "balances":
+		return oc.balances()
+	case "statement":
+		return oc.statement()
+	default:
+		msg := fmt.Sprintf("invalid query action: %s", oc.args.Action)
+		oc.log.Error(msg)
+		return shim.Error(oc.describe(msg))
+	}
+}
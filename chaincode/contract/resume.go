@@ -0,0 +1,115 @@
+package contract
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pp "github.com/hyperledger/fabric/protos/peer"
+	"github.com/kchristidis/island/chaincode/schema"
+)
+
+// The queries in this file let the simulator resume a run that was cut short.
+
+// - Looks up partial read-key <slot_number>-<markend>
+// - Returns JSON-encoded `schema.MarkedOutput`
+func (oc *opContext) slotMarked() pp.Response {
+	marked, err := oc.marked()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	markedOutputValB, err := oc.Marshal(schema.MarkedOutput{
+		Slot:   oc.args.Slot,
+		Marked: marked,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(markedOutputValB)
+}
+
+// - Experiment 1: reads the bids map in write-key <slot_number>-<action>
+// - Experiments 2, 3: iterates over partial read-key <slot_number>-<action>,
+//		skipping the keys that hold private keys
+// - Returns JSON-encoded `schema.BidsOutput` with the encrypted bids
+//		that were posted for `oc.args.Slot`
+func (oc *opContext) slotBids() pp.Response {
+	var bidsOutputVal schema.BidsOutput
+
+	var err error
+	if bidsOutputVal.Buys, err = oc.postedBids("buy"); err != nil {
+		return shim.Error(err.Error())
+	}
+	if bidsOutputVal.Sells, err = oc.postedBids("sell"); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	bidsOutputValB, err := oc.Marshal(bidsOutputVal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(bidsOutputValB)
+}
+
+func (oc *opContext) postedBids(bidType string) ([]schema.PostedBid, error) {
+	var resp []schema.PostedBid
+
+	keyAttrs := []string{strconv.Itoa(oc.args.Slot), "-", bidType}
+
+	if expNum == 1 {
+		valB, err := oc.Get(keyAttrs)
+		if err != nil || valB == nil {
+			return nil, err
+		}
+		var val map[string][]byte
+		if err := oc.Unmarshal(valB, &val); err != nil {
+			return nil, err
+		}
+		for bidEventID, encBidInputValB := range val {
+			resp = append(resp, schema.PostedBid{
+				WriteKeyAttrs: keyAttrs,
+				BidEventID:    bidEventID,
+				Data:          encBidInputValB,
+			})
+		}
+		return resp, nil
+	}
+
+	iter, err := oc.Iter(keyAttrs)
+	if err != nil {
+		metricsOutputVal.ProblematicIterCount[oc.args.Slot]++
+		return nil, err
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		bidKV, err := iter.Next()
+		if err != nil {
+			msg := fmt.Sprintf("failed during iteration on bid-key w/ attributes %s: %s", keyAttrs, err.Error())
+			oc.log.Error(msg)
+			metricsOutputVal.ProblematicIterCount[oc.args.Slot]++
+			return nil, errors.New(oc.describe(msg))
+		}
+
+		bidKeyAttrs, err := oc.Split(bidKV.GetKey())
+		if err != nil {
+			return nil, err
+		}
+
+		// Is this a key holding a private key? If so, skip it.
+		if bidKeyAttrs[len(bidKeyAttrs)-1] == schema.PostKeySuffix {
+			continue
+		}
+
+		resp = append(resp, schema.PostedBid{
+			WriteKeyAttrs: bidKeyAttrs,
+			Data:          bidKV.GetValue(),
+		})
+	}
+
+	return resp, nil
+}
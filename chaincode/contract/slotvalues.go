@@ -1,4 +1,4 @@
-package main
+package contract
 
 import (
 	"fmt"
@@ -22,9 +22,9 @@ func (oc *opContext) slotvalues() pp.Response {
 	defer iter.Close()
 
 	if !iter.HasNext() {
-		msg := fmt.Sprintf("tx_id:%s event_id:%s slot:%012d • no values exist for partial key w/ attributes %s", oc.txID, oc.args.EventID, oc.args.Slot, keyAttrs)
-		fmt.Fprintln(w, msg)
-		return shim.Error(msg)
+		msg := fmt.Sprintf("no values exist for partial key w/ attributes %s", keyAttrs)
+		oc.log.Error(msg)
+		return shim.Error(oc.describe(msg))
 	}
 
 	var slotOutputVal schema.SlotOutput
@@ -32,13 +32,13 @@ func (oc *opContext) slotvalues() pp.Response {
 	for iter.HasNext() {
 		respRange, err := iter.Next()
 		if err != nil {
-			msg := fmt.Sprintf("tx_id:%s event_id:%s slot:%012d • failed during iteration on key w/ attributes %s: %s", oc.txID, oc.args.EventID, oc.args.Slot, keyAttrs, err.Error())
-			fmt.Fprintln(w, msg)
-			return shim.Error(msg)
+			msg := fmt.Sprintf("failed during iteration on key w/ attributes %s: %s", keyAttrs, err.Error())
+			oc.log.Error(msg)
+			return shim.Error(oc.describe(msg))
 		}
 		item := respRange.Value
-		msg := fmt.Sprintf("tx_id:%s event_id:%s slot:%012d • adding tx_id:%s to the response payload for key w/ attributes %s", oc.txID, oc.args.EventID, oc.args.Slot, string(item), keyAttrs)
-		fmt.Fprintln(w, msg)
+		msg := fmt.Sprintf("adding tx_id:%s to the response payload for key w/ attributes %s", string(item), keyAttrs)
+		oc.log.Debug(msg)
 		slotOutputVal.Values = append(slotOutputVal.Values, item)
 	}
 
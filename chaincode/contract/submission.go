@@ -0,0 +1,85 @@
+package contract
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/kchristidis/island/chaincode/schema"
+)
+
+// creatorOf returns the identity that signed the transaction: the common name
+// of the subject of its X.509 certificate. The network has a single
+// organization in this PoC, so the name tells the callers apart.
+func creatorOf(stub shim.ChaincodeStubInterface) (string, error) {
+	cert, err := cid.GetX509Certificate(stub)
+	if err == nil && (cert == nil || cert.Subject.CommonName == "") {
+		err = errors.New("no common name to go by")
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot tell the identity of the caller: %s", err.Error())
+	}
+	return cert.Subject.CommonName, nil
+}
+
+// creator returns the identity of the caller; see `creatorOf`.
+func (oc *opContext) creator() (string, error) {
+	id, err := creatorOf(oc.stub)
+	if err != nil {
+		oc.log.Warn(err.Error())
+		return "", errors.New(oc.describe(err.Error()))
+	}
+	return id, nil
+}
+
+// putSubmission writes JSON-encoded `schema.SubmissionOutput` to write-key
+// <slot_number>-<submission>-<bid_id>, so that the bid rules can go by the
+// identity that posted a bid (see `validate`), and bids at the same price can
+// be ordered by when they were submitted (see `Bid.Before`).
+func (oc *opContext) putSubmission(bidID string) error {
+	creator, err := oc.creator()
+	if err != nil {
+		return err
+	}
+
+	submissionOutputVal := schema.SubmissionOutput{
+		BidID:   bidID,
+		Creator: creator,
+		TxID:    oc.txID,
+	}
+	if ts, err := oc.stub.GetTxTimestamp(); err == nil && ts != nil {
+		submissionOutputVal.TimestampInNanos = ts.GetSeconds()*1e9 + int64(ts.GetNanos())
+	}
+
+	submissionOutputValB, err := oc.Marshal(submissionOutputVal)
+	if err != nil {
+		return err
+	}
+
+	return oc.Put([]string{strconv.Itoa(oc.args.Slot), "-", schema.SubmissionKey, "-", bidID}, submissionOutputValB)
+}
+
+// stamp sets the creator and the submission of every bid in the collection, as
+// recorded by `putSubmission`. A bid without a record on file keeps a zero
+// submission, and is then ordered by its ID.
+func (oc *opContext) stamp(bc BidCollection) error {
+	for i := range bc {
+		valB, err := oc.Get([]string{strconv.Itoa(oc.args.Slot), "-", schema.SubmissionKey, "-", bc[i].ID})
+		if err != nil {
+			return err
+		}
+		if valB == nil {
+			continue
+		}
+		var submissionOutputVal schema.SubmissionOutput
+		if err := oc.Unmarshal(valB, &submissionOutputVal); err != nil {
+			return err
+		}
+		bc[i].Creator = submissionOutputVal.Creator
+		bc[i].SubmittedAt = submissionOutputVal.TimestampInNanos
+		bc[i].TxID = submissionOutputVal.TxID
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package contract
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
+)
+
+// BidRules captures the rules that a decrypted bid is checked against before
+// it is admitted to clearing. A zero value for any field other than
+// MinPricePerUnit disables the corresponding rule.
+type BidRules struct {
+	MinPricePerUnit   float64
+	MaxPricePerUnit   float64
+	MaxUnitsPerBidder float64 // Per slot and bid type, across all of the bids that an identity posted
+	MaxBidsPerBidder  int     // Per slot and bid type, and identity
+	TickSizePerUnit   float64
+}
+
+// Check returns the reason code for the first rule the given bid violates,
+// or the empty string if the bid passes all the stateless rules.
+func (r BidRules) Check(b Bid) string {
+	switch {
+	case math.IsNaN(b.PricePerUnit) || b.PricePerUnit < r.MinPricePerUnit ||
+		(r.MaxPricePerUnit > 0 && b.PricePerUnit > r.MaxPricePerUnit):
+		return schema.RejectPrice
+	case math.IsNaN(b.Units) || math.IsInf(b.Units, 0) || b.Units <= 0:
+		return schema.RejectQuantity
+	case r.TickSizePerUnit > 0 && !onTick(b.PricePerUnit, r.TickSizePerUnit):
+		return schema.RejectTick
+	default:
+		return ""
+	}
+}
+
+// bidRules returns the bid rules that were passed to the chaincode during
+// instantiation or, if there were none, the rules in `rules`.
+func (oc *opContext) bidRules() (BidRules, error) {
+	valB, err := oc.Get([]string{schema.BidRulesKey})
+	if err != nil || valB == nil {
+		return rules, err
+	}
+
+	var bidRulesVal schema.BidRules
+	if err := oc.Unmarshal(valB, &bidRulesVal); err != nil {
+		return rules, err
+	}
+
+	return BidRules{
+		MinPricePerUnit:   bidRulesVal.MinPricePerUnitInCents,
+		MaxPricePerUnit:   bidRulesVal.MaxPricePerUnitInCents,
+		MaxUnitsPerBidder: bidRulesVal.MaxQuantityInKWh,
+		MaxBidsPerBidder:  bidRulesVal.MaxBidsPerIdentity,
+		TickSizePerUnit:   bidRulesVal.TickSizeInCents,
+	}, nil
+}
+
+func onTick(price, tick float64) bool {
+	ticks := price / tick
+	return math.Abs(ticks-math.Round(ticks)) < 1e-9
+}
+
+// validate checks the bids of a collection against the bid rules (see
+// `bidRules`) and returns those that pass. Bids are considered in order of submission, so that the
+// per-bidder rules reject the same bids on every peer, and reject a bidder's
+// latest bids rather than those w/ the highest IDs. Every rejected bid is logged and
+// counted in the metrics for `oc.args.Slot`.
+func (oc *opContext) validate(bc BidCollection) (BidCollection, []schema.RejectionOutput, error) {
+	var resp BidCollection
+	var rejections []schema.RejectionOutput
+
+	rules, err := oc.bidRules()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ordered := make(BidCollection, len(bc))
+	copy(ordered, bc)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Before(ordered[j])
+	})
+
+	// The per-bidder rules go by the identity that posted the bid, as the
+	// bidder ID in the bid is whatever the bidder says it is
+	bidCount := make(map[string]int)
+	unitCount := make(map[string]float64)
+
+	for _, bid := range ordered {
+		reason := rules.Check(bid)
+		if reason == "" {
+			switch {
+			case rules.MaxBidsPerBidder > 0 && bidCount[bid.Creator] >= rules.MaxBidsPerBidder:
+				reason = schema.RejectExcess
+			case rules.MaxUnitsPerBidder > 0 && unitCount[bid.Creator]+bid.Units > rules.MaxUnitsPerBidder:
+				reason = schema.RejectCap
+			}
+		}
+
+		if reason == "" {
+			bidCount[bid.Creator]++
+			unitCount[bid.Creator] += bid.Units
+			resp = append(resp, bid)
+			continue
+		}
+
+		msg := fmt.Sprintf("rejected bid [%s] w/ id %s from bidder %d: %s", bid, bid.ID, bid.BidderID, reason)
+		oc.log.Warn(msg, logging.Bidder(bid.BidderID), logging.F("creator", bid.Creator))
+
+		switch reason {
+		case schema.RejectPrice:
+			metricsOutputVal.RejectedPriceCount[oc.args.Slot]++
+		case schema.RejectQuantity:
+			metricsOutputVal.RejectedQuantityCount[oc.args.Slot]++
+		case schema.RejectCap:
+			metricsOutputVal.RejectedCapCount[oc.args.Slot]++
+		case schema.RejectExcess:
+			metricsOutputVal.RejectedExcessCount[oc.args.Slot]++
+		case schema.RejectTick:
+			metricsOutputVal.RejectedTickCount[oc.args.Slot]++
+		}
+
+		rejections = append(rejections, schema.RejectionOutput{
+			BidID:    bid.ID,
+			BidderID: bid.BidderID,
+			Reason:   reason,
+		})
+	}
+
+	return resp, rejections, nil
+}
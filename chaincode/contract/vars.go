@@ -0,0 +1,25 @@
+package contract
+
+import (
+	"os"
+
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
+)
+
+// Variable definitions go here.
+var (
+	expNum           = schema.ExpNum                                           // The experiment this chaincode is running. Overridden in tests so that all experiments can be exercised.
+	metricsOutputVal schema.MetricsOutput                                      // A singleton that gets populated with metrics during the lifecycle of the chaincode
+	logger           = logging.New(os.Stdout, logging.InfoLevel, logging.JSON) // Log all messages with this logger
+
+	// The rules that the bids are checked against during `markEnd`, unless
+	// other rules were passed to the chaincode during instantiation.
+	rules = BidRules{
+		MinPricePerUnit:   schema.MinPricePerUnitInCents,
+		MaxPricePerUnit:   schema.MaxPricePerUnitInCents,
+		MaxUnitsPerBidder: schema.MaxQuantityInKWh,
+		MaxBidsPerBidder:  schema.MaxBidsPerIdentity,
+		TickSizePerUnit:   schema.TickSizeInCents,
+	}
+)
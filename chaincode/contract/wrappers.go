@@ -0,0 +1,118 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+
+	"github.com/kchristidis/island/chaincode/schema"
+)
+
+// Get retrieves the value associated with the given composite key.
+func (oc *opContext) Get(keyAttrs []string) ([]byte, error) {
+	key, err := oc.stub.CreateCompositeKey("", keyAttrs)
+	if err != nil {
+		msg := fmt.Sprintf("cannot create key w/ attributes %s: %s", keyAttrs, err.Error())
+		oc.log.Error(msg)
+		metricsOutputVal.ProblematicKeyCount[oc.args.Slot]++
+		return nil, errors.New(oc.describe(msg))
+	}
+
+	valB, err := oc.stub.GetState(key)
+	if err != nil {
+		msg := fmt.Sprintf("cannot read key %s: %s", key, err.Error())
+		oc.log.Error(msg)
+		metricsOutputVal.ProblematicGetStateCount[oc.args.Slot]++
+		return nil, errors.New(oc.describe(msg))
+	}
+
+	msg := fmt.Sprintf("read key w/ attributes %s successfully", keyAttrs)
+	oc.log.Debug(msg)
+
+	return valB, nil
+}
+
+// Put persists the value for a given composite key.
+func (oc *opContext) Put(keyAttrs []string, valB []byte) error {
+	key, err := oc.stub.CreateCompositeKey("", keyAttrs)
+	if err != nil {
+		msg := fmt.Sprintf("cannot create key w/ attributes %s: %s", keyAttrs, err.Error())
+		oc.log.Error(msg)
+		metricsOutputVal.ProblematicKeyCount[oc.args.Slot]++
+		return errors.New(oc.describe(msg))
+	}
+
+	if err := oc.stub.PutState(key, valB); err != nil {
+		msg := fmt.Sprintf("cannot persist value to key %s: %s", key, err.Error())
+		oc.log.Error(msg)
+		metricsOutputVal.ProblematicPutStateCount[oc.args.Slot]++
+		return errors.New(oc.describe(msg))
+	}
+
+	msg := fmt.Sprintf("wrote to key w/ attributes %s successfully", keyAttrs)
+	oc.log.Debug(msg)
+
+	return nil
+}
+
+// Iter returns the iterator associated with a given partial composite key.
+func (oc *opContext) Iter(keyAttrs []string) (shim.StateQueryIteratorInterface, error) {
+	iter, err := oc.stub.GetStateByPartialCompositeKey("", keyAttrs)
+	if err != nil {
+		msg := fmt.Sprintf("cannot create iterator for key w/ attributes %s: %s", keyAttrs, err.Error())
+		oc.log.Error(msg)
+		return nil, errors.New(oc.describe(msg))
+	}
+	return iter, nil
+}
+
+// Split returns the components (key attributes) of a composite key.
+func (oc *opContext) Split(key string) ([]string, error) {
+	_, keyAttrs, err := oc.stub.SplitCompositeKey(key)
+	if err != nil {
+		msg := fmt.Sprintf("cannot split key %s: %s", key, err.Error())
+		oc.log.Error(msg)
+		metricsOutputVal.ProblematicKeyCount[oc.args.Slot]++
+		return nil, errors.New(oc.describe(msg))
+	}
+	return keyAttrs, nil
+}
+
+// Marshal wraps the JSON encoder and logs any errors.
+func (oc *opContext) Marshal(val interface{}) ([]byte, error) {
+	valB, err := json.Marshal(val)
+	if err != nil {
+		msg := fmt.Sprintf("cannot encode to JSON: %s", err.Error())
+		oc.log.Error(msg)
+		metricsOutputVal.ProblematicMarshalCount[oc.args.Slot]++
+		return nil, errors.New(oc.describe(msg))
+	}
+	return valB, nil
+}
+
+// Unmarshal wraps the JSON decoder and logs any errors.
+func (oc *opContext) Unmarshal(valB []byte, val interface{}) error {
+	if err := json.Unmarshal(valB, &val); err != nil {
+		msg := fmt.Sprintf("cannot decode JSON: %s", err.Error())
+		oc.log.Error(msg)
+		metricsOutputVal.ProblematicMarshalCount[oc.args.Slot]++
+		return errors.New(oc.describe(msg))
+	}
+	return nil
+}
+
+// Event sends an event.
+func (oc *opContext) Event() error {
+	if schema.EnableEvents {
+		// Notify listeners that the event has been executed
+		err := oc.stub.SetEvent(oc.args.EventID, nil)
+		if err != nil {
+			msg := fmt.Sprintf("cannot create event: %s", err.Error())
+			oc.log.Error(msg)
+			return errors.New(oc.describe(msg))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+// Package logging is a structured, leveled logger that both the simulator and
+// the chaincode log with. It lives under the chaincode tree, and depends on the
+// standard library only, so that it is packaged along with the chaincode. Both
+// sides use the same keys for the fields they share (bidder, slot, event_id,
+// tx_id, attempt), so that their logs can be merged and queried together.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int32
+
+// Supported levels.
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+// String satisfies the fmt.Stringer interface.
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+}
+
+// ParseLevel returns the level with the given name. It is case-insensitive,
+// and accepts the names that Fabric's logging levels go by as well.
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error", "critical", "panic", "fatal":
+		return ErrorLevel, nil
+	default:
+		return 0, fmt.Errorf("unknown log level: %q", name)
+	}
+}
+
+// Format is the encoding of the log entries.
+type Format int
+
+// Supported formats.
+const (
+	Text Format = iota // One human-readable line per entry
+	JSON               // One JSON object per line
+)
+
+// ParseFormat returns the format with the given name: `text`, or `json`.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "text":
+		return Text, nil
+	case "json":
+		return JSON, nil
+	default:
+		return 0, fmt.Errorf("unknown log format: %q", name)
+	}
+}
+
+// Field is a key/value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns a field.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// The fields that the simulator and the chaincode share.
+
+// Component returns a field that names the component logging the entry.
+func Component(name string) Field { return F("component", name) }
+
+// Bidder returns a field with the ID of a bidder.
+func Bidder(id int) Field { return F("bidder", id) }
+
+// Slot returns a field with a slot number.
+func Slot(slot int) Field { return F("slot", slot) }
+
+// EventID returns a field with an event ID; see `schema.EventID`.
+func EventID(eventID string) Field { return F("event_id", eventID) }
+
+// TxID returns a field with a transaction ID.
+func TxID(txID string) Field { return F("tx_id", txID) }
+
+// Attempt returns a field with the attempt number of an invocation.
+func Attempt(attempt int) Field { return F("attempt", attempt) }
+
+// Action returns a field with the action that an invocation carries.
+func Action(action string) Field { return F("action", action) }
+
+// Err returns a field with an error.
+func Err(err error) Field { return F("error", err.Error()) }
+
+// sink is the destination that a logger and the loggers derived from it share.
+type sink struct {
+	mu    sync.Mutex
+	w     io.Writer
+	json  bool
+	level int32 // Accessed atomically, so that it can be changed while logging
+}
+
+// Logger writes structured log entries. It is safe for concurrent use. The zero
+// value is not usable; see New and Nop.
+type Logger struct {
+	sink   *sink
+	fields []Field
+}
+
+// New returns a logger that writes entries at or above the given level to w,
+// in the given format.
+func New(w io.Writer, level Level, format Format) *Logger {
+	return &Logger{sink: &sink{w: w, json: format == JSON, level: int32(level)}}
+}
+
+// Nop returns a logger that discards every entry.
+func Nop() *Logger {
+	return New(ioutil.Discard, ErrorLevel+1, Text)
+}
+
+// With returns a logger that adds the fields to every entry. It shares its
+// destination and level with the logger it is derived from.
+func (l *Logger) With(fields ...Field) *Logger {
+	merged := make([]Field, 0, len(l.fields)+len(fields))
+	merged = append(append(merged, l.fields...), fields...)
+	return &Logger{sink: l.sink, fields: merged}
+}
+
+// SetLevel changes the level of the logger, and of all the loggers that share
+// its destination, at runtime.
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.sink.level, int32(level))
+}
+
+// Enabled reports whether entries at the given level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return int32(level) >= atomic.LoadInt32(&l.sink.level)
+}
+
+// Debug logs an entry at DebugLevel.
+func (l *Logger) Debug(msg string, fields ...Field) { l.log(DebugLevel, msg, fields) }
+
+// Info logs an entry at InfoLevel.
+func (l *Logger) Info(msg string, fields ...Field) { l.log(InfoLevel, msg, fields) }
+
+// Warn logs an entry at WarnLevel.
+func (l *Logger) Warn(msg string, fields ...Field) { l.log(WarnLevel, msg, fields) }
+
+// Error logs an entry at ErrorLevel.
+func (l *Logger) Error(msg string, fields ...Field) { l.log(ErrorLevel, msg, fields) }
+
+func (l *Logger) log(level Level, msg string, fields []Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	all := make([]Field, 0, len(l.fields)+len(fields))
+	all = append(append(all, l.fields...), fields...)
+	ts := time.Now()
+
+	var b bytes.Buffer
+	if l.sink.json {
+		writeJSON(&b, ts, level, msg, all)
+	} else {
+		writeText(&b, ts, level, msg, all)
+	}
+
+	l.sink.mu.Lock()
+	l.sink.w.Write(b.Bytes())
+	l.sink.mu.Unlock()
+}
+
+// writeJSON writes the entry as a JSON object on a line of its own. A field
+// whose key repeats overrides the earlier ones.
+func writeJSON(b *bytes.Buffer, ts time.Time, level Level, msg string, fields []Field) {
+	entry := map[string]interface{}{
+		"ts":    ts.UTC().Format(time.RFC3339Nano),
+		"level": level.String(),
+		"msg":   msg,
+	}
+	for _, f := range fields {
+		entry[f.Key] = jsonValue(f.Value)
+	}
+	entryB, err := json.Marshal(entry) // Keys come out sorted
+	if err != nil {
+		entryB, _ = json.Marshal(map[string]interface{}{
+			"ts":    entry["ts"],
+			"level": entry["level"],
+			"msg":   msg,
+			"error": fmt.Sprintf("cannot encode log fields: %s", err),
+		})
+	}
+	b.Write(entryB)
+	b.WriteByte('\n')
+}
+
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+// writeText writes the entry as a line of the form
+// `<ts> <LEVEL> <key>=<value>... • <msg>`.
+func writeText(b *bytes.Buffer, ts time.Time, level Level, msg string, fields []Field) {
+	b.WriteString(ts.Format("15:04:05.000"))
+	b.WriteByte(' ')
+	fmt.Fprintf(b, "%-5s", strings.ToUpper(level.String()))
+	for _, f := range dedupe(fields) {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		b.WriteString(textValue(f.Value))
+	}
+	b.WriteString(" • ")
+	b.WriteString(msg)
+	b.WriteByte('\n')
+}
+
+// dedupe drops the fields whose key repeats later on, keeping the order of the rest.
+func dedupe(fields []Field) []Field {
+	last := make(map[string]int, len(fields))
+	for i, f := range fields {
+		last[f.Key] = i
+	}
+	if len(last) == len(fields) {
+		return fields
+	}
+	idx := make([]int, 0, len(last))
+	for _, i := range last {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	res := make([]Field, len(idx))
+	for i, j := range idx {
+		res[i] = fields[j]
+	}
+	return res
+}
+
+func textValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,92 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for name, want := range map[string]logging.Level{
+		"debug":    logging.DebugLevel,
+		"INFO":     logging.InfoLevel,
+		"warning":  logging.WarnLevel,
+		"critical": logging.ErrorLevel,
+	} {
+		level, err := logging.ParseLevel(name)
+		require.NoError(t, err)
+		require.Equal(t, want, level)
+	}
+	_, err := logging.ParseLevel("verbose")
+	require.Error(t, err)
+
+	format, err := logging.ParseFormat("JSON")
+	require.NoError(t, err)
+	require.Equal(t, logging.JSON, format)
+	_, err = logging.ParseFormat("xml")
+	require.Error(t, err)
+}
+
+func TestLevels(t *testing.T) {
+	var b bytes.Buffer
+	l := logging.New(&b, logging.InfoLevel, logging.Text)
+	derived := l.With(logging.Component("bidder"))
+
+	derived.Debug("dropped")
+	derived.Info("kept")
+	require.NotContains(t, b.String(), "dropped")
+	require.Contains(t, b.String(), "kept")
+
+	// The level is shared with the loggers derived from the same one
+	l.SetLevel(logging.ErrorLevel)
+	require.False(t, derived.Enabled(logging.WarnLevel))
+	l.SetLevel(logging.DebugLevel)
+	derived.Debug("now kept")
+	require.Contains(t, b.String(), "now kept")
+
+	b.Reset()
+	logging.Nop().Error("dropped")
+	require.Empty(t, b.String())
+}
+
+func TestJSON(t *testing.T) {
+	var b bytes.Buffer
+	l := logging.New(&b, logging.DebugLevel, logging.JSON).With(logging.Component("bidder"), logging.Bidder(3))
+
+	l.Warn("failure! cannot invoke 'buy'",
+		logging.EventID("bidder0003-slot000000000005-buy-0-1"),
+		logging.Slot(5),
+		logging.Attempt(2),
+		logging.Err(errors.New("mvcc_read_conflict")),
+	)
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	require.Len(t, lines, 1)
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	require.Equal(t, "warn", entry["level"])
+	require.Equal(t, "failure! cannot invoke 'buy'", entry["msg"])
+	require.Equal(t, "bidder", entry["component"])
+	require.EqualValues(t, 3, entry["bidder"])
+	require.Equal(t, "bidder0003-slot000000000005-buy-0-1", entry["event_id"])
+	require.EqualValues(t, 5, entry["slot"])
+	require.EqualValues(t, 2, entry["attempt"])
+	require.Equal(t, "mvcc_read_conflict", entry["error"])
+	require.NotEmpty(t, entry["ts"])
+}
+
+func TestText(t *testing.T) {
+	var b bytes.Buffer
+	l := logging.New(&b, logging.DebugLevel, logging.Text).With(logging.Component("regulator"), logging.Slot(4))
+
+	l.Info("new slot!", logging.Slot(5), logging.F("note", "marks slot 4"))
+
+	line := strings.TrimSpace(b.String())
+	require.Contains(t, line, ` INFO  component=regulator slot=5 note="marks slot 4" • new slot!`)
+	require.NotContains(t, line, "slot=4 ")
+}
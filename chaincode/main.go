@@ -1,37 +1,32 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
-	pp "github.com/hyperledger/fabric/protos/peer"
+	"github.com/kchristidis/island/chaincode/contract"
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
 )
 
+// The peer passes its chaincode logging level on to the chaincode container
+// via this environment variable.
+const levelEnv = "CORE_CHAINCODE_LOGGING_LEVEL"
+
 func main() {
-	w = os.Stdout
-	if err := shim.Start(new(Contract)); err != nil {
-		msg := fmt.Sprintf("Cannot establish handler with peer: %s", err)
-		fmt.Fprintln(w, msg)
+	level := logging.InfoLevel
+	if schema.StagingLevel <= schema.Debug {
+		level = logging.DebugLevel
 	}
-}
-
-// Contract satisfies the shim.Chaincode interface
-// so that it is a valid Fabric chaincode.
-type Contract struct{}
-
-// Init carries initialization logic for the chaincode.
-// It is automatically invoked during chaincode instantiation.
-func (c *Contract) Init(stub shim.ChaincodeStubInterface) pp.Response {
-	return shim.Success(nil)
-}
-
-// Invoke is used whenever we wish to interact with the chaincode.
-func (c *Contract) Invoke(stub shim.ChaincodeStubInterface) pp.Response {
-	op, err := newOpContext(stub)
-	if err != nil {
-		return shim.Error(err.Error())
+	if name, ok := os.LookupEnv(levelEnv); ok {
+		if l, err := logging.ParseLevel(name); err == nil {
+			level = l
+		}
 	}
 
-	return op.run()
+	logger := logging.New(os.Stdout, level, logging.JSON).With(logging.Component("chaincode"))
+	contract.SetLogger(logger)
+	if err := shim.Start(new(contract.Contract)); err != nil {
+		logger.Error("cannot establish handler with peer", logging.Err(err))
+	}
 }
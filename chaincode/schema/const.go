@@ -14,20 +14,73 @@ const (
 	BlockOffset   = 70                     // How many blocks into a slot should the 'PostKey' notification come up?
 	ClockPeriod   = 100 * time.Millisecond // How often do we invoke the clock method to help with the creation of new blocks?
 	SleepDuration = 100 * time.Millisecond // How often do we check for new blocks?
+	SlotDuration  = 15 * time.Minute       // How much time does a slot cover? The trace is resampled to this duration.
 
-	TraceLength         = 35    // How many slots do we wish to process? Max value allowed is trace.RowCount (35036).
+	TraceLength         = 35    // How many slots do we wish to process? Max value allowed is the length of the (resampled) trace, e.g. 35036 for the default one.
 	StagingLevel        = Debug // Identifies the staging level for the experiment.
 	DebugBidderIDsCount = 5     // If in debugging mode, work only with the first DebugBidderIDsCount bidders in our set.
 
-	PostKeySuffix = "privkey" // The suffix we use for the write-key in `postKey` calls. Separated with the prefix using a dash.
-	EnableEvents  = false     // Used to enable/disable the emission of chaincode events.
+	PostKeySuffix = "privkey"    // The suffix we use for the write-key in `postKey` calls. Separated with the prefix using a dash.
+	TopologyKey   = "topology"   // The key that the network topology passed to `Init` is persisted to.
+	SubmissionKey = "submission" // The key that the transaction that posted a bid is persisted to. Separated with the slot number using a dash.
+	DepositsKey   = "deposits"   // The key that the deposit rules passed to `Init` are persisted to.
+	DepositKey    = "deposit"    // The key that the deposit of a bid is persisted to. Separated with the account using a dash.
+	PaymentsKey   = "payments"   // The key that the payment rules passed to `Init` are persisted to.
+	BidRulesKey   = "bidrules"   // The key that the bid rules passed to `Init` are persisted to.
+	StatementKey  = "statement"  // The key that the statement entries of an account are persisted to. Separated with the account using a dash.
+	AccountKey    = "account"    // The key that the running balance of an account is persisted to. Separated with the account using a dash.
+	EnableEvents  = false        // Used to enable/disable the emission of chaincode events.
+
+	// Bid rules. Every decrypted bid is checked against these during `markEnd`, unless other
+	// rules are passed to `Init` (see `BidRules`); the bids that violate them are excluded from
+	// clearing. Setting a value to 0 disables the corresponding rule, except for MinPricePerUnitInCents.
+	MinPricePerUnitInCents = 0.0   // The lowest price per unit a bid may carry.
+	MaxPricePerUnitInCents = 100.0 // The highest price per unit a bid may carry.
+	MaxQuantityInKWh       = 25.0  // The most a household may buy (or sell) in a slot, across all its bids.
+	MaxBidsPerIdentity     = 1     // How many buy (or sell) bids a household may place in a slot.
+	TickSizeInCents        = 0.0   // Prices should be multiples of this value.
 
 	// Used to collect block-indexed stats. This is gated because it requires querying every block
 	// and apparently this operation seems to eventually result in a nil pointer dereference in the
-	// peer that ultimately kills it (and ruins your simulation run). Enable with caution.
+	// peer that ultimately kills it (and cuts your simulation run short; see the `-resume` flag).
+	// Enable with caution.
 	EnableBlockStatsCollection = false
 )
 
+// Reason codes for the rejection of a bid during `markEnd`.
+const (
+	RejectPrice    = "price_out_of_bounds" // The price is outside [MinPricePerUnitInCents, MaxPricePerUnitInCents]
+	RejectQuantity = "invalid_quantity"    // The quantity is not a positive number
+	RejectCap      = "quantity_cap"        // The household's bids in the slot add up to more than MaxQuantityInKWh
+	RejectExcess   = "too_many_bids"       // The household has placed more than MaxBidsPerIdentity bids in the slot
+	RejectTick     = "off_tick"            // The price is not a multiple of TickSizeInCents
+	RejectKey      = "malformed_key"       // The key the bid was posted to cannot be parsed; counted as a problematic key
+	RejectUnfunded = "unfunded_deposit"    // The balance of the household's account does not cover the deposit of the bid
+)
+
+// Statuses of a deposit.
+const (
+	DepositLocked    = "locked"    // The bid's slot has not been marked yet
+	DepositRefunded  = "refunded"  // The bid was revealed in time
+	DepositForfeited = "forfeited" // The bid was not revealed in time
+	DepositUnfunded  = "unfunded"  // The balance of the account did not cover the deposit when the slot was marked
+)
+
+// UtilityAccount is the account that the simulator names as the utility in the
+// payment rules, i.e. the one that mints tokens, and that the grid settles against.
+const UtilityAccount = "utility"
+
+// Kinds of statement entries.
+const (
+	StatementMint       = "mint"        // Tokens minted by the utility
+	StatementBuy        = "buy"         // Paid for the fill of a buy bid, at the clearing price
+	StatementSell       = "sell"        // Paid for the fill of a sell bid, at the clearing price
+	StatementImport     = "import"      // Paid for what a buy bid was not delivered, at the retail price
+	StatementExport     = "export"      // Paid for what a sell bid was not filled for, at the feed-in price
+	StatementForfeit    = "forfeit"     // A deposit that was forfeited, and paid to the utility
+	StatementNetworkFee = "network_fee" // Paid by a buy bid to the utility, for the use of the feeder lines
+)
+
 // Level identifies a staging level.
 type Level int
 
@@ -0,0 +1,66 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// EventID returns the ID of the event that marks an attempt at an action that
+// an agent takes in a slot, e.g. `bidder0042-slot000000000017-buy-0-1`. The
+// sequence number tells apart the actions of the same kind that the agent
+// takes in the slot; attempts count from 1. Event IDs are unique within a
+// run, and carry no regex metacharacters, so they are safe to use as event
+// filters.
+func EventID(agent string, slot int, action string, seq, attempt int) string {
+	return fmt.Sprintf("%s-slot%012d-%s-%d-%d", agent, slot, action, seq, attempt)
+}
+
+// EventSlot returns the slot that an event ID was built for, see EventID.
+func EventSlot(eventID string) (int, bool) {
+	for _, part := range strings.Split(eventID, "-") {
+		if len(part) == len("slot")+12 && strings.HasPrefix(part, "slot") {
+			slot, err := strconv.Atoi(strings.TrimPrefix(part, "slot"))
+			return slot, err == nil
+		}
+	}
+	return 0, false
+}
+
+// Event is what an event ID is built from; see EventID.
+type Event struct {
+	Agent   string
+	Slot    int
+	Action  string
+	Seq     int
+	Attempt int
+}
+
+var eventIDRegexp = regexp.MustCompile(`^(.+)-slot(-?[0-9]{11,12})-([^-]+)-([0-9]+)-([0-9]+)$`)
+
+// ParseEventID returns the event that an event ID was built for, see EventID.
+func ParseEventID(eventID string) (Event, error) {
+	m := eventIDRegexp.FindStringSubmatch(eventID)
+	if m == nil {
+		return Event{}, fmt.Errorf("malformed event ID: %q", eventID)
+	}
+	ev := Event{Agent: m[1], Action: m[3]}
+	var err error
+	if ev.Slot, err = strconv.Atoi(m[2]); err != nil {
+		return Event{}, fmt.Errorf("malformed event ID: %q", eventID)
+	}
+	if ev.Seq, err = strconv.Atoi(m[4]); err != nil {
+		return Event{}, fmt.Errorf("malformed event ID: %q", eventID)
+	}
+	if ev.Attempt, err = strconv.Atoi(m[5]); err != nil {
+		return Event{}, fmt.Errorf("malformed event ID: %q", eventID)
+	}
+	return ev, nil
+}
+
+// TxInfo identifies the transaction that an invocation resulted in.
+type TxInfo struct {
+	ID          string // The transaction ID assigned by the ledger.
+	BlockNumber uint64 // The number of the block the transaction was committed in.
+}
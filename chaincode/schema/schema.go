@@ -3,6 +3,7 @@ package schema
 // BidInput is the type that we expect the `oc.args.Data`
 // JSON-encoded argument to a `bid` call to decode to.
 type BidInput struct {
+	BidderID            int // Reported by the bidder for the stats; the bid rules go by the identity that signs the bid
 	PricePerUnitInCents float64
 	QuantityInKWh       float64
 }
@@ -28,10 +29,71 @@ type OpContextInput struct {
 	Data    []byte // Not needed for `metrics` query, or `clock`
 }
 
+// InitInput is the type that we expect the optional second argument
+// to the chaincode's `Init` call to decode to.
+type InitInput struct {
+	Topology *Topology     // If nil, the microgrid is treated as a copper plate
+	Deposits *DepositRules // If nil, bids lock no deposits
+	Payments *PaymentRules // If nil, no money moves
+	BidRules *BidRules     // If nil, the bid rules in const.go apply
+}
+
+// BidRules are the rules that every decrypted bid is checked against during
+// `markEnd`; the bids that violate them are excluded from clearing. Setting a
+// value to 0 disables the corresponding rule, except for MinPricePerUnitInCents.
+// See the constants of the same names in const.go for the defaults.
+type BidRules struct {
+	MinPricePerUnitInCents float64
+	MaxPricePerUnitInCents float64
+	MaxQuantityInKWh       float64 // Per slot and bid type, across all of the bids of a household
+	MaxBidsPerIdentity     int     // Per slot and bid type
+	TickSizeInCents        float64
+}
+
+// DepositRules set the deposit that every bid locks in experiments 1 and 3,
+// until the end of its slot. The deposit is refunded if the bid's key is
+// posted, and the bid can be decrypted, by then; otherwise it is forfeited.
+type DepositRules struct {
+	AmountInCents         float64 // Locked per bid
+	OpeningBalanceInCents float64 // What an account holds when it first bids
+}
+
+// PaymentRules have the market settle every slot in tokens when it is marked
+// as over: buyers pay sellers the clearing price for their fills, and whatever
+// a bid is not filled for is settled with the grid through the utility account.
+// Accounts open empty, and are funded by the utility minting tokens.
+type PaymentRules struct {
+	Utility string // The account that mints tokens, and that the grid settles against; the identity that instantiates the chaincode if empty
+}
+
+// Topology describes the distribution network of the microgrid. Every feeder
+// connects a set of households to the substation via a line. Households that
+// are not assigned to a feeder connect to the substation directly.
+type Topology struct {
+	Feeders           []Feeder
+	NetworkFeeInCents float64 // Charged per kWh that is traded between feeders
+}
+
+// Feeder is a line that connects a set of households to the substation.
+type Feeder struct {
+	ID            string
+	Households    []int   // Identified by their ID in the trace
+	CapacityInKWh float64 // How much energy can flow through the line in a slot, in either direction
+	LossFactor    float64 // The fraction of the energy flowing through the line that is lost
+}
+
 // MarkEndInput is the type that we expect the `oc.args.Data`
 // JSON-encoded argument to a `markEnd` call to decode to.
 type MarkEndInput struct {
-	PrivKey []byte // Not needed for experiments 1, 3
+	PrivKey    []byte      // Not needed for experiments 1, 3
+	GridPrices *GridPrices // Needed to settle with the grid, if the market settles payments
+}
+
+// GridPrices are the prices that the grid buys and sells energy at in a slot.
+// They are encoded as part of `MarkEndInput`.
+type GridPrices struct {
+	RetailInCents float64 // Per kWh bought from the grid
+	FeedInInCents float64 // Per kWh sold to the grid
 }
 
 // MarkEndOutput is the type that we encapsulate `markEnd`'s successful response in.
@@ -42,11 +104,142 @@ type MarkEndOutput struct {
 	WriteKeyAttrs       []string
 	PrivKey             []byte // Not needed for experiments 1, 3
 	PricePerUnitInCents float64
-	QuantityInKWh       float64
+	QuantityInKWh       float64 // The sum of the fills on either side
+	BuyerFills          []FillOutput
+	SellerFills         []FillOutput
+	Rejections          []RejectionOutput // Bids that were excluded from clearing
+	Network             *NetworkOutput    // Only set if the network has a topology
+	Deposits            *DepositsOutput   // Only set if bids lock deposits
+	Payments            *PaymentsOutput   // Only set if the market settles payments
 	Slot                int
 	Message             string
 }
 
+// NetworkOutput captures the effect of the distribution network on a `markEnd` call.
+// It is encoded as part of `MarkEndOutput`.
+type NetworkOutput struct {
+	CongestedFeeders   []string // The feeders whose line capacity limited the trades
+	CurtailedInKWh     float64  // How much less was traded because of the line capacities
+	CurtailedBidsCount int      // How many bids saw their fill reduced because of the line capacities
+	LossesInKWh        float64  // How much energy was lost on the feeder lines, out of what the buyers were delivered
+	NetworkFeesInCents float64  // What the buyers were charged for the use of the feeder lines
+}
+
+// DepositsOutput captures the settlement of the deposits that the bids of a
+// slot locked, during a `markEnd` call. It is encoded as part of `MarkEndOutput`.
+type DepositsOutput struct {
+	RefundedCount    int
+	Forfeits         []DepositOutput // The deposits of the bids that were not revealed
+	ForfeitedInCents float64
+	Unfunded         []DepositOutput // The deposits that the balances of their accounts did not cover; their bids are rejected
+}
+
+// PaymentsOutput captures the payments that settle a slot, during a `markEnd`
+// call. It is encoded as part of `MarkEndOutput`.
+type PaymentsOutput struct {
+	TradedInCents      float64 // Paid by buyers to sellers
+	GridImportsInCents float64 // Paid by buyers to the utility, for what their bids were not delivered
+	GridExportsInCents float64 // Paid by the utility to sellers, as above
+	NetworkFeesInCents float64 // Paid by buyers to the utility, for the energy they bought across feeders
+	EntriesCount       int     // The statement entries that were written
+}
+
+// SubmissionOutput records the transaction that posted a bid. It is encoded as a
+// JSON object and persisted in the chaincode's write-key <slot_number>-<submission>-<bid_id>.
+type SubmissionOutput struct {
+	BidID            string // The event ID (exp 1) or tx ID (exps 2, 3) of the bid
+	Creator          string // The identity that signed the transaction
+	TxID             string
+	TimestampInNanos int64 // The timestamp of the transaction, since the Unix epoch
+}
+
+// DepositOutput is the deposit that a bid locks. It is encoded as a JSON object
+// and persisted in the chaincode's write-key
+// <deposit>-<account_id>-<slot_number>-<bid_id>.
+type DepositOutput struct {
+	BidID         string // The event ID (exp 1) or tx ID (exp 3) of the bid
+	Account       string
+	AmountInCents float64
+	Status        string // One of the `Deposit*` statuses
+}
+
+// BalancesInput is the type that we expect the optional `oc.args.Data`
+// JSON-encoded argument to a `balances` query to decode to.
+type BalancesInput struct {
+	Accounts []string // If empty, all accounts are returned
+}
+
+// BalancesOutput is the type that we encapsulate `balances`'s successful response in.
+// It is encoded as a JSON object and returned to the user via the `shim.Success` method.
+type BalancesOutput struct {
+	Accounts []BalanceOutput // Sorted by account
+}
+
+// BalanceOutput is the balance that the ledger holds for an account. It is
+// persisted to `AccountKey` by the calls that settle funds, i.e. `mint` and
+// `markEnd`, and the deposits that are still locked are folded in when it is
+// looked up. Accounts are named after the identity that signs their calls,
+// e.g. `bidder0042`.
+type BalanceOutput struct {
+	Account          string
+	AvailableInCents float64
+	LockedInCents    float64 // Held as deposits
+	ForfeitedInCents float64 // Lost to deposits that were forfeited, over the lifetime of the account
+}
+
+// MintInput is the type that we expect the `oc.args.Data`
+// JSON-encoded argument to a `mint` call to decode to.
+type MintInput struct {
+	Accounts      []string
+	AmountInCents float64 // Minted to each of the accounts
+}
+
+// StatementInput is the type that we expect the `oc.args.Data`
+// JSON-encoded argument to a `statement` query to decode to.
+type StatementInput struct {
+	Account string
+	Slot    *int // If nil, the entries of all slots are returned
+}
+
+// StatementOutput is the type that we encapsulate `statement`'s successful response in.
+// It is encoded as a JSON object and returned to the user via the `shim.Success` method.
+type StatementOutput struct {
+	Account string
+	Entries []StatementEntry // In the order they were written
+}
+
+// StatementEntry is a payment to or from an account. It is encoded as a JSON
+// object and persisted in the chaincode's write-key
+// <statement>-<account_id>-<slot_number>-<tx_id>-<seq>, where the slot number
+// is zero-padded to twelve digits so that the entries sort by slot.
+type StatementEntry struct {
+	Slot                int
+	TxID                string
+	Kind                string  // One of the `Statement*` kinds
+	BidID               string  // Set for the entries that settle a bid, or forfeit its deposit
+	QuantityInKWh       float64 // Set for the entries that settle a bid
+	PricePerUnitInCents float64 // As above
+	AmountInCents       float64 // Credited to the account if positive, debited if negative
+}
+
+// FillOutput captures the allocation for a single bid during a `markEnd` call.
+// It is encoded as part of `MarkEndOutput`.
+type FillOutput struct {
+	BidID               string // The event ID (exp 1) or tx ID (exps 2, 3) of the bid
+	PricePerUnitInCents float64
+	QuantityInKWh       float64 // What the bid asked for
+	FilledInKWh         float64 // What the bid was allocated
+}
+
+// RejectionOutput captures a bid that was rejected during a `markEnd` call
+// because it violates the bid rules, or because its key cannot be parsed. It
+// is encoded as part of `MarkEndOutput`.
+type RejectionOutput struct {
+	BidID    string // The event ID (exp 1) or tx ID (exps 2, 3) of the bid; empty if its key cannot be parsed
+	BidderID int
+	Reason   string // One of the `Reject*` reason codes
+}
+
 // MetricsOutput is the type that we encapsulate `metrics`'s successful response in.
 // It is encoded as a JSON object and returned to the user via the `shim.Success` method.
 // It is populated during the bidding process.
@@ -56,6 +249,8 @@ type MetricsOutput struct {
 	ProblematicIterCount, ProblematicMarshalCount                           [TraceLength]int
 	ProblematicDecryptCount, ProblematicBidCalcCount                        [TraceLength]int
 	ProblematicKeyCount, ProblematicGetStateCount, ProblematicPutStateCount [TraceLength]int
+	RejectedPriceCount, RejectedQuantityCount, RejectedCapCount             [TraceLength]int
+	RejectedExcessCount, RejectedTickCount                                  [TraceLength]int
 	// DuplTXsCount, DuplBuysCount, DuplSellsCount [TraceLength]int
 }
 
@@ -79,3 +274,24 @@ type PostKeyOutput struct {
 type SlotOutput struct {
 	Values [][]byte
 }
+
+// MarkedOutput is the type that we encapsulate `marked`'s successful response in.
+// It is encoded as a JSON object and returned to the user via the `shim.Success` method.
+type MarkedOutput struct {
+	Slot   int
+	Marked bool // Whether a `markEnd` call has gone through for the slot
+}
+
+// BidsOutput is the type that we encapsulate `bids`'s successful response in.
+// It is encoded as a JSON object and returned to the user via the `shim.Success` method.
+type BidsOutput struct {
+	Buys, Sells []PostedBid
+}
+
+// PostedBid is a bid as it is persisted in the chaincode's KV store.
+// It is encoded as part of `BidsOutput`.
+type PostedBid struct {
+	WriteKeyAttrs []string // The key the bid was written to
+	BidEventID    string   // Used in exp 1
+	Data          []byte   // The encrypted JSON `BidInput` object
+}
@@ -39,9 +39,10 @@ func New(cap int) (*Container, error) {
 }
 
 // Get returns the value that corresponds to the given key, if it exists.
+// It takes the write lock, as it marks the key as the most recently used.
 func (cm *Container) Get(key interface{}) (interface{}, bool) {
-	cm.rwm.RLock()
-	defer cm.rwm.RUnlock()
+	cm.rwm.Lock()
+	defer cm.rwm.Unlock()
 
 	v, ok := cm.vals[key]
 	if !ok {
@@ -0,0 +1,74 @@
+// Command compare compares the results of several runs against a baseline, and
+// exits with an error if any run regresses beyond the thresholds.
+//
+// Usage:
+//
+//	go run ./cmd/compare [flags] output/exp-01-run-01 output/exp-02-run-01 output/exp-03-run-01
+//
+// A run is referred to by the prefix of its files, or by any one of them. The
+// first run is the baseline.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/kchristidis/island/compare"
+	"github.com/kchristidis/island/runs"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	th := compare.DefaultThresholds()
+
+	var perSlot bool
+	var out string
+
+	flag.Float64Var(&th.Latency, "max-latency", th.Latency, "largest relative increase of the median latency of a transaction type; 0 disables the check")
+	flag.Float64Var(&th.Late, "max-late", th.Late, "largest absolute increase of the share of late transactions; 0 disables the check")
+	flag.Float64Var(&th.Conflicts, "max-conflicts", th.Conflicts, "largest absolute increase of the share of MVCC read conflicts; 0 disables the check")
+	flag.Float64Var(&th.Traded, "max-traded-drop", th.Traded, "largest relative decrease of the energy traded locally; 0 disables the check")
+	flag.Float64Var(&th.Alpha, "alpha", th.Alpha, "significance level of the tests")
+	flag.BoolVar(&perSlot, "slots", false, "include a table of the runs side by side, slot by slot")
+	flag.StringVar(&out, "out", "", "output file for the Markdown report; stdout if empty")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		return errors.New("usage: compare [flags] BASELINE RUN...")
+	}
+
+	var rs []*runs.Run
+	for _, path := range flag.Args() {
+		r, err := runs.Load(path)
+		if err != nil {
+			return err
+		}
+		rs = append(rs, r)
+	}
+
+	c, err := compare.Compare(rs, th)
+	if err != nil {
+		return err
+	}
+
+	report := c.Markdown(perSlot)
+	if out == "" {
+		os.Stdout.Write(report)
+	} else if err := ioutil.WriteFile(out, report, 0644); err != nil {
+		return err
+	}
+
+	if len(c.Regressions) > 0 {
+		return fmt.Errorf("%d regressions against %s", len(c.Regressions), rs[0].Name)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Command report renders the results of a run as a self-contained HTML page,
+// with the charts embedded as SVG.
+//
+// Usage:
+//
+//	go run ./cmd/report output/exp-01-run-01
+//
+// A run is referred to by the prefix of its files, or by any one of them. The
+// page is written next to them, to `exp-01-run-01-report.html`, unless -out is set.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/kchristidis/island/report"
+	"github.com/kchristidis/island/runs"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var out string
+
+	flag.StringVar(&out, "out", "", "output file; `<run>-report.html` if empty")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		return errors.New("usage: report [flags] RUN")
+	}
+
+	r, err := runs.Load(flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	page, err := report.HTML(r)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		out = runs.Prefix(flag.Arg(0)) + runs.SuffixReport
+	}
+	if err := ioutil.WriteFile(out, page, 0644); err != nil {
+		return err
+	}
+	fmt.Println(out)
+	return nil
+}
@@ -0,0 +1,69 @@
+// Command tracegen writes a synthetic trace that the simulator can consume.
+//
+// Usage:
+//
+//	go run ./cmd/tracegen -households 63 -days 30 -pv 0.8 -out trace/synthetic.csv.gz
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kchristidis/island/tracegen"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	cfg := tracegen.DefaultConfig()
+
+	var days int
+	var out string
+
+	flag.IntVar(&cfg.Households, "households", cfg.Households, "number of households")
+	flag.IntVar(&days, "days", 0, "number of days to generate; overrides -slots")
+	flag.IntVar(&cfg.Slots, "slots", cfg.Slots, "number of slots to generate")
+	flag.IntVar(&cfg.FirstID, "first-id", cfg.FirstID, "ID of the first household")
+	flag.IntVar(&cfg.StartDay, "start-day", cfg.StartDay, "day of the year (0-364) of the first slot")
+	flag.Int64Var(&cfg.Seed, "seed", cfg.Seed, "random seed")
+	flag.Float64Var(&cfg.MeanLoadKW, "load", cfg.MeanLoadKW, "average household load in kW")
+	flag.Float64Var(&cfg.PVPenetration, "pv", cfg.PVPenetration, "fraction of households with solar panels")
+	flag.Float64Var(&cfg.PVCapacityKW, "pv-capacity", cfg.PVCapacityKW, "average solar panel capacity in kW")
+	flag.Float64Var(&cfg.CloudCover, "clouds", cfg.CloudCover, "average cloud cover, in [0, 1]")
+	flag.Float64Var(&cfg.FeedIn, "lo", cfg.FeedIn, "feed-in price (lo column) in cents per kWh")
+	flag.Float64Var(&cfg.Retail, "hi", cfg.Retail, "retail price (hi column) in cents per kWh")
+	flag.Float64Var(&cfg.PeakRetail, "hi-peak", cfg.PeakRetail, "retail price between 16:00 and 20:00; 0 means no peak")
+	flag.StringVar(&out, "out", "", "output file; gzip-compressed if it ends in .gz; stdout if empty")
+	flag.Parse()
+
+	if days > 0 {
+		cfg.Slots = days * tracegen.SlotsPerDay
+	}
+
+	var w io.Writer = os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+
+		if strings.HasSuffix(out, ".gz") {
+			zw := gzip.NewWriter(f)
+			defer zw.Close()
+			w = zw
+		}
+	}
+
+	return tracegen.Generate(cfg, w)
+}
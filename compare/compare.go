@@ -0,0 +1,307 @@
+// Package compare compares the results of several runs against a baseline,
+// e.g. experiments 1, 2, and 3, or a contract change against the code it
+// changes. The runs are aligned by slot; latencies are compared with the
+// Mann-Whitney U test, and the energy traded per slot with the Wilcoxon
+// signed-rank test on the paired differences.
+package compare
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/kchristidis/island/runs"
+	"github.com/kchristidis/island/stats"
+)
+
+// Thresholds are how much worse than the baseline a run may get before it is
+// flagged as a regression. Setting a value to 0 disables the check.
+type Thresholds struct {
+	// The relative increase of the median latency of any transaction type,
+	// e.g. 0.2 for 20%. The increase should also be significant.
+	Latency float64
+	// The absolute increase of the share of late transactions, e.g. 0.01 for
+	// one percentage point.
+	Late float64
+	// The absolute increase of the share of MVCC read conflicts.
+	Conflicts float64
+	// The relative decrease of the energy traded locally. The decrease should
+	// also be significant.
+	Traded float64
+
+	// The significance level of the tests.
+	Alpha float64
+}
+
+// DefaultThresholds returns the thresholds that the `compare` command uses by default.
+func DefaultThresholds() Thresholds {
+	return Thresholds{
+		Latency:   0.2,
+		Late:      0.01,
+		Conflicts: 0.01,
+		Traded:    0.05,
+		Alpha:     0.05,
+	}
+}
+
+// LatencyTest compares the latencies of a transaction type in a run against
+// those in the baseline.
+type LatencyTest struct {
+	Run  string
+	Type string
+
+	BaselineP50, P50 int64
+	U, P             float64
+}
+
+// TradedTest compares the energy traded locally in a run against the baseline,
+// slot by slot, over the slots that both have cleared.
+type TradedTest struct {
+	Run string
+
+	Pairs    int
+	MeanDiff float64 // In kWh per slot, run minus baseline
+	W, P     float64
+}
+
+// Comparison is the comparison of several runs against the first one.
+type Comparison struct {
+	Runs      []*runs.Run // The baseline first
+	Summaries []*stats.Summary
+
+	// The slot numbers that any of the runs has cleared, in ascending order.
+	Slots []int
+
+	Latency []LatencyTest
+	Traded  []TradedTest
+
+	Regressions []string
+}
+
+// Compare compares the runs against the first one.
+func Compare(rs []*runs.Run, th Thresholds) (*Comparison, error) {
+	if len(rs) < 2 {
+		return nil, errors.New("need a baseline and at least one run to compare it against")
+	}
+
+	c := &Comparison{Runs: rs}
+	slotSet := make(map[int]bool)
+	for _, r := range rs {
+		c.Summaries = append(c.Summaries, r.Summary())
+		for _, slot := range r.Slots {
+			slotSet[slot.Number] = true
+		}
+	}
+	for slot := range slotSet {
+		c.Slots = append(c.Slots, slot)
+	}
+	sort.Ints(c.Slots)
+
+	base, baseSummary := rs[0], c.Summaries[0]
+	baseLatencies := latencies(base)
+	baseTraded := traded(base)
+
+	for i, r := range rs[1:] {
+		summary := c.Summaries[i+1]
+		flag := func(format string, a ...interface{}) {
+			c.Regressions = append(c.Regressions, fmt.Sprintf("%s: ", r.Name)+fmt.Sprintf(format, a...))
+		}
+
+		runLatencies := latencies(r)
+		for _, txType := range types(baseLatencies, runLatencies) {
+			test := LatencyTest{
+				Run:         r.Name,
+				Type:        txType,
+				BaselineP50: baseSummary.Latency[txType].P50,
+				P50:         summary.Latency[txType].P50,
+			}
+			test.U, test.P = MannWhitney(runLatencies[txType], baseLatencies[txType])
+			c.Latency = append(c.Latency, test)
+
+			if th.Latency > 0 && test.P < th.Alpha && test.BaselineP50 > 0 &&
+				float64(test.P50) > float64(test.BaselineP50)*(1+th.Latency) {
+				flag("the median '%s' latency rose from %d to %d ms (p=%.3g)", txType, test.BaselineP50, test.P50, test.P)
+			}
+		}
+
+		var diffs []float64
+		runTraded := traded(r)
+		for slot, qty := range runTraded {
+			if baseQty, ok := baseTraded[slot]; ok {
+				diffs = append(diffs, qty-baseQty)
+			}
+		}
+		test := TradedTest{Run: r.Name, Pairs: len(diffs)}
+		for _, d := range diffs {
+			test.MeanDiff += d / float64(len(diffs))
+		}
+		test.W, test.P = WilcoxonSignedRank(diffs)
+		c.Traded = append(c.Traded, test)
+
+		if th.Traded > 0 && test.P < th.Alpha &&
+			summary.Energy.Local < baseSummary.Energy.Local*(1-th.Traded) {
+			flag("the energy traded locally fell from %.3f to %.3f kWh (p=%.3g)", baseSummary.Energy.Local, summary.Energy.Local, test.P)
+		}
+		if th.Late > 0 && summary.Late.AllRate > baseSummary.Late.AllRate+th.Late {
+			flag("the share of late transactions rose from %.2f%% to %.2f%%", 100*baseSummary.Late.AllRate, 100*summary.Late.AllRate)
+		}
+		if th.Conflicts > 0 && summary.MVCCConflictRatio > baseSummary.MVCCConflictRatio+th.Conflicts {
+			flag("the share of MVCC read conflicts rose from %.2f%% to %.2f%%", 100*baseSummary.MVCCConflictRatio, 100*summary.MVCCConflictRatio)
+		}
+	}
+
+	return c, nil
+}
+
+// latencies returns the latencies of a run by transaction type.
+func latencies(r *runs.Run) map[string][]float64 {
+	res := make(map[string][]float64)
+	for _, tx := range r.Transactions {
+		if tx.LatencyInMillis >= 0 {
+			res[tx.Type] = append(res[tx.Type], float64(tx.LatencyInMillis))
+		}
+	}
+	return res
+}
+
+// traded returns the energy traded locally in each slot of a run.
+func traded(r *runs.Run) map[int]float64 {
+	res := make(map[int]float64)
+	for _, slot := range r.Slots {
+		res[slot.Number] = slot.EnergyTraded
+	}
+	return res
+}
+
+// types returns the transaction types in either map, sorted.
+func types(a, b map[string][]float64) []string {
+	set := make(map[string]bool)
+	for t := range a {
+		set[t] = true
+	}
+	for t := range b {
+		set[t] = true
+	}
+	var res []string
+	for t := range set {
+		res = append(res, t)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Markdown renders the comparison as a Markdown document. If perSlot is set,
+// it includes a table of the runs side by side, slot by slot.
+func (c *Comparison) Markdown(perSlot bool) []byte {
+	var b bytes.Buffer
+	pct := func(v float64) string { return fmt.Sprintf("%.2f%%", 100*v) }
+
+	row := func(label string, val func(s *stats.Summary) string) {
+		fmt.Fprintf(&b, "| %s |", label)
+		for _, s := range c.Summaries {
+			fmt.Fprintf(&b, " %s |", val(s))
+		}
+		fmt.Fprintln(&b)
+	}
+	header := func(first string) {
+		fmt.Fprintf(&b, "| %s |", first)
+		for _, r := range c.Runs {
+			fmt.Fprintf(&b, " %s |", r.Name)
+		}
+		fmt.Fprintln(&b)
+		fmt.Fprint(&b, "|---|")
+		for range c.Runs {
+			fmt.Fprint(&b, "---:|")
+		}
+		fmt.Fprintln(&b)
+	}
+
+	fmt.Fprintln(&b, "# Run comparison")
+	fmt.Fprintln(&b)
+	fmt.Fprintf(&b, "Baseline: %s.\n", c.Runs[0].Name)
+
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "## Summary")
+	fmt.Fprintln(&b)
+	header("")
+	row("Cleared slots", func(s *stats.Summary) string { return fmt.Sprint(s.Slots) })
+	row("Transactions", func(s *stats.Summary) string { return fmt.Sprint(s.Transactions) })
+	var txTypes []string
+	for txType := range c.Summaries[0].Latency {
+		txTypes = append(txTypes, txType)
+	}
+	sort.Strings(txTypes)
+	for _, txType := range txTypes {
+		txType := txType
+		row(fmt.Sprintf("'%s' latency p50/p90/p99 (ms)", txType), func(s *stats.Summary) string {
+			l := s.Latency[txType]
+			return fmt.Sprintf("%d / %d / %d", l.P50, l.P90, l.P99)
+		})
+	}
+	row("MVCC read conflicts", func(s *stats.Summary) string { return pct(s.MVCCConflictRatio) })
+	row("Late transactions", func(s *stats.Summary) string { return pct(s.Late.AllRate) })
+	row("Energy traded locally (kWh)", func(s *stats.Summary) string { return fmt.Sprintf("%.3f", s.Energy.Local) })
+	row("Self-sufficiency", func(s *stats.Summary) string { return pct(s.Energy.SelfSufficiency) })
+	row("Average clearing price (ç/kWh)", func(s *stats.Summary) string { return fmt.Sprintf("%.3f", s.Prices.Clearing) })
+	row("Social welfare (ç)", func(s *stats.Summary) string { return fmt.Sprintf("%.3f", s.Welfare.Total) })
+
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "## Latency against the baseline (Mann-Whitney U)")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "| Run | Type | Baseline p50 (ms) | p50 (ms) | U | p |")
+	fmt.Fprintln(&b, "|---|---|---:|---:|---:|---:|")
+	for _, t := range c.Latency {
+		fmt.Fprintf(&b, "| %s | %s | %d | %d | %.1f | %.3g |\n", t.Run, t.Type, t.BaselineP50, t.P50, t.U, t.P)
+	}
+
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "## Energy traded against the baseline (Wilcoxon signed-rank, paired by slot)")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "| Run | Slots | Mean difference (kWh) | W+ | p |")
+	fmt.Fprintln(&b, "|---|---:|---:|---:|---:|")
+	for _, t := range c.Traded {
+		fmt.Fprintf(&b, "| %s | %d | %+.3f | %.1f | %.3g |\n", t.Run, t.Pairs, t.MeanDiff, t.W, t.P)
+	}
+
+	if perSlot {
+		fmt.Fprintln(&b)
+		fmt.Fprintln(&b, "## By slot")
+		fmt.Fprintln(&b)
+		fmt.Fprintln(&b, "Energy traded locally (kWh) @ clearing price (ç/kWh), and late transactions; blank if the run did not clear the slot.")
+		fmt.Fprintln(&b)
+		header("Slot")
+		bySlot := make([]map[int]int, len(c.Runs))
+		for i, r := range c.Runs {
+			bySlot[i] = make(map[int]int)
+			for j, slot := range r.Slots {
+				bySlot[i][slot.Number] = j
+			}
+		}
+		for _, slotNum := range c.Slots {
+			fmt.Fprintf(&b, "| %d |", slotNum)
+			for i, r := range c.Runs {
+				j, ok := bySlot[i][slotNum]
+				if !ok {
+					fmt.Fprint(&b, " |")
+					continue
+				}
+				slot := r.Slots[j]
+				fmt.Fprintf(&b, " %.3f @ %.3f, %d late |", slot.EnergyTraded, slot.PriceTraded, r.Counters[j].LateTXs)
+			}
+			fmt.Fprintln(&b)
+		}
+	}
+
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "## Regressions")
+	fmt.Fprintln(&b)
+	if len(c.Regressions) == 0 {
+		fmt.Fprintln(&b, "None.")
+	}
+	for _, r := range c.Regressions {
+		fmt.Fprintf(&b, "* %s\n", r)
+	}
+
+	return b.Bytes()
+}
@@ -0,0 +1,88 @@
+package compare_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/kchristidis/island/compare"
+	"github.com/kchristidis/island/runs"
+	"github.com/kchristidis/island/stats"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMannWhitney(t *testing.T) {
+	u, p := compare.MannWhitney([]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10})
+	require.Equal(t, 0.0, u)
+	require.InDelta(t, 0.0122, p, 1e-4)
+
+	u, p = compare.MannWhitney([]float64{1, 2, 3}, []float64{1, 2, 3})
+	require.Equal(t, 4.5, u)
+	require.Equal(t, 1.0, p)
+
+	_, p = compare.MannWhitney(nil, []float64{1})
+	require.Equal(t, 1.0, p)
+}
+
+func TestWilcoxonSignedRank(t *testing.T) {
+	w, p := compare.WilcoxonSignedRank([]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+	require.Equal(t, 55.0, w)
+	require.InDelta(t, 0.0059, p, 1e-4)
+
+	w, p = compare.WilcoxonSignedRank([]float64{0, -1, 1, -2, 2})
+	require.Equal(t, 5.0, w)
+	require.Equal(t, 1.0, p)
+
+	_, p = compare.WilcoxonSignedRank([]float64{0, 0})
+	require.Equal(t, 1.0, p)
+}
+
+// newRun returns a run with 20 slots, and a 'buy' transaction in each.
+func newRun(name string, latency int64, traded float64, late int) *runs.Run {
+	r := &runs.Run{Name: name}
+	for i := 0; i < 20; i++ {
+		r.Transactions = append(r.Transactions, stats.Transaction{
+			ID:              fmt.Sprintf("bidder0001-slot%012d-buy-0-1", i),
+			Type:            "buy",
+			Status:          "success",
+			LatencyInMillis: latency + int64(i),
+			Attempt:         1,
+		})
+		r.Slots = append(r.Slots, stats.Slot{Number: i, EnergyTraded: traded + float64(i)/10, PriceTraded: 7, Cleared: true})
+		r.Counters = append(r.Counters, stats.SlotCounters{LateTXs: late})
+	}
+	return r
+}
+
+func TestCompare(t *testing.T) {
+	th := compare.DefaultThresholds()
+
+	t.Run("too few runs", func(t *testing.T) {
+		_, err := compare.Compare([]*runs.Run{newRun("base", 100, 1, 0)}, th)
+		require.Error(t, err)
+	})
+
+	t.Run("no regressions", func(t *testing.T) {
+		c, err := compare.Compare([]*runs.Run{newRun("base", 100, 1, 0), newRun("same", 100, 1, 0)}, th)
+		require.NoError(t, err)
+		require.Empty(t, c.Regressions)
+		require.Len(t, c.Slots, 20)
+		require.Equal(t, []compare.TradedTest{{Run: "same", Pairs: 20, P: 1}}, c.Traded)
+		require.Contains(t, string(c.Markdown(true)), "None.")
+	})
+
+	t.Run("regressions", func(t *testing.T) {
+		c, err := compare.Compare([]*runs.Run{newRun("base", 100, 1, 0), newRun("worse", 200, 0.5, 1)}, th)
+		require.NoError(t, err)
+		require.Len(t, c.Regressions, 3)
+		require.Contains(t, c.Regressions[0], "worse: the median 'buy' latency rose from 109 to 209 ms")
+		require.Contains(t, c.Regressions[1], "worse: the energy traded locally fell from 39.000 to 29.000 kWh")
+		require.Contains(t, c.Regressions[2], "worse: the share of late transactions rose from 0.00% to 100.00%")
+		require.InDelta(t, -0.5, c.Traded[0].MeanDiff, 1e-9)
+	})
+
+	t.Run("disabled checks", func(t *testing.T) {
+		c, err := compare.Compare([]*runs.Run{newRun("base", 100, 1, 0), newRun("worse", 200, 0.5, 1)}, compare.Thresholds{Alpha: 0.05})
+		require.NoError(t, err)
+		require.Empty(t, c.Regressions)
+	})
+}
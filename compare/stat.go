@@ -0,0 +1,105 @@
+package compare
+
+import (
+	"math"
+	"sort"
+)
+
+// Both tests use the normal approximation, with a correction for ties and for
+// continuity; this is sound for the sample sizes of a run, i.e. more than 10 or
+// so values per sample. The p-values are two-sided.
+
+// MannWhitney returns the U statistic of the first sample against the second,
+// and the p-value of the hypothesis that neither sample tends to have larger
+// values than the other. The p-value is 1 if either sample is empty.
+func MannWhitney(x, y []float64) (u, p float64) {
+	n1, n2 := float64(len(x)), float64(len(y))
+	if n1 == 0 || n2 == 0 {
+		return 0, 1
+	}
+
+	all := make([]float64, 0, len(x)+len(y))
+	all = append(append(all, x...), y...)
+	ranks, ties := rank(all)
+
+	var r1 float64
+	for i := range x {
+		r1 += ranks[i]
+	}
+	u = r1 - n1*(n1+1)/2
+
+	n := n1 + n2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1)))
+	return u, pValue(u, mean, variance)
+}
+
+// WilcoxonSignedRank returns the W+ statistic of the paired differences, i.e.
+// the sum of the ranks of the positive ones, and the p-value of the hypothesis
+// that the differences are centered at zero. Zero differences are dropped. The
+// p-value is 1 if no differences are left.
+func WilcoxonSignedRank(diffs []float64) (w, p float64) {
+	var abs, signs []float64
+	for _, d := range diffs {
+		if d != 0 {
+			abs = append(abs, math.Abs(d))
+			signs = append(signs, d)
+		}
+	}
+	if len(abs) == 0 {
+		return 0, 1
+	}
+
+	ranks, ties := rank(abs)
+	for i, d := range signs {
+		if d > 0 {
+			w += ranks[i]
+		}
+	}
+
+	n := float64(len(abs))
+	mean := n * (n + 1) / 4
+	variance := n*(n+1)*(2*n+1)/24 - ties/48
+	return w, pValue(w, mean, variance)
+}
+
+// rank returns the ranks of the values, from 1 up, with tied values getting
+// the average of their ranks. It also returns the sum of t^3-t over the groups
+// of t tied values, which the variance of both tests is corrected by.
+func rank(vals []float64) (ranks []float64, ties float64) {
+	idx := make([]int, len(vals))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return vals[idx[i]] < vals[idx[j]] })
+
+	ranks = make([]float64, len(vals))
+	for i := 0; i < len(idx); {
+		j := i + 1
+		for j < len(idx) && vals[idx[j]] == vals[idx[i]] {
+			j++
+		}
+		avg := float64(i+j+1) / 2 // Ranks i+1 through j
+		for k := i; k < j; k++ {
+			ranks[idx[k]] = avg
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+	return ranks, ties
+}
+
+// pValue returns the two-sided p-value of the statistic, under the normal
+// approximation.
+func pValue(stat, mean, variance float64) float64 {
+	if variance <= 0 {
+		return 1
+	}
+	diff := math.Abs(stat-mean) - 0.5 // Continuity correction
+	if diff < 0 {
+		diff = 0
+	}
+	z := diff / math.Sqrt(variance)
+	return math.Erfc(z / math.Sqrt2)
+}
@@ -0,0 +1,130 @@
+// +build !virtual
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kchristidis/island/blockchain"
+	"github.com/kchristidis/island/blocknotifier"
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/kchristidis/island/identity"
+	"github.com/kchristidis/island/stats"
+)
+
+// This file wires the simulation to a Fabric network. Build with `-tags virtual`
+// to run in virtual time against an in-process ledger instead; see virtual.go.
+
+// Whether we are running in virtual time.
+const virtualTime = false
+
+var (
+	sdkctx     *blockchain.SDKContext
+	bNotifiers []*blocknotifier.Notifier
+)
+
+// setupLedger connects to the Fabric network, and installs and instantiates the
+// chaincode with the given init arguments. It returns a function that closes the
+// connection.
+func setupLedger(initArgs [][]byte) (func(), error) {
+	// Every agent signs with an identity of its own, issued by the CA of the
+	// organization, as the bid rules go by identity
+	identities, err := identity.LoadIssuer("clark.example.com",
+		os.Getenv("GOPATH")+"/src/github.com/kchristidis/island/fixtures/crypto-config/peerOrganizations/clark.example.com/ca")
+	if err != nil {
+		return nil, fmt.Errorf("cannot load the CA of the organization: %s", err)
+	}
+
+	sdkctx = &blockchain.SDKContext{
+		SDKConfigFile: "config.yaml",
+
+		OrgName:  "clark",
+		OrgAdmin: "Admin",
+		UserName: "User1",
+
+		OrdererID:   "joe.example.com",
+		ChannelID:   "clark-channel",
+		ChaincodeID: fmt.Sprintf("exp%d", schema.ExpNum),
+
+		ChannelConfigPath:   os.Getenv("GOPATH") + "/src/github.com/kchristidis/island/fixtures/artifacts/clark-channel.tx",
+		ChaincodeGoPath:     os.Getenv("GOPATH"),
+		ChaincodeSourcePath: "github.com/kchristidis/island/chaincode/",
+
+		InitArgs:   initArgs,
+		Resume:     resume,
+		Identities: identities,
+
+		Logger: logger.With(logging.Component("sdk")),
+	}
+	if err := sdkctx.Setup(); err != nil {
+		return nil, err
+	}
+	if err := sdkctx.Install(); err != nil {
+		sdkctx.SDK.Close()
+		return nil, err
+	}
+
+	ledger = sdkctx
+	return sdkctx.SDK.Close, nil
+}
+
+// signAs returns a client of the ledger whose calls are signed by the identity
+// of the given agent; see `blockchain.SDKContext.As`.
+func signAs(agent string) (ledgerClient, error) {
+	client, err := sdkctx.As(agent)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// startClock starts the block notifiers that feed the slot notifiers. When
+// resuming a run, the slots are counted so that the current block starts the
+// first slot of the run.
+func startClock() error {
+	if firstSlot > 0 {
+		info, err := sdkctx.LedgerClient.QueryInfo()
+		if err != nil {
+			return fmt.Errorf("cannot query the ledger height: %s", err)
+		}
+		currentBlock := int64(info.BCI.GetHeight() - 1)
+		start := currentBlock - int64(firstSlot*schema.BlocksPerSlot)
+		if start < 0 {
+			return fmt.Errorf("ledger at block %d is too short for a run that cleared slot %d", currentBlock, firstSlot-1)
+		}
+		startFromBlock = uint64(start)
+	}
+
+	// The size of the slotCs slice dictates how many block notifiers we need
+
+	bNotifiers = append(bNotifiers, blocknotifier.New(
+		schema.BlocksPerSlot, schema.ClockPeriod, schema.SleepDuration, startFromBlock,
+		statsBlockC, slotCs[0],
+		faulty(ledger), sdkctx.LedgerClient,
+		logger,
+	))
+
+	if len(slotCs) > 1 {
+		nilChan := make(chan stats.Block) // This ensures that only the first blockNotifier feeds the stats collector
+		bNotifiers = append(bNotifiers, blocknotifier.New(
+			schema.BlocksPerSlot, schema.ClockPeriod, schema.SleepDuration, startFromBlock+uint64(schema.BlockOffset),
+			nilChan, slotCs[1],
+			faulty(ledger), sdkctx.LedgerClient,
+			logger,
+		))
+	}
+
+	for i := range bNotifiers {
+		agentsWG.Add(1)
+		go func(i int) {
+			if err := bNotifiers[i].Run(runCtx); err != nil {
+				stop(fmt.Sprintf("block-notifier:%d", i))
+			}
+			agentsWG.Done()
+		}(i)
+	}
+
+	return nil
+}
@@ -0,0 +1,462 @@
+// Package faults injects faults into the calls that the agents make to the
+// ledger, so that the robustness of the market can be tested on purpose. An
+// Injector decorates an Invoker and a Querier. Every call goes through the
+// first of the injector's rules that matches the call's agent, action, slot,
+// and attempt, as read off its event ID; the rule may delay the call, and then
+// fail it, drop it, time it out, or submit it twice. Every fault injected is
+// recorded.
+package faults
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
+)
+
+//go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . Invoker
+
+// Invoker is an interface that encapsulates the
+// peer calls that the injector decorates.
+type Invoker interface {
+	Invoke(args schema.OpContextInput) ([]byte, schema.TxInfo, error)
+}
+
+//go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . Querier
+
+// Querier is an interface that encapsulates the
+// ledger queries that the injector decorates.
+type Querier interface {
+	Query(args schema.OpContextInput) ([]byte, error)
+}
+
+// The faults that a rule injects.
+const (
+	FaultDelay     = "delay"     // The call is delayed, and goes through otherwise.
+	FaultFail      = "fail"      // The call is rejected: it is not submitted, and the caller gets ErrInjected.
+	FaultDrop      = "drop"      // The call is lost on its way: it is not submitted, and the caller gets ErrDropped.
+	FaultTimeout   = "timeout"   // The response is lost: the call is submitted, but the caller gets ErrTimeout.
+	FaultDuplicate = "duplicate" // The call is submitted twice; the caller gets the response to the first submission.
+)
+
+// The errors that the callers get for the faults injected.
+var (
+	ErrInjected = errors.New("fault: injected failure")
+	ErrDropped  = errors.New("fault: dropped")
+	ErrTimeout  = errors.New("fault: timed out")
+)
+
+// Supported latency distributions.
+const (
+	DistFixed       = "fixed"       // Always Mean.
+	DistUniform     = "uniform"     // Between Min and Max.
+	DistExponential = "exponential" // Min, plus an exponential with mean Mean.
+	DistNormal      = "normal"      // A normal with mean Mean and standard deviation StdDev, never below Min.
+)
+
+// Latency is a distribution of delays. All values are in milliseconds.
+type Latency struct {
+	Dist string // One of the Dist* constants
+
+	Min, Max     float64
+	Mean, StdDev float64
+}
+
+func (l *Latency) validate() error {
+	switch l.Dist {
+	case DistFixed, DistExponential, DistNormal:
+		if l.Mean < 0 || l.StdDev < 0 || l.Min < 0 {
+			return fmt.Errorf("invalid %s latency: negative parameters", l.Dist)
+		}
+	case DistUniform:
+		if l.Min < 0 || l.Max < l.Min {
+			return fmt.Errorf("invalid uniform latency: need 0 <= Min <= Max, got %g and %g", l.Min, l.Max)
+		}
+	default:
+		return fmt.Errorf("unknown latency distribution: %q", l.Dist)
+	}
+	return nil
+}
+
+func (l *Latency) draw(r *rand.Rand) time.Duration {
+	var millis float64
+	switch l.Dist {
+	case DistFixed:
+		millis = l.Mean
+	case DistUniform:
+		millis = l.Min + r.Float64()*(l.Max-l.Min)
+	case DistExponential:
+		millis = l.Min + r.ExpFloat64()*l.Mean
+	case DistNormal:
+		millis = math.Max(l.Min, l.Mean+r.NormFloat64()*l.StdDev)
+	}
+	return time.Duration(millis * float64(time.Millisecond))
+}
+
+// Rule scripts the faults that are injected into a set of calls. The fields
+// that select the calls are optional; a rule that sets none of them applies to
+// every call.
+type Rule struct {
+	Name string // Recorded along with the faults that the rule injects; `rule-<index>` if empty
+
+	Agents   []string // Patterns that the agent should match, see path.Match, e.g. `bidder0042`, `bidder00*`, `regulator`
+	Actions  []string // e.g. `buy`, `postKey`, `markEnd`, `clock`, `bids`
+	FromSlot *int     // The slots that the agent acts in, inclusive
+	ToSlot   *int
+	Attempts []int // Attempts count from 1
+
+	Latency *Latency // Delays the calls, whatever else happens to them
+
+	// The probabilities of the faults. They add up to at most 1.
+	Fail, Drop, Timeout, Duplicate float64
+}
+
+func (r *Rule) validate() error {
+	for _, pattern := range r.Agents {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid agent pattern %q: %s", pattern, err)
+		}
+	}
+	if r.FromSlot != nil && r.ToSlot != nil && *r.FromSlot > *r.ToSlot {
+		return fmt.Errorf("invalid slots: from %d to %d", *r.FromSlot, *r.ToSlot)
+	}
+	if r.Latency != nil {
+		if err := r.Latency.validate(); err != nil {
+			return err
+		}
+	}
+	var sum float64
+	for _, p := range []float64{r.Fail, r.Drop, r.Timeout, r.Duplicate} {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("invalid probability: %g", p)
+		}
+		sum += p
+	}
+	if sum > 1 {
+		return fmt.Errorf("the probabilities of the faults add up to %g, more than 1", sum)
+	}
+	return nil
+}
+
+func (r *Rule) matches(ev schema.Event) bool {
+	if len(r.Agents) > 0 {
+		var ok bool
+		for _, pattern := range r.Agents {
+			if ok, _ = path.Match(pattern, ev.Agent); ok {
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(r.Actions) > 0 && !containsString(r.Actions, ev.Action) {
+		return false
+	}
+	if (r.FromSlot != nil && ev.Slot < *r.FromSlot) || (r.ToSlot != nil && ev.Slot > *r.ToSlot) {
+		return false
+	}
+	if len(r.Attempts) > 0 && !containsInt(r.Attempts, ev.Attempt) {
+		return false
+	}
+	return true
+}
+
+// draw returns the fault to inject, if any.
+func (r *Rule) draw(rnd *rand.Rand) string {
+	u := rnd.Float64()
+	for _, f := range []struct {
+		fault string
+		p     float64
+	}{
+		{FaultFail, r.Fail},
+		{FaultDrop, r.Drop},
+		{FaultTimeout, r.Timeout},
+		{FaultDuplicate, r.Duplicate},
+	} {
+		if u < f.p {
+			return f.fault
+		}
+		u -= f.p
+	}
+	return ""
+}
+
+// Load reads a list of rules, in JSON, from a file, and validates them.
+func Load(fname string) ([]Rule, error) {
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("cannot decode fault rules in %s: %s", fname, err)
+	}
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %s", fname, i, err)
+		}
+	}
+	return rules, nil
+}
+
+// Record is a fault that was injected into a call.
+type Record struct {
+	EventID string
+	Agent   string
+	Slot    int
+	Action  string
+	Attempt int
+
+	Rule        string
+	Fault       string // One of the Fault* constants
+	DelayMillis int64
+
+	// The outcome of the submission that the caller did not hear back from,
+	// i.e. of the only one for a timeout, and of the second one for a duplicate:
+	// `success`, or the error.
+	Status string `json:",omitempty"`
+}
+
+// Injector decorates an Invoker and a Querier with faults. It is safe for
+// concurrent use.
+type Injector struct {
+	Invoker Invoker
+	Querier Querier
+
+	Rules []Rule // The first rule that matches a call applies to it
+	Seed  int64  // Every call draws from a stream derived from the seed and its event ID
+
+	Record io.Writer // If set, every fault injected is written to it, as a JSON-encoded Record per line
+
+	Logger *logging.Logger
+
+	mu     sync.Mutex
+	counts map[string]int // By fault
+}
+
+// New returns an injector that applies the given rules to the calls that it
+// passes on to the invoker and the querier.
+func New(invoker Invoker, querier Querier, rules []Rule, seed int64, logger *logging.Logger) *Injector {
+	named := make([]Rule, len(rules))
+	copy(named, rules)
+	for i := range named {
+		if named[i].Name == "" {
+			named[i].Name = fmt.Sprintf("rule-%d", i)
+		}
+	}
+
+	return &Injector{
+		Invoker: invoker,
+		Querier: querier,
+
+		Rules: named,
+		Seed:  seed,
+
+		Logger: logger.With(logging.Component("faults")),
+
+		counts: make(map[string]int),
+	}
+}
+
+// Invoke satisfies the Invoker interface.
+func (inj *Injector) Invoke(args schema.OpContextInput) ([]byte, schema.TxInfo, error) {
+	return inj.Wrap(inj.Invoker, inj.Querier).Invoke(args)
+}
+
+// Query satisfies the Querier interface.
+func (inj *Injector) Query(args schema.OpContextInput) ([]byte, error) {
+	return inj.Wrap(inj.Invoker, inj.Querier).Query(args)
+}
+
+// Wrap returns a client that applies the rules of the injector to the calls
+// that it passes on to the given invoker and querier, e.g. those of an agent
+// that signs with an identity of its own. The faults of every client are
+// counted and recorded by the injector.
+func (inj *Injector) Wrap(invoker Invoker, querier Querier) *Client {
+	return &Client{inj: inj, invoker: invoker, querier: querier}
+}
+
+// Client decorates an Invoker and a Querier with the faults of the injector
+// that it was returned by; see `Injector.Wrap`.
+type Client struct {
+	inj     *Injector
+	invoker Invoker
+	querier Querier
+}
+
+// Invoke satisfies the Invoker interface.
+func (c *Client) Invoke(args schema.OpContextInput) ([]byte, schema.TxInfo, error) {
+	res := c.inj.inject(args, func() result {
+		respB, txInfo, err := c.invoker.Invoke(args)
+		return result{respB, txInfo, err}
+	})
+	return res.respB, res.txInfo, res.err
+}
+
+// Query satisfies the Querier interface.
+func (c *Client) Query(args schema.OpContextInput) ([]byte, error) {
+	res := c.inj.inject(args, func() result {
+		respB, err := c.querier.Query(args)
+		return result{respB: respB, err: err}
+	})
+	return res.respB, res.err
+}
+
+// Counts returns the number of faults injected so far, by fault.
+func (inj *Injector) Counts() map[string]int {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	res := make(map[string]int, len(inj.counts))
+	for fault, n := range inj.counts {
+		res[fault] = n
+	}
+	return res
+}
+
+type result struct {
+	respB  []byte
+	txInfo schema.TxInfo
+	err    error
+}
+
+func (res result) status() string {
+	if res.err != nil {
+		return res.err.Error()
+	}
+	return "success"
+}
+
+func (inj *Injector) inject(args schema.OpContextInput, submit func() result) result {
+	// Calls whose event ID does not parse are told apart by their action and slot only
+	ev, err := schema.ParseEventID(args.EventID)
+	if err != nil {
+		ev = schema.Event{Slot: args.Slot, Attempt: 1}
+	}
+	ev.Action = args.Action
+
+	var rule *Rule
+	for i := range inj.Rules {
+		if inj.Rules[i].matches(ev) {
+			rule = &inj.Rules[i]
+			break
+		}
+	}
+	if rule == nil {
+		return submit()
+	}
+
+	rnd := inj.rand(args.EventID)
+	var delay time.Duration
+	if rule.Latency != nil {
+		delay = rule.Latency.draw(rnd)
+	}
+	fault := rule.draw(rnd)
+	if fault == "" {
+		if delay == 0 {
+			return submit()
+		}
+		fault = FaultDelay
+	}
+
+	time.Sleep(delay)
+
+	rec := Record{
+		EventID: args.EventID,
+		Agent:   ev.Agent,
+		Slot:    ev.Slot,
+		Action:  ev.Action,
+		Attempt: ev.Attempt,
+
+		Rule:        rule.Name,
+		Fault:       fault,
+		DelayMillis: int64(delay / time.Millisecond),
+	}
+
+	var res result
+	switch fault {
+	case FaultDelay:
+		res = submit()
+	case FaultFail:
+		res = result{err: ErrInjected}
+	case FaultDrop:
+		res = result{err: ErrDropped}
+	case FaultTimeout:
+		rec.Status = submit().status()
+		res = result{err: ErrTimeout}
+	case FaultDuplicate:
+		res = submit()
+		rec.Status = submit().status()
+	}
+
+	inj.record(rec)
+	return res
+}
+
+// rand returns the stream that the call with the given event ID draws from.
+// Calls thus draw the same faults no matter the order they come in.
+func (inj *Injector) rand(eventID string) *rand.Rand {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s", inj.Seed, eventID)
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+func (inj *Injector) record(rec Record) {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+
+	inj.counts[rec.Fault]++
+
+	fields := []logging.Field{
+		logging.EventID(rec.EventID),
+		logging.Slot(rec.Slot),
+		logging.Action(rec.Action),
+		logging.Attempt(rec.Attempt),
+		logging.F("rule", rec.Rule),
+		logging.F("fault", rec.Fault),
+		logging.F("delay_ms", rec.DelayMillis),
+	}
+	if rec.Status != "" {
+		fields = append(fields, logging.F("status", rec.Status))
+	}
+	inj.Logger.Debug("injected fault", fields...)
+
+	if inj.Record == nil {
+		return
+	}
+	recB, err := json.Marshal(rec)
+	if err == nil {
+		_, err = inj.Record.Write(append(recB, '\n'))
+	}
+	if err != nil {
+		inj.Logger.Error("cannot record injected fault", logging.EventID(rec.EventID), logging.Err(err))
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
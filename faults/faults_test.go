@@ -0,0 +1,205 @@
+package faults_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/kchristidis/island/faults"
+	"github.com/kchristidis/island/faults/faultsfakes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faults")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(content string) string {
+		fname := filepath.Join(dir, "rules.json")
+		require.NoError(t, ioutil.WriteFile(fname, []byte(content), 0644))
+		return fname
+	}
+
+	rules, err := faults.Load(write(`[
+		{"Name": "slow", "Latency": {"Dist": "exponential", "Min": 5, "Mean": 20}},
+		{"Agents": ["bidder00*"], "Actions": ["postKey"], "FromSlot": 10, "ToSlot": 20, "Fail": 0.1, "Duplicate": 0.05}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, "slow", rules[0].Name)
+	require.Equal(t, 10, *rules[1].FromSlot)
+	require.Equal(t, 0.1, rules[1].Fail)
+
+	for _, content := range []string{
+		`[{"Fail": 0.6, "Drop": 0.6}]`,
+		`[{"Timeout": -0.1}]`,
+		`[{"Latency": {"Dist": "pareto"}}]`,
+		`[{"Latency": {"Dist": "uniform", "Min": 10, "Max": 5}}]`,
+		`[{"Agents": ["bidder[0"]}]`,
+		`[{"FromSlot": 5, "ToSlot": 4}]`,
+		`{"Fail": 1}`,
+	} {
+		_, err := faults.Load(write(content))
+		require.Error(t, err, content)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	intP := func(n int) *int { return &n }
+
+	newInjector := func(rules ...faults.Rule) (*faults.Injector, *faultsfakes.FakeInvoker, *faultsfakes.FakeQuerier, *bytes.Buffer) {
+		invoker, querier := new(faultsfakes.FakeInvoker), new(faultsfakes.FakeQuerier)
+		invoker.InvokeReturns([]byte("resp"), schema.TxInfo{ID: "tx", BlockNumber: 7}, nil)
+		querier.QueryReturns([]byte("resp"), nil)
+		inj := faults.New(invoker, querier, rules, 1, logging.Nop())
+		var b bytes.Buffer
+		inj.Record = &b
+		return inj, invoker, querier, &b
+	}
+
+	records := func(b *bytes.Buffer) []faults.Record {
+		var res []faults.Record
+		for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var rec faults.Record
+			require.NoError(t, json.Unmarshal([]byte(line), &rec))
+			res = append(res, rec)
+		}
+		return res
+	}
+
+	args := func(agent string, slot int, action string, attempt int) schema.OpContextInput {
+		return schema.OpContextInput{
+			EventID: schema.EventID(agent, slot, action, 0, attempt),
+			Action:  action,
+			Slot:    slot,
+		}
+	}
+
+	t.Run("targeted failures", func(t *testing.T) {
+		inj, invoker, _, b := newInjector(faults.Rule{
+			Name:     "postkey-outage",
+			Agents:   []string{"bidder00*"},
+			Actions:  []string{"postKey"},
+			FromSlot: intP(2),
+			ToSlot:   intP(3),
+			Attempts: []int{1},
+			Fail:     1,
+		})
+
+		_, _, err := inj.Invoke(args("bidder0001", 2, "postKey", 1))
+		require.Equal(t, faults.ErrInjected, err)
+		require.Equal(t, 0, invoker.InvokeCallCount())
+
+		for _, a := range []schema.OpContextInput{
+			args("bidder0001", 2, "postKey", 2), // The retry goes through
+			args("bidder0001", 4, "postKey", 1),
+			args("bidder0001", 2, "buy", 1),
+			args("bidder0100", 2, "postKey", 1),
+			args("regulator", 2, "postKey", 1),
+		} {
+			respB, txInfo, err := inj.Invoke(a)
+			require.NoError(t, err, a.EventID)
+			require.Equal(t, "resp", string(respB))
+			require.Equal(t, "tx", txInfo.ID)
+		}
+		require.Equal(t, 5, invoker.InvokeCallCount())
+
+		recs := records(b)
+		require.Len(t, recs, 1)
+		require.Equal(t, faults.Record{
+			EventID: "bidder0001-slot000000000002-postKey-0-1",
+			Agent:   "bidder0001",
+			Slot:    2,
+			Action:  "postKey",
+			Attempt: 1,
+			Rule:    "postkey-outage",
+			Fault:   faults.FaultFail,
+		}, recs[0])
+		require.Equal(t, map[string]int{faults.FaultFail: 1}, inj.Counts())
+	})
+
+	t.Run("first match applies", func(t *testing.T) {
+		inj, invoker, _, _ := newInjector(
+			faults.Rule{Agents: []string{"regulator"}},
+			faults.Rule{Drop: 1},
+		)
+
+		_, _, err := inj.Invoke(args("regulator", 2, "markEnd", 1))
+		require.NoError(t, err)
+		_, _, err = inj.Invoke(args("bidder0001", 2, "buy", 1))
+		require.Equal(t, faults.ErrDropped, err)
+		require.Equal(t, 1, invoker.InvokeCallCount())
+		require.Equal(t, "rule-1", inj.Rules[1].Name)
+	})
+
+	t.Run("timeouts and duplicates", func(t *testing.T) {
+		inj, invoker, _, b := newInjector(
+			faults.Rule{Actions: []string{"buy"}, Timeout: 1},
+			faults.Rule{Actions: []string{"sell"}, Duplicate: 1},
+		)
+
+		_, _, err := inj.Invoke(args("bidder0001", 2, "buy", 1))
+		require.Equal(t, faults.ErrTimeout, err)
+		require.Equal(t, 1, invoker.InvokeCallCount())
+
+		invoker.InvokeReturnsOnCall(2, nil, schema.TxInfo{}, fmt.Errorf("bid posted already"))
+		respB, _, err := inj.Invoke(args("bidder0001", 2, "sell", 1))
+		require.NoError(t, err)
+		require.Equal(t, "resp", string(respB))
+		require.Equal(t, 3, invoker.InvokeCallCount())
+
+		recs := records(b)
+		require.Len(t, recs, 2)
+		require.Equal(t, faults.FaultTimeout, recs[0].Fault)
+		require.Equal(t, "success", recs[0].Status)
+		require.Equal(t, faults.FaultDuplicate, recs[1].Fault)
+		require.Equal(t, "bid posted already", recs[1].Status)
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		inj, _, querier, b := newInjector(faults.Rule{
+			Latency: &faults.Latency{Dist: faults.DistFixed, Mean: 20},
+		})
+
+		start := time.Now()
+		_, err := inj.Query(args("main", 0, "metrics", 1))
+		require.NoError(t, err)
+		require.True(t, time.Since(start) >= 20*time.Millisecond)
+		require.Equal(t, 1, querier.QueryCallCount())
+
+		recs := records(b)
+		require.Len(t, recs, 1)
+		require.Equal(t, faults.FaultDelay, recs[0].Fault)
+		require.EqualValues(t, 20, recs[0].DelayMillis)
+	})
+
+	t.Run("reproducible draws", func(t *testing.T) {
+		failed := func() []string {
+			inj, _, _, _ := newInjector(faults.Rule{Fail: 0.5})
+			var res []string
+			for slot := 0; slot < 200; slot++ {
+				a := args("bidder0001", slot, "buy", 1)
+				if _, _, err := inj.Invoke(a); err != nil {
+					res = append(res, a.EventID)
+				}
+			}
+			return res
+		}
+
+		first := failed()
+		require.Equal(t, first, failed())
+		require.InDelta(t, 100, len(first), 30)
+	})
+}
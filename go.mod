@@ -16,12 +16,14 @@ require (
 	github.com/hyperledger/fabric-sdk-go v1.0.0-beta1
 	github.com/klauspost/cpuid v1.2.2 // indirect
 	github.com/magiconair/properties v1.8.0 // indirect
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/maxbrunsfeld/counterfeiter/v6 v6.2.2
 	github.com/miekg/pkcs11 v1.0.3 // indirect
 	github.com/mitchellh/mapstructure v0.0.0-20180511142126-bb74f1db0675 // indirect
 	github.com/onsi/gomega v1.5.0
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7 // indirect
 	github.com/pelletier/go-toml v1.2.0 // indirect
+	github.com/prometheus/client_golang v0.8.0
 	github.com/spf13/afero v1.1.1 // indirect
 	github.com/spf13/viper v1.0.3-0.20180507071007-15738813a09d // indirect
 	github.com/stretchr/testify v1.4.0
@@ -0,0 +1,194 @@
+// Package identity issues the X.509 identities that the agents sign their calls
+// to the ledger with. The contract tells its callers apart by the certificates
+// they sign with, so every agent needs one of its own, whereas the Fabric
+// fixtures only carry a single user. An Issuer signs the certificates with the
+// CA of the organization, or with a CA of its own when nothing validates them,
+// as is the case with the in-process ledger.
+package identity
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Identity is an identity of an MSP: an X.509 certificate, and the private key
+// that goes with it, both PEM-encoded.
+type Identity struct {
+	Name    string // The common name of the certificate's subject
+	MSPID   string
+	CertPEM []byte
+	KeyPEM  []byte // PKCS #8
+}
+
+// Issuer issues identities that are signed by a CA. An agent gets the same
+// identity every time it asks for one. It is safe for concurrent use.
+type Issuer struct {
+	mspID  string
+	caCert *x509.Certificate
+	caKey  crypto.Signer
+
+	mu     sync.Mutex
+	issued map[string]Identity
+}
+
+// NewIssuer returns an issuer for the given MSP that is backed by a CA of its
+// own, which it creates in memory.
+func NewIssuer(mspID string) (*Issuer, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template("ca."+mspID, &caKey.PublicKey, time.Now().AddDate(10, 0, 0))
+	if err != nil {
+		return nil, err
+	}
+	tmpl.IsCA = true
+	tmpl.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	certB, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(certB)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Issuer{mspID: mspID, caCert: caCert, caKey: caKey, issued: make(map[string]Identity)}, nil
+}
+
+// LoadIssuer returns an issuer for the given MSP that is backed by the CA whose
+// certificate and key `cryptogen` wrote to the given directory, i.e. the
+// crypto-config/peerOrganizations/<org>/ca directory. The identities that it
+// issues are members of the organization.
+func LoadIssuer(mspID, caDir string) (*Issuer, error) {
+	files, err := ioutil.ReadDir(caDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var caCert *x509.Certificate
+	var keyFiles []string
+	for _, f := range files {
+		switch {
+		case strings.HasSuffix(f.Name(), "-cert.pem"):
+			certPEM, err := ioutil.ReadFile(filepath.Join(caDir, f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			block, _ := pem.Decode(certPEM)
+			if block == nil {
+				return nil, fmt.Errorf("no PEM block in %s", f.Name())
+			}
+			if caCert, err = x509.ParseCertificate(block.Bytes); err != nil {
+				return nil, fmt.Errorf("cannot parse %s: %s", f.Name(), err)
+			}
+		case strings.HasSuffix(f.Name(), "_sk"):
+			keyFiles = append(keyFiles, f.Name())
+		}
+	}
+	if caCert == nil {
+		return nil, fmt.Errorf("no CA certificate in %s", caDir)
+	}
+	caPub, ok := caCert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("the CA certificate does not carry an ECDSA key")
+	}
+
+	// `cryptogen` leaves a key behind for every run, so we look for the one
+	// that matches the certificate.
+	for _, name := range keyFiles {
+		keyPEM, err := ioutil.ReadFile(filepath.Join(caDir, name))
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(keyPEM)
+		if block == nil {
+			continue
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if caKey, ok := key.(*ecdsa.PrivateKey); ok && caKey.X.Cmp(caPub.X) == 0 && caKey.Y.Cmp(caPub.Y) == 0 {
+			return &Issuer{mspID: mspID, caCert: caCert, caKey: caKey, issued: make(map[string]Identity)}, nil
+		}
+	}
+	return nil, fmt.Errorf("no key in %s matches the CA certificate", caDir)
+}
+
+// CACert returns the certificate of the CA that signs the identities.
+func (is *Issuer) CACert() *x509.Certificate {
+	return is.caCert
+}
+
+// Issue returns the identity with the given name, and issues it if need be.
+func (is *Issuer) Issue(name string) (Identity, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	if id, ok := is.issued[name]; ok {
+		return id, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Identity{}, err
+	}
+	tmpl, err := template(name, &key.PublicKey, is.caCert.NotAfter)
+	if err != nil {
+		return Identity{}, err
+	}
+	certB, err := x509.CreateCertificate(rand.Reader, tmpl, is.caCert, &key.PublicKey, is.caKey)
+	if err != nil {
+		return Identity{}, err
+	}
+	keyB, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	id := Identity{
+		Name:    name,
+		MSPID:   is.mspID,
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certB}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyB}),
+	}
+	is.issued[name] = id
+	return id, nil
+}
+
+// template returns the template of a certificate for a signing key, in the
+// fashion of `cryptogen`: the subject key ID is the hash of the public key.
+func template(name string, pub *ecdsa.PublicKey, notAfter time.Time) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	ski := sha256.Sum256(elliptic.Marshal(pub.Curve, pub.X, pub.Y))
+
+	return &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-5 * time.Minute),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		SubjectKeyId:          ski[:],
+	}, nil
+}
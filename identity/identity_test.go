@@ -0,0 +1,66 @@
+package identity_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"path/filepath"
+	"testing"
+
+	"github.com/kchristidis/island/identity"
+	"github.com/stretchr/testify/require"
+)
+
+func verify(t *testing.T, is *identity.Issuer, id identity.Identity) *x509.Certificate {
+	block, _ := pem.Decode(id.CertPEM)
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(is.CACert())
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	require.NoError(t, err)
+
+	block, _ = pem.Decode(id.KeyPEM)
+	require.NotNil(t, block)
+	_, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	require.NoError(t, err)
+
+	return cert
+}
+
+func TestIssuer(t *testing.T) {
+	t.Run("in memory", func(t *testing.T) {
+		is, err := identity.NewIssuer("clark.example.com")
+		require.NoError(t, err)
+
+		id, err := is.Issue("bidder0001")
+		require.NoError(t, err)
+		require.Equal(t, "bidder0001", id.Name)
+		require.Equal(t, "clark.example.com", id.MSPID)
+		require.Equal(t, "bidder0001", verify(t, is, id).Subject.CommonName)
+
+		// The same name gets the same identity, and another name another one
+		again, err := is.Issue("bidder0001")
+		require.NoError(t, err)
+		require.Equal(t, id, again)
+		other, err := is.Issue("bidder0002")
+		require.NoError(t, err)
+		require.NotEqual(t, id.CertPEM, other.CertPEM)
+	})
+
+	t.Run("from the fixtures", func(t *testing.T) {
+		is, err := identity.LoadIssuer("clark.example.com", filepath.Join("..", "fixtures", "crypto-config", "peerOrganizations", "clark.example.com", "ca"))
+		require.NoError(t, err)
+		require.Equal(t, "ca.clark.example.com", is.CACert().Subject.CommonName)
+
+		id, err := is.Issue("bidder0001")
+		require.NoError(t, err)
+		verify(t, is, id)
+	})
+
+	t.Run("no CA", func(t *testing.T) {
+		_, err := identity.LoadIssuer("clark.example.com", t.Name())
+		require.Error(t, err)
+	})
+}
@@ -1,23 +1,49 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math/rand"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/kchristidis/island/bidder"
-	"github.com/kchristidis/island/blockchain"
-	"github.com/kchristidis/island/blocknotifier"
+	"github.com/kchristidis/island/chaincode/logging"
 	"github.com/kchristidis/island/chaincode/schema"
 	"github.com/kchristidis/island/crypto"
+	"github.com/kchristidis/island/faults"
 	"github.com/kchristidis/island/regulator"
 	"github.com/kchristidis/island/slotnotifier"
 	"github.com/kchristidis/island/stats"
+	"github.com/kchristidis/island/tariff"
 	"github.com/kchristidis/island/trace"
 )
 
 func main() {
+	flag.BoolVar(&resume, "resume", false, "resume the last run on the same channel, from the first slot that the market has not cleared")
+	flag.StringVar(&outputSinks, "sinks", "csv,summary", "comma-separated sinks to write the results to: csv, jsonl, sqlite (w/ -tags sqlite), summary")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "serve live Prometheus metrics on this `address`, e.g. :9090; off if empty")
+	flag.StringVar(&faultsFile, "faults", "", "inject the faults that this JSON `file` scripts into the agents' calls; off if empty")
+	flag.Float64Var(&depositRules.AmountInCents, "deposit", 0, "have every bid lock a deposit of this many `cents` in experiments 1 and 3, forfeited if its key is not posted in time; off if 0")
+	flag.Float64Var(&depositRules.OpeningBalanceInCents, "opening-balance", 10000, "the `cents` that every account holds when it first bids, if bids lock deposits and the market does not settle payments")
+	flag.BoolVar(&payments, "payments", false, "have the market settle every slot in tokens: buyers pay sellers for their fills, and the rest is settled with the grid")
+	flag.Float64Var(&mintInCents, "mint", 10000, "the `cents` that the utility mints to every bidder before the run, if the market settles payments; none if 0")
+	flag.StringVar(&adversaries, "adversaries", "", "comma-separated `behavior=count` pairs of adversarial bidders, e.g. spam=1,late=2; all honest if empty")
+	flag.StringVar(&logLevel, "log-level", defaultLogLevel(), "log entries at or above this `level`: debug, info, warn, or error")
+	flag.StringVar(&logFormat, "log-format", "text", "log entries in this `format`: text, or json")
+	flag.Parse()
+
 	if err := run(); err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		os.Exit(1)
@@ -25,7 +51,10 @@ func main() {
 }
 
 func run() error {
-	writer = os.Stdout
+	if logger, err = newLogger(); err != nil {
+		return err
+	}
+	mainLog = logger.With(logging.Component("main"))
 	iter++
 	outputPrefix = fmt.Sprintf("exp-%02d-run-%02d", schema.ExpNum, iter)
 
@@ -37,13 +66,92 @@ func run() error {
 	statsSlotC = make(chan stats.Slot, StatChannelBuffer)
 	statsTranC = make(chan stats.Transaction, StatChannelBuffer)
 
-	doneC = make(chan struct{})
-	doneStatsC = make(chan struct{})
+	traceStream, err = trace.Open(tracePath, traceOptions)
+	if err != nil {
+		return err
+	}
+	defer traceStream.Close()
+	if traceStream.Len() < schema.TraceLength {
+		return fmt.Errorf("trace covers %d slots, need %d (schema.TraceLength)", traceStream.Len(), schema.TraceLength)
+	}
 
-	tracePath := filepath.Join("trace", trace.Filename)
-	traceMap, err = trace.Load(tracePath)
+	// ATTN: The grid prices are the same for all households in the trace
+	priceCursor, err := traceStream.Cursor(traceStream.IDs[0])
 	if err != nil {
-		return nil
+		return err
+	}
+	priceRows, err := trace.ReadRows(priceCursor, schema.TraceLength)
+	priceCursor.Close()
+	if err != nil {
+		return err
+	}
+	gridTariff, err = tariff.New(tariffConfig, priceRows)
+	if err != nil {
+		return err
+	}
+
+	initArgs := [][]byte{[]byte("init")}
+	var initInput schema.InitInput
+	if topologyFile != "" {
+		topologyB, err := ioutil.ReadFile(topologyFile)
+		if err != nil {
+			return err
+		}
+		var topology schema.Topology
+		if err := json.Unmarshal(topologyB, &topology); err != nil {
+			return fmt.Errorf("cannot decode topology in %s: %s", topologyFile, err)
+		}
+		initInput.Topology = &topology
+	}
+	if depositRules.AmountInCents > 0 {
+		initInput.Deposits = &depositRules
+	}
+	if payments {
+		initInput.Payments = &schema.PaymentRules{Utility: schema.UtilityAccount}
+	}
+	if initInput.Topology != nil || initInput.Deposits != nil || initInput.Payments != nil {
+		initInputB, err := json.Marshal(initInput)
+		if err != nil {
+			return err
+		}
+		initArgs = append(initArgs, initInputB)
+	}
+
+	if faultsFile != "" {
+		if faultRules, err = faults.Load(faultsFile); err != nil {
+			return err
+		}
+		// A slot lasts as long as its calls take in virtual time, so a delay
+		// cannot make a call late; it would only slow the run down.
+		if virtualTime {
+			for i, rule := range faultRules {
+				if rule.Latency != nil {
+					return fmt.Errorf("fault rule %d (%q) cannot delay calls in virtual time", i, rule.Name)
+				}
+			}
+		}
+	}
+	if behaviors, err = assignBehaviors(adversaries, bidderIDs()); err != nil {
+		return err
+	}
+
+	// The stats collector journals the stats as they come in, so that they survive a
+	// crash. A resumed run picks up the stats of the run it resumes from there.
+	statsCollector = stats.New(statsBlockC, statsSlotC, statsTranC, gridTariff, logger)
+	if statsCollector.Sinks, err = newSinks(); err != nil {
+		return err
+	}
+	if journalFile, err = openJournal(); err != nil {
+		return err
+	}
+	defer journalFile.Close()
+	statsCollector.Journal = journalFile
+	if metricsAddr != "" {
+		stopMetrics, err := serveMetrics()
+		if err != nil {
+			return err
+		}
+		defer stopMetrics()
 	}
 
 	privKeyPath := filepath.Join("crypto", "priv.pem")
@@ -55,163 +163,455 @@ func run() error {
 
 	// Begin initializations
 
-	println()
-	msg := fmt.Sprintf("Simulating experiment %d (running in prod: %t)...", schema.ExpNum, schema.StagingLevel == schema.Prod)
-	fmt.Fprintln(writer, msg)
-	println()
-
-	sdkctx = &blockchain.SDKContext{
-		SDKConfigFile: "config.yaml",
-
-		OrgName:  "clark",
-		OrgAdmin: "Admin",
-		UserName: "User1",
+	msg := fmt.Sprintf("simulating experiment %d (running in prod: %t, virtual time: %t)...", schema.ExpNum, schema.StagingLevel == schema.Prod, virtualTime)
+	mainLog.Info(msg)
 
-		OrdererID:   "joe.example.com",
-		ChannelID:   "clark-channel",
-		ChaincodeID: fmt.Sprintf("exp%d", schema.ExpNum),
-
-		ChannelConfigPath:   os.Getenv("GOPATH") + "/src/github.com/kchristidis/island/fixtures/artifacts/clark-channel.tx",
-		ChaincodeGoPath:     os.Getenv("GOPATH"),
-		ChaincodeSourcePath: "github.com/kchristidis/island/chaincode/",
-	}
-	if err = sdkctx.Setup(); err != nil {
+	closeLedger, err := setupLedger(initArgs)
+	if err != nil {
 		return err
 	}
-	defer sdkctx.SDK.Close()
-	if err = sdkctx.Install(); err != nil {
+	defer closeLedger()
+
+	closeFaults, err := wrapLedger()
+	if err != nil {
 		return err
 	}
+	defer closeFaults()
 
-	statsCollector = &stats.Collector{
-		BlockChan:       statsBlockC,
-		SlotChan:        statsSlotC,
-		TransactionChan: statsTranC,
-		Writer:          writer,
-		DoneChan:        doneStatsC,
+	if resume {
+		if firstSlot, err = resumeSlot(); err != nil {
+			return err
+		}
+		mainLog.Info("resuming the run", logging.Slot(firstSlot))
+	} else if payments {
+		if err := fundAccounts(); err != nil {
+			return err
+		}
 	}
-	wg3.Add(1)
+
+	// The collector outlives the run, so that it picks up the stats of the calls
+	// that are in flight when the run is stopped.
+	statsCtx, stopStats := context.WithCancel(context.Background())
+	defer stopStats()
+	statsDoneC := make(chan struct{})
 	go func() {
-		statsCollector.Run()
-		wg3.Done()
+		statsCollector.Run(statsCtx)
+		close(statsDoneC)
 	}()
 
+	runCtx, cancelRun = context.WithCancel(context.Background())
+	defer cancelRun()
+	defer handleSignals()()
+
 	switch schema.ExpNum {
 	case 1, 3:
 		for i := 0; i < 2; i++ {
 			// 1st for markend+bid calls
 			// 2nd one for postkey calls
 			slotCs = append(slotCs, make(chan int))
-			sNotifiers = append(sNotifiers, slotnotifier.New(slotCs[i], writer, doneC))
+			sNotifiers = append(sNotifiers, slotnotifier.New(i, slotCs[i], logger))
 		}
 	case 2:
 		slotCs = append(slotCs, make(chan int))
-		sNotifiers = []*slotnotifier.Notifier{slotnotifier.New(slotCs[0], writer, doneC), nil}
+		sNotifiers = []*slotnotifier.Notifier{slotnotifier.New(0, slotCs[0], logger), nil}
+	}
+	if virtualTime {
+		for _, n := range sNotifiers {
+			if n != nil {
+				n.Tracker = tracker
+			}
+		}
 	}
 
-	regtor = regulator.New(sdkctx, sNotifiers[0],
+	regClient, err := agentClient("regulator")
+	if err != nil {
+		return err
+	}
+	regtor = regulator.New(regClient, sNotifiers[0],
 		privKeyBytes,
-		statsSlotC, statsTranC, writer, doneC)
-	wg2.Add(1)
+		statsSlotC, statsTranC, logger)
+	regtor.FirstSlot = firstSlot
+	if payments {
+		regtor.Tariff = gridTariff
+	}
+	if virtualTime {
+		regtor.Tracker = tracker
+	}
+	traceWG.Add(1)
+	agentsWG.Add(1)
 	go func() {
-		if err := regtor.Run(); err != nil {
-			once.Do(func() {
-				msg := fmt.Sprint("regulator • closing donec")
-				fmt.Fprintln(writer, msg)
-				close(doneC)
-			})
-		}
-		wg2.Done()
+		if err := regtor.Run(runCtx); err != nil {
+			stop("regulator")
+		}
+		traceWG.Done()
+		agentsWG.Done()
 	}()
 
-	biddersList := trace.IDs
-	if schema.StagingLevel <= schema.Debug {
-		biddersList = biddersList[:schema.DebugBidderIDsCount] // We only care about the first `schema.DebugBidderIDsCount` bidders.
-	}
-
+	biddersList := bidderIDs()
+	bidders = make([]*bidder.Bidder, len(biddersList))
 	for i, ID := range biddersList {
-		bidders[i] = bidder.New(sdkctx, sNotifiers[0], sNotifiers[1],
-			ID, privKeyBytes, traceMap[ID],
-			statsSlotC, statsTranC, writer, doneC)
-		wg1.Add(1)
+		rows, err := traceStream.Cursor(ID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		client, err := agentClient(bidder.Agent(ID))
+		if err != nil {
+			return err
+		}
+
+		bidders[i] = bidder.New(client, sNotifiers[0], sNotifiers[1],
+			ID, privKeyBytes, rows, gridTariff, agentRand(ID),
+			statsSlotC, statsTranC, logger)
+		bidders[i].Querier = client
+		bidders[i].Behavior = behaviors[ID]
+		if virtualTime {
+			bidders[i].Tracker = tracker
+			bidders[i].BlockDuration = 0 // There are no read conflicts to back off from
+		}
+		if resume {
+			if err := bidders[i].Restore(client, firstSlot); err != nil {
+				return err
+			}
+		}
+		traceWG.Add(1)
+		agentsWG.Add(1)
 		go func(i int) {
-			if err = bidders[i].Run(); err != nil {
-				once.Do(func() {
-					msg := fmt.Sprintf("bidder:%04d • closing donec", bidders[i].ID)
-					fmt.Fprintln(writer, msg)
-					close(doneC)
-				})
+			if err := bidders[i].Run(runCtx); err != nil {
+				stop(fmt.Sprintf("bidder:%04d", bidders[i].ID))
 			}
-			wg1.Done()
+			traceWG.Done()
+			agentsWG.Done()
 		}(i)
 	}
 
-	// The size of the slotCs slice dictates how many block notifiers we need
-
-	bNotifiers = append(bNotifiers, blocknotifier.New(
-		schema.BlocksPerSlot, schema.ClockPeriod, schema.SleepDuration, startFromBlock,
-		statsBlockC, slotCs[0],
-		sdkctx, sdkctx.LedgerClient,
-		writer, doneC,
-	))
-
-	if len(slotCs) > 1 {
-		nilChan := make(chan stats.Block) // This ensures that only the first blockNotifier feeds the stats collector
-		bNotifiers = append(bNotifiers, blocknotifier.New(
-			schema.BlocksPerSlot, schema.ClockPeriod, schema.SleepDuration, startFromBlock+uint64(schema.BlockOffset),
-			nilChan, slotCs[1],
-			sdkctx, sdkctx.LedgerClient,
-			writer, doneC,
-		))
-	}
+	// Start whatever drives the slot notifiers: block notifiers, or a virtual clock
 
-	for i := range bNotifiers {
-		wg2.Add(1)
-		go func(i int) {
-			if err := bNotifiers[i].Run(); err != nil {
-				once.Do(func() {
-					msg := fmt.Sprintf("block-notifier:%d • closing donec", i)
-					fmt.Fprintln(writer, msg)
-					close(doneC)
-				})
-			}
-			wg2.Done()
-		}(i)
+	if err := startClock(); err != nil {
+		return err
 	}
 
 	for i := range sNotifiers {
 		if sNotifiers[i] == nil {
 			continue
 		}
-		wg2.Add(1)
+		agentsWG.Add(1)
 		go func(i int) {
-			sNotifiers[i].Run()
-			wg2.Done()
+			sNotifiers[i].Run(runCtx)
+			agentsWG.Done()
 		}(i)
 	}
 
 	// Start the simulation
 
-	// In the green path, the *bidders* will run their entire trace, then exit.
-	// We then close `doneC`, and wait for all the other goroutines to conclude.
-	// This is why we use two separate waitgroups: one for bidders, and one for
-	// regulator/notifiers.
-	// An unfortunate side-effect of this design is that we do not let the regulator
-	// 'markEnd' the very last slot, since @ slot N the regulator closes slot N-1.
-	// That means we missing out on the stats of the very last slot, which is why
-	// we only output N-1 slots in our stats output ("cleared" slots).
-
-	wg1.Wait()
-
-	once.Do(func() {
-		msg := fmt.Sprint("main • closing donec")
-		fmt.Fprintln(writer, msg)
-		close(doneC)
+	// In the green path, the *bidders* will run their entire trace, then exit;
+	// in experiments 1 and 3, once they have posted their keys for the last slot.
+	// Since @ slot N the regulator closes slot N-1, the slot notifiers keep going
+	// for one more slot, so that the regulator clears the last slot of the trace,
+	// then exits as well. We then stop the run, and wait for all the other
+	// goroutines to conclude.
+	// The run may also be stopped early, by a signal or an agent's failure. In
+	// either case, the agents get `ShutdownTimeout` to see the calls they have
+	// in flight through, and we write out whatever results we have.
+
+	select {
+	case <-waitC(&traceWG):
+	case <-runCtx.Done():
+	}
+	stop("main")
+
+	select {
+	case <-waitC(&agentsWG):
+		mainLog.Info("run completed")
+	case <-time.After(ShutdownTimeout):
+		mainLog.Warn(fmt.Sprintf("agents still running after %s, giving up on them", ShutdownTimeout))
+	}
+
+	stopStats()
+	<-statsDoneC
+
+	if err := metrics(); err != nil {
+		return err
+	}
+	if stoppedBy != "main" {
+		return fmt.Errorf("run stopped early by %s, results are partial", stoppedBy)
+	}
+	return nil
+}
+
+// openJournal opens the stats collector's journal. A fresh run starts a new
+// journal, while a resumed run replays the journal into the collector, and
+// appends to it.
+func openJournal() (*os.File, error) {
+	if err := os.MkdirAll(OutputDir, 0755); err != nil {
+		return nil, err
+	}
+	journalPath := filepath.Join(OutputDir, fmt.Sprintf("%s-%s", outputPrefix, OutputJournal))
+
+	if !resume {
+		return os.OpenFile(journalPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	}
+
+	f, err := os.OpenFile(journalPath, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	size, err := statsCollector.Replay(f)
+	if err == nil {
+		// Drop the line that a crash may have cut short
+		err = f.Truncate(size)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot replay the journal in %s: %s", journalPath, err)
+	}
+	return f, nil
+}
+
+// wrapLedger sets up the fault injector that decorates what the agents call the
+// ledger through, if the `-faults` flag is set; see `agentClient`. The injector
+// records the faults it injects in the output dir; the calls that the main
+// thread makes go through unharmed. It returns a function that closes the record.
+func wrapLedger() (func(), error) {
+	if faultsFile == "" {
+		return func() {}, nil
+	}
+
+	recordPath := filepath.Join(OutputDir, fmt.Sprintf("%s-%s", outputPrefix, OutputFaults))
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(recordPath, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	injector = faults.New(ledger, ledger, faultRules, runSeed, logger)
+	injector.Record = f
+	mainLog.Info(fmt.Sprintf("injecting the faults scripted in %s", faultsFile), logging.F("rules", len(faultRules)))
+	return func() { f.Close() }, nil
+}
+
+// agentClient returns what the given agent calls the ledger through: a client
+// that signs with the identity of the agent, whatever the event IDs of its
+// calls say, and that injects faults if the `-faults` flag is set.
+func agentClient(agent string) (ledgerClient, error) {
+	client, err := signAs(agent)
+	if err != nil {
+		return nil, err
+	}
+	return faulty(client), nil
+}
+
+// faulty decorates the given client with the fault injector, if there is one.
+func faulty(client ledgerClient) ledgerClient {
+	if injector == nil {
+		return client
+	}
+	return injector.Wrap(client, client)
+}
+
+// fundAccounts has the utility mint the `-mint` amount to every bidder, so
+// that the bidders can pay for what they buy.
+func fundAccounts() error {
+	if mintInCents <= 0 {
+		return nil
+	}
+
+	mintInputVal := schema.MintInput{AmountInCents: mintInCents}
+	for _, ID := range bidderIDs() {
+		mintInputVal.Accounts = append(mintInputVal.Accounts, bidder.Agent(ID))
+	}
+	mintInputValB, err := json.Marshal(mintInputVal)
+	if err != nil {
+		return err
+	}
+
+	args := schema.OpContextInput{
+		EventID: schema.EventID(schema.UtilityAccount, firstSlot, "mint", 0, 1),
+		Action:  "mint",
+		Slot:    firstSlot,
+		Data:    mintInputValB,
+	}
+	utility, err := signAs(schema.UtilityAccount)
+	if err != nil {
+		return err
+	}
+	if _, _, err := utility.Invoke(args); err != nil {
+		return fmt.Errorf("cannot fund the bidders' accounts: %s", err)
+	}
+	mainLog.Info(fmt.Sprintf("minted %.3f ç to each of %d bidders", mintInputVal.AmountInCents, len(mintInputVal.Accounts)))
+	return nil
+}
+
+// resumeSlot returns the first slot of the trace that the market has not cleared.
+// The journal may lag the ledger, so we check the ledger from the slot after the
+// last one that the journal has on record as cleared.
+func resumeSlot() (int, error) {
+	for slot := statsCollector.LargestSlotCleared() + 1; slot < schema.TraceLength; slot++ {
+		args := schema.OpContextInput{
+			EventID: schema.EventID("main", slot, "marked", 0, 1),
+			Action:  "marked",
+			Slot:    slot,
+		}
+		respB, err := ledger.Query(args)
+		if err != nil {
+			return 0, fmt.Errorf("cannot query whether slot %d is cleared: %s", slot, err)
+		}
+		var markedOutputVal schema.MarkedOutput
+		if err := json.Unmarshal(respB, &markedOutputVal); err != nil {
+			return 0, err
+		}
+		if !markedOutputVal.Marked {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("the market has cleared every slot of the trace, nothing to resume")
+}
+
+// stop stops the run. The first caller is logged, and recorded as the one that
+// stopped the run.
+func stop(who string) {
+	stopOnce.Do(func() {
+		stoppedBy = who
+		mainLog.Info("stopping the run", logging.F("stopped_by", who))
 	})
+	cancelRun()
+}
+
+// handleSignals stops the run upon SIGINT or SIGTERM. A second signal exits right
+// away, without writing out any results. It returns a function that stops the
+// handling.
+func handleSignals() func() {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	releasec := make(chan struct{})
+	go func() {
+		for received := false; ; received = true {
+			select {
+			case sig := <-sigc:
+				if received {
+					fmt.Fprintf(os.Stderr, "received %s again, exiting\n", sig)
+					os.Exit(1)
+				}
+				stop(fmt.Sprintf("signal:%s", sig))
+			case <-releasec:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigc)
+		close(releasec)
+	}
+}
+
+// defaultLogLevel returns the level that the run logs at, unless told otherwise.
+func defaultLogLevel() string {
+	if schema.StagingLevel <= schema.Debug {
+		return logging.DebugLevel.String()
+	}
+	return logging.InfoLevel.String()
+}
 
-	wg2.Wait()
+// newLogger returns the root logger of the run, as set by the `-log-level`
+// and `-log-format` flags. It logs to stdout.
+func newLogger() (*logging.Logger, error) {
+	level, err := logging.ParseLevel(logLevel)
+	if err != nil {
+		return nil, err
+	}
+	format, err := logging.ParseFormat(logFormat)
+	if err != nil {
+		return nil, err
+	}
+	return logging.New(os.Stdout, level, format), nil
+}
 
-	fmt.Fprintln(writer, "main • run completed")
+// waitC returns a channel that is closed once the waitgroup is done.
+func waitC(wg *sync.WaitGroup) <-chan struct{} {
+	c := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(c)
+	}()
+	return c
+}
+
+// bidderIDs returns the IDs of the households in the trace that get a bidder.
+func bidderIDs() []int {
+	IDs := traceStream.IDs
+	if schema.StagingLevel <= schema.Debug && len(IDs) > schema.DebugBidderIDsCount {
+		IDs = IDs[:schema.DebugBidderIDsCount] // We only care about the first `schema.DebugBidderIDsCount` bidders.
+	}
+	return IDs
+}
+
+// assignBehaviors parses the `-adversaries` flag, and picks the bidders that
+// act on each behavior out of the given ones. The picks are drawn from the run
+// seed, so that they are the same for every run with the same seed.
+func assignBehaviors(spec string, IDs []int) (map[int]bidder.Behavior, error) {
+	res := make(map[int]bidder.Behavior)
+	if spec == "" {
+		return res, nil
+	}
+
+	counts := make(map[bidder.Behavior]int)
+	var total int
+	for _, pair := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("cannot parse adversaries %q: want behavior=count pairs", spec)
+		}
+		behavior, err := bidder.ParseBehavior(kv[0])
+		if err != nil {
+			return nil, err
+		}
+		count, err := strconv.Atoi(kv[1])
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("cannot parse adversaries %q: invalid count for %s", spec, behavior)
+		}
+		counts[behavior] += count
+		total += count
+	}
+	if total > len(IDs) {
+		return nil, fmt.Errorf("cannot assign %d adversaries to %d bidders", total, len(IDs))
+	}
+
+	shuffled := append([]int(nil), IDs...)
+	rng := rand.New(rand.NewSource(runSeed))
+	rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	for _, behavior := range bidder.Behaviors { // In a fixed order
+		for i := 0; i < counts[behavior]; i++ {
+			res[shuffled[0]] = behavior
+			shuffled = shuffled[1:]
+		}
+	}
+	return res, nil
+}
+
+// adversaryIDs returns the IDs of the adversarial bidders, by behavior.
+func adversaryIDs() map[string][]int {
+	if len(behaviors) == 0 {
+		return nil
+	}
+	res := make(map[string][]int)
+	for ID, behavior := range behaviors {
+		res[string(behavior)] = append(res[string(behavior)], ID)
+	}
+	for _, IDs := range res {
+		sort.Ints(IDs)
+	}
+	return res
+}
 
-	return metrics()
+// agentRand returns the random number generator of the agent with the given ID.
+// It is derived from the run seed and the ID, so that an agent draws the same
+// numbers no matter which other agents take part in the run.
+func agentRand(id int) *rand.Rand {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%d", runSeed, id)
+	return rand.New(rand.NewSource(int64(h.Sum64())))
 }
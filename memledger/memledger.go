@@ -0,0 +1,199 @@
+// Package memledger runs the market contract in-process, against an in-memory
+// key-value store. It stands in for the Fabric network when we care about
+// market-level results rather than blockchain performance: transactions are
+// executed one at a time, as soon as they are submitted, so there are no
+// endorsements, no blocks, and no MVCC read conflicts. Every agent signs its
+// calls with an identity of its own, as it would in a Fabric network; see `As`.
+package memledger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pp "github.com/hyperledger/fabric/protos/peer"
+	"github.com/kchristidis/island/chaincode/contract"
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/kchristidis/island/identity"
+)
+
+// Admin is the identity that instantiates the contract, and signs the calls
+// that are made on the ledger itself rather than on a client; see `As`.
+const Admin = "admin"
+
+// The MSP that the identities belong to.
+const mspID = "clark.example.com"
+
+// Ledger executes invocations and queries against an in-process instance of
+// the contract. It is safe for concurrent use. The contract keeps its metrics
+// in package-level state, so there should be a single ledger per process.
+type Ledger struct {
+	mu      sync.Mutex
+	stub    *shim.MockStub
+	txCount uint64
+
+	issuer  *identity.Issuer
+	admin   []byte // The serialized identity of Admin
+	creator []byte // The identity that signs the transaction in flight; see `call`
+}
+
+// New instantiates the contract with the given init arguments; nil means
+// `init`. The contract logs to the given logger.
+func New(initArgs [][]byte, logger *logging.Logger) (*Ledger, error) {
+	contract.SetLogger(logger.With(logging.Component("chaincode")))
+
+	if initArgs == nil {
+		initArgs = [][]byte{[]byte("init")}
+	}
+
+	issuer, err := identity.NewIssuer(mspID)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Ledger{issuer: issuer}
+	l.stub = shim.NewMockStub(fmt.Sprintf("exp%d", schema.ExpNum), signedContract{l})
+
+	if l.admin, err = l.serialize(Admin); err != nil {
+		return nil, err
+	}
+	l.creator = l.admin
+	resp := l.stub.MockInit(l.nextTxID(), initArgs)
+	if resp.Status != shim.OK {
+		return nil, fmt.Errorf("cannot instantiate contract: %s", resp.Message)
+	}
+
+	return l, nil
+}
+
+// Invoke executes an `invoke` transaction, signed by Admin. Every transaction
+// can be thought of as a block, so the block number returned is the ledger
+// height at commit.
+func (l *Ledger) Invoke(args schema.OpContextInput) ([]byte, schema.TxInfo, error) {
+	return l.call("invoke", args, l.admin)
+}
+
+// Query executes a `query` transaction, signed by Admin. Unlike in Fabric, any
+// writes that the query makes are committed.
+func (l *Ledger) Query(args schema.OpContextInput) ([]byte, error) {
+	return l.query(args, l.admin)
+}
+
+// As returns a client of the ledger whose calls are all signed by the identity
+// of the given agent, whatever their event IDs say.
+func (l *Ledger) As(agent string) (*Client, error) {
+	creator, err := l.serialize(agent)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{l: l, creator: creator}, nil
+}
+
+// Client executes calls against the ledger on behalf of an agent; see `As`.
+type Client struct {
+	l       *Ledger
+	creator []byte
+}
+
+// Invoke is as `Ledger.Invoke`, but signed by the agent of the client.
+func (c *Client) Invoke(args schema.OpContextInput) ([]byte, schema.TxInfo, error) {
+	return c.l.call("invoke", args, c.creator)
+}
+
+// Query is as `Ledger.Query`, but signed by the agent of the client.
+func (c *Client) Query(args schema.OpContextInput) ([]byte, error) {
+	return c.l.query(args, c.creator)
+}
+
+// Height returns the number of transactions executed so far, counting the
+// instantiation. Every transaction can be thought of as a block.
+func (l *Ledger) Height() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.txCount
+}
+
+func (l *Ledger) query(args schema.OpContextInput, creator []byte) ([]byte, error) {
+	resp, _, err := l.call("query", args, creator)
+	if err != nil {
+		return nil, fmt.Errorf("[%s] query failed: %s", args.EventID, err.Error())
+	}
+	return resp, nil
+}
+
+func (l *Ledger) call(fn string, args schema.OpContextInput, creator []byte) ([]byte, schema.TxInfo, error) {
+	argsB, err := json.Marshal(args)
+	if err != nil {
+		return nil, schema.TxInfo{}, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.creator = creator
+	txID := l.nextTxID()
+	resp := l.stub.MockInvoke(txID, [][]byte{[]byte(fn), argsB})
+	l.drainEvents()
+	if resp.Status != shim.OK {
+		return nil, schema.TxInfo{}, errors.New(resp.Message)
+	}
+
+	return resp.Payload, schema.TxInfo{ID: txID, BlockNumber: l.txCount}, nil
+}
+
+// serialize returns the serialized identity of the given agent, as the
+// contract finds it in the transactions that the agent signs.
+func (l *Ledger) serialize(agent string) ([]byte, error) {
+	id, err := l.issuer.Issue(agent)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&msp.SerializedIdentity{Mspid: id.MSPID, IdBytes: id.CertPEM})
+}
+
+// signedContract hands the contract a stub that reports the identity that
+// signs the transaction in flight; a `shim.MockStub` reports none.
+type signedContract struct {
+	l *Ledger
+}
+
+func (c signedContract) Init(stub shim.ChaincodeStubInterface) pp.Response {
+	return new(contract.Contract).Init(signedStub{stub, c.l.creator})
+}
+
+func (c signedContract) Invoke(stub shim.ChaincodeStubInterface) pp.Response {
+	return new(contract.Contract).Invoke(signedStub{stub, c.l.creator})
+}
+
+type signedStub struct {
+	shim.ChaincodeStubInterface
+	creator []byte
+}
+
+func (s signedStub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+// nextTxID returns the ID of the next transaction. Callers should hold mu,
+// except during instantiation.
+func (l *Ledger) nextTxID() string {
+	l.txCount++
+	return fmt.Sprintf("%016x", l.txCount)
+}
+
+// drainEvents discards the events that the contract emits, so that the stub's
+// buffered event channel never fills up. Nobody waits on events in-process.
+func (l *Ledger) drainEvents() {
+	for {
+		select {
+		case <-l.stub.ChaincodeEventsChannel:
+		default:
+			return
+		}
+	}
+}
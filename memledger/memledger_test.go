@@ -0,0 +1,110 @@
+package memledger_test
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/kchristidis/island/memledger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLedger(t *testing.T) {
+	l, err := memledger.New(nil, logging.Nop())
+	require.NoError(t, err)
+	require.EqualValues(t, 1, l.Height())
+
+	t.Run("invoke", func(t *testing.T) {
+		respB, txInfo, err := l.Invoke(schema.OpContextInput{
+			EventID: "clock",
+			Action:  "clock",
+			Slot:    0,
+		})
+		require.NoError(t, err)
+		require.Equal(t, "0000000000000002", txInfo.ID)
+		require.EqualValues(t, 2, txInfo.BlockNumber)
+
+		var clockOutputVal schema.ClockOutput
+		require.NoError(t, json.Unmarshal(respB, &clockOutputVal))
+		require.Equal(t, "0", clockOutputVal.WriteKeyAttrs[0])
+		require.EqualValues(t, 2, l.Height())
+	})
+
+	t.Run("invalid action", func(t *testing.T) {
+		_, _, err := l.Invoke(schema.OpContextInput{
+			EventID: "foo",
+			Action:  "foo",
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid action")
+	})
+
+	t.Run("query", func(t *testing.T) {
+		respB, err := l.Query(schema.OpContextInput{
+			EventID: "metrics",
+			Action:  "metrics",
+		})
+		require.NoError(t, err)
+
+		var metricsOutputVal schema.MetricsOutput
+		require.NoError(t, json.Unmarshal(respB, &metricsOutputVal))
+	})
+
+	t.Run("concurrent calls", func(t *testing.T) {
+		before := l.Height()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, _, err := l.Invoke(schema.OpContextInput{
+					EventID: "clock",
+					Action:  "clock",
+					Slot:    i,
+				})
+				require.NoError(t, err)
+			}(i)
+		}
+		wg.Wait()
+
+		require.EqualValues(t, 20, l.Height()-before)
+	})
+}
+
+func TestInit(t *testing.T) {
+	_, err := memledger.New([][]byte{[]byte("init"), []byte("{")}, logging.Nop())
+	require.Error(t, err)
+}
+
+func TestAs(t *testing.T) {
+	initInputValB, err := json.Marshal(schema.InitInput{Payments: &schema.PaymentRules{Utility: schema.UtilityAccount}})
+	require.NoError(t, err)
+	l, err := memledger.New([][]byte{[]byte("init"), initInputValB}, logging.Nop())
+	require.NoError(t, err)
+
+	mintInputValB, err := json.Marshal(schema.MintInput{Accounts: []string{"bidder0001"}, AmountInCents: 100})
+	require.NoError(t, err)
+	args := schema.OpContextInput{
+		EventID: schema.EventID(schema.UtilityAccount, 0, "mint", 0, 1),
+		Action:  "mint",
+		Data:    mintInputValB,
+	}
+
+	// The event ID names the utility, but the call is signed by whoever makes it
+	_, _, err = l.Invoke(args)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "only account utility may mint")
+
+	bidder, err := l.As("bidder0001")
+	require.NoError(t, err)
+	_, _, err = bidder.Invoke(args)
+	require.Error(t, err)
+
+	utility, err := l.As(schema.UtilityAccount)
+	require.NoError(t, err)
+	_, _, err = utility.Invoke(args)
+	require.NoError(t, err)
+}
@@ -1,149 +1,154 @@
 package main
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
-	"math/rand"
-	"os"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"path/filepath"
 	"runtime"
-	"strconv"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/kchristidis/island/chaincode/logging"
 	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/kchristidis/island/faults"
+	"github.com/kchristidis/island/monitor"
 	"github.com/kchristidis/island/stats"
+	"github.com/kchristidis/island/tariff"
+	"github.com/kchristidis/island/trace"
 )
 
-func metrics() error {
-	msg := fmt.Sprint("main • time to collect & print the results...")
-	fmt.Fprintln(writer, msg)
-
-	msg = fmt.Sprint("main • closing donestatsc...")
-	fmt.Fprintln(writer, msg)
-	close(doneStatsC)
-	wg3.Wait()
-
-	// Create the output dir if it doesn't exist already
-	if _, err := os.Stat(OutputDir); os.IsNotExist(err) {
-		if err := os.Mkdir(OutputDir, 755); err != nil {
-			return err
-		}
-	}
+// runConfig is what a run was set up with. It is embedded in the run's summary,
+// so that the run can be reproduced.
+type runConfig struct {
+	ExpNum      int
+	Seed        int64
+	Debug       bool // Staging level
+	VirtualTime bool
+	Resumed     bool
+
+	TracePath    string
+	TraceOptions trace.Options
+	TraceLength  int
+	BidderIDs    []int
+	Tariff       tariff.Config
+	TopologyFile string               `json:",omitempty"`
+	FaultsFile   string               `json:",omitempty"`
+	Faults       []faults.Rule        `json:",omitempty"`
+	Adversaries  map[string][]int     `json:",omitempty"` // Bidder IDs by behavior
+	Deposits     *schema.DepositRules `json:",omitempty"`
+	Payments     bool                 `json:",omitempty"`
+	Minted       float64              `json:",omitempty"` // Per bidder, in cents, if the market settles payments
+
+	SlotDuration  string
+	BlocksPerSlot int
+	BlockOffset   int
+	BatchTimeout  string
+	RetryCount    int
+	Alpha         int
+}
 
-	tranFile, err := os.Create(filepath.Join(OutputDir, fmt.Sprintf("%s-%s", outputPrefix, OutputTran)))
-	if err != nil {
-		return err
+func newRunConfig() *runConfig {
+	return &runConfig{
+		ExpNum:      schema.ExpNum,
+		Seed:        runSeed,
+		Debug:       schema.StagingLevel <= schema.Debug,
+		VirtualTime: virtualTime,
+		Resumed:     resume,
+
+		TracePath:    tracePath,
+		TraceOptions: traceOptions,
+		TraceLength:  schema.TraceLength,
+		BidderIDs:    bidderIDs(),
+		Tariff:       tariffConfig,
+		TopologyFile: topologyFile,
+		FaultsFile:   faultsFile,
+		Faults:       faultRules,
+		Adversaries:  adversaryIDs(),
+		Deposits:     deposits(),
+		Payments:     payments,
+		Minted:       minted(),
+
+		SlotDuration:  schema.SlotDuration.String(),
+		BlocksPerSlot: schema.BlocksPerSlot,
+		BlockOffset:   schema.BlockOffset,
+		BatchTimeout:  schema.BatchTimeout.String(),
+		RetryCount:    schema.RetryCount,
+		Alpha:         schema.Alpha,
 	}
-	defer tranFile.Close()
+}
 
-	tranWriter := csv.NewWriter(tranFile)
-	if err := tranWriter.Write([]string{"tx_id", "latency_ms", "tx_type", "attempt", "tx_status"}); err != nil {
-		return err
-	}
-	defer func() error {
-		tranWriter.Flush()
-		if err := tranWriter.Error(); err != nil {
-			return err
-		}
+// deposits returns the deposit rules of the run, or nil if bids lock no deposits.
+func deposits() *schema.DepositRules {
+	if depositRules.AmountInCents <= 0 || schema.ExpNum == 2 {
 		return nil
-	}()
-
-	blockFile, err := os.Create(filepath.Join(OutputDir, fmt.Sprintf("%s-%s", outputPrefix, OutputBlock)))
-	if err != nil {
-		return err
 	}
-	defer blockFile.Close()
-
-	blockWriter := csv.NewWriter(blockFile)
-	if err := blockWriter.Write([]string{"block_num", "size_kib"}); err != nil {
-		return err
-	}
-	defer func() error {
-		blockWriter.Flush()
-		if err := blockWriter.Error(); err != nil {
-			return err
-		}
-		return nil
-	}()
+	return &depositRules
+}
 
-	slotFile, err := os.Create(filepath.Join(OutputDir, fmt.Sprintf("%s-%s", outputPrefix, OutputSlot)))
-	if err != nil {
-		return err
+// minted returns what every bidder is minted at the start of the run.
+func minted() float64 {
+	if !payments {
+		return 0
 	}
-	defer slotFile.Close()
-
-	slotWriter := csv.NewWriter(slotFile)
-	if err := slotWriter.Write([]string{"slot_num",
-		"bfg_qty_kwh", "bfg_ppu_c_per_kWh", // bfg = bought from grid
-		"stg_qty_kwh", "stg_ppu_c_per_kWh", // stg = sold to grid
-		"dmi_qty_kwh", "dmi_ppu_c_per_kWh", // dmi = demand met internally
-		"late_cnt_all", "late_cnt_buy", "late_cnt_sell",
-		"late_decrs",
-		"prob_iters", "prob_marshals",
-		"prob_decrs", "prob_bid_calcs",
-		"prob_keys", "prob_gets", "prob_puts"}); err != nil {
-		return err
-	}
-	defer func() error {
-		slotWriter.Flush()
-		if err := slotWriter.Error(); err != nil {
-			return err
-		}
-		return nil
-	}()
+	return mintInCents
+}
 
-	if schema.StagingLevel <= schema.Debug {
-		println()
-		fmt.Fprintln(writer, "transaction stats")
+// newSinks returns the sinks that the `-sinks` flag asks for. They write to
+// files in the output dir, named after the run.
+func newSinks() ([]stats.Sink, error) {
+	path := func(name string) string {
+		return filepath.Join(OutputDir, fmt.Sprintf("%s-%s", outputPrefix, name))
 	}
 
-	for _, tx := range stats.TransactionStats {
-		idNum, _ := strconv.Atoi(tx.ID)
-		idVal := fmt.Sprintf("%012d", idNum)
-		latVal := fmt.Sprintf("%d", tx.LatencyInMillis)
-		attVal := fmt.Sprintf("%d", tx.Attempt)
-		msg := fmt.Sprintf("[event_id: %s]"+
-			"\tlatency:%s ms"+
-			"\t\ttype:%s"+
-			"\t\tattempt:%s"+
-			"\t\tstatus:%s",
-			idVal,
-			latVal,
-			tx.Type,
-			attVal,
-			tx.Status,
-		)
-		if schema.StagingLevel <= schema.Debug {
-			fmt.Fprintln(writer, msg)
-		}
-		if err := tranWriter.Write([]string{idVal, latVal, tx.Type, attVal, tx.Status}); err != nil {
-			return err
+	var sinks []stats.Sink
+	for _, name := range strings.Split(outputSinks, ",") {
+		switch strings.TrimSpace(name) {
+		case "csv":
+			sinks = append(sinks, stats.NewCSVSink(path(OutputTran), path(OutputBlock), path(OutputSlot), logger))
+		case "jsonl":
+			sinks = append(sinks, stats.NewJSONLSink(path(OutputJSONL)))
+		case "sqlite":
+			sink, err := newSQLiteSink(path(OutputSQLite))
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case "summary":
+			sinks = append(sinks, stats.NewSummarySink(path(OutputSummaryJSON), path(OutputSummaryMarkdown), newRunConfig()))
+		default:
+			return nil, fmt.Errorf("unknown sink: %q", name)
 		}
 	}
+	return sinks, nil
+}
 
-	if schema.StagingLevel <= schema.Debug {
-		println()
-		fmt.Fprintln(writer, "block stats")
-	}
+// serveMetrics attaches a monitor to the stats collector, and serves its
+// metrics on `/metrics` at the address that the `-metrics-addr` flag asks for.
+// The returned function stops the server.
+func serveMetrics() (func(), error) {
+	mon := monitor.New()
+	statsCollector.Observers = append(statsCollector.Observers, mon)
 
-	for _, block := range stats.BlockStats {
-		numVal := fmt.Sprintf("%012d", block.Number)
-		sizeVal := fmt.Sprintf("%.1f", block.Size) // ATTN: This is the size in KiB
-		msg := fmt.Sprintf("[block: %s]"+
-			"\t%s KiB",
-			numVal,
-			sizeVal,
-		)
-		if schema.StagingLevel <= schema.Debug {
-			fmt.Fprintln(writer, msg)
-		}
-		if err := blockWriter.Write([]string{numVal, sizeVal}); err != nil {
-			return err
-		}
+	ln, err := net.Listen("tcp", metricsAddr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot serve the metrics: %s", err)
 	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", mon.Handler())
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+
+	mainLog.Info(fmt.Sprintf("serving live metrics at http://%s/metrics", ln.Addr()))
+
+	return func() { srv.Close() }, nil
+}
 
-	println()
+func metrics() error {
+	mainLog.Info("time to collect & print the results...")
 
 	var (
 		metricsOutputVal schema.MetricsOutput
@@ -151,88 +156,67 @@ func metrics() error {
 	)
 
 	args := schema.OpContextInput{
-		EventID: strconv.Itoa(rand.Intn(1E12)),
+		EventID: schema.EventID("main", statsCollector.LargestSlotSeen(), "metrics", 0, 1),
 		Action:  "metrics",
 	}
-	if respB, err = sdkctx.Query(args); err != nil {
-		return err
+	// If the ledger cannot be queried, e.g. because the run was stopped early, we
+	// still write out the slot stats; the contract's counters are left at zero.
+	if respB, err = ledger.Query(args); err != nil {
+		mainLog.Error("cannot query the contract's metrics", logging.Err(err))
+	} else if err := json.Unmarshal(respB, &metricsOutputVal); err != nil {
+		mainLog.Error("cannot unmarshal returned response", logging.Err(err))
 	}
 
-	if err := json.Unmarshal(respB, &metricsOutputVal); err != nil {
-		msg := fmt.Sprintf("main • cannot unmarshal returned response: %s", err.Error())
-		fmt.Fprintln(writer, msg)
+	// We only care about the *cleared* slots, i.e. those slots where we had
+	// a MarkEnd call. In the green path, that is every slot of the trace.
+	if err := statsCollector.Emit(metricsOutputVal); err != nil {
+		return err
 	}
 
-	msg = fmt.Sprintf("main • cleared slot stats")
-	fmt.Fprintln(writer, msg)
-	// We decrement LargestSlotSeen by 1 because we care about the
-	// *cleared* slot, i.e. those slots where we had a MarkEnd call.
-	for i := 0; i <= stats.LargestSlotSeen-1; i++ {
-		slotVal := fmt.Sprintf("%012d", stats.SlotStats[i].Number)
-		bfgQtyVal := fmt.Sprintf("%.3f", stats.SlotStats[i].EnergyUse)
-		bfgPpuVal := fmt.Sprintf("%.3f", stats.SlotStats[i].PricePaid)
-		stgQtyVal := fmt.Sprintf("%.3f", stats.SlotStats[i].EnergyGen)
-		stgPpuVal := fmt.Sprintf("%.3f", stats.SlotStats[i].PriceSold)
-		dmiQtyVal := fmt.Sprintf("%.3f", stats.SlotStats[i].EnergyTraded)
-		dmiPpuVal := fmt.Sprintf("%.3f", stats.SlotStats[i].PriceTraded)
-		lateAllVal := fmt.Sprintf("%d", metricsOutputVal.LateTXsCount[i])
-		lateBuyVal := fmt.Sprintf("%d", metricsOutputVal.LateBuysCount[i])
-		lateSellVal := fmt.Sprintf("%d", metricsOutputVal.LateSellsCount[i])
-		lateDecrVal := fmt.Sprintf("%d", metricsOutputVal.LateDecryptsCount[i])
-		probIterVal := fmt.Sprintf("%d", metricsOutputVal.ProblematicIterCount[i])
-		probMarVal := fmt.Sprintf("%d", metricsOutputVal.ProblematicMarshalCount[i])
-		probDecrVal := fmt.Sprintf("%d", metricsOutputVal.ProblematicDecryptCount[i])
-		probBidCalcVal := fmt.Sprintf("%d", metricsOutputVal.ProblematicBidCalcCount[i])
-		probKeyVal := fmt.Sprintf("%d", metricsOutputVal.ProblematicKeyCount[i])
-		probGetVal := fmt.Sprintf("%d", metricsOutputVal.ProblematicGetStateCount[i])
-		probPutVal := fmt.Sprintf("%d", metricsOutputVal.ProblematicPutStateCount[i])
-		msg := fmt.Sprintf("[slot: %s]"+
-			"\t%s kWh bought from the grid @ %s ç/kWh"+
-			"\t\t%s kWh sold to grid @ %s ç/kWh"+
-			"\t\t%s kWh of demand met internally @ %s ç/kWh"+
-			"\t\t%s late transactions (total)"+
-			"\t\t%s late buy transactions"+
-			"\t\t%s late sell transactions"+
-			"\t\t%s late decryptions"+
-			"\t\t%s problematic iterations"+
-			"\t\t%s problematic de/serializations"+
-			"\t\t%s problematic decrypts"+
-			"\t\t%s problematic bid calculations"+
-			"\t\t%s problematic key creations"+
-			"\t\t%s problematic get states"+
-			"\t\t%s problematic put states",
-			slotVal,
-			bfgQtyVal, bfgPpuVal,
-			stgQtyVal, stgPpuVal,
-			dmiQtyVal, dmiPpuVal,
-			lateAllVal, lateBuyVal, lateSellVal,
-			lateDecrVal,
-			probIterVal, probMarVal,
-			probDecrVal, probBidCalcVal,
-			probKeyVal, probGetVal, probPutVal,
-		)
-		fmt.Fprintln(writer, msg)
-
-		if err := slotWriter.Write([]string{slotVal,
-			bfgQtyVal, bfgPpuVal,
-			stgQtyVal, stgPpuVal,
-			dmiQtyVal, dmiPpuVal,
-			lateAllVal, lateBuyVal, lateSellVal,
-			lateDecrVal,
-			probIterVal, probMarVal,
-			probDecrVal, probBidCalcVal,
-			probKeyVal, probGetVal, probPutVal}); err != nil {
-			return err
+	if deposits() != nil || payments {
+		if err := writeBalances(); err != nil {
+			mainLog.Error("cannot write out the balances of the accounts", logging.Err(err))
 		}
 	}
 
-	println()
+	if injector != nil {
+		counts := injector.Counts()
+		names := make([]string, 0, len(counts))
+		for fault := range counts {
+			names = append(names, fault)
+		}
+		sort.Strings(names)
+		fields := make([]logging.Field, len(names))
+		for i, fault := range names {
+			fields[i] = logging.F(fault, counts[fault])
+		}
+		mainLog.Info("faults injected into the agents' calls", fields...)
+	}
+	mainLog.Info(fmt.Sprintf("number of goroutines still running: %d", runtime.NumGoroutine()))
+	mainLog.Info(fmt.Sprintf("run completed in %s", time.Now().Sub(timeStart)))
 
-	msg = fmt.Sprintf("main • number of goroutines still running: %d", runtime.NumGoroutine())
-	fmt.Fprintln(writer, msg)
+	return nil
+}
 
-	msg = fmt.Sprintf("main • run completed in %s", time.Now().Sub(timeStart))
-	fmt.Fprintln(writer, msg)
+// writeBalances writes the balances of the accounts on the ledger to the
+// output dir, so that what each bidder paid, and forfeited, can be told apart.
+func writeBalances() error {
+	args := schema.OpContextInput{
+		EventID: schema.EventID("main", statsCollector.LargestSlotSeen(), "balances", 0, 1),
+		Action:  "balances",
+	}
+	respB, err := ledger.Query(args)
+	if err != nil {
+		return err
+	}
+	var balancesOutputVal schema.BalancesOutput
+	if err := json.Unmarshal(respB, &balancesOutputVal); err != nil {
+		return err
+	}
 
-	return nil
+	balancesB, err := json.MarshalIndent(balancesOutputVal, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(OutputDir, fmt.Sprintf("%s-%s", outputPrefix, OutputBalances)), balancesB, 0644)
 }
@@ -0,0 +1,241 @@
+package monitor
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/kchristidis/island/stats"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes the names of all the metrics that the monitor exposes.
+const Namespace = "island"
+
+// The statuses that the transactions are counted by. The collector gets the
+// error that an invocation failed with as its status, so we bucket these.
+const (
+	StatusSuccess  = "success"
+	StatusConflict = "mvcc_read_conflict" // Lost to a concurrent transaction (an MVCC or a phantom read)
+	StatusLate     = "late"               // Came in after the slot was marked
+	StatusNoBids   = "no_bids"            // A 'postKey' with no bids to reveal
+	StatusWithheld = "withheld"           // A 'postKey' that an adversarial bidder held back
+	StatusError    = "error"              // Any other failure
+)
+
+// Monitor exposes the stats of a run as Prometheus metrics while the run is in
+// progress. It satisfies the stats.Observer interface; attach it to the
+// collector, and serve its handler.
+type Monitor struct {
+	Registry *prometheus.Registry
+
+	transactions *prometheus.CounterVec
+	latency      *prometheus.HistogramVec
+	retries      *prometheus.CounterVec
+	conflicts    *prometheus.CounterVec
+	late         *prometheus.CounterVec
+	adversarial  *prometheus.CounterVec
+
+	blocks      prometheus.Counter
+	blockHeight prometheus.Gauge
+
+	currentSlot   prometheus.Gauge
+	clearedSlot   prometheus.Gauge
+	slotLate      prometheus.Gauge
+	clearingPrice prometheus.Gauge
+	energyTraded  prometheus.Gauge
+
+	// Late transactions by slot, for the slots that have not been cleared yet,
+	// and the last one that has. A transaction is late once the regulator has
+	// marked its slot, which may be before the slot's stats reach us.
+	lateBySlot  map[int]int
+	lastCleared int
+
+	lastSeen int
+	height   uint64
+}
+
+// New returns a monitor whose metrics are registered with a registry of its own.
+func New() *Monitor {
+	m := &Monitor{
+		Registry: prometheus.NewRegistry(),
+
+		transactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "transactions_total",
+			Help:      "Invocations by type and status.",
+		}, []string{"type", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "transaction_latency_seconds",
+			Help:      "Latency of the invocations by type.",
+			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+		}, []string{"type"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "transaction_retries_total",
+			Help:      "Invocations that were retries of a failed one, by type.",
+		}, []string{"type"}),
+		conflicts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "mvcc_conflicts_total",
+			Help:      "Invocations that failed on an MVCC or a phantom read conflict, by type.",
+		}, []string{"type"}),
+		late: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "late_transactions_total",
+			Help:      "Invocations that came in after their slot was marked, by type.",
+		}, []string{"type"}),
+		adversarial: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "adversarial_transactions_total",
+			Help:      "Invocations of adversarial agents by behavior, type, and status.",
+		}, []string{"behavior", "type", "status"}),
+
+		blocks: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "blocks_total",
+			Help:      "Blocks that the block notifier has seen.",
+		}),
+		blockHeight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "block_height",
+			Help:      "Largest block number seen, off the blocks or the transactions committed in them.",
+		}),
+
+		currentSlot: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "slot",
+			Help:      "Largest slot that the agents have reported stats for.",
+		}),
+		clearedSlot: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "cleared_slot",
+			Help:      "Largest slot that the market has cleared.",
+		}),
+		slotLate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "cleared_slot_late_transactions",
+			Help:      "Late invocations for the largest slot that the market has cleared.",
+		}),
+		clearingPrice: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "cleared_slot_price",
+			Help:      "Clearing price of the largest slot that the market has cleared, in cents per kWh.",
+		}),
+		energyTraded: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "cleared_slot_energy_traded",
+			Help:      "Energy traded in the largest slot that the market has cleared, in kWh.",
+		}),
+
+		lateBySlot:  make(map[int]int),
+		lastCleared: -1,
+	}
+
+	m.Registry.MustRegister(
+		m.transactions, m.latency, m.retries, m.conflicts, m.late, m.adversarial,
+		m.blocks, m.blockHeight,
+		m.currentSlot, m.clearedSlot, m.slotLate, m.clearingPrice, m.energyTraded,
+	)
+	m.clearedSlot.Set(-1)
+
+	return m
+}
+
+// Handler returns an HTTP handler that serves the metrics.
+func (m *Monitor) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveTransaction satisfies the stats.Observer interface. The transactions
+// of adversarial agents are only counted by `adversarial_transactions_total`,
+// and by the late transactions of their slot, which the contract counts too.
+func (m *Monitor) ObserveTransaction(newLine stats.Transaction) {
+	status := Status(newLine.Status)
+	m.observeHeight(newLine.BlockNumber)
+	if newLine.Behavior != "" {
+		m.adversarial.WithLabelValues(newLine.Behavior, newLine.Type, status).Inc()
+	} else {
+		m.transactions.WithLabelValues(newLine.Type, status).Inc()
+		if newLine.LatencyInMillis >= 0 {
+			m.latency.WithLabelValues(newLine.Type).Observe(float64(newLine.LatencyInMillis) / 1000)
+		}
+		if newLine.Attempt > 1 {
+			m.retries.WithLabelValues(newLine.Type).Inc()
+		}
+	}
+
+	switch status {
+	case StatusConflict:
+		if newLine.Behavior == "" {
+			m.conflicts.WithLabelValues(newLine.Type).Inc()
+		}
+	case StatusLate:
+		if newLine.Behavior == "" {
+			m.late.WithLabelValues(newLine.Type).Inc()
+		}
+		slot, ok := schema.EventSlot(newLine.ID)
+		if !ok || slot < m.lastCleared {
+			return
+		}
+		m.lateBySlot[slot]++
+		if slot == m.lastCleared {
+			m.slotLate.Set(float64(m.lateBySlot[slot]))
+		}
+	}
+}
+
+// ObserveBlock satisfies the stats.Observer interface.
+func (m *Monitor) ObserveBlock(newLine stats.Block) {
+	m.blocks.Inc()
+	m.observeHeight(newLine.Number)
+}
+
+// ObserveSlot satisfies the stats.Observer interface.
+func (m *Monitor) ObserveSlot(newLine stats.Slot) {
+	if newLine.Number > m.lastSeen {
+		m.lastSeen = newLine.Number
+		m.currentSlot.Set(float64(m.lastSeen))
+	}
+	if !newLine.Cleared || newLine.Number <= m.lastCleared {
+		return
+	}
+
+	m.lastCleared = newLine.Number
+	for slot := range m.lateBySlot {
+		if slot < m.lastCleared {
+			delete(m.lateBySlot, slot)
+		}
+	}
+	m.clearedSlot.Set(float64(newLine.Number))
+	m.slotLate.Set(float64(m.lateBySlot[newLine.Number]))
+	m.clearingPrice.Set(newLine.PriceTraded)
+	m.energyTraded.Set(newLine.EnergyTraded)
+}
+
+func (m *Monitor) observeHeight(blockNum uint64) {
+	if blockNum > m.height {
+		m.height = blockNum
+		m.blockHeight.Set(float64(m.height))
+	}
+}
+
+// Status buckets the status of a transaction, as reported to the collector.
+func Status(status string) string {
+	switch {
+	case status == "success":
+		return StatusSuccess
+	case strings.Contains(status, "mvcc_read_conflict"), strings.Contains(status, "phantom_read_conflict"):
+		return StatusConflict
+	case strings.Contains(status, "slot marked already"):
+		return StatusLate
+	case strings.Contains(status, "no_bids"):
+		return StatusNoBids
+	case status == "withheld":
+		return StatusWithheld
+	default:
+		return StatusError
+	}
+}
@@ -0,0 +1,102 @@
+package monitor_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/kchristidis/island/monitor"
+	"github.com/kchristidis/island/stats"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, m *monitor.Monitor) string {
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestStatus(t *testing.T) {
+	require.Equal(t, monitor.StatusSuccess, monitor.Status("success"))
+	require.Equal(t, monitor.StatusConflict, monitor.Status("failure: mvcc_read_conflict"))
+	require.Equal(t, monitor.StatusConflict, monitor.Status("failure: phantom_read_conflict"))
+	require.Equal(t, monitor.StatusLate, monitor.Status("tx_id:1 event_id:2 slot:000000000003 action:buy • slot marked already, aborting 'bid' 🛑"))
+	require.Equal(t, monitor.StatusNoBids, monitor.Status("failure: no_bids"))
+	require.Equal(t, monitor.StatusWithheld, monitor.Status("withheld"))
+	require.Equal(t, monitor.StatusError, monitor.Status("unexpected EOF"))
+}
+
+func TestMonitor(t *testing.T) {
+	t.Run("transactions", func(t *testing.T) {
+		m := monitor.New()
+		m.ObserveTransaction(stats.Transaction{Type: "buy", Status: "failure: mvcc_read_conflict", LatencyInMillis: 300, Attempt: 1})
+		m.ObserveTransaction(stats.Transaction{Type: "buy", Status: "success", LatencyInMillis: 2000, Attempt: 2, BlockNumber: 42})
+
+		body := scrape(t, m)
+		require.Contains(t, body, `island_transactions_total{status="mvcc_read_conflict",type="buy"} 1`)
+		require.Contains(t, body, `island_transactions_total{status="success",type="buy"} 1`)
+		require.Contains(t, body, `island_transaction_latency_seconds_count{type="buy"} 2`)
+		require.Contains(t, body, `island_transaction_latency_seconds_sum{type="buy"} 2.3`)
+		require.Contains(t, body, `island_transaction_retries_total{type="buy"} 1`)
+		require.Contains(t, body, `island_mvcc_conflicts_total{type="buy"} 1`)
+		require.Contains(t, body, "island_block_height 42")
+	})
+
+	t.Run("adversarial transactions", func(t *testing.T) {
+		m := monitor.New()
+		m.ObserveTransaction(stats.Transaction{Type: "buy", Status: "success", LatencyInMillis: 300, Attempt: 1, Behavior: "spam"})
+		m.ObserveTransaction(stats.Transaction{Type: "postKey", Status: "withheld", LatencyInMillis: -1, Behavior: "withhold"})
+
+		body := scrape(t, m)
+		require.Contains(t, body, `island_adversarial_transactions_total{behavior="spam",status="success",type="buy"} 1`)
+		require.Contains(t, body, `island_adversarial_transactions_total{behavior="withhold",status="withheld",type="postKey"} 1`)
+		require.NotContains(t, body, "island_transactions_total{")
+		require.NotContains(t, body, "island_transaction_latency_seconds_count")
+	})
+
+	t.Run("blocks", func(t *testing.T) {
+		m := monitor.New()
+		m.ObserveBlock(stats.Block{Number: 7})
+		m.ObserveBlock(stats.Block{Number: 8})
+
+		body := scrape(t, m)
+		require.Contains(t, body, "island_blocks_total 2")
+		require.Contains(t, body, "island_block_height 8")
+	})
+
+	t.Run("slots", func(t *testing.T) {
+		m := monitor.New()
+		require.Contains(t, scrape(t, m), "island_cleared_slot -1")
+
+		late := func(slot int) stats.Transaction {
+			return stats.Transaction{
+				ID:     schema.EventID("bidder0001", slot, "sell", 0, 1),
+				Type:   "sell",
+				Status: "slot marked already, aborting 'bid'",
+			}
+		}
+
+		m.ObserveSlot(stats.Slot{Number: 3, EnergyUse: 1.5})
+		m.ObserveTransaction(late(2)) // Slot 2 is marked before its stats come in
+		m.ObserveSlot(stats.Slot{Number: 2, EnergyTraded: 0.5, PriceTraded: 7.25, Cleared: true})
+		m.ObserveTransaction(late(2))
+
+		body := scrape(t, m)
+		require.Contains(t, body, "island_slot 3")
+		require.Contains(t, body, "island_cleared_slot 2")
+		require.Contains(t, body, "island_cleared_slot_price 7.25")
+		require.Contains(t, body, "island_cleared_slot_energy_traded 0.5")
+		require.Contains(t, body, "island_cleared_slot_late_transactions 2")
+		require.Contains(t, body, `island_late_transactions_total{type="sell"} 2`)
+
+		m.ObserveSlot(stats.Slot{Number: 3, Cleared: true})
+		require.Contains(t, scrape(t, m), "island_cleared_slot_late_transactions 0")
+	})
+}
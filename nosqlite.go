@@ -0,0 +1,15 @@
+// +build !sqlite
+
+package main
+
+import (
+	"errors"
+
+	"github.com/kchristidis/island/stats"
+)
+
+// newSQLiteSink stands in for the `sqlite` sink in binaries built w/o the
+// `sqlite` tag, which do not link SQLite.
+func newSQLiteSink(path string) (stats.Sink, error) {
+	return nil, errors.New("the sqlite sink requires a binary built w/ '-tags sqlite'")
+}
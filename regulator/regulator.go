@@ -1,14 +1,14 @@
 package regulator
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"math/rand"
 	"sync"
 	"time"
 
+	"github.com/kchristidis/island/chaincode/logging"
 	"github.com/kchristidis/island/chaincode/schema"
 	"github.com/kchristidis/island/stats"
 )
@@ -21,7 +21,7 @@ const BufferLen = 100
 // Invoker is an interface that encapsulates the
 // peer calls that are relevant to the regulator.
 type Invoker interface {
-	Invoke(args schema.OpContextInput) ([]byte, error)
+	Invoke(args schema.OpContextInput) ([]byte, schema.TxInfo, error)
 }
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 . Notifier
@@ -32,14 +32,42 @@ type Notifier interface {
 	Register(id int, queue chan int) bool
 }
 
+// Tracker is an interface that encapsulates the calls with which
+// the regulator reports the work it takes on, and finishes, in
+// response to a slot notification. See the `vclock` package.
+type Tracker interface {
+	Add(delta int)
+	Done()
+}
+
+// Tariff is an interface that encapsulates the grid prices
+// that the regulator passes on to the market when it clears a slot.
+type Tariff interface {
+	Prices(slot int) (feedIn, retail float64)
+}
+
+type nopTracker struct{}
+
+func (nopTracker) Add(int) {}
+func (nopTracker) Done()   {}
+
 // Regulator issues regulatory calls to the peer
 // upon receiving slot notifications.
 type Regulator struct {
 	Invoker  Invoker
 	Notifier Notifier
+	Tracker  Tracker // Set it when running in virtual time; a no-op by default
+
+	// Set it when the market settles payments, so that the bids that are not
+	// filled are settled with the grid at its prices.
+	Tariff Tariff
 
 	PrivKeyBytes []byte // The regulator's key pair
 
+	// The first slot that the regulator clears. Set it when resuming a run, as
+	// the market has cleared the slots before it already.
+	FirstSlot int
+
 	// Used to feed the stats collector
 	SlotChan        chan stats.Slot
 	TransactionChan chan stats.Transaction
@@ -54,14 +82,8 @@ type Regulator struct {
 	// main thread in this package for the return of errors.
 	ErrChan chan error
 
-	Writer io.Writer
+	Logger *logging.Logger
 
-	// An external kill switch. Signals to all threads in this package
-	// that they should return.
-	DoneChan chan struct{}
-	// An internal kill switch. It can only be closed by this package,
-	// and it signals to the package's goroutines that they should exit.
-	killChan chan struct{}
 	// Ensures that the main thread in this package doesn't return
 	// before the goroutines it spawned have.
 	waitGroup *sync.WaitGroup
@@ -72,10 +94,11 @@ func New(
 	invoker Invoker, slotnotifier Notifier,
 	privKeyBytes []byte,
 	slotc chan stats.Slot, transactionc chan stats.Transaction,
-	writer io.Writer, donec chan struct{}) *Regulator {
+	logger *logging.Logger) *Regulator {
 	return &Regulator{
 		Invoker:  invoker,
 		Notifier: slotnotifier,
+		Tracker:  nopTracker{},
 
 		PrivKeyBytes: privKeyBytes,
 
@@ -86,121 +109,48 @@ func New(
 		TaskQueue: make(chan int, BufferLen),
 		ErrChan:   make(chan error),
 
-		Writer: writer,
+		Logger: logger.With(logging.Component("regulator")),
 
-		DoneChan:  donec,
-		killChan:  make(chan struct{}),
 		waitGroup: new(sync.WaitGroup),
 	}
 }
 
-// Run executes the regulator logic.
-func (r *Regulator) Run() error {
-	msg := fmt.Sprint("regulator • exited")
-	defer fmt.Fprintln(r.Writer, msg)
+// Run executes the regulator logic until the context is canceled. A
+// 'markEnd' call that is in flight is seen through before Run returns.
+func (r *Regulator) Run(ctx context.Context) error {
+	defer r.Logger.Info("exited")
 
+	// Canceled when Run returns, so that the goroutines spawned here exit
+	ctx, cancel := context.WithCancel(ctx)
 	defer func() {
-		close(r.killChan)
+		cancel()
 		r.waitGroup.Wait()
 	}()
 
 	if ok := r.Notifier.Register(-1, r.SlotQueue); !ok {
-		msg := fmt.Sprintf("regulator • cannot register with slot notifier")
-		fmt.Fprintln(r.Writer, msg)
+		msg := "cannot register with slot notifier"
+		r.Logger.Error(msg)
 		return errors.New(msg)
 	}
 
-	if schema.StagingLevel <= schema.Debug {
-		msg := fmt.Sprint("regulator • registered with slot notifier")
-		fmt.Fprintln(r.Writer, msg)
-	}
+	r.Logger.Debug("registered with slot notifier")
+
+	// Closed once the end of the last slot of the trace has been marked
+	lastc := make(chan struct{})
 
 	r.waitGroup.Add(1)
 	go func() {
 		defer r.waitGroup.Done()
 		for {
 			select {
-			case <-r.killChan:
-				return
 			case slot := <-r.TaskQueue:
-				affectedSlot := slot - 1
-				eventID := fmt.Sprintf("%013d", rand.Intn(1E12))
-
-				msg := fmt.Sprintf("regulator event_id:%s slot:%012d • about to invoke 'markEnd' - note! this will mark the end of slot %012d", eventID, slot, affectedSlot)
-				fmt.Fprintln(r.Writer, msg)
-
-				// We decrement the slot number because a markEnd call
-				// @ slot N is supposed to mark the end of slot N-1.
-				args := schema.OpContextInput{
-					EventID: eventID,
-					Action:  "markEnd",
-					Slot:    affectedSlot,
+				r.markEnd(ctx, slot)
+				r.Tracker.Done()
+				if slot-1 == schema.TraceLength-1 {
+					close(lastc)
+					return
 				}
-
-				// Does the regulator need to post its private key for slot
-				// N-1 so that everyone else can verify the encrypted bids?
-				switch schema.ExpNum {
-				case 1, 3:
-					args.Data = nil
-				case 2:
-					markEndInputVal := schema.MarkEndInput{
-						PrivKey: r.PrivKeyBytes,
-					}
-					markEndInputValB, err := json.Marshal(markEndInputVal)
-					if err != nil {
-						msg := fmt.Sprintf("regulator event_id:%s slot:%012d • cannot encode 'markEnd' call to JSON: %s", eventID, slot, err.Error())
-						fmt.Fprintln(r.Writer, msg)
-						continue
-					}
-					args.Data = markEndInputValB
-				}
-
-				timeStart := time.Now()
-
-				respB, err := r.Invoker.Invoke(args)
-
-				// Update stats
-				timeEnd := time.Now()
-				elapsed := int64(timeEnd.Sub(timeStart) / time.Millisecond)
-
-				if err != nil {
-					r.TransactionChan <- stats.Transaction{
-						ID:              eventID,
-						Type:            "markEnd",
-						Status:          err.Error(),
-						LatencyInMillis: elapsed,
-						Attempt:         1, // TODO: Implement retries
-					}
-					msg := fmt.Sprintf("regulator event_id:%s slot:%012d • failure! cannot invoke 'markEnd': %s\n", eventID, slot, err)
-					r.ErrChan <- errors.New(msg)
-				} else {
-					r.TransactionChan <- stats.Transaction{
-						ID:              eventID,
-						Type:            "markEnd",
-						Status:          "success",
-						LatencyInMillis: elapsed,
-					}
-
-					var markendOutputVal schema.MarkEndOutput
-					if err := json.Unmarshal(respB, &markendOutputVal); err != nil {
-						msg := fmt.Sprintf("regulator event_id:%s slot:%012d • cannot decode JSON response to 'markEnd' invocation: %s", eventID, slot, err.Error())
-						fmt.Fprintln(r.Writer, msg)
-						r.ErrChan <- errors.New(msg)
-						continue
-					}
-
-					msg := fmt.Sprintf("regulator event_id:%s slot:%012d • invocation response: %s", eventID, slot, markendOutputVal.Message)
-					fmt.Fprintln(r.Writer, msg)
-					if slot > -1 { // The markEnd call @ -1 is useless.
-						r.SlotChan <- stats.Slot{
-							Number:       affectedSlot, // ATTN: markEnd @ slot N clears the market @ slot N-1.
-							EnergyTraded: markendOutputVal.QuantityInKWh,
-							PriceTraded:  markendOutputVal.PricePerUnitInCents,
-						}
-					}
-
-				}
-			case <-r.DoneChan:
+			case <-ctx.Done():
 				return
 			}
 		}
@@ -208,27 +158,31 @@ func (r *Regulator) Run() error {
 
 	for {
 		select {
-		case err := <-r.ErrChan:
-			fmt.Fprintln(r.Writer, err.Error())
+		case <-r.ErrChan:
+			// Logged by markEnd already
 			// return err
+		case <-lastc:
+			r.Logger.Info("done clearing the trace! exiting", logging.Slot(schema.TraceLength-1))
+			return nil
 		case slot := <-r.SlotQueue:
-			msg := fmt.Sprintf("regulator slot:%012d • new slot!", slot)
-			fmt.Fprintln(r.Writer, msg)
-			if slot == 0 {
-				msg := fmt.Sprintf("regulator slot:%012d • no point to act on slot 0 — skipping!", slot)
-				fmt.Fprintln(r.Writer, msg)
+			r.Logger.Info("new slot!", logging.Slot(slot))
+			if slot-1 < r.FirstSlot || slot > schema.TraceLength {
+				r.Logger.Info("no slot to clear ends here — skipping!", logging.Slot(slot))
+				r.Tracker.Done()
 				continue
 			}
+			r.Tracker.Add(1)
 			select {
 			case r.TaskQueue <- slot:
+				r.Tracker.Done()
 			default:
-				msg := fmt.Sprintf("regulator slot:%012d • cannot push notification to task queue (size: %d)", slot, len(r.TaskQueue))
-				fmt.Fprintln(r.Writer, msg)
-				return errors.New(msg)
+				msg := fmt.Sprintf("cannot push notification to task queue (size: %d)", len(r.TaskQueue))
+				r.Logger.Error(msg, logging.Slot(slot))
+				return fmt.Errorf("slot %d: %s", slot, msg)
 			}
-		case <-r.DoneChan:
+		case <-ctx.Done():
 			select {
-			// Cover the edge case in tests where you close the DoneChan to conclude
+			// Cover the edge case in tests where you cancel the context to conclude
 			// the test, and this branch is selected over the top-level ErrChan one
 			case err := <-r.ErrChan:
 				return err
@@ -238,3 +192,107 @@ func (r *Regulator) Run() error {
 		}
 	}
 }
+
+// markEnd marks the end of the slot that precedes the given one.
+func (r *Regulator) markEnd(ctx context.Context, slot int) {
+	affectedSlot := slot - 1
+	eventID := schema.EventID("regulator", slot, "markEnd", 0, 1)
+	log := r.Logger.With(logging.EventID(eventID), logging.Slot(slot))
+
+	log.Info(fmt.Sprintf("about to invoke 'markEnd' - note! this will mark the end of slot %012d", affectedSlot))
+
+	// We decrement the slot number because a markEnd call
+	// @ slot N is supposed to mark the end of slot N-1.
+	args := schema.OpContextInput{
+		EventID: eventID,
+		Action:  "markEnd",
+		Slot:    affectedSlot,
+	}
+
+	// Does the regulator need to post its private key for slot
+	// N-1 so that everyone else can verify the encrypted bids?
+	// Does the market need the grid prices to settle with the grid?
+	var markEndInputVal schema.MarkEndInput
+	if schema.ExpNum == 2 {
+		markEndInputVal.PrivKey = r.PrivKeyBytes
+	}
+	if r.Tariff != nil {
+		feedIn, retail := r.Tariff.Prices(affectedSlot)
+		markEndInputVal.GridPrices = &schema.GridPrices{RetailInCents: retail, FeedInInCents: feedIn}
+	}
+	if schema.ExpNum == 2 || markEndInputVal.GridPrices != nil {
+		markEndInputValB, err := json.Marshal(markEndInputVal)
+		if err != nil {
+			log.Error("cannot encode 'markEnd' call to JSON", logging.Err(err))
+			return
+		}
+		args.Data = markEndInputValB
+	}
+
+	timeStart := time.Now()
+
+	respB, txInfo, err := r.Invoker.Invoke(args)
+
+	// Update stats
+	timeEnd := time.Now()
+	elapsed := int64(timeEnd.Sub(timeStart) / time.Millisecond)
+
+	if err != nil {
+		r.TransactionChan <- stats.Transaction{
+			ID:              eventID,
+			Type:            "markEnd",
+			Status:          err.Error(),
+			LatencyInMillis: elapsed,
+			Attempt:         1, // TODO: Implement retries
+		}
+		msg := fmt.Sprintf("failure! cannot invoke 'markEnd': %s", err)
+		log.Error(msg)
+		r.report(ctx, errors.New(msg))
+	} else {
+		r.TransactionChan <- stats.Transaction{
+			ID:              eventID,
+			Type:            "markEnd",
+			Status:          "success",
+			LatencyInMillis: elapsed,
+			Attempt:         1,
+			TxID:            txInfo.ID,
+			BlockNumber:     txInfo.BlockNumber,
+		}
+
+		var markendOutputVal schema.MarkEndOutput
+		if err := json.Unmarshal(respB, &markendOutputVal); err != nil {
+			msg := fmt.Sprintf("cannot decode JSON response to 'markEnd' invocation: %s", err.Error())
+			log.Error(msg)
+			r.report(ctx, errors.New(msg))
+			return
+		}
+
+		log.Info(fmt.Sprintf("invocation response: %s", markendOutputVal.Message))
+		if slot > -1 { // The markEnd call @ -1 is useless.
+			slotStats := stats.Slot{
+				Number:       affectedSlot, // ATTN: markEnd @ slot N clears the market @ slot N-1.
+				Cleared:      true,
+				EnergyTraded: markendOutputVal.QuantityInKWh,
+				PriceTraded:  markendOutputVal.PricePerUnitInCents,
+			}
+			if n := markendOutputVal.Network; n != nil {
+				slotStats.EnergyCurtailed = n.CurtailedInKWh
+				slotStats.EnergyLost = n.LossesInKWh
+				slotStats.Congestions = len(n.CongestedFeeders)
+			}
+			if d := markendOutputVal.Deposits; d != nil {
+				slotStats.Forfeits = len(d.Forfeits)
+				slotStats.DepositsForfeited = d.ForfeitedInCents
+			}
+			r.SlotChan <- slotStats
+		}
+	}
+}
+
+// report hands an error to the main thread, unless the latter has returned.
+func (r *Regulator) report(ctx context.Context, err error) {
+	select {
+	case r.ErrChan <- err:
+	case <-ctx.Done():
+	}
+}
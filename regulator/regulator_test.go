@@ -1,12 +1,14 @@
 package regulator_test
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"path/filepath"
 	"testing"
 
+	"github.com/kchristidis/island/chaincode/logging"
 	"github.com/kchristidis/island/chaincode/schema"
 	"github.com/kchristidis/island/crypto"
 	"github.com/kchristidis/island/regulator"
@@ -36,54 +38,54 @@ func TestRegulator(t *testing.T) {
 		slotnotifier.RegisterReturns(false)
 
 		bfr := gbytes.NewBuffer()
-		donec := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
 
 		r := regulator.New(
 			invoker, slotnotifier,
 			privkeybytes,
 			slotc, transactionc,
-			bfr, donec,
+			logging.New(bfr, logging.DebugLevel, logging.Text),
 		)
 
 		var err error
 		deadc := make(chan struct{})
 		go func() {
-			err = r.Run()
+			err = r.Run(ctx)
 			close(deadc)
 		}()
 
 		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say("cannot register"))
 		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say("exited"))
 
-		close(donec)
+		cancel()
 		<-deadc
 		g.Expect(err).To(HaveOccurred())
 	})
 
-	t.Run("done chan closes", func(t *testing.T) {
+	t.Run("context is canceled", func(t *testing.T) {
 		invoker := new(regulatorfakes.FakeInvoker)
 
 		slotnotifier := new(regulatorfakes.FakeNotifier)
 		slotnotifier.RegisterReturns(true)
 
 		bfr := gbytes.NewBuffer()
-		donec := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
 
 		r := regulator.New(
 			invoker, slotnotifier,
 			privkeybytes,
 			slotc, transactionc,
-			bfr, donec,
+			logging.New(bfr, logging.DebugLevel, logging.Text),
 		)
 
 		var err error
 		deadc := make(chan struct{})
 		go func() {
-			err = r.Run()
+			err = r.Run(ctx)
 			close(deadc)
 		}()
 
-		close(donec)
+		cancel()
 		<-deadc
 
 		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say("exited"))
@@ -97,45 +99,143 @@ func TestRegulator(t *testing.T) {
 			Slot: slot,
 		}
 		markendOutputValB, _ := json.Marshal(markendOutputVal)
-		invoker.InvokeReturns(markendOutputValB, nil)
+		invoker.InvokeReturns(markendOutputValB, schema.TxInfo{}, nil)
 
 		slotnotifier := new(regulatorfakes.FakeNotifier)
 		slotnotifier.RegisterReturns(true)
 
 		bfr := gbytes.NewBuffer()
-		donec := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
 
 		r := regulator.New(
 			invoker, slotnotifier,
 			privkeybytes,
 			slotc, transactionc,
-			bfr, donec,
+			logging.New(bfr, logging.DebugLevel, logging.Text),
 		)
 
 		var err error
 		deadc := make(chan struct{})
 		go func() {
-			err = r.Run()
+			err = r.Run(ctx)
 			close(deadc)
 		}()
 
 		r.SlotQueue <- slot
 
-		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say(fmt.Sprintf("slot:%012d • about to invoke 'markEnd'", slot)))
+		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say(fmt.Sprintf("slot=%d • about to invoke 'markEnd'", slot)))
 
 		g.Eventually(func() int {
 			args := invoker.InvokeArgsForCall(0)
 			return args.Slot
 		}, "1s", "50ms").Should(Equal(slot - 1))
+		g.Expect(invoker.InvokeArgsForCall(0).EventID).To(Equal(schema.EventID("regulator", slot, "markEnd", 0, 1)))
 
-		close(donec)
+		cancel()
 		<-deadc
 		g.Expect(err).NotTo(HaveOccurred())
 	})
 
+	t.Run("passes the grid prices on", func(t *testing.T) {
+		invoker := new(regulatorfakes.FakeInvoker)
+		slot := 5
+		markendOutputValB, _ := json.Marshal(schema.MarkEndOutput{Slot: slot})
+		invoker.InvokeReturns(markendOutputValB, schema.TxInfo{}, nil)
+
+		slotnotifier := new(regulatorfakes.FakeNotifier)
+		slotnotifier.RegisterReturns(true)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		r := regulator.New(
+			invoker, slotnotifier,
+			privkeybytes,
+			slotc, transactionc,
+			logging.Nop(),
+		)
+		r.Tariff = flatTariff{feedIn: 4, retail: 20}
+
+		deadc := make(chan struct{})
+		go func() {
+			r.Run(ctx)
+			close(deadc)
+		}()
+
+		r.SlotQueue <- slot
+
+		g.Eventually(invoker.InvokeCallCount, "1s", "50ms").Should(Equal(1))
+		var markEndInputVal schema.MarkEndInput
+		g.Expect(json.Unmarshal(invoker.InvokeArgsForCall(0).Data, &markEndInputVal)).To(Succeed())
+		g.Expect(markEndInputVal.GridPrices).To(Equal(&schema.GridPrices{RetailInCents: 20, FeedInInCents: 4}))
+
+		cancel()
+		<-deadc
+	})
+
+	t.Run("returns once the last slot is cleared", func(t *testing.T) {
+		invoker := new(regulatorfakes.FakeInvoker)
+		last := schema.TraceLength - 1
+		markendOutputValB, _ := json.Marshal(schema.MarkEndOutput{Slot: last})
+		invoker.InvokeReturns(markendOutputValB, schema.TxInfo{}, nil)
+
+		slotnotifier := new(regulatorfakes.FakeNotifier)
+		slotnotifier.RegisterReturns(true)
+
+		slotc := make(chan stats.Slot, 10)
+		r := regulator.New(
+			invoker, slotnotifier,
+			privkeybytes,
+			slotc, transactionc,
+			logging.Nop(),
+		)
+
+		errc := make(chan error, 1)
+		go func() {
+			errc <- r.Run(context.Background())
+		}()
+
+		r.SlotQueue <- last + 1
+		g.Eventually(errc, "1s", "50ms").Should(Receive(BeNil()))
+		g.Expect(invoker.InvokeArgsForCall(0).Slot).To(Equal(last))
+		slotStats := <-slotc
+		g.Expect(slotStats.Number).To(Equal(last))
+		g.Expect(slotStats.Cleared).To(BeTrue())
+	})
+
+	t.Run("skips the slots cleared before the run was resumed", func(t *testing.T) {
+		invoker := new(regulatorfakes.FakeInvoker)
+
+		slotnotifier := new(regulatorfakes.FakeNotifier)
+		slotnotifier.RegisterReturns(true)
+
+		bfr := gbytes.NewBuffer()
+		ctx, cancel := context.WithCancel(context.Background())
+
+		r := regulator.New(
+			invoker, slotnotifier,
+			privkeybytes,
+			slotc, transactionc,
+			logging.New(bfr, logging.DebugLevel, logging.Text),
+		)
+		r.FirstSlot = 5
+
+		deadc := make(chan struct{})
+		go func() {
+			r.Run(ctx)
+			close(deadc)
+		}()
+
+		r.SlotQueue <- r.FirstSlot
+		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say("no slot to clear ends here"))
+		g.Expect(invoker.InvokeCallCount()).To(BeZero())
+
+		cancel()
+		<-deadc
+	})
+
 	t.Run("invocation returns error", func(t *testing.T) {
 		invoker := new(regulatorfakes.FakeInvoker)
-		invoker.InvokeReturns(nil, errors.New("foo"))
+		invoker.InvokeReturns(nil, schema.TxInfo{}, errors.New("foo"))
 
 		slotnotifier := new(regulatorfakes.FakeNotifier)
 		slotnotifier.RegisterReturns(true)
@@ -143,27 +243,27 @@ func TestRegulator(t *testing.T) {
 		slot := 5
 
 		bfr := gbytes.NewBuffer()
-		donec := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
 
 		r := regulator.New(
 			invoker, slotnotifier,
 			privkeybytes,
 			slotc, transactionc,
-			bfr, donec,
+			logging.New(bfr, logging.DebugLevel, logging.Text),
 		)
 
 		var err error
 		deadc := make(chan struct{})
 		go func() {
-			err = r.Run()
+			err = r.Run(ctx)
 			close(deadc)
 		}()
 
 		r.SlotQueue <- slot
 
-		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say(fmt.Sprintf("slot:%012d • failure! cannot invoke 'markEnd'", slot)))
+		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say(fmt.Sprintf("slot=%d • failure! cannot invoke 'markEnd'", slot)))
 
-		close(donec)
+		cancel()
 		<-deadc
 		g.Expect(err).NotTo(HaveOccurred())
 	})
@@ -177,29 +277,33 @@ func TestRegulator(t *testing.T) {
 		slot := 5
 
 		bfr := gbytes.NewBuffer()
-		donec := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
 
 		r := regulator.New(
 			invoker, slotnotifier,
 			privkeybytes,
 			slotc, transactionc,
-			bfr, donec,
+			logging.New(bfr, logging.DebugLevel, logging.Text),
 		)
 		r.TaskQueue = nil
 
 		var err error
 		deadc := make(chan struct{})
 		go func() {
-			err = r.Run()
+			err = r.Run(ctx)
 			close(deadc)
 		}()
 
 		r.SlotQueue <- slot
 
-		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say(fmt.Sprintf("slot:%012d • cannot push notification to task queue", slot)))
+		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say(fmt.Sprintf("slot=%d • cannot push notification to task queue", slot)))
 
-		close(donec)
+		cancel()
 		<-deadc
 		g.Expect(err).To(HaveOccurred())
 	})
 }
+
+type flatTariff struct{ feedIn, retail float64 }
+
+func (t flatTariff) Prices(int) (float64, float64) { return t.feedIn, t.retail }
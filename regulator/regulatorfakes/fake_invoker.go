@@ -9,24 +9,26 @@ import (
 )
 
 type FakeInvoker struct {
-	InvokeStub        func(schema.OpContextInput) ([]byte, error)
+	InvokeStub        func(schema.OpContextInput) ([]byte, schema.TxInfo, error)
 	invokeMutex       sync.RWMutex
 	invokeArgsForCall []struct {
 		arg1 schema.OpContextInput
 	}
 	invokeReturns struct {
 		result1 []byte
-		result2 error
+		result2 schema.TxInfo
+		result3 error
 	}
 	invokeReturnsOnCall map[int]struct {
 		result1 []byte
-		result2 error
+		result2 schema.TxInfo
+		result3 error
 	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeInvoker) Invoke(arg1 schema.OpContextInput) ([]byte, error) {
+func (fake *FakeInvoker) Invoke(arg1 schema.OpContextInput) ([]byte, schema.TxInfo, error) {
 	fake.invokeMutex.Lock()
 	ret, specificReturn := fake.invokeReturnsOnCall[len(fake.invokeArgsForCall)]
 	fake.invokeArgsForCall = append(fake.invokeArgsForCall, struct {
@@ -38,10 +40,10 @@ func (fake *FakeInvoker) Invoke(arg1 schema.OpContextInput) ([]byte, error) {
 		return fake.InvokeStub(arg1)
 	}
 	if specificReturn {
-		return ret.result1, ret.result2
+		return ret.result1, ret.result2, ret.result3
 	}
 	fakeReturns := fake.invokeReturns
-	return fakeReturns.result1, fakeReturns.result2
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
 }
 
 func (fake *FakeInvoker) InvokeCallCount() int {
@@ -50,7 +52,7 @@ func (fake *FakeInvoker) InvokeCallCount() int {
 	return len(fake.invokeArgsForCall)
 }
 
-func (fake *FakeInvoker) InvokeCalls(stub func(schema.OpContextInput) ([]byte, error)) {
+func (fake *FakeInvoker) InvokeCalls(stub func(schema.OpContextInput) ([]byte, schema.TxInfo, error)) {
 	fake.invokeMutex.Lock()
 	defer fake.invokeMutex.Unlock()
 	fake.InvokeStub = stub
@@ -63,30 +65,33 @@ func (fake *FakeInvoker) InvokeArgsForCall(i int) schema.OpContextInput {
 	return argsForCall.arg1
 }
 
-func (fake *FakeInvoker) InvokeReturns(result1 []byte, result2 error) {
+func (fake *FakeInvoker) InvokeReturns(result1 []byte, result2 schema.TxInfo, result3 error) {
 	fake.invokeMutex.Lock()
 	defer fake.invokeMutex.Unlock()
 	fake.InvokeStub = nil
 	fake.invokeReturns = struct {
 		result1 []byte
-		result2 error
-	}{result1, result2}
+		result2 schema.TxInfo
+		result3 error
+	}{result1, result2, result3}
 }
 
-func (fake *FakeInvoker) InvokeReturnsOnCall(i int, result1 []byte, result2 error) {
+func (fake *FakeInvoker) InvokeReturnsOnCall(i int, result1 []byte, result2 schema.TxInfo, result3 error) {
 	fake.invokeMutex.Lock()
 	defer fake.invokeMutex.Unlock()
 	fake.InvokeStub = nil
 	if fake.invokeReturnsOnCall == nil {
 		fake.invokeReturnsOnCall = make(map[int]struct {
 			result1 []byte
-			result2 error
+			result2 schema.TxInfo
+			result3 error
 		})
 	}
 	fake.invokeReturnsOnCall[i] = struct {
 		result1 []byte
-		result2 error
-	}{result1, result2}
+		result2 schema.TxInfo
+		result3 error
+	}{result1, result2, result3}
 }
 
 func (fake *FakeInvoker) Invocations() map[string][][]interface{} {
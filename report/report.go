@@ -0,0 +1,225 @@
+// Package report renders the results of a run as a self-contained HTML page,
+// with the charts embedded as SVG, so that it can be shared as a single file
+// and viewed offline.
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"sort"
+
+	"github.com/kchristidis/island/runs"
+	"github.com/kchristidis/island/stats"
+)
+
+// MaxCDFPoints caps the points that a latency CDF is drawn with.
+const MaxCDFPoints = 500
+
+// page is what the template is executed with.
+type page struct {
+	Name    string
+	Summary *stats.Summary
+	Types   []string // The transaction types in the summary, sorted
+	Config  string   // Indented JSON; empty if unknown
+	Charts  []*chart
+}
+
+// HTML renders the run as an HTML page.
+func HTML(run *runs.Run) ([]byte, error) {
+	p := &page{
+		Name:    run.Name,
+		Summary: run.Summary(),
+	}
+	for txType := range p.Summary.Latency {
+		p.Types = append(p.Types, txType)
+	}
+	sort.Strings(p.Types)
+	if len(run.Config) > 0 {
+		var b bytes.Buffer
+		if err := json.Indent(&b, run.Config, "", "  "); err != nil {
+			return nil, err
+		}
+		p.Config = b.String()
+	}
+
+	p.Charts = []*chart{
+		latencyChart(run),
+		lateChart(run),
+		priceChart(run),
+		energyChart(run),
+		blockChart(run),
+	}
+
+	var b bytes.Buffer
+	if err := pageTmpl.Execute(&b, p); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// latencyChart plots the CDF of the latencies of each transaction type.
+func latencyChart(run *runs.Run) *chart {
+	c := &chart{
+		Title:  "Latency CDF by transaction type",
+		XLabel: "latency (ms)",
+		YLabel: "share of transactions",
+	}
+
+	byType := make(map[string][]float64)
+	for _, tx := range run.Transactions {
+		if tx.LatencyInMillis >= 0 {
+			byType[tx.Type] = append(byType[tx.Type], float64(tx.LatencyInMillis))
+		}
+	}
+	var types []string
+	for txType := range byType {
+		types = append(types, txType)
+	}
+	sort.Strings(types)
+
+	for _, txType := range types {
+		vals := byType[txType]
+		sort.Float64s(vals)
+		step := 1
+		if len(vals) > MaxCDFPoints {
+			step = (len(vals) + MaxCDFPoints - 1) / MaxCDFPoints
+		}
+		s := series{Name: txType}
+		for i := 0; i < len(vals); i += step {
+			s.Points = append(s.Points, point{vals[i], float64(i+1) / float64(len(vals))})
+		}
+		if last := len(vals) - 1; last%step != 0 {
+			s.Points = append(s.Points, point{vals[last], 1})
+		}
+		c.Series = append(c.Series, s)
+	}
+	return c
+}
+
+// lateChart plots the late transactions in each slot.
+func lateChart(run *runs.Run) *chart {
+	s := series{Name: "late transactions", Bars: true}
+	for i, slot := range run.Slots {
+		s.Points = append(s.Points, point{float64(slot.Number), float64(run.Counters[i].LateTXs)})
+	}
+	return &chart{
+		Title:  "Late transactions by slot",
+		XLabel: "slot",
+		YLabel: "transactions",
+		Series: []series{s},
+	}
+}
+
+// priceChart plots the clearing price of the slots where energy was traded,
+// against the band of the grid's feed-in (Lo) and retail (Hi) prices.
+func priceChart(run *runs.Run) *chart {
+	b := &band{Name: "grid prices (Lo–Hi)"}
+	s := series{Name: "clearing price"}
+	for _, slot := range run.Slots {
+		x := float64(slot.Number)
+		b.Lower = append(b.Lower, point{x, slot.PriceSold})
+		b.Upper = append(b.Upper, point{x, slot.PricePaid})
+		if slot.EnergyTraded > 0 {
+			s.Points = append(s.Points, point{x, slot.PriceTraded})
+		}
+	}
+	return &chart{
+		Title:  "Clearing price against the grid prices",
+		XLabel: "slot",
+		YLabel: "price (ç/kWh)",
+		Series: []series{s},
+		Band:   b,
+	}
+}
+
+// energyChart plots the energy traded locally in each slot, against the energy
+// bought from, and sold to, the grid.
+func energyChart(run *runs.Run) *chart {
+	local := series{Name: "traded locally"}
+	bought := series{Name: "bought from the grid"}
+	sold := series{Name: "sold to the grid"}
+	for _, slot := range run.Slots {
+		x := float64(slot.Number)
+		local.Points = append(local.Points, point{x, slot.EnergyTraded})
+		bought.Points = append(bought.Points, point{x, slot.EnergyUse})
+		sold.Points = append(sold.Points, point{x, slot.EnergyGen})
+	}
+	return &chart{
+		Title:  "Energy traded locally against the grid",
+		XLabel: "slot",
+		YLabel: "energy (kWh)",
+		Series: []series{local, bought, sold},
+	}
+}
+
+// blockChart plots the size of the blocks; these are only collected if
+// `schema.EnableBlockStatsCollection` is set.
+func blockChart(run *runs.Run) *chart {
+	s := series{Name: "block size"}
+	for _, block := range run.Blocks {
+		s.Points = append(s.Points, point{float64(block.Number), float64(block.Size)})
+	}
+	return &chart{
+		Title:  "Block size over time",
+		XLabel: "block",
+		YLabel: "size (KiB)",
+		Series: []series{s},
+	}
+}
+
+var pageTmpl = template.Must(template.New("page").Funcs(template.FuncMap{
+	"pct": func(v float64) string { return fmt.Sprintf("%.2f%%", 100*v) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 760px; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border-bottom: 1px solid #ddd; padding: 4px 10px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+svg { font-size: 11px; margin-bottom: 1em; }
+pre { background: #f6f6f6; padding: 1em; overflow-x: auto; }
+.empty { color: #888; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+{{with .Summary}}
+<p>{{.Slots}} cleared slots, {{.Transactions}} transactions.</p>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Type</th><th>Count</th><th>p50 (ms)</th><th>p90 (ms)</th><th>p99 (ms)</th></tr>
+{{range $t := $.Types}}{{with index $.Summary.Latency $t}}<tr><td>{{$t}}</td><td>{{.Count}}</td><td>{{.P50}}</td><td>{{.P90}}</td><td>{{.P99}}</td></tr>
+{{end}}{{end}}</table>
+<table>
+<tr><th>Attempt</th><th>Count</th><th>Succeeded</th><th>Rate</th></tr>
+{{range .Attempts}}<tr><td>{{.Attempt}}</td><td>{{.Count}}</td><td>{{.Succeeded}}</td><td>{{pct .SuccessRate}}</td></tr>
+{{end}}</table>
+<table>
+<tr><td>MVCC read conflicts</td><td>{{.MVCCConflicts}}</td><td>{{pct .MVCCConflictRatio}}</td></tr>
+<tr><td>Late transactions</td><td>{{.Late.All}}</td><td>{{pct .Late.AllRate}}</td></tr>
+<tr><td>Energy traded locally</td><td colspan="2">{{printf "%.3f" .Energy.Local}} kWh</td></tr>
+<tr><td>Energy bought from the grid</td><td colspan="2">{{printf "%.3f" .Energy.GridBought}} kWh</td></tr>
+<tr><td>Energy sold to the grid</td><td colspan="2">{{printf "%.3f" .Energy.GridSold}} kWh</td></tr>
+<tr><td>Self-sufficiency</td><td colspan="2">{{pct .Energy.SelfSufficiency}}</td></tr>
+<tr><td>Average clearing price</td><td colspan="2">{{printf "%.3f" .Prices.Clearing}} ç/kWh</td></tr>
+<tr><td>Average retail / feed-in price</td><td colspan="2">{{printf "%.3f" .Prices.Retail}} / {{printf "%.3f" .Prices.FeedIn}} ç/kWh</td></tr>
+<tr><td>Social welfare</td><td colspan="2">{{printf "%.3f" .Welfare.Total}} ç</td></tr>
+</table>
+{{end}}
+{{range .Charts}}
+<h2>{{.Title}}</h2>
+{{.SVG}}
+{{end}}
+{{with .Config}}
+<h2>Configuration</h2>
+<pre>{{.}}</pre>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,51 @@
+package report_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/kchristidis/island/report"
+	"github.com/kchristidis/island/runs"
+	"github.com/kchristidis/island/stats"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTML(t *testing.T) {
+	run := &runs.Run{
+		Name: "exp-01-run-01",
+		Results: stats.Results{
+			Transactions: []stats.Transaction{
+				{Type: "buy", Status: "success", LatencyInMillis: 2100, Attempt: 1},
+				{Type: "markEnd", Status: "success", LatencyInMillis: 2300, Attempt: 1},
+			},
+			Slots: []stats.Slot{
+				{Number: 0, EnergyUse: 1.25, PricePaid: 10.84, PriceSold: 3.4, EnergyTraded: 0.75, PriceTraded: 7.125, Cleared: true},
+				{Number: 1, EnergyUse: 2, PricePaid: 10.84, PriceSold: 3.4, Cleared: true},
+			},
+			Counters: []stats.SlotCounters{{}, {LateTXs: 3}},
+		},
+		Config: json.RawMessage(`{"Seed":1,"TracePath":"<trace>"}`),
+	}
+
+	t.Run("without block stats", func(t *testing.T) {
+		page, err := report.HTML(run)
+		require.NoError(t, err)
+		html := string(page)
+
+		require.Equal(t, 4, strings.Count(html, "<svg"))
+		require.Contains(t, html, "Block size over time: no data.")
+		require.Contains(t, html, "<td>buy</td><td>1</td><td>2100</td>")
+		require.Contains(t, html, "&lt;trace&gt;") // The config is escaped
+		require.NotContains(t, html, "<script")
+		require.NotContains(t, html, "src=")
+		require.NotContains(t, html, "href=")
+	})
+
+	t.Run("with block stats", func(t *testing.T) {
+		run.Blocks = []stats.Block{{Number: 10, Size: 4.5}, {Number: 11, Size: 6}}
+		page, err := report.HTML(run)
+		require.NoError(t, err)
+		require.Equal(t, 5, strings.Count(string(page), "<svg"))
+	})
+}
@@ -0,0 +1,188 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+	"strconv"
+)
+
+// The dimensions of a chart, in pixels.
+const (
+	chartWidth  = 720
+	chartHeight = 300
+
+	marginLeft   = 60
+	marginRight  = 20
+	marginTop    = 20
+	marginBottom = 40
+)
+
+// The colors that the series of a chart cycle through.
+var palette = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b"}
+
+// point is a point of a series.
+type point struct {
+	X, Y float64
+}
+
+// series is a line, or a set of bars, on a chart.
+type series struct {
+	Name   string
+	Points []point
+	Bars   bool // Draw a bar per point, instead of a line through them
+}
+
+// band is an area that a chart shades, between a lower and an upper series
+// over the same X values.
+type band struct {
+	Name         string
+	Lower, Upper []point
+}
+
+// chart is a chart with a linear X and Y axis.
+type chart struct {
+	Title          string
+	XLabel, YLabel string
+	Series         []series
+	Band           *band
+}
+
+// SVG renders the chart as an inline SVG element.
+func (c *chart) SVG() template.HTML {
+	var pts []point
+	for _, s := range c.Series {
+		pts = append(pts, s.Points...)
+	}
+	if c.Band != nil {
+		pts = append(append(pts, c.Band.Lower...), c.Band.Upper...)
+	}
+	if len(pts) == 0 {
+		return template.HTML(fmt.Sprintf("<p class=\"empty\">%s: no data.</p>", template.HTMLEscapeString(c.Title)))
+	}
+
+	minX, maxX := pts[0].X, pts[0].X
+	minY, maxY := 0.0, pts[0].Y // The Y axis starts at zero, unless there are negative values
+	for _, p := range pts {
+		minX, maxX = math.Min(minX, p.X), math.Max(maxX, p.X)
+		minY, maxY = math.Min(minY, p.Y), math.Max(maxY, p.Y)
+	}
+	for _, s := range c.Series {
+		if s.Bars { // Leave room for the first and last bars
+			minX, maxX = minX-0.5, maxX+0.5
+			break
+		}
+	}
+	xTicks, yTicks := ticks(minX, maxX), ticks(minY, maxY)
+	minX, maxX = math.Min(minX, xTicks[0]), math.Max(maxX, xTicks[len(xTicks)-1])
+	minY, maxY = yTicks[0], yTicks[len(yTicks)-1]
+	if maxX == minX {
+		maxX = minX + 1
+	}
+	if maxY == minY {
+		maxY = minY + 1
+	}
+
+	plotW := float64(chartWidth - marginLeft - marginRight)
+	plotH := float64(chartHeight - marginTop - marginBottom)
+	sx := func(x float64) float64 { return marginLeft + (x-minX)/(maxX-minX)*plotW }
+	sy := func(y float64) float64 { return marginTop + (maxY-y)/(maxY-minY)*plotH }
+	path := func(ps []point) string {
+		var b bytes.Buffer
+		for i, p := range ps {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			fmt.Fprintf(&b, "%.1f,%.1f", sx(p.X), sy(p.Y))
+		}
+		return b.String()
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img">`, chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<title>%s</title>`, template.HTMLEscapeString(c.Title))
+
+	// Grid and axes
+	for _, y := range yTicks {
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`, marginLeft, sy(y), chartWidth-marginRight, sy(y))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle">%s</text>`, marginLeft-6, sy(y), label(y))
+	}
+	for _, x := range xTicks {
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="#999"/>`, sx(x), chartHeight-marginBottom, sx(x), chartHeight-marginBottom+4)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, sx(x), chartHeight-marginBottom+16, label(x))
+	}
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`, marginLeft, chartHeight-marginBottom, chartWidth-marginRight, chartHeight-marginBottom)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`, marginLeft, marginTop, marginLeft, chartHeight-marginBottom)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle">%s</text>`, marginLeft+int(plotW)/2, chartHeight-6, template.HTMLEscapeString(c.XLabel))
+	fmt.Fprintf(&b, `<text x="14" y="%d" text-anchor="middle" transform="rotate(-90 14 %d)">%s</text>`, marginTop+int(plotH)/2, marginTop+int(plotH)/2, template.HTMLEscapeString(c.YLabel))
+
+	var legend []string
+	var colors []string
+	if c.Band != nil {
+		outline := append(append([]point{}, c.Band.Lower...), reverse(c.Band.Upper)...)
+		fmt.Fprintf(&b, `<polygon points="%s" fill="#999" fill-opacity="0.2" stroke="none"/>`, path(outline))
+		legend, colors = append(legend, c.Band.Name), append(colors, "#ccc")
+	}
+
+	for i, s := range c.Series {
+		color := palette[i%len(palette)]
+		legend, colors = append(legend, s.Name), append(colors, color)
+		if !s.Bars {
+			fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1.5"/>`, path(s.Points), color)
+			continue
+		}
+		barW := math.Max(1, 0.8*plotW/(maxX-minX))
+		for _, p := range s.Points {
+			top, bottom := sy(math.Max(p.Y, 0)), sy(math.Min(p.Y, 0))
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`, sx(p.X)-barW/2, top, barW, bottom-top, color)
+		}
+	}
+
+	for i, name := range legend {
+		y := marginTop + 14*i
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`, chartWidth-marginRight-150, y, colors[i])
+		fmt.Fprintf(&b, `<text x="%d" y="%d" dominant-baseline="hanging">%s</text>`, chartWidth-marginRight-135, y, template.HTMLEscapeString(name))
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// ticks returns about five evenly spaced round values that cover [lo, hi].
+func ticks(lo, hi float64) []float64 {
+	if hi == lo {
+		return []float64{lo}
+	}
+	raw := (hi - lo) / 5
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := mag
+	for _, m := range []float64{1, 2, 5, 10} {
+		if m*mag >= raw {
+			step = m * mag
+			break
+		}
+	}
+
+	var res []float64
+	for k := math.Floor(lo / step); k <= math.Ceil(hi/step); k++ {
+		res = append(res, k*step)
+	}
+	return res
+}
+
+// label formats an axis value with no more precision than it needs.
+func label(v float64) string {
+	if math.Abs(v) < 1e-9 {
+		return "0"
+	}
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+func reverse(ps []point) []point {
+	res := make([]point, len(ps))
+	for i, p := range ps {
+		res[len(ps)-1-i] = p
+	}
+	return res
+}
@@ -0,0 +1,227 @@
+// Package runs reads the results of past runs back from the files that the
+// `csv` and `summary` sinks wrote, so that runs can be analyzed after the fact.
+package runs
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kchristidis/island/stats"
+)
+
+// The suffixes of the files of a run; a run named `exp-01-run-01` writes its
+// transaction stats to `exp-01-run-01-tran.csv`, etc.
+const (
+	SuffixTran    = "-tran.csv"
+	SuffixSlot    = "-slot.csv"
+	SuffixBlock   = "-block.csv"
+	SuffixSummary = "-summary.json"
+
+	SuffixSummaryMarkdown = "-summary.md"
+	SuffixReport          = "-report.html" // See the `report` package
+)
+
+// Run is a past run, as read off its files.
+type Run struct {
+	Name string // e.g. `exp-01-run-01`
+	stats.Results
+
+	// The configuration that the run was set up with, if it wrote a summary.
+	Config json.RawMessage
+}
+
+// Prefix returns the prefix of the files of a run, given the path to the
+// prefix itself, or to any of the run's files.
+func Prefix(path string) string {
+	for _, suffix := range []string{SuffixTran, SuffixSlot, SuffixBlock, SuffixSummary, SuffixSummaryMarkdown, SuffixReport} {
+		if strings.HasSuffix(path, suffix) {
+			return strings.TrimSuffix(path, suffix)
+		}
+	}
+	return path
+}
+
+// Load reads a run off its files; see Prefix for the paths it accepts. The
+// transaction and slot stats are required, the block stats and the summary
+// are not.
+func Load(path string) (*Run, error) {
+	prefix := Prefix(path)
+	run := &Run{Name: filepath.Base(prefix)}
+
+	rows, err := readCSV(prefix + SuffixTran)
+	if err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		tx, err := parseTransaction(row)
+		if err != nil {
+			return nil, fmt.Errorf("%s%s: row %d: %s", prefix, SuffixTran, i+2, err)
+		}
+		run.Transactions = append(run.Transactions, tx)
+	}
+
+	if rows, err = readCSV(prefix + SuffixSlot); err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		slot, counters, err := parseSlot(row)
+		if err != nil {
+			return nil, fmt.Errorf("%s%s: row %d: %s", prefix, SuffixSlot, i+2, err)
+		}
+		run.Slots = append(run.Slots, slot)
+		run.Counters = append(run.Counters, counters)
+	}
+
+	rows, err = readCSV(prefix + SuffixBlock)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for i, row := range rows {
+		block, err := parseBlock(row)
+		if err != nil {
+			return nil, fmt.Errorf("%s%s: row %d: %s", prefix, SuffixBlock, i+2, err)
+		}
+		run.Blocks = append(run.Blocks, block)
+	}
+
+	summaryB, err := ioutil.ReadFile(prefix + SuffixSummary)
+	switch {
+	case err == nil:
+		var summary struct{ Config json.RawMessage }
+		if err := json.Unmarshal(summaryB, &summary); err != nil {
+			return nil, fmt.Errorf("%s%s: %s", prefix, SuffixSummary, err)
+		}
+		run.Config = summary.Config
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// Summary returns the summary of the run's results. The config is not set.
+func (r *Run) Summary() *stats.Summary {
+	return stats.Summarize(&r.Results)
+}
+
+// readCSV returns the rows of a CSV file, without its header.
+func readCSV(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%s: no header", path)
+	}
+	return rows[1:], nil
+}
+
+// fields parses the fields of a row in order, and remembers the first error.
+type fields struct {
+	row []string
+	idx int
+	err error
+}
+
+func (f *fields) next() string {
+	if f.idx >= len(f.row) {
+		if f.err == nil {
+			f.err = fmt.Errorf("expected more than %d columns", len(f.row))
+		}
+		return ""
+	}
+	f.idx++
+	return f.row[f.idx-1]
+}
+
+func (f *fields) int() int {
+	v := f.next()
+	n, err := strconv.Atoi(v)
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	return n
+}
+
+func (f *fields) uint() uint64 {
+	v := f.next()
+	if v == "" { // Blank for a transaction that did not go through
+		return 0
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	return n
+}
+
+func (f *fields) float() float64 {
+	v := f.next()
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	return n
+}
+
+func parseTransaction(row []string) (stats.Transaction, error) {
+	f := &fields{row: row}
+	tx := stats.Transaction{
+		ID:              f.next(),
+		LatencyInMillis: int64(f.int()),
+		Type:            f.next(),
+		Attempt:         f.int(),
+		Status:          f.next(),
+		TxID:            f.next(),
+		BlockNumber:     f.uint(),
+	}
+	if len(row) > f.idx { // Runs from before adversarial agents lack the column
+		tx.Behavior = f.next()
+	}
+	return tx, f.err
+}
+
+func parseBlock(row []string) (stats.Block, error) {
+	f := &fields{row: row}
+	block := stats.Block{
+		Number: f.uint(),
+		Size:   float32(f.float()),
+	}
+	return block, f.err
+}
+
+func parseSlot(row []string) (stats.Slot, stats.SlotCounters, error) {
+	f := &fields{row: row}
+	slot := stats.Slot{Number: f.int(), Cleared: true}
+	slot.EnergyUse, slot.PricePaid = f.float(), f.float()
+	slot.EnergyGen, slot.PriceSold = f.float(), f.float()
+	slot.EnergyTraded, slot.PriceTraded = f.float(), f.float()
+
+	var c stats.SlotCounters
+	c.LateTXs, c.LateBuys, c.LateSells = f.int(), f.int(), f.int()
+	c.LateDecrypts = f.int()
+	c.ProblematicIters, c.ProblematicMarshals = f.int(), f.int()
+	c.ProblematicDecrypts, c.ProblematicBidCalcs = f.int(), f.int()
+	c.ProblematicKeys, c.ProblematicGets, c.ProblematicPuts = f.int(), f.int(), f.int()
+	c.RejectedPrices, c.RejectedQuantities, c.RejectedCaps = f.int(), f.int(), f.int()
+	c.RejectedExcess, c.RejectedTicks = f.int(), f.int()
+
+	slot.EnergyCurtailed, slot.EnergyLost = f.float(), f.float()
+	slot.Congestions = f.int()
+	if len(row) > f.idx { // Runs from before deposits lack the columns
+		slot.Forfeits, slot.DepositsForfeited = f.int(), f.float()
+	}
+	return slot, c, f.err
+}
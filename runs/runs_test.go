@@ -0,0 +1,77 @@
+package runs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/runs"
+	"github.com/kchristidis/island/stats"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefix(t *testing.T) {
+	for _, path := range []string{
+		"output/exp-01-run-01",
+		"output/exp-01-run-01-tran.csv",
+		"output/exp-01-run-01-slot.csv",
+		"output/exp-01-run-01-summary.json",
+		"output/exp-01-run-01-summary.md",
+	} {
+		require.Equal(t, "output/exp-01-run-01", runs.Prefix(path), path)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	prefix := filepath.Join(dir, "exp-01-run-01")
+
+	results := &stats.Results{
+		Transactions: []stats.Transaction{
+			{ID: "bidder0001-slot000000000000-buy-0-1", Type: "buy", Status: "failure: mvcc_read_conflict", LatencyInMillis: 310, Attempt: 1},
+			{ID: "bidder0001-slot000000000000-buy-0-2", Type: "buy", Status: "success", LatencyInMillis: 2040, Attempt: 2, TxID: "a1b2", BlockNumber: 17},
+			{ID: "bidder0002-slot000000000000-buy-1-1", Type: "buy", Status: "success", LatencyInMillis: 15, Attempt: 1, TxID: "c3d4", BlockNumber: 17, Behavior: "spam"},
+		},
+		Slots: []stats.Slot{
+			{Number: 0, EnergyUse: 1.25, PricePaid: 10.84, EnergyGen: 0.5, PriceSold: 3.4, EnergyTraded: 0.75, PriceTraded: 7.125, Cleared: true},
+			{Number: 1, EnergyUse: 2, PricePaid: 10.84, PriceSold: 3.4, EnergyLost: 0.125, Congestions: 1, Cleared: true},
+		},
+		Counters: []stats.SlotCounters{
+			{},
+			{LateTXs: 3, LateBuys: 1, LateSells: 1, LateDecrypts: 1, RejectedTicks: 2},
+		},
+	}
+	csvSink := stats.NewCSVSink(prefix+runs.SuffixTran, prefix+runs.SuffixBlock, prefix+runs.SuffixSlot, logging.Nop())
+	require.NoError(t, csvSink.Write(results))
+
+	t.Run("without a summary", func(t *testing.T) {
+		run, err := runs.Load(prefix + runs.SuffixSlot)
+		require.NoError(t, err)
+		require.Equal(t, "exp-01-run-01", run.Name)
+		require.Equal(t, results.Transactions, run.Transactions)
+		require.Empty(t, run.Blocks)
+		require.Equal(t, results.Slots, run.Slots)
+		require.Equal(t, results.Counters, run.Counters)
+		require.Nil(t, run.Config)
+	})
+
+	t.Run("with a summary", func(t *testing.T) {
+		summarySink := stats.NewSummarySink(prefix+runs.SuffixSummary, "", map[string]int{"Seed": 7})
+		require.NoError(t, summarySink.Write(results))
+
+		run, err := runs.Load(prefix)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"Seed": 7}`, string(run.Config))
+		require.Equal(t, stats.Summarize(results), run.Summary())
+	})
+
+	t.Run("missing slot stats", func(t *testing.T) {
+		require.NoError(t, os.Remove(prefix+runs.SuffixSlot))
+		_, err := runs.Load(prefix)
+		require.Error(t, err)
+	})
+}
@@ -1,14 +1,24 @@
 package slotnotifier
 
 import (
-	"fmt"
-	"io"
-	"math/rand"
+	"context"
 	"sync"
 
-	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/kchristidis/island/chaincode/logging"
 )
 
+// Tracker is an interface that encapsulates the calls with which the
+// notifier reports the notifications it hands out. See the `vclock` package.
+type Tracker interface {
+	Add(delta int)
+	Done()
+}
+
+type nopTracker struct{}
+
+func (nopTracker) Add(int) {}
+func (nopTracker) Done()   {}
+
 // Notifier receives slot notifications and fans them out to its subscribers.
 type Notifier struct {
 	SourceChan <-chan int
@@ -18,27 +28,28 @@ type Notifier struct {
 
 	ID int
 
-	Writer   io.Writer
-	DoneChan <-chan struct{}
-}
+	// Every notification handed to a subscriber is added to the tracker, and
+	// every notification received is marked done once it has been handed out.
+	// Set it when running in virtual time; a no-op by default.
+	Tracker Tracker
 
-// New returns a new notifier.
-func New(sourcec chan int, writer io.Writer, donec chan struct{}) *Notifier {
-	// We use this to differentiate between the
-	// bid/markEnd notifier, and the postKey one.
-	randomID := int(rand.Float32() * 100)
+	Logger *logging.Logger
+}
 
+// New returns a new notifier. The ID differentiates between
+// the bid/markEnd notifier, and the postKey one.
+func New(id int, sourcec chan int, logger *logging.Logger) *Notifier {
 	return &Notifier{
 		SourceChan: sourcec, // The channel on which slot notifications are received from the block notifier.
 
 		Subs:    new(sync.Map),
 		LastVal: -1, // -1 because we want the event for slot 0 to go through.
 
-		ID: randomID,
+		ID: id,
 
-		Writer: writer, // Used for logging.
+		Tracker: nopTracker{},
 
-		DoneChan: donec, // An external kill switch. Signals to all threads in this package that they should return.
+		Logger: logger.With(logging.Component("slot-notifier"), logging.F("notifier", id)),
 	}
 }
 
@@ -48,44 +59,37 @@ func (n *Notifier) Register(id int, queue chan int) bool {
 	return !loaded
 }
 
-// Run executes the notifier logic.
-func (n *Notifier) Run() {
-	defer func() {
-		msg := fmt.Sprintf("slot-notifier:%02d • exited", n.ID)
-		fmt.Fprintln(n.Writer, msg)
-	}()
+// Run executes the notifier logic until the context is canceled.
+func (n *Notifier) Run(ctx context.Context) {
+	defer n.Logger.Info("exited")
 
-	msg := fmt.Sprintf("slot-notifier:%02d • running", n.ID)
-	fmt.Fprintln(n.Writer, msg)
+	n.Logger.Info("running")
 
 	for {
 		select {
-		case <-n.DoneChan:
+		case <-ctx.Done():
 			return
 		case newVal := <-n.SourceChan:
-			msg := fmt.Sprintf("slot-notifier:%02d slot:%012d • received new slot from block notifier", n.ID, newVal)
-			fmt.Fprintln(n.Writer, msg)
+			n.Logger.Info("received new slot from block notifier", logging.Slot(newVal))
 			if newVal > n.LastVal {
 				n.LastVal = newVal
 				n.Subs.Range(func(k, v interface{}) bool {
+					n.Tracker.Add(1)
 					select {
 					case v.(chan int) <- n.LastVal:
-						if schema.StagingLevel <= schema.Debug {
-							id := k.(int)
-							var msg string
-							if id == -1 {
-								msg = fmt.Sprintf("slot-notifier:%02d slot:%012d • sent new slot to regulator", n.ID, newVal)
-							} else {
-								msg = fmt.Sprintf("slot-notifier:%02d slot:%012d • sent new slot to agent %d", n.ID, newVal, id)
-							}
-							fmt.Fprintln(n.Writer, msg)
+						if id := k.(int); id == -1 {
+							n.Logger.Debug("sent new slot to regulator", logging.Slot(newVal))
+						} else {
+							n.Logger.Debug("sent new slot to agent", logging.Slot(newVal), logging.Bidder(id))
 						}
 						return true
 					default:
+						n.Tracker.Done()
 						return false
 					}
 				})
 			}
+			n.Tracker.Done()
 		}
 	}
 }
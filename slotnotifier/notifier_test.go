@@ -1,8 +1,10 @@
 package slotnotifier
 
 import (
+	"context"
 	"testing"
 
+	"github.com/kchristidis/island/chaincode/logging"
 	"github.com/onsi/gomega/gbytes"
 	"github.com/stretchr/testify/require"
 
@@ -14,11 +16,12 @@ func TestNotifier(t *testing.T) {
 
 	sourcec := make(chan int)
 	bfr := gbytes.NewBuffer()
-	donec := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	n := New(sourcec, bfr, donec)
+	n := New(1, sourcec, logging.New(bfr, logging.DebugLevel, logging.Text))
 
-	go n.Run()
+	go n.Run(ctx)
 
 	t.Run("register", func(t *testing.T) {
 		require.True(t, n.Register(1, make(chan int, 1)))
@@ -51,7 +54,7 @@ func TestNotifier(t *testing.T) {
 	})
 
 	t.Run("close", func(t *testing.T) {
-		close(donec)
+		cancel()
 
 		// Signal closes properly
 		g.Eventually(bfr, "1s", "50ms").Should(gbytes.Say("exited"))
@@ -0,0 +1,14 @@
+// +build sqlite
+
+package main
+
+import (
+	"github.com/kchristidis/island/stats"
+	"github.com/kchristidis/island/stats/sqlite"
+)
+
+// newSQLiteSink returns the `sqlite` sink. It is only built w/ the `sqlite`
+// tag, as it links SQLite, which requires cgo.
+func newSQLiteSink(path string) (stats.Sink, error) {
+	return sqlite.New(path), nil
+}
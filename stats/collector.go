@@ -1,9 +1,12 @@
 package stats
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"strings"
 
+	"github.com/kchristidis/island/chaincode/logging"
 	"github.com/kchristidis/island/chaincode/schema"
 )
 
@@ -14,25 +17,22 @@ import (
 
 // Transaction ...
 type Transaction struct {
-	ID              string
+	ID              string // The event ID
 	Type            string
 	Status          string
 	LatencyInMillis int64
 	Attempt         int
+	TxID            string // Set if the transaction went through
+	BlockNumber     uint64 // As above
+	Behavior        string `json:",omitempty"` // Set if the agent is adversarial; see `bidder.Behavior`
 }
 
-// TransactionStats ...
-var TransactionStats []Transaction
-
 // Block ...
 type Block struct {
 	Number uint64
 	Size   float32 // In KiB
 }
 
-// BlockStats ...
-var BlockStats []Block
-
 // Slot ...
 type Slot struct {
 	Number       int
@@ -42,13 +42,31 @@ type Slot struct {
 	PriceSold    float64
 	EnergyTraded float64
 	PriceTraded  float64
+	Cleared      bool // Set by the regulator once the market has cleared the slot
+
+	// Populated when the market clears over a distribution network topology.
+	EnergyCurtailed float64 // Cleared but undeliverable due to line capacity
+	EnergyLost      float64 // Lost on the feeder lines
+	Congestions     int     // Count of congested feeders
+
+	// Populated when bids lock deposits.
+	Forfeits          int     // Count of deposits forfeited by bids that were not revealed
+	DepositsForfeited float64 // In cents
 }
 
-// SlotStats ...
-var (
-	SlotStats       [schema.TraceLength]Slot
-	LargestSlotSeen int
-)
+// Tariff is an interface that encapsulates the grid prices
+// that the collector reports for every slot.
+type Tariff interface {
+	Prices(slot int) (feedIn, retail float64)
+}
+
+// Observer is an interface that encapsulates a consumer of the stats as they
+// come in, e.g. a live monitor.
+type Observer interface {
+	ObserveTransaction(newLine Transaction)
+	ObserveBlock(newLine Block)
+	ObserveSlot(newLine Slot)
+}
 
 // Collector ...
 type Collector struct {
@@ -56,63 +74,139 @@ type Collector struct {
 	SlotChan        chan Slot
 	TransactionChan chan Transaction
 
-	Writer io.Writer // Used for logging.
+	// If set, the grid prices of a slot are taken from the tariff,
+	// instead of the prices reported by the agents.
+	Tariff Tariff
+
+	// If set, every line that comes in is appended to it; see Replay.
+	Journal io.Writer
+
+	// Notified of every line that comes in through the input channels, after
+	// it has been journaled. Lines that are replayed are not observed.
+	Observers []Observer
+
+	// Where the results go at the end of the run; see Emit.
+	Sinks []Sink
+
+	Logger *logging.Logger
+
+	// The aggregated stats. The slots are indexed by slot number.
+	transactions []Transaction
+	blocks       []Block
+	slots        []Slot
 
-	DoneChan chan struct{} // An external kill switch.
+	largestSlotSeen    int
+	largestSlotCleared int
 }
 
-// Run ...
-func (c *Collector) Run() {
-	defer fmt.Fprintln(c.Writer, "stats • exited")
+// New returns a new collector.
+func New(blockc chan Block, slotc chan Slot, transactionc chan Transaction,
+	tariff Tariff, logger *logging.Logger) *Collector {
+	return &Collector{
+		BlockChan:       blockc,
+		SlotChan:        slotc,
+		TransactionChan: transactionc,
+
+		Tariff: tariff,
+
+		Logger: logger.With(logging.Component("stats")),
+
+		largestSlotCleared: -1,
+	}
+}
+
+// Run aggregates stats until the context is canceled. Cancel it only after the
+// agents that feed the collector have returned, or have been given up on.
+func (c *Collector) Run(ctx context.Context) {
+	defer c.Logger.Info("exited")
 
 	for {
 		select {
 		case newLine := <-c.TransactionChan:
-			c.TransactionCalc(newLine, &TransactionStats)
+			c.journal(entry{Transaction: &newLine})
+			c.observe(entry{Transaction: &newLine})
+			c.TransactionCalc(newLine)
 		case newLine := <-c.BlockChan:
-			c.BlockCalc(newLine, &BlockStats)
+			c.journal(entry{Block: &newLine})
+			c.observe(entry{Block: &newLine})
+			c.BlockCalc(newLine)
 		case newLine := <-c.SlotChan:
-			c.SlotCalc(newLine, &SlotStats)
-		case <-c.DoneChan:
+			c.journal(entry{Slot: &newLine})
+			c.observe(entry{Slot: &newLine})
+			c.SlotCalc(newLine)
+		case <-ctx.Done():
 			// Don't exit until you make sure that the channels are drained first
-			close(c.BlockChan)
-			for newLine := range c.BlockChan {
-				c.BlockCalc(newLine, &BlockStats)
-			}
-			close(c.TransactionChan)
-			for newLine := range c.TransactionChan {
-				c.TransactionCalc(newLine, &TransactionStats)
-			}
-			close(c.SlotChan)
-			for newLine := range c.SlotChan {
-				c.SlotCalc(newLine, &SlotStats)
-			}
+			c.drain()
+			return
+		}
+	}
+}
+
+// drain aggregates whatever is buffered in the input channels. The channels are
+// left open: an agent that was given up on during shutdown may still send on
+// them, and it should block rather than panic.
+func (c *Collector) drain() {
+	for {
+		select {
+		case newLine := <-c.TransactionChan:
+			c.journal(entry{Transaction: &newLine})
+			c.observe(entry{Transaction: &newLine})
+			c.TransactionCalc(newLine)
+		case newLine := <-c.BlockChan:
+			c.journal(entry{Block: &newLine})
+			c.observe(entry{Block: &newLine})
+			c.BlockCalc(newLine)
+		case newLine := <-c.SlotChan:
+			c.journal(entry{Slot: &newLine})
+			c.observe(entry{Slot: &newLine})
+			c.SlotCalc(newLine)
+		default:
 			return
 		}
 	}
 }
 
+// observe notifies the collector's observers of the entry.
+func (c *Collector) observe(e entry) {
+	for _, o := range c.Observers {
+		switch {
+		case e.Transaction != nil:
+			o.ObserveTransaction(*e.Transaction)
+		case e.Block != nil:
+			o.ObserveBlock(*e.Block)
+		case e.Slot != nil:
+			o.ObserveSlot(*e.Slot)
+		}
+	}
+}
+
 // TransactionCalc ...
-func (c *Collector) TransactionCalc(newLine Transaction, aggStats *[]Transaction) {
-	*aggStats = append(*aggStats, newLine)
+func (c *Collector) TransactionCalc(newLine Transaction) {
+	c.transactions = append(c.transactions, newLine)
 }
 
 // BlockCalc ...
-func (c *Collector) BlockCalc(newLine Block, aggStats *[]Block) {
-	*aggStats = append(*aggStats, newLine)
+func (c *Collector) BlockCalc(newLine Block) {
+	c.blocks = append(c.blocks, newLine)
 }
 
 // SlotCalc ...
-func (c *Collector) SlotCalc(newLine Slot, aggStats *[schema.TraceLength]Slot) {
+func (c *Collector) SlotCalc(newLine Slot) {
 	slotNum := newLine.Number
-	if slotNum > LargestSlotSeen {
-		LargestSlotSeen = slotNum
+	if slotNum > c.largestSlotSeen {
+		c.largestSlotSeen = slotNum
+	}
+	if newLine.Cleared && slotNum > c.largestSlotCleared {
+		c.largestSlotCleared = slotNum
+	}
+	for len(c.slots) <= slotNum {
+		c.slots = append(c.slots, Slot{Number: len(c.slots)})
 	}
 
-	if ((*aggStats)[slotNum] == Slot{}) {
-		(*aggStats)[slotNum] = newLine
+	curLine := c.slots[slotNum]
+	if (curLine == Slot{Number: slotNum}) {
+		curLine = newLine
 	} else {
-		curLine := aggStats[slotNum]
 		curLine.EnergyUse += newLine.EnergyUse
 		curLine.EnergyGen += newLine.EnergyGen
 
@@ -124,14 +218,69 @@ func (c *Collector) SlotCalc(newLine Slot, aggStats *[schema.TraceLength]Slot) {
 			curLine.PriceSold = newLine.PriceSold
 		}
 
+		curLine.Cleared = curLine.Cleared || newLine.Cleared
 		curLine.EnergyTraded = newLine.EnergyTraded
 		curLine.PriceTraded = newLine.PriceTraded
+		curLine.EnergyCurtailed = newLine.EnergyCurtailed
+		curLine.EnergyLost = newLine.EnergyLost
+		curLine.Congestions = newLine.Congestions
+		curLine.Forfeits = newLine.Forfeits
+		curLine.DepositsForfeited = newLine.DepositsForfeited
 		// This variable should be renamed; `EnergyUse` actually tracks energy that is
 		// consumed by the grid. Therefore we deduct the quantity that was met internally.
 		curLine.EnergyUse -= newLine.EnergyTraded
 		// As above, `EnergyGen` tracks energy that is sold to the grid.
 		curLine.EnergyGen -= newLine.EnergyTraded
+		// The energy that is lost on the lines has to be made up for by the grid.
+		curLine.EnergyUse += newLine.EnergyLost
+	}
 
-		(*aggStats)[slotNum] = curLine
+	if c.Tariff != nil {
+		curLine.PriceSold, curLine.PricePaid = c.Tariff.Prices(slotNum)
+	}
+	c.slots[slotNum] = curLine
+}
+
+// LargestSlotSeen returns the largest slot that the collector has stats for.
+func (c *Collector) LargestSlotSeen() int {
+	return c.largestSlotSeen
+}
+
+// LargestSlotCleared returns the largest slot that the market has cleared, or
+// -1 if it has cleared none.
+func (c *Collector) LargestSlotCleared() int {
+	return c.largestSlotCleared
+}
+
+// Results returns the stats aggregated so far, along with the given contract
+// counters. Only the slots up to the largest one cleared are included. Don't
+// call it while the collector is running.
+func (c *Collector) Results(contract schema.MetricsOutput) *Results {
+	results := &Results{
+		Transactions: c.transactions,
+		Blocks:       c.blocks,
+	}
+	for i := 0; i <= c.largestSlotCleared; i++ {
+		results.Slots = append(results.Slots, c.slots[i])
+		results.Counters = append(results.Counters, newSlotCounters(contract, i))
+	}
+	return results
+}
+
+// Emit writes the results to every sink, see Results. A sink that fails does
+// not keep the others from being written to.
+func (c *Collector) Emit(contract schema.MetricsOutput) error {
+	results := c.Results(contract)
+
+	var failed []string
+	for _, sink := range c.Sinks {
+		if err := sink.Write(results); err != nil {
+			c.Logger.Error("cannot write the results to a sink", logging.Err(err))
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("cannot write the results to %d of %d sinks: %s", len(failed), len(c.Sinks), strings.Join(failed, "; "))
 	}
+	return nil
 }
@@ -0,0 +1,200 @@
+package stats
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+
+	"github.com/kchristidis/island/chaincode/logging"
+)
+
+// CSVSink writes the results to three CSV files: one for the transactions,
+// one for the blocks, and one for the cleared slots. See the README for the
+// columns of each file.
+type CSVSink struct {
+	TransactionPath string
+	BlockPath       string
+	SlotPath        string
+
+	Logger *logging.Logger
+}
+
+// NewCSVSink returns a new CSV sink.
+func NewCSVSink(transactionPath, blockPath, slotPath string, logger *logging.Logger) *CSVSink {
+	return &CSVSink{
+		TransactionPath: transactionPath,
+		BlockPath:       blockPath,
+		SlotPath:        slotPath,
+
+		Logger: logger.With(logging.Component("stats")),
+	}
+}
+
+// Write writes the results to the sink's files.
+func (s *CSVSink) Write(results *Results) error {
+	if err := s.writeTransactions(results); err != nil {
+		return err
+	}
+	if err := s.writeBlocks(results); err != nil {
+		return err
+	}
+	return s.writeSlots(results)
+}
+
+func (s *CSVSink) writeTransactions(results *Results) error {
+	rows := [][]string{{"event_id", "latency_ms", "tx_type", "attempt", "tx_status", "tx_id", "block_num", "behavior"}}
+	for _, tx := range results.Transactions {
+		latVal := fmt.Sprintf("%d", tx.LatencyInMillis)
+		attVal := fmt.Sprintf("%d", tx.Attempt)
+		var blockVal string // Blank unless the transaction went through
+		if tx.TxID != "" {
+			blockVal = fmt.Sprintf("%012d", tx.BlockNumber)
+		}
+		fields := []logging.Field{
+			logging.EventID(tx.ID),
+			logging.F("latency_ms", tx.LatencyInMillis),
+			logging.F("type", tx.Type),
+			logging.Attempt(tx.Attempt),
+			logging.F("status", tx.Status),
+		}
+		if tx.TxID != "" {
+			fields = append(fields, logging.TxID(tx.TxID), logging.F("block", tx.BlockNumber))
+		}
+		if tx.Behavior != "" {
+			fields = append(fields, logging.F("behavior", tx.Behavior))
+		}
+		s.Logger.Debug("transaction stats", fields...)
+		rows = append(rows, []string{tx.ID, latVal, tx.Type, attVal, tx.Status, tx.TxID, blockVal, tx.Behavior})
+	}
+
+	return writeCSV(s.TransactionPath, rows)
+}
+
+func (s *CSVSink) writeBlocks(results *Results) error {
+	rows := [][]string{{"block_num", "size_kib"}}
+	for _, block := range results.Blocks {
+		numVal := fmt.Sprintf("%012d", block.Number)
+		sizeVal := fmt.Sprintf("%.1f", block.Size) // ATTN: This is the size in KiB
+		s.Logger.Debug(fmt.Sprintf("block stats: %s KiB", sizeVal), logging.F("block", block.Number))
+		rows = append(rows, []string{numVal, sizeVal})
+	}
+
+	return writeCSV(s.BlockPath, rows)
+}
+
+func (s *CSVSink) writeSlots(results *Results) error {
+	rows := [][]string{{"slot_num",
+		"bfg_qty_kwh", "bfg_ppu_c_per_kWh", // bfg = bought from grid
+		"stg_qty_kwh", "stg_ppu_c_per_kWh", // stg = sold to grid
+		"dmi_qty_kwh", "dmi_ppu_c_per_kWh", // dmi = demand met internally
+		"late_cnt_all", "late_cnt_buy", "late_cnt_sell",
+		"late_decrs",
+		"prob_iters", "prob_marshals",
+		"prob_decrs", "prob_bid_calcs",
+		"prob_keys", "prob_gets", "prob_puts",
+		"rej_prices", "rej_qtys", "rej_caps",
+		"rej_excess", "rej_ticks",
+		"curt_qty_kwh", "loss_qty_kwh", "cong_cnt",
+		"fft_cnt", "fft_amt_c"}}
+
+	for i, slot := range results.Slots {
+		counters := results.Counters[i]
+		slotVal := fmt.Sprintf("%012d", slot.Number)
+		bfgQtyVal := fmt.Sprintf("%.3f", slot.EnergyUse)
+		bfgPpuVal := fmt.Sprintf("%.3f", slot.PricePaid)
+		stgQtyVal := fmt.Sprintf("%.3f", slot.EnergyGen)
+		stgPpuVal := fmt.Sprintf("%.3f", slot.PriceSold)
+		dmiQtyVal := fmt.Sprintf("%.3f", slot.EnergyTraded)
+		dmiPpuVal := fmt.Sprintf("%.3f", slot.PriceTraded)
+		lateAllVal := fmt.Sprintf("%d", counters.LateTXs)
+		lateBuyVal := fmt.Sprintf("%d", counters.LateBuys)
+		lateSellVal := fmt.Sprintf("%d", counters.LateSells)
+		lateDecrVal := fmt.Sprintf("%d", counters.LateDecrypts)
+		probIterVal := fmt.Sprintf("%d", counters.ProblematicIters)
+		probMarVal := fmt.Sprintf("%d", counters.ProblematicMarshals)
+		probDecrVal := fmt.Sprintf("%d", counters.ProblematicDecrypts)
+		probBidCalcVal := fmt.Sprintf("%d", counters.ProblematicBidCalcs)
+		probKeyVal := fmt.Sprintf("%d", counters.ProblematicKeys)
+		probGetVal := fmt.Sprintf("%d", counters.ProblematicGets)
+		probPutVal := fmt.Sprintf("%d", counters.ProblematicPuts)
+		rejPriceVal := fmt.Sprintf("%d", counters.RejectedPrices)
+		rejQtyVal := fmt.Sprintf("%d", counters.RejectedQuantities)
+		rejCapVal := fmt.Sprintf("%d", counters.RejectedCaps)
+		rejExcessVal := fmt.Sprintf("%d", counters.RejectedExcess)
+		rejTickVal := fmt.Sprintf("%d", counters.RejectedTicks)
+		curtQtyVal := fmt.Sprintf("%.3f", slot.EnergyCurtailed)
+		lossQtyVal := fmt.Sprintf("%.3f", slot.EnergyLost)
+		congVal := fmt.Sprintf("%d", slot.Congestions)
+		fftCntVal := fmt.Sprintf("%d", slot.Forfeits)
+		fftAmtVal := fmt.Sprintf("%.3f", slot.DepositsForfeited)
+		msg := fmt.Sprintf("cleared slot stats:"+
+			" %s kWh bought from the grid @ %s ç/kWh"+
+			", %s kWh sold to grid @ %s ç/kWh"+
+			", %s kWh of demand met internally @ %s ç/kWh"+
+			", %s late transactions (total)"+
+			", %s late buy transactions"+
+			", %s late sell transactions"+
+			", %s late decryptions"+
+			", %s problematic iterations"+
+			", %s problematic de/serializations"+
+			", %s problematic decrypts"+
+			", %s problematic bid calculations"+
+			", %s problematic key creations"+
+			", %s problematic get states"+
+			", %s problematic put states"+
+			", %s bids rejected for price"+
+			", %s bids rejected for quantity"+
+			", %s bids rejected for quantity cap"+
+			", %s bids rejected for excess bids"+
+			", %s bids rejected for tick size"+
+			", %s kWh curtailed due to congestion"+
+			", %s kWh lost on the lines"+
+			", %s congested feeders"+
+			", %s deposits forfeited"+
+			", %s ç forfeited",
+			bfgQtyVal, bfgPpuVal,
+			stgQtyVal, stgPpuVal,
+			dmiQtyVal, dmiPpuVal,
+			lateAllVal, lateBuyVal, lateSellVal,
+			lateDecrVal,
+			probIterVal, probMarVal,
+			probDecrVal, probBidCalcVal,
+			probKeyVal, probGetVal, probPutVal,
+			rejPriceVal, rejQtyVal, rejCapVal,
+			rejExcessVal, rejTickVal,
+			curtQtyVal, lossQtyVal, congVal,
+			fftCntVal, fftAmtVal,
+		)
+		s.Logger.Info(msg, logging.Slot(slot.Number))
+
+		rows = append(rows, []string{slotVal,
+			bfgQtyVal, bfgPpuVal,
+			stgQtyVal, stgPpuVal,
+			dmiQtyVal, dmiPpuVal,
+			lateAllVal, lateBuyVal, lateSellVal,
+			lateDecrVal,
+			probIterVal, probMarVal,
+			probDecrVal, probBidCalcVal,
+			probKeyVal, probGetVal, probPutVal,
+			rejPriceVal, rejQtyVal, rejCapVal,
+			rejExcessVal, rejTickVal,
+			curtQtyVal, lossQtyVal, congVal,
+			fftCntVal, fftAmtVal})
+	}
+
+	return writeCSV(s.SlotPath, rows)
+}
+
+// writeCSV writes the rows to a CSV file at the given path.
+func writeCSV(path string, rows [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(rows); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,72 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/kchristidis/island/chaincode/logging"
+)
+
+// The collector appends every line it aggregates to its journal, if it has one,
+// so that the stats of a run survive a crash. A line is written in a single call,
+// as a JSON object on a line of its own. A run that is resumed replays its journal
+// into the collector before it appends to it again.
+
+// entry is a line of the journal. Exactly one of its fields is set.
+type entry struct {
+	Transaction *Transaction `json:",omitempty"`
+	Block       *Block       `json:",omitempty"`
+	Slot        *Slot        `json:",omitempty"`
+}
+
+// journal appends the entry to the collector's journal, if it has one.
+func (c *Collector) journal(e entry) {
+	if c.Journal == nil {
+		return
+	}
+	entryB, err := json.Marshal(e)
+	if err == nil {
+		_, err = c.Journal.Write(append(entryB, '\n'))
+	}
+	if err != nil {
+		c.Logger.Error("cannot append to the journal", logging.Err(err))
+	}
+}
+
+// Replay aggregates the lines of a journal, as if they had come in through the
+// input channels; they are not appended to the collector's journal. A crash may
+// cut the last line of the journal short, so Replay returns the length of the
+// journal up to its last complete line. Truncate the journal to that length
+// before appending to it.
+func (c *Collector) Replay(r io.Reader) (int64, error) {
+	var size int64
+	br := bufio.NewReader(r)
+	for lineNum := 1; ; lineNum++ {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return size, nil // The last line is either empty or incomplete
+		}
+		if err != nil {
+			return size, err
+		}
+
+		var e entry
+		if err := json.Unmarshal(bytes.TrimSpace(line), &e); err != nil {
+			return size, fmt.Errorf("cannot decode journal line %d: %s", lineNum, err)
+		}
+		switch {
+		case e.Transaction != nil:
+			c.TransactionCalc(*e.Transaction)
+		case e.Block != nil:
+			c.BlockCalc(*e.Block)
+		case e.Slot != nil:
+			c.SlotCalc(*e.Slot)
+		default:
+			return size, fmt.Errorf("journal line %d is empty", lineNum)
+		}
+		size += int64(len(line))
+	}
+}
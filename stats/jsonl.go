@@ -0,0 +1,61 @@
+package stats
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+)
+
+// JSONLSink writes the results to a JSON Lines file. Each line is a JSON object
+// that carries a single transaction, block, or cleared slot; a slot comes with
+// the contract's counters for it.
+type JSONLSink struct {
+	Path string
+}
+
+// NewJSONLSink returns a new JSON Lines sink.
+func NewJSONLSink(path string) *JSONLSink {
+	return &JSONLSink{Path: path}
+}
+
+// resultLine is a line of the JSON Lines file. Either one of the first two
+// fields is set, or the last two are.
+type resultLine struct {
+	Transaction *Transaction  `json:",omitempty"`
+	Block       *Block        `json:",omitempty"`
+	Slot        *Slot         `json:",omitempty"`
+	Counters    *SlotCounters `json:",omitempty"`
+}
+
+// Write writes the results to the sink's file.
+func (s *JSONLSink) Write(results *Results) error {
+	f, err := os.Create(s.Path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+
+	var lines []resultLine
+	for i := range results.Transactions {
+		lines = append(lines, resultLine{Transaction: &results.Transactions[i]})
+	}
+	for i := range results.Blocks {
+		lines = append(lines, resultLine{Block: &results.Blocks[i]})
+	}
+	for i := range results.Slots {
+		lines = append(lines, resultLine{Slot: &results.Slots[i], Counters: &results.Counters[i]})
+	}
+	for _, line := range lines {
+		if err := enc.Encode(line); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
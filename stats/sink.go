@@ -0,0 +1,53 @@
+package stats
+
+import "github.com/kchristidis/island/chaincode/schema"
+
+// Sink is an interface that encapsulates an output
+// that the results of a run are written to.
+type Sink interface {
+	Write(results *Results) error
+}
+
+// Results are the stats of a run, as they are handed to the sinks.
+type Results struct {
+	Transactions []Transaction
+	Blocks       []Block
+	// The slots that the market has cleared, indexed by slot number,
+	// and the contract's counters for each.
+	Slots    []Slot
+	Counters []SlotCounters
+}
+
+// SlotCounters are the counters that the contract keeps for a slot; see
+// `schema.MetricsOutput`. They are left at zero if the contract could not
+// be queried.
+type SlotCounters struct {
+	LateTXs, LateBuys, LateSells, LateDecrypts  int
+	ProblematicIters, ProblematicMarshals       int
+	ProblematicDecrypts, ProblematicBidCalcs    int
+	ProblematicKeys, ProblematicGets            int
+	ProblematicPuts                             int
+	RejectedPrices, RejectedQuantities          int
+	RejectedCaps, RejectedExcess, RejectedTicks int
+}
+
+func newSlotCounters(m schema.MetricsOutput, slot int) SlotCounters {
+	return SlotCounters{
+		LateTXs:             m.LateTXsCount[slot],
+		LateBuys:            m.LateBuysCount[slot],
+		LateSells:           m.LateSellsCount[slot],
+		LateDecrypts:        m.LateDecryptsCount[slot],
+		ProblematicIters:    m.ProblematicIterCount[slot],
+		ProblematicMarshals: m.ProblematicMarshalCount[slot],
+		ProblematicDecrypts: m.ProblematicDecryptCount[slot],
+		ProblematicBidCalcs: m.ProblematicBidCalcCount[slot],
+		ProblematicKeys:     m.ProblematicKeyCount[slot],
+		ProblematicGets:     m.ProblematicGetStateCount[slot],
+		ProblematicPuts:     m.ProblematicPutStateCount[slot],
+		RejectedPrices:      m.RejectedPriceCount[slot],
+		RejectedQuantities:  m.RejectedQuantityCount[slot],
+		RejectedCaps:        m.RejectedCapCount[slot],
+		RejectedExcess:      m.RejectedExcessCount[slot],
+		RejectedTicks:       m.RejectedTickCount[slot],
+	}
+}
@@ -0,0 +1,143 @@
+// Package sqlite provides a stats sink that writes the results of a run to a
+// SQLite database. It lives in a package of its own, so that only the binaries
+// that use it link SQLite, which requires cgo. The island binary does so only
+// when it is built w/ the `sqlite` tag.
+package sqlite
+
+import (
+	"database/sql"
+
+	"github.com/kchristidis/island/stats"
+	_ "github.com/mattn/go-sqlite3" // Registers the "sqlite3" driver
+)
+
+// The tables mirror the CSV files that `stats.CSVSink` writes; see the README
+// for their columns. A sink replaces any tables of the same name.
+var schemaStmts = []string{
+	`DROP TABLE IF EXISTS transactions`,
+	`DROP TABLE IF EXISTS blocks`,
+	`DROP TABLE IF EXISTS slots`,
+	`CREATE TABLE transactions (
+		event_id TEXT NOT NULL,
+		latency_ms INTEGER NOT NULL,
+		tx_type TEXT NOT NULL,
+		attempt INTEGER NOT NULL,
+		tx_status TEXT NOT NULL,
+		tx_id TEXT,
+		block_num INTEGER,
+		behavior TEXT
+	)`,
+	`CREATE TABLE blocks (
+		block_num INTEGER PRIMARY KEY,
+		size_kib REAL NOT NULL
+	)`,
+	`CREATE TABLE slots (
+		slot_num INTEGER PRIMARY KEY,
+		bfg_qty_kwh REAL, bfg_ppu_c_per_kWh REAL,
+		stg_qty_kwh REAL, stg_ppu_c_per_kWh REAL,
+		dmi_qty_kwh REAL, dmi_ppu_c_per_kWh REAL,
+		late_cnt_all INTEGER, late_cnt_buy INTEGER, late_cnt_sell INTEGER,
+		late_decrs INTEGER,
+		prob_iters INTEGER, prob_marshals INTEGER,
+		prob_decrs INTEGER, prob_bid_calcs INTEGER,
+		prob_keys INTEGER, prob_gets INTEGER, prob_puts INTEGER,
+		rej_prices INTEGER, rej_qtys INTEGER, rej_caps INTEGER,
+		rej_excess INTEGER, rej_ticks INTEGER,
+		curt_qty_kwh REAL, loss_qty_kwh REAL, cong_cnt INTEGER,
+		fft_cnt INTEGER, fft_amt_c REAL
+	)`,
+}
+
+// Sink writes the results to a SQLite database.
+type Sink struct {
+	Path string
+}
+
+// New returns a new SQLite sink.
+func New(path string) *Sink {
+	return &Sink{Path: path}
+}
+
+// Write writes the results to the sink's database, in a single transaction.
+func (s *Sink) Write(results *stats.Results) error {
+	db, err := sql.Open("sqlite3", s.Path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := write(tx, results); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func write(tx *sql.Tx, results *stats.Results) error {
+	for _, stmt := range schemaStmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	txStmt, err := tx.Prepare(`INSERT INTO transactions VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer txStmt.Close()
+	for _, t := range results.Transactions {
+		// The tx ID and block number are NULL unless the transaction went through,
+		// and the behavior is NULL for honest agents
+		var txID, blockNum, behavior interface{}
+		if t.TxID != "" {
+			txID, blockNum = t.TxID, int64(t.BlockNumber)
+		}
+		if t.Behavior != "" {
+			behavior = t.Behavior
+		}
+		if _, err := txStmt.Exec(t.ID, t.LatencyInMillis, t.Type, t.Attempt, t.Status, txID, blockNum, behavior); err != nil {
+			return err
+		}
+	}
+
+	blockStmt, err := tx.Prepare(`INSERT INTO blocks VALUES (?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer blockStmt.Close()
+	for _, b := range results.Blocks {
+		if _, err := blockStmt.Exec(int64(b.Number), b.Size); err != nil {
+			return err
+		}
+	}
+
+	slotStmt, err := tx.Prepare(`INSERT INTO slots VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer slotStmt.Close()
+	for i, sl := range results.Slots {
+		c := results.Counters[i]
+		if _, err := slotStmt.Exec(sl.Number,
+			sl.EnergyUse, sl.PricePaid,
+			sl.EnergyGen, sl.PriceSold,
+			sl.EnergyTraded, sl.PriceTraded,
+			c.LateTXs, c.LateBuys, c.LateSells,
+			c.LateDecrypts,
+			c.ProblematicIters, c.ProblematicMarshals,
+			c.ProblematicDecrypts, c.ProblematicBidCalcs,
+			c.ProblematicKeys, c.ProblematicGets, c.ProblematicPuts,
+			c.RejectedPrices, c.RejectedQuantities, c.RejectedCaps,
+			c.RejectedExcess, c.RejectedTicks,
+			sl.EnergyCurtailed, sl.EnergyLost, sl.Congestions,
+			sl.Forfeits, sl.DepositsForfeited); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
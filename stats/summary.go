@@ -0,0 +1,391 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/kchristidis/island/chaincode/schema"
+)
+
+// Summary carries the headline numbers of a run, as derived from its results.
+// Latencies are in ms, energy in kWh, prices in ç/kWh, and welfare in ç.
+type Summary struct {
+	Config interface{} `json:",omitempty"` // What the run was set up with
+
+	Slots        int // The cleared slots covered
+	Transactions int
+
+	// The transactions of adversarial agents are left out of these, and
+	// summarized by behavior in Adversaries instead.
+	Latency           map[string]LatencyStats // By transaction type
+	Attempts          []AttemptStats          // By attempt number, in ascending order
+	MVCCConflicts     int                     // Counts the phantom read conflicts too
+	MVCCConflictRatio float64                 // Over the transactions of honest agents
+
+	Late LateStats
+
+	Adversaries map[string]AdversaryStats `json:",omitempty"` // By behavior
+	Deposits    *DepositStats             `json:",omitempty"` // Set if any deposit was forfeited
+
+	Energy  EnergyStats
+	Prices  PriceStats
+	Welfare WelfareStats
+}
+
+// LatencyStats ...
+type LatencyStats struct {
+	Count         int
+	P50, P90, P99 int64
+}
+
+// AttemptStats ...
+type AttemptStats struct {
+	Attempt     int
+	Count       int
+	Succeeded   int
+	SuccessRate float64
+}
+
+// LateStats counts the transactions that the contract turned down because
+// their slot had been marked already. Each rate is over the transactions of
+// the same type: all of them, 'buy', 'sell', and 'postKey' respectively.
+type LateStats struct {
+	All, Buys, Sells, Decrypts              int
+	AllRate, BuyRate, SellRate, DecryptRate float64
+}
+
+// AdversaryStats counts the transactions of the agents with a given adversarial
+// behavior, and how the contract took them.
+type AdversaryStats struct {
+	Agents       int
+	Transactions int
+	Accepted     int // Went through
+	Late         int // Turned down because their slot had been marked
+	Withheld     int // Keys that were withheld, i.e. never submitted
+
+	AcceptRate float64 // Over the transactions that were submitted
+}
+
+// DepositStats counts the deposits that were forfeited by bids that were not
+// revealed by the end of their slot.
+type DepositStats struct {
+	Forfeits  int
+	Forfeited float64 // In ç
+}
+
+// EnergyStats ...
+type EnergyStats struct {
+	Local      float64 // Traded in the market, i.e. demand met internally
+	GridBought float64
+	GridSold   float64
+	Total      float64 // All of the above
+
+	// The share of the demand that was met internally.
+	SelfSufficiency float64
+}
+
+// PriceStats ...
+type PriceStats struct {
+	Clearing float64 // Weighted by the energy traded
+	Retail   float64 // Averaged over the cleared slots
+	FeedIn   float64 // As above
+}
+
+// WelfareStats is the surplus that the market generates over trading with the
+// grid: buyers pay the clearing price instead of the retail one, and sellers
+// get the clearing price instead of the feed-in one.
+type WelfareStats struct {
+	Buyers  float64
+	Sellers float64
+	Total   float64
+}
+
+// Summarize returns the summary of the results.
+func Summarize(results *Results) *Summary {
+	s := &Summary{
+		Slots:        len(results.Slots),
+		Transactions: len(results.Transactions),
+		Latency:      make(map[string]LatencyStats),
+	}
+
+	latencies := make(map[string][]int64)
+	attempts := make(map[int]*AttemptStats)
+	counts := make(map[string]int)
+	adversaries := make(map[string]*AdversaryStats)
+	agents := make(map[string]map[string]bool) // By behavior
+	var honest int
+	for _, tx := range results.Transactions {
+		counts[tx.Type]++
+		if tx.Behavior != "" {
+			a := adversaries[tx.Behavior]
+			if a == nil {
+				a = new(AdversaryStats)
+				adversaries[tx.Behavior] = a
+				agents[tx.Behavior] = make(map[string]bool)
+			}
+			a.Transactions++
+			switch {
+			case tx.Status == "success":
+				a.Accepted++
+			case tx.Status == "withheld":
+				a.Withheld++
+			case strings.Contains(tx.Status, "slot marked already"):
+				a.Late++
+			}
+			if ev, err := schema.ParseEventID(tx.ID); err == nil {
+				agents[tx.Behavior][ev.Agent] = true
+			}
+			continue
+		}
+
+		honest++
+		if tx.LatencyInMillis >= 0 {
+			latencies[tx.Type] = append(latencies[tx.Type], tx.LatencyInMillis)
+		}
+		if attempts[tx.Attempt] == nil {
+			attempts[tx.Attempt] = &AttemptStats{Attempt: tx.Attempt}
+		}
+		attempts[tx.Attempt].Count++
+		switch tx.Status {
+		case "success":
+			attempts[tx.Attempt].Succeeded++
+		case "failure: mvcc_read_conflict", "failure: phantom_read_conflict":
+			s.MVCCConflicts++
+		}
+	}
+
+	for txType, vals := range latencies {
+		sort.Slice(vals, func(i, j int) bool { return vals[i] < vals[j] })
+		s.Latency[txType] = LatencyStats{
+			Count: len(vals),
+			P50:   percentile(vals, 50),
+			P90:   percentile(vals, 90),
+			P99:   percentile(vals, 99),
+		}
+	}
+	for _, a := range attempts {
+		a.SuccessRate = ratio(float64(a.Succeeded), float64(a.Count))
+		s.Attempts = append(s.Attempts, *a)
+	}
+	sort.Slice(s.Attempts, func(i, j int) bool { return s.Attempts[i].Attempt < s.Attempts[j].Attempt })
+	s.MVCCConflictRatio = ratio(float64(s.MVCCConflicts), float64(honest))
+
+	if len(adversaries) > 0 {
+		s.Adversaries = make(map[string]AdversaryStats, len(adversaries))
+	}
+	for behavior, a := range adversaries {
+		a.Agents = len(agents[behavior])
+		a.AcceptRate = ratio(float64(a.Accepted), float64(a.Transactions-a.Withheld))
+		s.Adversaries[behavior] = *a
+	}
+
+	for _, c := range results.Counters {
+		s.Late.All += c.LateTXs
+		s.Late.Buys += c.LateBuys
+		s.Late.Sells += c.LateSells
+		s.Late.Decrypts += c.LateDecrypts
+	}
+	s.Late.AllRate = ratio(float64(s.Late.All), float64(s.Transactions))
+	s.Late.BuyRate = ratio(float64(s.Late.Buys), float64(counts["buy"]))
+	s.Late.SellRate = ratio(float64(s.Late.Sells), float64(counts["sell"]))
+	s.Late.DecryptRate = ratio(float64(s.Late.Decrypts), float64(counts["postKey"]))
+
+	var clearingValue float64
+	for _, slot := range results.Slots {
+		s.Energy.Local += slot.EnergyTraded
+		s.Energy.GridBought += slot.EnergyUse
+		s.Energy.GridSold += slot.EnergyGen
+
+		clearingValue += slot.PriceTraded * slot.EnergyTraded
+		s.Prices.Retail += slot.PricePaid
+		s.Prices.FeedIn += slot.PriceSold
+
+		s.Welfare.Buyers += (slot.PricePaid - slot.PriceTraded) * slot.EnergyTraded
+		s.Welfare.Sellers += (slot.PriceTraded - slot.PriceSold) * slot.EnergyTraded
+
+		if slot.Forfeits > 0 {
+			if s.Deposits == nil {
+				s.Deposits = new(DepositStats)
+			}
+			s.Deposits.Forfeits += slot.Forfeits
+			s.Deposits.Forfeited += slot.DepositsForfeited
+		}
+	}
+	s.Energy.Total = s.Energy.Local + s.Energy.GridBought + s.Energy.GridSold
+	s.Energy.SelfSufficiency = ratio(s.Energy.Local, s.Energy.Local+s.Energy.GridBought)
+	s.Prices.Clearing = ratio(clearingValue, s.Energy.Local)
+	s.Prices.Retail = ratio(s.Prices.Retail, float64(s.Slots))
+	s.Prices.FeedIn = ratio(s.Prices.FeedIn, float64(s.Slots))
+	s.Welfare.Total = s.Welfare.Buyers + s.Welfare.Sellers
+
+	return s
+}
+
+// percentile returns the nearest-rank percentile of the sorted values.
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// ratio returns a/b, or 0 if b is 0.
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
+
+// SummarySink writes the summary of the results to a JSON file, and to a
+// Markdown file for humans. Either path may be left empty.
+type SummarySink struct {
+	JSONPath     string
+	MarkdownPath string
+
+	// Embedded in the summary, so that the run can be reproduced. It should
+	// encode to JSON.
+	Config interface{}
+}
+
+// NewSummarySink returns a new summary sink.
+func NewSummarySink(jsonPath, markdownPath string, config interface{}) *SummarySink {
+	return &SummarySink{
+		JSONPath:     jsonPath,
+		MarkdownPath: markdownPath,
+
+		Config: config,
+	}
+}
+
+// Write writes the summary of the results to the sink's files.
+func (s *SummarySink) Write(results *Results) error {
+	summary := Summarize(results)
+	summary.Config = s.Config
+
+	if s.JSONPath != "" {
+		summaryB, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(s.JSONPath, append(summaryB, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	if s.MarkdownPath != "" {
+		markdownB, err := summary.Markdown()
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(s.MarkdownPath, markdownB, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Markdown renders the summary as a Markdown document.
+func (s *Summary) Markdown() ([]byte, error) {
+	var b bytes.Buffer
+	pct := func(v float64) string { return fmt.Sprintf("%.2f%%", 100*v) }
+
+	fmt.Fprintln(&b, "# Run summary")
+	fmt.Fprintln(&b)
+	fmt.Fprintf(&b, "%d cleared slots, %d transactions.\n", s.Slots, s.Transactions)
+
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "## Latency (ms)")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "| Type | Count | p50 | p90 | p99 |")
+	fmt.Fprintln(&b, "|---|---:|---:|---:|---:|")
+	var txTypes []string
+	for txType := range s.Latency {
+		txTypes = append(txTypes, txType)
+	}
+	sort.Strings(txTypes)
+	for _, txType := range txTypes {
+		l := s.Latency[txType]
+		fmt.Fprintf(&b, "| %s | %d | %d | %d | %d |\n", txType, l.Count, l.P50, l.P90, l.P99)
+	}
+
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "## Success rate by attempt")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "| Attempt | Count | Succeeded | Rate |")
+	fmt.Fprintln(&b, "|---:|---:|---:|---:|")
+	for _, a := range s.Attempts {
+		fmt.Fprintf(&b, "| %d | %d | %d | %s |\n", a.Attempt, a.Count, a.Succeeded, pct(a.SuccessRate))
+	}
+
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "## Conflicts and late transactions")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "| | Count | Rate |")
+	fmt.Fprintln(&b, "|---|---:|---:|")
+	fmt.Fprintf(&b, "| MVCC read conflicts | %d | %s |\n", s.MVCCConflicts, pct(s.MVCCConflictRatio))
+	fmt.Fprintf(&b, "| Late (all) | %d | %s |\n", s.Late.All, pct(s.Late.AllRate))
+	fmt.Fprintf(&b, "| Late buys | %d | %s |\n", s.Late.Buys, pct(s.Late.BuyRate))
+	fmt.Fprintf(&b, "| Late sells | %d | %s |\n", s.Late.Sells, pct(s.Late.SellRate))
+	fmt.Fprintf(&b, "| Late decryptions | %d | %s |\n", s.Late.Decrypts, pct(s.Late.DecryptRate))
+
+	if len(s.Adversaries) > 0 {
+		fmt.Fprintln(&b)
+		fmt.Fprintln(&b, "## Adversaries")
+		fmt.Fprintln(&b)
+		fmt.Fprintln(&b, "| Behavior | Agents | Transactions | Accepted | Late | Withheld | Accept rate |")
+		fmt.Fprintln(&b, "|---|---:|---:|---:|---:|---:|---:|")
+		var behaviors []string
+		for behavior := range s.Adversaries {
+			behaviors = append(behaviors, behavior)
+		}
+		sort.Strings(behaviors)
+		for _, behavior := range behaviors {
+			a := s.Adversaries[behavior]
+			fmt.Fprintf(&b, "| %s | %d | %d | %d | %d | %d | %s |\n", behavior, a.Agents, a.Transactions, a.Accepted, a.Late, a.Withheld, pct(a.AcceptRate))
+		}
+	}
+
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "## Market")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "| | |")
+	fmt.Fprintln(&b, "|---|---:|")
+	fmt.Fprintf(&b, "| Energy traded locally | %.3f kWh |\n", s.Energy.Local)
+	fmt.Fprintf(&b, "| Energy bought from the grid | %.3f kWh |\n", s.Energy.GridBought)
+	fmt.Fprintf(&b, "| Energy sold to the grid | %.3f kWh |\n", s.Energy.GridSold)
+	fmt.Fprintf(&b, "| Energy traded in total | %.3f kWh |\n", s.Energy.Total)
+	fmt.Fprintf(&b, "| Self-sufficiency | %s |\n", pct(s.Energy.SelfSufficiency))
+	fmt.Fprintf(&b, "| Average clearing price | %.3f ç/kWh |\n", s.Prices.Clearing)
+	fmt.Fprintf(&b, "| Average retail price | %.3f ç/kWh |\n", s.Prices.Retail)
+	fmt.Fprintf(&b, "| Average feed-in price | %.3f ç/kWh |\n", s.Prices.FeedIn)
+	fmt.Fprintf(&b, "| Buyer surplus | %.3f ç |\n", s.Welfare.Buyers)
+	fmt.Fprintf(&b, "| Seller surplus | %.3f ç |\n", s.Welfare.Sellers)
+	fmt.Fprintf(&b, "| Social welfare | %.3f ç |\n", s.Welfare.Total)
+	if s.Deposits != nil {
+		fmt.Fprintf(&b, "| Deposits forfeited | %d (%.3f ç) |\n", s.Deposits.Forfeits, s.Deposits.Forfeited)
+	}
+
+	if s.Config != nil {
+		configB, err := json.MarshalIndent(s.Config, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintln(&b)
+		fmt.Fprintln(&b, "## Configuration")
+		fmt.Fprintln(&b)
+		fmt.Fprintln(&b, "```json")
+		fmt.Fprintln(&b, string(configB))
+		fmt.Fprintln(&b, "```")
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,233 @@
+package tariff
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/kchristidis/island/trace"
+)
+
+// Supported tariff kinds.
+const (
+	KindTrace     = "trace"  // Use the Lo/Hi columns of the trace.
+	KindFlat      = "flat"   // A fixed retail and feed-in price.
+	KindTimeOfUse = "tou"    // A retail price that depends on the time of day.
+	KindSeries    = "series" // A real-time price series, read from a CSV file.
+)
+
+// SlotsPerDay is the number of slots in a day, given `schema.SlotDuration`.
+const SlotsPerDay = int(24 * time.Hour / schema.SlotDuration)
+
+// Tariff returns the prices that the grid offers for a given slot, in cents per kWh.
+type Tariff interface {
+	// Prices returns the feed-in price, i.e. what the grid pays for the energy that
+	// households send back to it (the trace's Lo column), and the retail price, i.e.
+	// what the grid charges for the energy it sells to households (the trace's Hi column).
+	Prices(slot int) (feedIn, retail float64)
+}
+
+// Trace is a tariff that reads the prices off the rows of a trace,
+// as returned by `trace.Load`.
+type Trace struct {
+	Rows []trace.Row
+}
+
+// Prices satisfies the Tariff interface.
+func (t Trace) Prices(slot int) (float64, float64) {
+	row := t.Rows[slot]
+	return row.Lo, row.Hi
+}
+
+// Flat is a tariff with fixed prices.
+type Flat struct {
+	FeedIn, Retail float64
+}
+
+// Prices satisfies the Tariff interface.
+func (t Flat) Prices(slot int) (float64, float64) {
+	return t.FeedIn, t.Retail
+}
+
+// Period is a time-of-use period. It starts at the given hour of the
+// day, and lasts until the start of the next period.
+type Period struct {
+	FromHour int
+	Retail   float64
+}
+
+// TimeOfUse is a tariff where the retail price depends on the time of
+// day. The feed-in price is fixed.
+type TimeOfUse struct {
+	SlotsPerDay int
+	Periods     []Period // Sorted by FromHour
+	FeedIn      float64
+}
+
+// NewTimeOfUse returns a time-of-use tariff. At least one period is needed.
+func NewTimeOfUse(slotsPerDay int, periods []Period, feedIn float64) (*TimeOfUse, error) {
+	if slotsPerDay < 1 {
+		return nil, fmt.Errorf("invalid number of slots per day: %d", slotsPerDay)
+	}
+	if len(periods) == 0 {
+		return nil, errors.New("a time-of-use tariff needs at least one period")
+	}
+
+	sorted := make([]Period, len(periods))
+	copy(sorted, periods)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].FromHour < sorted[j].FromHour
+	})
+
+	for _, p := range sorted {
+		if p.FromHour < 0 || p.FromHour > 23 {
+			return nil, fmt.Errorf("invalid hour for time-of-use period: %d", p.FromHour)
+		}
+	}
+
+	return &TimeOfUse{
+		SlotsPerDay: slotsPerDay,
+		Periods:     sorted,
+		FeedIn:      feedIn,
+	}, nil
+}
+
+// Prices satisfies the Tariff interface.
+func (t *TimeOfUse) Prices(slot int) (float64, float64) {
+	hour := (slot % t.SlotsPerDay) * 24 / t.SlotsPerDay
+
+	// The period that started last before this hour applies. If the hour
+	// precedes all periods, we are still in the last period of the previous day.
+	retail := t.Periods[len(t.Periods)-1].Retail
+	for _, p := range t.Periods {
+		if p.FromHour > hour {
+			break
+		}
+		retail = p.Retail
+	}
+
+	return t.FeedIn, retail
+}
+
+// Series is a tariff with a price pair per slot. If a run has more slots
+// than the series, the series repeats.
+type Series struct {
+	FeedIn, Retail []float64
+}
+
+// Prices satisfies the Tariff interface.
+func (t *Series) Prices(slot int) (float64, float64) {
+	idx := slot % len(t.Retail)
+	return t.FeedIn[idx], t.Retail[idx]
+}
+
+// LoadSeries reads a price series from a CSV file. The file should carry a
+// header, followed by one row per slot, with the feed-in price in the first
+// column and the retail price in the second one.
+func LoadSeries(fname string) (*Series, error) {
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	cr := csv.NewReader(bytes.NewReader(b))
+
+	// Skip the headers
+	if _, err := cr.Read(); err != nil {
+		return nil, err
+	}
+
+	recs, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(recs) == 0 {
+		return nil, fmt.Errorf("no prices in %s", fname)
+	}
+
+	s := &Series{
+		FeedIn: make([]float64, len(recs)),
+		Retail: make([]float64, len(recs)),
+	}
+
+	for i, rec := range recs {
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("%s: row %d: expected 2 columns, got %d", fname, i+1, len(rec))
+		}
+		if s.FeedIn[i], err = strconv.ParseFloat(rec[0], 64); err != nil {
+			return nil, fmt.Errorf("%s: row %d: %s", fname, i+1, err)
+		}
+		if s.Retail[i], err = strconv.ParseFloat(rec[1], 64); err != nil {
+			return nil, fmt.Errorf("%s: row %d: %s", fname, i+1, err)
+		}
+	}
+
+	return s, nil
+}
+
+// FeedIn is a tariff that overrides the feed-in price of another tariff.
+type FeedIn struct {
+	Tariff
+	Price float64
+}
+
+// Prices satisfies the Tariff interface.
+func (t FeedIn) Prices(slot int) (float64, float64) {
+	_, retail := t.Tariff.Prices(slot)
+	return t.Price, retail
+}
+
+// Config describes a tariff.
+type Config struct {
+	Kind string // One of the Kind* constants
+
+	FeedIn float64 // Used by flat and time-of-use tariffs
+	Retail float64 // Used by flat tariffs
+
+	Periods []Period // Used by time-of-use tariffs
+
+	SeriesFile string // Used by series tariffs
+
+	// If set, overrides the feed-in price of the tariff.
+	FeedInOverride *float64
+}
+
+// New returns the tariff described by the config. The trace rows are
+// used by tariffs of kind `KindTrace`.
+func New(cfg Config, rows []trace.Row) (Tariff, error) {
+	var t Tariff
+
+	switch cfg.Kind {
+	case KindTrace, "":
+		t = Trace{Rows: rows}
+	case KindFlat:
+		t = Flat{FeedIn: cfg.FeedIn, Retail: cfg.Retail}
+	case KindTimeOfUse:
+		tou, err := NewTimeOfUse(SlotsPerDay, cfg.Periods, cfg.FeedIn)
+		if err != nil {
+			return nil, err
+		}
+		t = tou
+	case KindSeries:
+		s, err := LoadSeries(cfg.SeriesFile)
+		if err != nil {
+			return nil, err
+		}
+		t = s
+	default:
+		return nil, fmt.Errorf("unknown tariff kind: %s", cfg.Kind)
+	}
+
+	if cfg.FeedInOverride != nil {
+		t = FeedIn{Tariff: t, Price: *cfg.FeedInOverride}
+	}
+
+	return t, nil
+}
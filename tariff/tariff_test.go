@@ -0,0 +1,117 @@
+package tariff_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kchristidis/island/tariff"
+	"github.com/kchristidis/island/trace"
+	"github.com/stretchr/testify/require"
+)
+
+func prices(t tariff.Tariff, slot int) []float64 {
+	feedIn, retail := t.Prices(slot)
+	return []float64{feedIn, retail}
+}
+
+func TestTrace(t *testing.T) {
+	rows := []trace.Row{
+		{Gen: -0.005, Grid: 0.878, Use: 0.878, Lo: 3.4, Hi: 10.84},
+		{Gen: -0.005, Grid: 0.878, Use: 0.878, Lo: 3.5, Hi: 11.2},
+	}
+	tr, err := tariff.New(tariff.Config{Kind: tariff.KindTrace}, rows)
+	require.NoError(t, err)
+	require.Equal(t, []float64{3.4, 10.84}, prices(tr, 0))
+	require.Equal(t, []float64{3.5, 11.2}, prices(tr, 1))
+}
+
+func TestFlat(t *testing.T) {
+	tr, err := tariff.New(tariff.Config{Kind: tariff.KindFlat, FeedIn: 4, Retail: 12}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []float64{4, 12}, prices(tr, 0))
+	require.Equal(t, []float64{4, 12}, prices(tr, 1000))
+}
+
+func TestTimeOfUse(t *testing.T) {
+	t.Run("no periods", func(t *testing.T) {
+		_, err := tariff.New(tariff.Config{Kind: tariff.KindTimeOfUse}, nil)
+		require.Error(t, err)
+	})
+
+	t.Run("invalid hour", func(t *testing.T) {
+		_, err := tariff.NewTimeOfUse(tariff.SlotsPerDay, []tariff.Period{{FromHour: 24, Retail: 10}}, 3)
+		require.Error(t, err)
+	})
+
+	t.Run("schedule", func(t *testing.T) {
+		tr, err := tariff.New(tariff.Config{
+			Kind:   tariff.KindTimeOfUse,
+			FeedIn: 3,
+			Periods: []tariff.Period{
+				{FromHour: 17, Retail: 20}, // Peak
+				{FromHour: 7, Retail: 12},  // Shoulder
+				{FromHour: 21, Retail: 8},  // Off-peak
+			},
+		}, nil)
+		require.NoError(t, err)
+
+		require.Equal(t, []float64{3, 8}, prices(tr, 0))                       // 00:00 belongs to the off-peak period that started the previous day
+		require.Equal(t, []float64{3, 8}, prices(tr, 7*4-1))                   // 06:45
+		require.Equal(t, []float64{3, 12}, prices(tr, 7*4))                    // 07:00
+		require.Equal(t, []float64{3, 20}, prices(tr, 17*4+3))                 // 17:45
+		require.Equal(t, []float64{3, 8}, prices(tr, 21*4))                    // 21:00
+		require.Equal(t, []float64{3, 12}, prices(tr, tariff.SlotsPerDay+7*4)) // 07:00 on the next day
+	})
+}
+
+func TestSeries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tariff")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("load", func(t *testing.T) {
+		fname := filepath.Join(dir, "series.csv")
+		require.NoError(t, ioutil.WriteFile(fname, []byte("lo,hi\n3.1,10.5\n2.9,14\n"), 0644))
+
+		tr, err := tariff.New(tariff.Config{Kind: tariff.KindSeries, SeriesFile: fname}, nil)
+		require.NoError(t, err)
+		require.Equal(t, []float64{3.1, 10.5}, prices(tr, 0))
+		require.Equal(t, []float64{2.9, 14}, prices(tr, 1))
+		require.Equal(t, []float64{3.1, 10.5}, prices(tr, 2)) // The series repeats
+	})
+
+	t.Run("bad value", func(t *testing.T) {
+		fname := filepath.Join(dir, "bad.csv")
+		require.NoError(t, ioutil.WriteFile(fname, []byte("lo,hi\n3.1,foo\n"), 0644))
+
+		_, err := tariff.LoadSeries(fname)
+		require.Error(t, err)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		fname := filepath.Join(dir, "empty.csv")
+		require.NoError(t, ioutil.WriteFile(fname, []byte("lo,hi\n"), 0644))
+
+		_, err := tariff.LoadSeries(fname)
+		require.Error(t, err)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := tariff.LoadSeries(filepath.Join(dir, "missing.csv"))
+		require.Error(t, err)
+	})
+}
+
+func TestFeedInOverride(t *testing.T) {
+	feedIn := 7.5
+	tr, err := tariff.New(tariff.Config{Kind: tariff.KindFlat, FeedIn: 4, Retail: 12, FeedInOverride: &feedIn}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []float64{7.5, 12}, prices(tr, 0))
+}
+
+func TestUnknownKind(t *testing.T) {
+	_, err := tariff.New(tariff.Config{Kind: "foo"}, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,514 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The canonical column names, indexed by the column headers of the default trace.
+var colNames = [ColCount]string{"dataid", "gen", "grid", "use", "lo", "hi"}
+
+// Alternative names that we accept for the canonical columns.
+var aliases = map[string]string{
+	"data_id":     "dataid",
+	"id":          "dataid",
+	"generation":  "gen",
+	"consumption": "use",
+	"feed_in":     "lo",
+	"retail":      "hi",
+}
+
+// builder accumulates the rows of a trace as they are read.
+type builder struct {
+	conv       converter
+	ids        []int
+	households map[int][]Row
+}
+
+func newBuilder(conv converter) *builder {
+	return &builder{
+		conv:       conv,
+		households: make(map[int][]Row),
+	}
+}
+
+func (b *builder) add(id int, r Row) {
+	if _, ok := b.households[id]; !ok {
+		b.ids = append(b.ids, id)
+	}
+	b.households[id] = append(b.households[id], b.conv.scaled(r))
+}
+
+// trace returns the rows that were read, resampled and rounded.
+func (b *builder) trace() (*Trace, error) {
+	for _, id := range b.ids {
+		rows, err := ReadRows(b.conv.wrap(&sliceCursor{rows: b.households[id]}), math.MaxInt32)
+		if err != nil {
+			return nil, err
+		}
+		b.households[id] = rows
+	}
+
+	return &Trace{
+		IDs:        b.ids,
+		Households: b.households,
+	}, nil
+}
+
+// readFunc reads the records of a table into a builder.
+type readFunc func(b *builder, t *table) error
+
+// gzipMagic are the first bytes of every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// openFile opens a file for reading, and decompresses it on the fly if it is
+// gzip-compressed. It also returns whether the file is compressed.
+func openFile(path string) (io.ReadCloser, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	br := bufio.NewReader(f)
+	if magic, err := br.Peek(len(gzipMagic)); err != nil || !bytes.Equal(magic, gzipMagic) {
+		return readCloser{Reader: br, Closer: f}, false, nil
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, false, fmt.Errorf("%s: %s", path, err)
+	}
+	return readCloser{Reader: zr, Closer: f}, true, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (b *builder) readFile(path string, read readFunc) error {
+	f, _, err := openFile(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return read(b, newTable(path, f))
+}
+
+// dirFiles lists the `<id>.csv` and `<id>.csv.gz` files of a directory, sorted by ID.
+func dirFiles(path string) ([]int, map[int]string, error) {
+	fis, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ids []int
+	fnames := make(map[int]string)
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() || !(strings.HasSuffix(name, ".csv") || strings.HasSuffix(name, ".csv.gz")) {
+			continue
+		}
+		fname := filepath.Join(path, name)
+		id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".csv"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: file name is not a household ID", fname)
+		}
+		if _, ok := fnames[id]; ok {
+			return nil, nil, fmt.Errorf("%s: household %d has more than one file", path, id)
+		}
+		ids = append(ids, id)
+		fnames[id] = fname
+	}
+	sort.Ints(ids)
+
+	return ids, fnames, nil
+}
+
+// readDir reads a directory with one file per household.
+func (b *builder) readDir(path string) error {
+	ids, fnames, err := dirFiles(path)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := b.readFile(fnames[id], readLongAs(id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// table wraps a CSV reader, and tracks the header and the current line for
+// error reporting.
+type table struct {
+	name string
+	cr   *csv.Reader
+	line int
+	cols map[string]int
+}
+
+func newTable(name string, r io.Reader) *table {
+	return &table{
+		name: name,
+		cr:   csv.NewReader(r),
+	}
+}
+
+func normalize(col string) string {
+	col = strings.ToLower(strings.TrimSpace(col))
+	if alias, ok := aliases[col]; ok {
+		return alias
+	}
+	return col
+}
+
+func (t *table) header() ([]string, error) {
+	rec, err := t.cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%s: empty file", t.name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.line++
+
+	t.cols = make(map[string]int, len(rec))
+	for i, col := range rec {
+		col = normalize(col)
+		if _, ok := t.cols[col]; ok {
+			return nil, fmt.Errorf("%s: duplicate column %s", t.name, col)
+		}
+		t.cols[col] = i
+	}
+
+	return rec, nil
+}
+
+func (t *table) require(cols ...string) error {
+	for _, col := range cols {
+		if _, ok := t.cols[col]; !ok {
+			return fmt.Errorf("%s: missing column %s", t.name, col)
+		}
+	}
+	return nil
+}
+
+func (t *table) has(col string) bool {
+	_, ok := t.cols[col]
+	return ok
+}
+
+// next returns the next record, or io.EOF.
+func (t *table) next() ([]string, error) {
+	rec, err := t.cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	t.line++
+	return rec, nil
+}
+
+func (t *table) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("%s: line %d: %s", t.name, t.line, fmt.Sprintf(format, a...))
+}
+
+// float parses a column of the given record. Columns that the table does not
+// carry are read as zero.
+func (t *table) float(rec []string, col string) (float64, error) {
+	i, ok := t.cols[col]
+	if !ok {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(rec[i]), 64)
+	if err != nil {
+		return 0, t.errorf("column %s: %s", col, err)
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, t.errorf("column %s: not a finite number", col)
+	}
+	return v, nil
+}
+
+func (t *table) int(rec []string, col string) (int, error) {
+	v, err := strconv.Atoi(strings.TrimSpace(rec[t.cols[col]]))
+	if err != nil {
+		return 0, t.errorf("column %s: %s", col, err)
+	}
+	return v, nil
+}
+
+// row parses the power and price columns of a record. If the table doesn't
+// carry a grid column, it is derived from the use and gen ones.
+func (t *table) row(rec []string) (Row, error) {
+	var r Row
+	var err error
+	for _, f := range []struct {
+		col string
+		dst *float64
+	}{
+		{"gen", &r.Gen}, {"grid", &r.Grid}, {"use", &r.Use}, {"lo", &r.Lo}, {"hi", &r.Hi},
+	} {
+		if *f.dst, err = t.float(rec, f.col); err != nil {
+			return r, err
+		}
+	}
+	if !t.has("grid") {
+		r.Grid = r.Use - r.Gen
+	}
+	return r, nil
+}
+
+func readLong(b *builder, t *table) error {
+	return readLongAs(-1)(b, t)
+}
+
+// longHeader reads the header of a long-format table. If fixedID is set, the
+// dataid column is optional.
+func (t *table) longHeader(fixedID bool) error {
+	header, err := t.header()
+	if err != nil {
+		return err
+	}
+
+	// Fall back to the positional layout of the default trace if the header
+	// doesn't name any of the columns we expect.
+	var named bool
+	for _, col := range colNames {
+		named = named || t.has(col)
+	}
+	if !named && len(header) == ColCount {
+		for i, col := range colNames {
+			t.cols[col] = i
+		}
+	}
+
+	if !fixedID {
+		if err := t.require("dataid"); err != nil {
+			return err
+		}
+	}
+	return t.require("gen", "use", "lo", "hi")
+}
+
+// longRecord parses a long-format record. If id is not negative, the record
+// should belong to that household.
+func (t *table) longRecord(rec []string, id int) (int, Row, error) {
+	if t.has("dataid") {
+		recID, err := t.int(rec, "dataid")
+		if err != nil {
+			return 0, Row{}, err
+		}
+		if id >= 0 && recID != id {
+			return 0, Row{}, t.errorf("expected household %d, got %d", id, recID)
+		}
+		id = recID
+	}
+
+	r, err := t.row(rec)
+	return id, r, err
+}
+
+// readLongAs returns a reader for long-format tables. If id is not negative,
+// all rows are attributed to that household, and the dataid column is optional.
+func readLongAs(id int) readFunc {
+	return func(b *builder, t *table) error {
+		if err := t.longHeader(id >= 0); err != nil {
+			return err
+		}
+
+		for {
+			rec, err := t.next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			recID, r, err := t.longRecord(rec, id)
+			if err != nil {
+				return err
+			}
+			b.add(recID, r)
+		}
+	}
+}
+
+// wideCol matches the per-household columns of a wide-format table, e.g. `use_171`.
+var wideCol = regexp.MustCompile(`^(gen|grid|use)_(\d+)$`)
+
+// wideHeader reads the header of a wide-format table, and returns the IDs of
+// the households it carries, along with a sub-table for every household that
+// maps the canonical column names to the indices of the household's columns.
+func (t *table) wideHeader() ([]int, map[int]*table, error) {
+	header, err := t.header()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := t.require("lo", "hi"); err != nil {
+		return nil, nil, err
+	}
+
+	var ids []int
+	subs := make(map[int]*table)
+	for _, col := range header {
+		m := wideCol.FindStringSubmatch(normalize(col))
+		if m == nil {
+			continue
+		}
+		id, _ := strconv.Atoi(m[2])
+		if _, ok := subs[id]; !ok {
+			ids = append(ids, id)
+			subs[id] = &table{name: t.name, cols: map[string]int{
+				"lo": t.cols["lo"],
+				"hi": t.cols["hi"],
+			}}
+		}
+		subs[id].cols[m[1]] = t.cols[normalize(col)]
+	}
+
+	for _, id := range ids {
+		if err := subs[id].require("gen", "use"); err != nil {
+			return nil, nil, fmt.Errorf("%s (household %d)", err, id)
+		}
+	}
+
+	return ids, subs, nil
+}
+
+func readWide(b *builder, t *table) error {
+	ids, subs, err := t.wideHeader()
+	if err != nil {
+		return err
+	}
+
+	for {
+		rec, err := t.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			sub := subs[id]
+			sub.line = t.line
+			r, err := sub.row(rec)
+			if err != nil {
+				return err
+			}
+			b.add(id, r)
+		}
+	}
+}
+
+// The timestamp layouts that we accept in Pecan Street exports.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05-07",
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+}
+
+func (t *table) time(rec []string, col string) (time.Time, error) {
+	v := strings.TrimSpace(rec[t.cols[col]])
+	for _, layout := range timeLayouts {
+		if ts, err := time.Parse(layout, v); err == nil {
+			return ts, nil
+		}
+	}
+	return time.Time{}, t.errorf("column %s: cannot parse timestamp %q", col, v)
+}
+
+// readPecanStreet reads a Pecan Street Dataport export. These carry the
+// `solar` (and optionally `solar2`) circuits instead of a gen column, and
+// do not carry prices. Rows are ordered by their `local_15min` timestamp.
+func readPecanStreet(b *builder, t *table) error {
+	if _, err := t.header(); err != nil {
+		return err
+	}
+	if err := t.require("dataid", "local_15min", "grid", "solar"); err != nil {
+		return err
+	}
+
+	type reading struct {
+		ts time.Time
+		r  Row
+	}
+	var ids []int
+	readings := make(map[int][]reading)
+
+	for {
+		rec, err := t.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		id, err := t.int(rec, "dataid")
+		if err != nil {
+			return err
+		}
+		ts, err := t.time(rec, "local_15min")
+		if err != nil {
+			return err
+		}
+
+		var r Row
+		var solar, solar2 float64
+		if r.Grid, err = t.float(rec, "grid"); err != nil {
+			return err
+		}
+		if solar, err = t.float(rec, "solar"); err != nil {
+			return err
+		}
+		if solar2, err = t.float(rec, "solar2"); err != nil {
+			return err
+		}
+		r.Gen = solar + solar2
+		r.Use = r.Grid + r.Gen
+		if t.has("use") {
+			if r.Use, err = t.float(rec, "use"); err != nil {
+				return err
+			}
+		}
+
+		if _, ok := readings[id]; !ok {
+			ids = append(ids, id)
+		}
+		readings[id] = append(readings[id], reading{ts: ts, r: r})
+	}
+
+	for _, id := range ids {
+		rs := readings[id]
+		sort.SliceStable(rs, func(i, j int) bool {
+			return rs[i].ts.Before(rs[j].ts)
+		})
+		for _, r := range rs {
+			b.add(id, r.r)
+		}
+	}
+
+	return nil
+}
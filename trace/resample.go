@@ -0,0 +1,163 @@
+package trace
+
+import (
+	"fmt"
+	"math"
+)
+
+// converter brings the rows that we read to the units, slot duration, and
+// precision of the trace.
+type converter struct {
+	scale  float64 // Converts the power columns to kW
+	factor float64 // 10^decimals, or 0 if we don't round
+
+	down   int // Source slots per slot, when resampling to longer slots
+	up     int // Slots per source slot, when resampling to shorter slots
+	prices string
+}
+
+func newConverter(opts Options) (converter, error) {
+	c := converter{down: 1, up: 1}
+
+	source := opts.SourceSlot
+	if source == 0 {
+		source = NativeSlotDuration
+	}
+	target := opts.Slot
+	if target == 0 {
+		target = source
+	}
+	switch {
+	case source < 0 || target < 0:
+		return c, fmt.Errorf("invalid slot duration: %s, %s", source, target)
+	case target%source == 0:
+		c.down = int(target / source)
+	case source%target == 0:
+		c.up = int(source / target)
+	default:
+		return c, fmt.Errorf("cannot resample %s slots to %s ones", source, target)
+	}
+
+	switch opts.Unit {
+	case UnitKW, "":
+		c.scale = 1
+	case UnitKWh:
+		c.scale = 1 / source.Hours()
+	default:
+		return c, fmt.Errorf("unknown unit: %s", opts.Unit)
+	}
+
+	switch opts.Prices {
+	case "":
+		c.prices = PriceMean
+	case PriceMean, PriceMin, PriceMax, PriceFirst:
+		c.prices = opts.Prices
+	default:
+		return c, fmt.Errorf("unknown price aggregation: %s", opts.Prices)
+	}
+
+	decimals := DefaultDecimals
+	if opts.Decimals != nil {
+		decimals = *opts.Decimals
+	}
+	if decimals >= 0 {
+		c.factor = math.Pow(10, float64(decimals))
+	}
+
+	return c, nil
+}
+
+// scaled converts the power columns of a row to kW.
+func (c converter) scaled(r Row) Row {
+	r.Gen *= c.scale
+	r.Grid *= c.scale
+	r.Use *= c.scale
+	return r
+}
+
+func (c converter) round(v float64) float64 {
+	if c.factor == 0 {
+		return v
+	}
+	return math.Round(v*c.factor) / c.factor
+}
+
+func (c converter) rounded(r Row) Row {
+	return Row{
+		Gen:  c.round(r.Gen),
+		Grid: c.round(r.Grid),
+		Use:  c.round(r.Use),
+		Lo:   c.round(r.Lo),
+		Hi:   c.round(r.Hi),
+	}
+}
+
+// count returns the number of slots that a given number of source slots
+// resample to. A trailing partial slot is dropped.
+func (c converter) count(n int) int {
+	return n / c.down * c.up
+}
+
+// wrap returns a cursor that resamples and rounds the rows of a cursor that
+// yields scaled rows at the source slot duration.
+func (c converter) wrap(src Cursor) Cursor {
+	return &resampler{Cursor: src, conv: c}
+}
+
+// resampler resamples the rows of a cursor to a different slot duration.
+// Since rows carry average power, resampling to longer slots averages the
+// power columns, which amounts to summing the energy over the slot.
+// Resampling to shorter slots repeats every row.
+type resampler struct {
+	Cursor
+	conv converter
+
+	cur  Row
+	left int // How many more times to repeat cur
+}
+
+func (r *resampler) Next() (Row, error) {
+	c := r.conv
+
+	if r.left > 0 {
+		r.left--
+		return r.cur, nil
+	}
+
+	var acc Row
+	for i := 0; i < c.down; i++ {
+		row, err := r.Cursor.Next()
+		if err != nil {
+			return Row{}, err // A trailing partial slot is dropped
+		}
+
+		acc.Gen += row.Gen
+		acc.Grid += row.Grid
+		acc.Use += row.Use
+
+		switch {
+		case i == 0:
+			acc.Lo, acc.Hi = row.Lo, row.Hi
+		case c.prices == PriceMean:
+			acc.Lo += row.Lo
+			acc.Hi += row.Hi
+		case c.prices == PriceMin:
+			acc.Lo, acc.Hi = math.Min(acc.Lo, row.Lo), math.Min(acc.Hi, row.Hi)
+		case c.prices == PriceMax:
+			acc.Lo, acc.Hi = math.Max(acc.Lo, row.Lo), math.Max(acc.Hi, row.Hi)
+		}
+	}
+
+	n := float64(c.down)
+	acc.Gen /= n
+	acc.Grid /= n
+	acc.Use /= n
+	if c.prices == PriceMean {
+		acc.Lo /= n
+		acc.Hi /= n
+	}
+
+	r.cur = c.rounded(acc)
+	r.left = c.up - 1
+	return r.cur, nil
+}
@@ -0,0 +1,504 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+// span locates the rows of a household in a long-format file.
+type span struct {
+	offset int64 // In bytes, from the start of the (uncompressed) file
+	line   int   // The line that precedes the household's first row
+	count  int
+}
+
+// Stream is a trace that is read from disk as it is consumed, so that memory
+// stays flat regardless of the trace length. Opening a stream takes a single
+// pass over the trace to discover the households and their row counts;
+// every cursor then reads its household's rows independently. A trace that
+// cannot be read that way as is, i.e. a compressed or a wide-format file, is
+// decoded into a spill file during that pass; close the stream to remove it.
+type Stream struct {
+	IDs []int
+
+	path   string
+	format string
+	conv   converter
+	counts map[int]int
+
+	spans  map[int]*span  // For long-format files
+	cols   map[string]int // For long-format files
+	fnames map[int]string // For directories
+	mem    *Trace         // For Pecan Street exports
+	spill  string         // The uncompressed copy of a long-format file, or the rows of a wide-format one
+	column map[int]int    // For wide-format files, maps a household ID to its position in a row of the spill
+}
+
+// Open indexes the trace at the given path for streaming. Files may be
+// gzip-compressed. Long-format files should carry the rows of every household
+// contiguously. Pecan Street exports have to be sorted by timestamp, so they
+// are loaded into memory.
+func Open(path string, opts Options) (*Stream, error) {
+	var err error
+	if opts.Format, err = format(path, opts); err != nil {
+		return nil, err
+	}
+
+	s := &Stream{
+		path:   path,
+		format: opts.Format,
+		counts: make(map[int]int),
+	}
+	if s.conv, err = newConverter(opts); err != nil {
+		return nil, err
+	}
+
+	switch opts.Format {
+	case FormatLong:
+		err = s.indexLong()
+	case FormatWide:
+		err = s.indexWide()
+	case FormatDir:
+		err = s.indexDir()
+	case FormatPecanStreet:
+		memOpts := opts
+		memOpts.IDs = nil
+		if s.mem, err = LoadWith(path, memOpts); err == nil {
+			s.IDs = s.mem.IDs
+			for _, id := range s.IDs {
+				s.counts[id] = len(s.mem.Households[id])
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown trace format: %s", opts.Format)
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if len(s.IDs) == 0 {
+		s.Close()
+		return nil, fmt.Errorf("%s: no households in trace", path)
+	}
+
+	if opts.IDs != nil {
+		seen := make(map[int]bool, len(opts.IDs))
+		for _, id := range opts.IDs {
+			if _, ok := s.counts[id]; !ok {
+				s.Close()
+				return nil, fmt.Errorf("household %d is not in the trace", id)
+			}
+			if seen[id] {
+				s.Close()
+				return nil, fmt.Errorf("household %d is selected twice", id)
+			}
+			seen[id] = true
+		}
+		s.IDs = opts.IDs
+	}
+
+	return s, nil
+}
+
+// Len returns the number of slots that all households in the stream cover.
+func (s *Stream) Len() int {
+	resp := math.MaxInt32
+	for _, id := range s.IDs {
+		if c := s.counts[id]; c < resp {
+			resp = c
+		}
+	}
+	if s.mem != nil { // Already resampled
+		return resp
+	}
+	return s.conv.count(resp)
+}
+
+// Close removes the spill file of the stream, if there is one. The cursors of
+// the stream should be closed first.
+func (s *Stream) Close() error {
+	if s.spill == "" {
+		return nil
+	}
+	err := os.Remove(s.spill)
+	s.spill = ""
+	return err
+}
+
+// newSpill creates a spill file for the stream.
+func (s *Stream) newSpill() (*os.File, error) {
+	f, err := ioutil.TempFile("", "trace-spill-")
+	if err != nil {
+		return nil, err
+	}
+	s.spill = f.Name()
+	return f, nil
+}
+
+// indexLong records where the rows of every household start. If the file is
+// compressed, it is copied uncompressed to a spill file as it is read, so that
+// a cursor can seek to its rows instead of decompressing all the rows before.
+func (s *Stream) indexLong() error {
+	f, compressed, err := openFile(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if !compressed {
+		return s.scanLong(f, nil)
+	}
+
+	sf, err := s.newSpill()
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(sf)
+	err = s.scanLong(f, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := sf.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// scanLong indexes a long-format file, and copies it to the given writer, if
+// any. Lines are read as-is so that we can track byte offsets, which means
+// that records should not span multiple lines.
+func (s *Stream) scanLong(r io.Reader, w io.Writer) error {
+	if w != nil {
+		r = io.TeeReader(r, w)
+	}
+	br := bufio.NewReader(r)
+
+	line, err := br.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	t := newTable(s.path, bytes.NewReader(line))
+	if err := t.longHeader(false); err != nil {
+		return err
+	}
+	s.cols = t.cols
+	s.spans = make(map[int]*span)
+	fields := t.cr.FieldsPerRecord // Set to the length of the header by the first read
+
+	offset := int64(len(line))
+	cur := -1
+	for err != io.EOF {
+		line, err = br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			t.cr = csv.NewReader(bytes.NewReader(line))
+			t.cr.FieldsPerRecord = fields
+			rec, err := t.next()
+			if err != nil {
+				return t.errorf("%s", err)
+			}
+			id, err := t.int(rec, "dataid")
+			if err != nil {
+				return err
+			}
+			if id != cur {
+				if _, ok := s.spans[id]; ok {
+					return t.errorf("the rows of household %d are not contiguous; load the trace into memory instead", id)
+				}
+				s.spans[id] = &span{offset: offset, line: t.line - 1}
+				s.IDs = append(s.IDs, id)
+				cur = id
+			}
+			s.spans[id].count++
+			s.counts[id]++
+		} else if len(line) > 0 {
+			t.line++
+		}
+		offset += int64(len(line))
+	}
+
+	return nil
+}
+
+// rowSize is the size of a row in the spill of a wide-format file: its fields,
+// in the order of `Row`, as little-endian float64 values.
+const rowSize = 5 * 8
+
+func putRow(buf []byte, r Row) {
+	for i, v := range []float64{r.Gen, r.Grid, r.Use, r.Lo, r.Hi} {
+		binary.LittleEndian.PutUint64(buf[i*8:], math.Float64bits(v))
+	}
+}
+
+func getRow(buf []byte) Row {
+	v := func(i int) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(buf[i*8:])) }
+	return Row{Gen: v(0), Grid: v(1), Use: v(2), Lo: v(3), Hi: v(4)}
+}
+
+// indexWide parses the rows of every household, and writes them to a spill
+// file, a row of the trace after the other, so that a cursor reads the fields
+// of its household only instead of parsing every row of the trace.
+func (s *Stream) indexWide() error {
+	f, _, err := openFile(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	t := newTable(s.path, f)
+	ids, subs, err := t.wideHeader()
+	if err != nil {
+		return err
+	}
+
+	sf, err := s.newSpill()
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	w := bufio.NewWriter(sf)
+
+	buf := make([]byte, rowSize)
+	var count int
+	for {
+		rec, err := t.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			sub := subs[id]
+			sub.line = t.line
+			r, err := sub.row(rec)
+			if err != nil {
+				return err
+			}
+			putRow(buf, r)
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+		}
+		count++
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	s.IDs = ids
+	s.column = make(map[int]int, len(ids))
+	for i, id := range ids {
+		s.counts[id] = count
+		s.column[id] = i
+	}
+
+	return sf.Close()
+}
+
+func (s *Stream) indexDir() error {
+	ids, fnames, err := dirFiles(s.path)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		f, _, err := openFile(fnames[id])
+		if err != nil {
+			return err
+		}
+
+		t := newTable(fnames[id], f)
+		if err := t.longHeader(true); err != nil {
+			f.Close()
+			return err
+		}
+		var count int
+		for {
+			if _, err = t.next(); err != nil {
+				break
+			}
+			count++
+		}
+		f.Close()
+		if err != io.EOF {
+			return err
+		}
+
+		s.counts[id] = count
+	}
+
+	s.IDs = ids
+	s.fnames = fnames
+
+	return nil
+}
+
+// fileCursor reads the rows of a household from a table, at the duration of
+// the source slots.
+type fileCursor struct {
+	rc    io.ReadCloser
+	t     *table
+	left  int // Rows left to read, or -1 if we read until the end of the table
+	parse func(rec []string) (Row, error)
+	conv  converter
+}
+
+func (c *fileCursor) Next() (Row, error) {
+	if c.left == 0 {
+		return Row{}, io.EOF
+	}
+
+	rec, err := c.t.next()
+	if err != nil {
+		return Row{}, err
+	}
+	r, err := c.parse(rec)
+	if err != nil {
+		return Row{}, err
+	}
+
+	if c.left > 0 {
+		c.left--
+	}
+	return c.conv.scaled(r), nil
+}
+
+func (c *fileCursor) Close() error {
+	return c.rc.Close()
+}
+
+// Cursor returns a cursor over the rows of a household. Every cursor holds
+// an open file; close it when done.
+func (s *Stream) Cursor(id int) (Cursor, error) {
+	if _, ok := s.counts[id]; !ok {
+		return nil, fmt.Errorf("household %d is not in the trace", id)
+	}
+
+	var c Cursor
+	var err error
+	switch s.format {
+	case FormatLong:
+		c, err = s.longCursor(id)
+	case FormatWide:
+		c, err = s.wideCursor(id)
+	case FormatDir:
+		c, err = s.dirCursor(id)
+	default:
+		return s.mem.Cursor(id) // Already resampled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.conv.wrap(c), nil
+}
+
+func (s *Stream) longCursor(id int) (Cursor, error) {
+	sp := s.spans[id]
+
+	path := s.path
+	if s.spill != "" {
+		path = s.spill
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(sp.offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	t := newTable(s.path, f)
+	t.cols = s.cols
+	t.line = sp.line
+
+	return &fileCursor{
+		rc:   f,
+		t:    t,
+		left: sp.count,
+		parse: func(rec []string) (Row, error) {
+			_, r, err := t.longRecord(rec, id)
+			return r, err
+		},
+		conv: s.conv,
+	}, nil
+}
+
+// spillCursor reads the rows of a household from the spill of a wide-format
+// file, at the duration of the source slots.
+type spillCursor struct {
+	f      *os.File
+	column int // The position of the household in a row of the spill
+	width  int // The households in a row of the spill
+	row    int
+	count  int
+	buf    []byte
+	conv   converter
+}
+
+func (c *spillCursor) Next() (Row, error) {
+	if c.row == c.count {
+		return Row{}, io.EOF
+	}
+
+	if _, err := c.f.ReadAt(c.buf, int64(c.row*c.width+c.column)*rowSize); err != nil {
+		return Row{}, err
+	}
+	c.row++
+	return c.conv.scaled(getRow(c.buf)), nil
+}
+
+func (c *spillCursor) Close() error {
+	return c.f.Close()
+}
+
+func (s *Stream) wideCursor(id int) (Cursor, error) {
+	f, err := os.Open(s.spill)
+	if err != nil {
+		return nil, err
+	}
+
+	return &spillCursor{
+		f:      f,
+		column: s.column[id],
+		width:  len(s.column),
+		count:  s.counts[id],
+		buf:    make([]byte, rowSize),
+		conv:   s.conv,
+	}, nil
+}
+
+func (s *Stream) dirCursor(id int) (Cursor, error) {
+	rc, _, err := openFile(s.fnames[id])
+	if err != nil {
+		return nil, err
+	}
+
+	t := newTable(s.fnames[id], rc)
+	if err := t.longHeader(true); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return &fileCursor{
+		rc:   rc,
+		t:    t,
+		left: -1,
+		parse: func(rec []string) (Row, error) {
+			_, r, err := t.longRecord(rec, id)
+			return r, err
+		},
+		conv: s.conv,
+	}, nil
+}
@@ -1,24 +1,25 @@
 package trace
 
 import (
-	"bytes"
-	"encoding/csv"
 	"fmt"
-	"io/ioutil"
-	"strconv"
+	"io"
+	"math"
+	"os"
+	"time"
 )
 
-// Filename points to the input trace.
+// Filename points to the default input trace.
 const Filename = "04-final-trace-2013.csv"
 
-// Dimensions of the input trace.
+// Dimensions of the default input trace. Other traces may track a different
+// number of households, across a different number of slots.
 const (
 	IDCount  = 63
 	RowCount = 35036 // per ID
 	ColCount = 6
 )
 
-// Column headers of the input trace.
+// Column headers of the default input trace.
 const (
 	DataID = iota
 	Gen
@@ -28,7 +29,7 @@ const (
 	Hi
 )
 
-// IDs lists all the data IDs in the input trace.
+// IDs lists all the data IDs in the default input trace.
 var IDs = []int{
 	171, 1103, 1283, 1718, 1792, 370, 2072, 2233, 2337, 2470, 2755,
 	2818, 2925, 2945, 2980, 2986, 3224, 3456, 3527, 3544, 3635, 3719,
@@ -38,67 +39,220 @@ var IDs = []int{
 	7863, 7989, 8084, 8155, 8626, 8829, 9121, 9631,
 }
 
-// Load a CSV file into a map.
-func Load(fname string) (map[int][][]float64, error) {
-	b, err := ioutil.ReadFile(fname)
-	if err != nil {
-		return nil, err
-	}
+// Supported trace layouts; see the "Trace" section in the README for
+// the columns that each one expects.
+const (
+	FormatLong        = "long"        // One row per household and slot. This is the layout of the default trace.
+	FormatWide        = "wide"        // One row per slot, with one column per household and quantity.
+	FormatDir         = "dir"         // A directory with one long-format file per household.
+	FormatPecanStreet = "pecanstreet" // A Pecan Street Dataport export of 15-minute data.
+)
 
-	br := bytes.NewReader(b)
-	cr := csv.NewReader(br)
+// Supported units for the power columns.
+const (
+	UnitKW  = "kW"  // Average real power over the slot
+	UnitKWh = "kWh" // Energy over the slot
+)
 
-	// Skip the headers
-	if _, err := cr.Read(); err != nil {
-		return nil, err
+// NativeSlotDuration is the duration of the slots in the traces we read, unless
+// `Options.SourceSlot` says otherwise.
+const NativeSlotDuration = 15 * time.Minute
+
+// Supported ways to aggregate the prices of the source slots that make up a
+// longer slot when resampling.
+const (
+	PriceMean  = "mean"  // The mean of the prices (default)
+	PriceMin   = "min"   // The lowest price
+	PriceMax   = "max"   // The highest price
+	PriceFirst = "first" // The price of the first source slot
+)
+
+// DefaultDecimals is the number of decimal places that trace values are rounded to, unless
+// `Options.Decimals` says otherwise.
+const DefaultDecimals = 3
+
+// Row is the reading of a household for a slot. Power values are in kW,
+// averaged over the slot. Prices are in cents per kWh.
+type Row struct {
+	Gen  float64 // Generated by the household's solar panels
+	Grid float64 // Drawn from the grid, or sent back to it if negative
+	Use  float64 // The aggregate of Gen and Grid
+	Lo   float64 // What the grid pays for the energy households send back to it
+	Hi   float64 // What the grid charges for the energy it sells to households
+}
+
+// Trace is the typed representation of an input trace.
+type Trace struct {
+	IDs        []int // In order of first appearance, unless selected via `Options.IDs`
+	Households map[int][]Row
+}
+
+// Len returns the number of slots that all households in the trace cover.
+func (t *Trace) Len() int {
+	if len(t.IDs) == 0 {
+		return 0
+	}
+	resp := math.MaxInt32
+	for _, id := range t.IDs {
+		if l := len(t.Households[id]); l < resp {
+			resp = l
+		}
 	}
+	return resp
+}
 
-	recs, err := cr.ReadAll()
-	if err != nil {
-		return nil, err
+// Select returns a trace that only carries the given households, in the given order.
+func (t *Trace) Select(ids []int) (*Trace, error) {
+	resp := &Trace{
+		IDs:        make([]int, 0, len(ids)),
+		Households: make(map[int][]Row, len(ids)),
+	}
+	for _, id := range ids {
+		rows, ok := t.Households[id]
+		if !ok {
+			return nil, fmt.Errorf("household %d is not in the trace", id)
+		}
+		if _, ok := resp.Households[id]; ok {
+			return nil, fmt.Errorf("household %d is selected twice", id)
+		}
+		resp.IDs = append(resp.IDs, id)
+		resp.Households[id] = rows
 	}
+	return resp, nil
+}
+
+// Cursor yields the rows of a household, one slot at a time.
+type Cursor interface {
+	// Next returns the row for the next slot, or io.EOF if there are no more rows.
+	Next() (Row, error)
+	Close() error
+}
 
-	m := make(map[string][][]string)
+type sliceCursor struct {
+	rows []Row
+}
 
-	for i := 0; i < IDCount; i++ {
-		m[recs[i*RowCount][DataID]] = recs[i*RowCount : (i+1)*RowCount]
+func (c *sliceCursor) Next() (Row, error) {
+	if len(c.rows) == 0 {
+		return Row{}, io.EOF
 	}
+	r := c.rows[0]
+	c.rows = c.rows[1:]
+	return r, nil
+}
 
-	return Convert(m)
+func (c *sliceCursor) Close() error {
+	return nil
 }
 
-// Convert a map from one type to another.
-func Convert(m1 map[string][][]string) (map[int][][]float64, error) {
-	m2 := make(map[int][][]float64)
+// Cursor returns a cursor over the rows of a household.
+func (t *Trace) Cursor(id int) (Cursor, error) {
+	rows, ok := t.Households[id]
+	if !ok {
+		return nil, fmt.Errorf("household %d is not in the trace", id)
+	}
+	return &sliceCursor{rows: rows}, nil
+}
 
-	for k1, v1 := range m1 {
-		k2, err := strconv.Atoi(k1)
+// ReadRows reads up to n rows from a cursor.
+func ReadRows(c Cursor, n int) ([]Row, error) {
+	var resp []Row
+	for len(resp) < n {
+		r, err := c.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			return nil, err
 		}
+		resp = append(resp, r)
+	}
+	return resp, nil
+}
 
-		v2 := make([][]float64, len(v1))
-		for row := 0; row < len(v1); row++ {
-			auxFlt := make([]float64, ColCount-1)
-			auxStr := make([]string, ColCount-1)
-			v2[row] = make([]float64, ColCount-1) // drop the DataID column from v1
-
-			var err error
-			for col := 0; col < ColCount-1; col++ {
-				auxFlt[col], err = strconv.ParseFloat(v1[row][col+1], 64)
-				if err != nil {
-					return nil, err
-				}
-				auxStr[col] = fmt.Sprintf("%.3f", auxFlt[col])
-				v2[row][col], err = strconv.ParseFloat(auxStr[col], 64)
-				if err != nil {
-					return nil, err
-				}
-			}
-		}
+// Options control how a trace is loaded.
+type Options struct {
+	// One of the Format* constants. If empty, it is inferred from the path:
+	// directories are read as `FormatDir`, files as `FormatLong`.
+	Format string
+	// One of the Unit* constants. Defaults to `UnitKW`.
+	Unit string
+	// If set, only these households are loaded, in this order.
+	IDs []int
+	// If set, the number of decimal places that values are rounded to. A negative
+	// value disables rounding. Defaults to `DefaultDecimals`.
+	Decimals *int
+	// The duration of the slots in the trace. Defaults to `NativeSlotDuration`.
+	SourceSlot time.Duration
+	// The duration of the slots that the trace is resampled to; it should be a
+	// multiple or a divisor of SourceSlot. Defaults to SourceSlot, i.e. no resampling.
+	Slot time.Duration
+	// One of the Price* constants. Controls how prices are aggregated when the
+	// trace is resampled to longer slots. Defaults to `PriceMean`.
+	Prices string
+}
+
+// Load reads the trace at the given path, using the default options.
+func Load(path string) (*Trace, error) {
+	return LoadWith(path, Options{})
+}
+
+// format returns the format of the trace at the given path.
+func format(path string, opts Options) (string, error) {
+	if opts.Format != "" {
+		return opts.Format, nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if fi.IsDir() {
+		return FormatDir, nil
+	}
+	return FormatLong, nil
+}
+
+// LoadWith reads the trace at the given path into memory. Files may be gzip-compressed.
+func LoadWith(path string, opts Options) (*Trace, error) {
+	var err error
+	if opts.Format, err = format(path, opts); err != nil {
+		return nil, err
+	}
+
+	conv, err := newConverter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	b := newBuilder(conv)
+
+	switch opts.Format {
+	case FormatLong:
+		err = b.readFile(path, readLong)
+	case FormatWide:
+		err = b.readFile(path, readWide)
+	case FormatDir:
+		err = b.readDir(path)
+	case FormatPecanStreet:
+		err = b.readFile(path, readPecanStreet)
+	default:
+		return nil, fmt.Errorf("unknown trace format: %s", opts.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := b.trace()
+	if err != nil {
+		return nil, err
+	}
+	if len(t.IDs) == 0 {
+		return nil, fmt.Errorf("%s: no households in trace", path)
+	}
 
-		m2[k2] = v2
+	if opts.IDs != nil {
+		return t.Select(opts.IDs)
 	}
 
-	return m2, nil
+	return t, nil
 }
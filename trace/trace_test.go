@@ -2,11 +2,16 @@ package trace
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/csv"
 	"io"
 	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -79,24 +84,338 @@ func TestLoadTrace(t *testing.T) {
 	})
 }
 
-func TestConversion(t *testing.T) {
-	m1 := map[string][][]string{
-		"171": [][]string{
-			[]string{"171", "-0.005", "0.8782666666666666", "0.8782666666666666", "3.4", "10.84"},
-		},
-	}
+func write(t *testing.T, dir, name, content string) string {
+	fname := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(fname, []byte(content), 0644))
+	return fname
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("long", func(t *testing.T) {
+		fname := write(t, dir, "long.csv", "dataid,gen,grid,use,lo,hi\n"+
+			"171,-0.005,0.8782666666666666,0.8782666666666666,3.4,10.84\n"+
+			"1103,1.5,-1,0.5,3.4,10.84\n"+
+			"171,0,1,1,3.5,11.2\n")
+
+		tr, err := Load(fname)
+		require.NoError(t, err)
+		require.Equal(t, []int{171, 1103}, tr.IDs)
+		require.Equal(t, []Row{
+			{Gen: -0.005, Grid: 0.878, Use: 0.878, Lo: 3.4, Hi: 10.84},
+			{Gen: 0, Grid: 1, Use: 1, Lo: 3.5, Hi: 11.2},
+		}, tr.Households[171])
+		require.Len(t, tr.Households[1103], 1)
+		require.Equal(t, 1, tr.Len()) // Households may cover a different number of slots
+	})
+
+	t.Run("long without names", func(t *testing.T) {
+		fname := write(t, dir, "positional.csv", "a,b,c,d,e,f\n171,1,0,1,3.4,10.84\n")
+
+		tr, err := Load(fname)
+		require.NoError(t, err)
+		require.Equal(t, []Row{{Gen: 1, Grid: 0, Use: 1, Lo: 3.4, Hi: 10.84}}, tr.Households[171])
+	})
+
+	t.Run("long in kWh without grid column", func(t *testing.T) {
+		fname := write(t, dir, "kwh.csv", "id,use,gen,lo,hi\n171,0.5,0.25,3,10\n")
+
+		tr, err := LoadWith(fname, Options{Unit: UnitKWh})
+		require.NoError(t, err)
+		require.Equal(t, []Row{{Gen: 1, Grid: 1, Use: 2, Lo: 3, Hi: 10}}, tr.Households[171])
+	})
+
+	t.Run("wide", func(t *testing.T) {
+		fname := write(t, dir, "wide.csv", "timestamp,use_171,gen_171,use_545,gen_545,grid_545,lo,hi\n"+
+			"2013-01-01 00:00,1,0,2,1,1,3,10\n"+
+			"2013-01-01 00:15,1.5,0.5,2,0,2,3,12\n")
+
+		tr, err := LoadWith(fname, Options{Format: FormatWide})
+		require.NoError(t, err)
+		require.Equal(t, []int{171, 545}, tr.IDs)
+		require.Equal(t, 2, tr.Len())
+		require.Equal(t, Row{Gen: 0.5, Grid: 1, Use: 1.5, Lo: 3, Hi: 12}, tr.Households[171][1])
+		require.Equal(t, Row{Gen: 1, Grid: 1, Use: 2, Lo: 3, Hi: 10}, tr.Households[545][0])
+	})
+
+	t.Run("dir", func(t *testing.T) {
+		sub := filepath.Join(dir, "households")
+		require.NoError(t, os.Mkdir(sub, 0755))
+		write(t, sub, "545.csv", "gen,grid,use,lo,hi\n0,1,1,3,10\n0,2,2,3,10\n")
+		write(t, sub, "171.csv", "dataid,gen,grid,use,lo,hi\n171,1,0,1,3,10\n")
+		write(t, sub, "README.md", "ignored")
+
+		tr, err := Load(sub)
+		require.NoError(t, err)
+		require.Equal(t, []int{171, 545}, tr.IDs)
+		require.Len(t, tr.Households[545], 2)
+	})
+
+	t.Run("pecan street", func(t *testing.T) {
+		fname := write(t, dir, "dataport.csv", "dataid,local_15min,grid,solar,solar2\n"+
+			"171,2013-01-01 00:15:00-06,0.5,1,0.5\n"+
+			"171,2013-01-01 00:00:00-06,1,0,0\n")
+
+		tr, err := LoadWith(fname, Options{Format: FormatPecanStreet})
+		require.NoError(t, err)
+		require.Equal(t, []Row{
+			{Gen: 0, Grid: 1, Use: 1},
+			{Gen: 1.5, Grid: 0.5, Use: 2},
+		}, tr.Households[171])
+	})
+
+	t.Run("select", func(t *testing.T) {
+		fname := write(t, dir, "select.csv", "dataid,gen,grid,use,lo,hi\n171,0,1,1,3,10\n545,0,1,1,3,10\n890,0,1,1,3,10\n")
+
+		tr, err := LoadWith(fname, Options{IDs: []int{890, 171}})
+		require.NoError(t, err)
+		require.Equal(t, []int{890, 171}, tr.IDs)
+		require.Len(t, tr.Households, 2)
+
+		_, err = LoadWith(fname, Options{IDs: []int{1}})
+		require.Error(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"missing column": "dataid,gen,use,lo\n171,0,1,3\n",
+			"duplicate":      "dataid,gen,gen,use,lo,hi\n171,0,0,1,3,10\n",
+			"bad value":      "dataid,gen,grid,use,lo,hi\n171,foo,1,1,3,10\n",
+			"not finite":     "dataid,gen,grid,use,lo,hi\n171,NaN,1,1,3,10\n",
+			"bad id":         "dataid,gen,grid,use,lo,hi\nabc,0,1,1,3,10\n",
+			"no households":  "dataid,gen,grid,use,lo,hi\n",
+			"empty":          "",
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := Load(write(t, dir, "invalid.csv", content))
+				require.Error(t, err)
+			})
+		}
+
+		_, err := LoadWith(write(t, dir, "ok.csv", "dataid,gen,grid,use,lo,hi\n171,0,1,1,3,10\n"), Options{Unit: "MW"})
+		require.Error(t, err)
+		_, err = LoadWith(filepath.Join(dir, "ok.csv"), Options{Format: "foo"})
+		require.Error(t, err)
+	})
+}
+
+func gzipped(t *testing.T, content string) string {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.String()
+}
+
+func readAll(t *testing.T, s *Stream, id int) []Row {
+	c, err := s.Cursor(id)
+	require.NoError(t, err)
+	defer c.Close()
+	rows, err := ReadRows(c, math.MaxInt32)
+	require.NoError(t, err)
+	return rows
+}
+
+func TestStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	long := "dataid,gen,grid,use,lo,hi\n" +
+		"171,-0.005,0.8782666666666666,0.8782666666666666,3.4,10.84\n" +
+		"171,0,1,1,3.5,11.2\n" +
+		"\n" +
+		"1103,1.5,-1,0.5,3.4,10.84\n" +
+		"1103,1,0,1,3.4,10.84\n" +
+		"1103,0,2,2,3.4,10.84\n"
+
+	for name, fname := range map[string]string{
+		"long":    write(t, dir, "long.csv", long),
+		"long.gz": write(t, dir, "long.csv.gz", gzipped(t, long)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, err := Open(fname, Options{})
+			require.NoError(t, err)
+			require.Equal(t, []int{171, 1103}, s.IDs)
+			require.Equal(t, 2, s.Len())
+
+			// Cursors are independent of each other
+			c, err := s.Cursor(1103)
+			require.NoError(t, err)
+			defer c.Close()
+			r, err := c.Next()
+			require.NoError(t, err)
+			require.Equal(t, Row{Gen: 1.5, Grid: -1, Use: 0.5, Lo: 3.4, Hi: 10.84}, r)
 
-	m2exp := map[int][][]float64{
-		171: [][]float64{
-			[]float64{-0.005, 0.878, 0.878, 3.4, 10.84},
-		},
+			require.Equal(t, []Row{
+				{Gen: -0.005, Grid: 0.878, Use: 0.878, Lo: 3.4, Hi: 10.84},
+				{Gen: 0, Grid: 1, Use: 1, Lo: 3.5, Hi: 11.2},
+			}, readAll(t, s, 171))
+			require.Len(t, readAll(t, s, 1103), 3)
+
+			// The stream yields what the in-memory loader does
+			tr, err := Load(fname)
+			require.NoError(t, err)
+			for _, id := range tr.IDs {
+				require.Equal(t, tr.Households[id], readAll(t, s, id))
+			}
+
+			// A compressed file is read off an uncompressed spill
+			spill := s.spill
+			require.Equal(t, name == "long.gz", spill != "")
+			require.NoError(t, s.Close())
+			if spill != "" {
+				_, err := os.Stat(spill)
+				require.True(t, os.IsNotExist(err))
+			}
+		})
 	}
 
-	m2act, err := Convert(m1)
+	t.Run("not contiguous", func(t *testing.T) {
+		fname := write(t, dir, "mixed.csv", "dataid,gen,grid,use,lo,hi\n171,0,1,1,3,10\n545,0,1,1,3,10\n171,0,1,1,3,10\n")
+		_, err := Open(fname, Options{})
+		require.Error(t, err)
+
+		_, err = Load(fname) // The in-memory loader doesn't mind
+		require.NoError(t, err)
+	})
+
+	t.Run("wide", func(t *testing.T) {
+		wide := "use_171,gen_171,use_545,gen_545,lo,hi\n1,0,2,1,3,10\n1.5,0.5,2,0,3,12\n"
+		for _, fname := range []string{write(t, dir, "wide.csv", wide), write(t, dir, "wide.csv.gz", gzipped(t, wide))} {
+			s, err := Open(fname, Options{Format: FormatWide})
+			require.NoError(t, err)
+			require.Equal(t, 2, s.Len())
+			require.Equal(t, []Row{
+				{Gen: 1, Grid: 1, Use: 2, Lo: 3, Hi: 10},
+				{Gen: 0, Grid: 2, Use: 2, Lo: 3, Hi: 12},
+			}, readAll(t, s, 545))
+			require.Equal(t, []Row{
+				{Gen: 0, Grid: 1, Use: 1, Lo: 3, Hi: 10},
+				{Gen: 0.5, Grid: 1, Use: 1.5, Lo: 3, Hi: 12},
+			}, readAll(t, s, 171))
+			require.NoError(t, s.Close())
+		}
+
+		// The rows are parsed as the file is indexed
+		_, err := Open(write(t, dir, "bad.csv", "use_171,gen_171,lo,hi\n1,foo,3,10\n"), Options{Format: FormatWide})
+		require.Error(t, err)
+	})
+
+	t.Run("dir", func(t *testing.T) {
+		sub := filepath.Join(dir, "households")
+		require.NoError(t, os.Mkdir(sub, 0755))
+		write(t, sub, "545.csv.gz", gzipped(t, "gen,grid,use,lo,hi\n0,1,1,3,10\n0,2,2,3,10\n"))
+		write(t, sub, "171.csv", "gen,grid,use,lo,hi\n1,0,1,3,10\n")
+
+		s, err := Open(sub, Options{IDs: []int{545}})
+		require.NoError(t, err)
+		require.Equal(t, []int{545}, s.IDs)
+		require.Equal(t, 2, s.Len())
+		require.Len(t, readAll(t, s, 545), 2)
+	})
+
+	t.Run("pecan street", func(t *testing.T) {
+		fname := write(t, dir, "dataport.csv", "dataid,local_15min,grid,solar\n171,2013-01-01 00:15:00-06,0.5,1\n171,2013-01-01 00:00:00-06,1,0\n")
+		s, err := Open(fname, Options{Format: FormatPecanStreet})
+		require.NoError(t, err)
+		require.Equal(t, []Row{{Grid: 1, Use: 1}, {Gen: 1, Grid: 0.5, Use: 1.5}}, readAll(t, s, 171))
+	})
+
+	t.Run("rounding", func(t *testing.T) {
+		fname := filepath.Join(dir, "long.csv")
+
+		decimals := -1
+		s, err := Open(fname, Options{Decimals: &decimals})
+		require.NoError(t, err)
+		require.Equal(t, 0.8782666666666666, readAll(t, s, 171)[0].Use)
+
+		decimals = 1
+		tr, err := LoadWith(fname, Options{Decimals: &decimals})
+		require.NoError(t, err)
+		require.Equal(t, 0.9, tr.Households[171][0].Use)
+	})
+
+	t.Run("unknown household", func(t *testing.T) {
+		s, err := Open(filepath.Join(dir, "long.csv"), Options{})
+		require.NoError(t, err)
+		_, err = s.Cursor(1)
+		require.Error(t, err)
+
+		_, err = Open(filepath.Join(dir, "long.csv"), Options{IDs: []int{1}})
+		require.Error(t, err)
+	})
+}
+
+func TestResample(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
 	require.NoError(t, err)
-	require.Equal(t, 1, len(m2act[171]))
-	require.Equal(t, ColCount-1, len(m2act[171][0]))
-	require.InDeltaSlice(t, m2exp[171][0], m2act[171][0], 0.0001)
-	t.Log(m1["171"][0])
-	t.Log(m2act[171][0])
+	defer os.RemoveAll(dir)
+
+	fname := write(t, dir, "long.csv", "dataid,gen,grid,use,lo,hi\n"+
+		"171,0,1,1,3,10\n"+
+		"171,1,1,2,3,12\n"+
+		"171,2,1,3,4,14\n"+
+		"171,3,1,4,4,16\n"+
+		"171,4,1,5,5,18\n") // A trailing partial slot for 30- and 60-minute slots
+
+	load := func(t *testing.T, opts Options) []Row {
+		tr, err := LoadWith(fname, opts)
+		require.NoError(t, err)
+
+		s, err := Open(fname, opts)
+		require.NoError(t, err)
+		require.Equal(t, tr.Len(), s.Len())
+		require.Equal(t, tr.Households[171], readAll(t, s, 171))
+
+		return tr.Households[171]
+	}
+
+	t.Run("30 minutes", func(t *testing.T) {
+		require.Equal(t, []Row{
+			{Gen: 0.5, Grid: 1, Use: 1.5, Lo: 3, Hi: 11},
+			{Gen: 2.5, Grid: 1, Use: 3.5, Lo: 4, Hi: 15},
+		}, load(t, Options{Slot: 30 * time.Minute}))
+	})
+
+	t.Run("60 minutes", func(t *testing.T) {
+		require.Equal(t, []Row{{Gen: 1.5, Grid: 1, Use: 2.5, Lo: 3.5, Hi: 13}}, load(t, Options{Slot: time.Hour}))
+	})
+
+	t.Run("price aggregation", func(t *testing.T) {
+		for prices, exp := range map[string][2]float64{
+			PriceMin:   {3, 10},
+			PriceMax:   {4, 16},
+			PriceFirst: {3, 10},
+		} {
+			rows := load(t, Options{Slot: time.Hour, Prices: prices})
+			require.Equal(t, exp, [2]float64{rows[0].Lo, rows[0].Hi}, prices)
+		}
+	})
+
+	t.Run("5 minutes", func(t *testing.T) {
+		rows := load(t, Options{Slot: 5 * time.Minute})
+		require.Len(t, rows, 15)
+		for i := 0; i < 3; i++ {
+			require.Equal(t, Row{Gen: 1, Grid: 1, Use: 2, Lo: 3, Hi: 12}, rows[3+i])
+		}
+	})
+
+	t.Run("energy is summed", func(t *testing.T) {
+		kwh := write(t, dir, "kwh.csv", "dataid,gen,use,lo,hi\n171,0,0.25,3,10\n171,0,0.5,3,10\n")
+		tr, err := LoadWith(kwh, Options{Unit: UnitKWh, Slot: 30 * time.Minute})
+		require.NoError(t, err)
+		require.Equal(t, 1.5, tr.Households[171][0].Use) // 0.75 kWh over half an hour
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := LoadWith(fname, Options{Slot: 20 * time.Minute})
+		require.Error(t, err)
+		_, err = Open(fname, Options{Slot: time.Hour, Prices: "median"})
+		require.Error(t, err)
+	})
 }
@@ -0,0 +1,211 @@
+// Package tracegen generates synthetic traces of household load and PV
+// generation, in the long format that `trace.Load` reads.
+package tracegen
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/kchristidis/island/trace"
+)
+
+// SlotsPerDay is the number of trace slots in a day. Traces are generated at
+// `trace.NativeSlotDuration`; resample them when loading them, if needed.
+const SlotsPerDay = int(24 * time.Hour / trace.NativeSlotDuration)
+
+// Config describes the trace to generate.
+type Config struct {
+	Households int   // How many households to generate
+	Slots      int   // How many slots to generate per household
+	FirstID    int   // Households are numbered sequentially, starting from this ID
+	StartDay   int   // The day of the year (0-364) that the first slot falls on
+	Seed       int64 // The same seed generates the same trace
+
+	MeanLoadKW    float64 // The average household load, before seasonal adjustments
+	PVPenetration float64 // The fraction of households with solar panels, in [0, 1]
+	PVCapacityKW  float64 // The average nameplate capacity of a household's solar panels
+	CloudCover    float64 // The average cloud cover, in [0, 1]
+
+	FeedIn     float64 // The Lo price column, in cents per kWh
+	Retail     float64 // The Hi price column, in cents per kWh
+	PeakRetail float64 // The Hi price column between 16:00 and 20:00; if zero, Retail applies
+}
+
+// DefaultConfig returns a config that generates a year-long trace for 63
+// households, with roughly the shape and prices of the default trace.
+func DefaultConfig() Config {
+	return Config{
+		Households: trace.IDCount,
+		Slots:      365 * SlotsPerDay,
+		FirstID:    1,
+		Seed:       1,
+
+		MeanLoadKW:    1.2,
+		PVPenetration: 0.5,
+		PVCapacityKW:  5,
+		CloudCover:    0.3,
+
+		FeedIn: 3.4,
+		Retail: 10.84,
+	}
+}
+
+func (cfg Config) validate() error {
+	switch {
+	case cfg.Households < 1:
+		return errors.New("need at least one household")
+	case cfg.Slots < 1:
+		return errors.New("need at least one slot")
+	case cfg.StartDay < 0 || cfg.StartDay > 364:
+		return fmt.Errorf("invalid start day: %d", cfg.StartDay)
+	case cfg.MeanLoadKW < 0 || cfg.PVCapacityKW < 0:
+		return errors.New("load and capacity should not be negative")
+	case cfg.PVPenetration < 0 || cfg.PVPenetration > 1:
+		return fmt.Errorf("invalid PV penetration: %.3f", cfg.PVPenetration)
+	case cfg.CloudCover < 0 || cfg.CloudCover > 1:
+		return fmt.Errorf("invalid cloud cover: %.3f", cfg.CloudCover)
+	default:
+		return nil
+	}
+}
+
+// hourOf returns the hour of the day (in [0, 24)) at the middle of a slot.
+func hourOf(slot int) float64 {
+	return (float64(slot%SlotsPerDay) + 0.5) * trace.NativeSlotDuration.Hours()
+}
+
+// dayOf returns the day of the year that a slot falls on.
+func dayOf(cfg Config, slot int) int {
+	return (cfg.StartDay + slot/SlotsPerDay) % 365
+}
+
+// loadShape returns the load multiplier for a given hour of the day: low at
+// night, with a morning peak around 7:30 and a larger evening one around 19:00.
+func loadShape(hour float64) float64 {
+	bump := func(center, width float64) float64 {
+		d := hour - center
+		return math.Exp(-d * d / (2 * width * width))
+	}
+	return 0.55 + 0.45*bump(7.5, 1.5) + 0.9*bump(19, 2.5)
+}
+
+// loadSeason returns the load multiplier for a given day of the year. Load
+// peaks in the summer (cooling) and, to a lesser extent, in the winter (heating).
+func loadSeason(day int) float64 {
+	angle := 2 * math.Pi * float64(day) / 365
+	return 1 + 0.35*math.Max(0, -math.Cos(angle-0.1)) + 0.1*math.Max(0, math.Cos(angle))
+}
+
+// clearSky returns the output of a 1 kW solar panel under a clear sky, for a
+// given day of the year and hour of the day.
+func clearSky(day int, hour float64) float64 {
+	angle := 2 * math.Pi * float64(day-80) / 365 // Zero at the spring equinox
+	dayLength := 12 + 2.5*math.Sin(angle)        // In hours
+	sunrise := 12.5 - dayLength/2                // Solar noon at 12:30
+	if hour <= sunrise || hour >= sunrise+dayLength {
+		return 0
+	}
+	elevation := math.Sin(math.Pi * (hour - sunrise) / dayLength)
+	return (0.8 + 0.15*math.Sin(angle)) * math.Pow(elevation, 1.3)
+}
+
+// clouds returns the cloud cover for every slot. Weather is shared by all
+// households: every day has its own cloudiness, around which the cover
+// fluctuates from slot to slot.
+func clouds(cfg Config, r *rand.Rand) []float64 {
+	resp := make([]float64, cfg.Slots)
+
+	var daily, cover float64
+	for slot := range resp {
+		if slot%SlotsPerDay == 0 {
+			// A truncated normal draw around the configured mean
+			daily = math.Min(1, math.Max(0, cfg.CloudCover+0.3*r.NormFloat64()))
+		}
+		cover = 0.8*cover + 0.2*daily + 0.1*r.NormFloat64()
+		resp[slot] = math.Min(1, math.Max(0, cover))
+	}
+
+	return resp
+}
+
+// Generate writes a trace for the given config to w, in the long format, with
+// the rows of every household stored contiguously.
+func Generate(cfg Config, w io.Writer) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	r := rand.New(rand.NewSource(cfg.Seed))
+	cover := clouds(cfg, r)
+
+	// Pick exactly the configured share of households to carry solar panels
+	withPV := make(map[int]bool)
+	for _, i := range r.Perm(cfg.Households)[:int(math.Round(cfg.PVPenetration*float64(cfg.Households)))] {
+		withPV[i] = true
+	}
+
+	peak := cfg.PeakRetail
+	if peak == 0 {
+		peak = cfg.Retail
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"dataid", "gen", "grid", "use", "lo", "hi"}); err != nil {
+		return err
+	}
+
+	factor := math.Pow10(trace.DefaultDecimals)
+	round := func(v float64) float64 {
+		return math.Round(v*factor) / factor
+	}
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', trace.DefaultDecimals, 64)
+	}
+
+	for i := 0; i < cfg.Households; i++ {
+		id := strconv.Itoa(cfg.FirstID + i)
+
+		// Households differ in size and panel capacity
+		scale := math.Exp(0.35*r.NormFloat64() - 0.35*0.35/2) // Log-normal with a mean of 1
+		var capacity float64
+		if withPV[i] {
+			capacity = cfg.PVCapacityKW * (0.6 + 0.8*r.Float64())
+		}
+
+		var noise float64
+		for slot := 0; slot < cfg.Slots; slot++ {
+			day, hour := dayOf(cfg, slot), hourOf(slot)
+
+			noise = 0.7*noise + 0.3*r.NormFloat64()
+			use := cfg.MeanLoadKW * scale * loadShape(hour) * loadSeason(day) * math.Exp(0.25*noise)
+
+			var gen float64
+			if capacity > 0 {
+				gen = capacity * clearSky(day, hour) * (1 - 0.75*cover[slot])
+				if gen == 0 {
+					gen = -0.005 // Inverters draw a little power at night
+				}
+			}
+
+			hi := cfg.Retail
+			if hour >= 16 && hour < 20 {
+				hi = peak
+			}
+
+			// Round before deriving grid, so that use = gen + grid holds in the output
+			use, gen = round(use), round(gen)
+			if err := cw.Write([]string{id, format(gen), format(use - gen), format(use), format(cfg.FeedIn), format(hi)}); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,127 @@
+package tracegen_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kchristidis/island/trace"
+	"github.com/kchristidis/island/tracegen"
+	"github.com/stretchr/testify/require"
+)
+
+func generate(t *testing.T, cfg tracegen.Config) *trace.Trace {
+	dir, err := ioutil.TempDir("", "tracegen")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	require.NoError(t, tracegen.Generate(cfg, &buf))
+
+	fname := filepath.Join(dir, "trace.csv")
+	require.NoError(t, ioutil.WriteFile(fname, buf.Bytes(), 0644))
+
+	// The trace should be streamable as well
+	_, err = trace.Open(fname, trace.Options{})
+	require.NoError(t, err)
+
+	tr, err := trace.Load(fname)
+	require.NoError(t, err)
+	return tr
+}
+
+func TestGenerate(t *testing.T) {
+	cfg := tracegen.DefaultConfig()
+	cfg.Households = 10
+	cfg.Slots = 7 * tracegen.SlotsPerDay
+	cfg.FirstID = 100
+	cfg.StartDay = 172 // Summer
+	cfg.PVPenetration = 0.8
+	cfg.PeakRetail = 20
+
+	tr := generate(t, cfg)
+
+	t.Run("dimensions", func(t *testing.T) {
+		require.Len(t, tr.IDs, 10)
+		require.Equal(t, 100, tr.IDs[0])
+		require.Equal(t, 109, tr.IDs[9])
+		require.Equal(t, cfg.Slots, tr.Len())
+	})
+
+	t.Run("pv penetration", func(t *testing.T) {
+		var withPV int
+		for _, id := range tr.IDs {
+			for _, r := range tr.Households[id] {
+				if r.Gen > 0 {
+					withPV++
+					break
+				}
+			}
+		}
+		require.Equal(t, 8, withPV)
+	})
+
+	t.Run("shapes", func(t *testing.T) {
+		var nightGen, noonGen, nightUse, eveningUse float64
+		for _, id := range tr.IDs {
+			for day := 0; day < 7; day++ {
+				base := day * tracegen.SlotsPerDay
+				nightGen += tr.Households[id][base+2*4].Gen // 02:00
+				noonGen += tr.Households[id][base+12*4].Gen // 12:00
+				nightUse += tr.Households[id][base+3*4].Use
+				eveningUse += tr.Households[id][base+19*4].Use
+			}
+		}
+		require.True(t, nightGen <= 0, "no generation at night")
+		require.True(t, noonGen > 0, "generation at noon")
+		require.True(t, eveningUse > nightUse, "evening peak")
+	})
+
+	t.Run("rows", func(t *testing.T) {
+		for _, id := range tr.IDs {
+			for slot, r := range tr.Households[id] {
+				require.InDelta(t, r.Use, r.Gen+r.Grid, 1e-9)
+				require.True(t, r.Use > 0)
+				require.Equal(t, cfg.FeedIn, r.Lo)
+				if slot%tracegen.SlotsPerDay == 17*4 { // 17:00
+					require.Equal(t, 20.0, r.Hi)
+				} else if slot%tracegen.SlotsPerDay == 12*4 {
+					require.Equal(t, cfg.Retail, r.Hi)
+				}
+			}
+		}
+	})
+}
+
+func TestSeed(t *testing.T) {
+	cfg := tracegen.DefaultConfig()
+	cfg.Households = 3
+	cfg.Slots = tracegen.SlotsPerDay
+
+	var a, b, c bytes.Buffer
+	require.NoError(t, tracegen.Generate(cfg, &a))
+	require.NoError(t, tracegen.Generate(cfg, &b))
+	cfg.Seed++
+	require.NoError(t, tracegen.Generate(cfg, &c))
+
+	require.Equal(t, a.String(), b.String())
+	require.NotEqual(t, a.String(), c.String())
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for name, mutate := range map[string]func(*tracegen.Config){
+		"no households": func(cfg *tracegen.Config) { cfg.Households = 0 },
+		"no slots":      func(cfg *tracegen.Config) { cfg.Slots = 0 },
+		"start day":     func(cfg *tracegen.Config) { cfg.StartDay = 365 },
+		"penetration":   func(cfg *tracegen.Config) { cfg.PVPenetration = 1.5 },
+		"clouds":        func(cfg *tracegen.Config) { cfg.CloudCover = -1 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := tracegen.DefaultConfig()
+			mutate(&cfg)
+			require.Error(t, tracegen.Generate(cfg, ioutil.Discard))
+		})
+	}
+}
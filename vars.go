@@ -1,18 +1,23 @@
 package main
 
 import (
+	"context"
 	"crypto/rsa"
-	"io"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/kchristidis/island/bidder"
-	"github.com/kchristidis/island/blockchain"
-	"github.com/kchristidis/island/blocknotifier"
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/kchristidis/island/faults"
 	"github.com/kchristidis/island/regulator"
 	"github.com/kchristidis/island/slotnotifier"
 	"github.com/kchristidis/island/stats"
+	"github.com/kchristidis/island/tariff"
 	"github.com/kchristidis/island/trace"
+	"github.com/kchristidis/island/vclock"
 )
 
 // The files that this simulation will write to for metrics/plotting.
@@ -21,16 +26,65 @@ const (
 	OutputTran  = "tran.csv"
 	OutputSlot  = "slot.csv"
 	OutputBlock = "block.csv"
+	// The results, when the `jsonl` and `sqlite` sinks are used
+	OutputJSONL  = "results.jsonl"
+	OutputSQLite = "results.sqlite"
+	// The summary of the results, when the `summary` sink is used
+	OutputSummaryJSON     = "summary.json"
+	OutputSummaryMarkdown = "summary.md"
+	// The stats collector's journal, which a resumed run picks up from
+	OutputJournal = "journal.jsonl"
+	// The faults injected into the agents' calls, when the `-faults` flag is set
+	OutputFaults = "faults.jsonl"
+	// The balances of the accounts on the ledger at the end of the run, when bids
+	// lock deposits or the market settles payments
+	OutputBalances = "balances.json"
 )
 
-// BidderCount counts the number of bidders we have in the system.
-const BidderCount = trace.IDCount
-
 // StatChannelBuffer sets the buffer of the channels we use to pipe metrics into the
 // stats collector from the agents. The larger the buffer of those channels, the less
 // chances the stats collector will block when aggregating stats.
 const StatChannelBuffer = 100
 
+// ShutdownTimeout is how long the agents get to see their in-flight calls through
+// once the run is stopped. The agents that are still running after that are given
+// up on, and the results are written out without them.
+const ShutdownTimeout = 30 * time.Second
+
+// The trace that drives the simulation, and the options it is loaded with; see the
+// `trace` package for the supported formats. Set `traceOptions.IDs` to simulate a
+// subset of the households in the trace. The trace is resampled to `schema.SlotDuration`.
+var (
+	tracePath    = filepath.Join("trace", trace.Filename)
+	traceOptions = trace.Options{
+		Slot: schema.SlotDuration,
+	}
+)
+
+// The tariff that sets the grid prices for a run; see the `tariff` package for the
+// supported kinds. The default uses the prices in the trace.
+var tariffConfig = tariff.Config{
+	Kind: tariff.KindTrace,
+}
+
+// The distribution network topology the market clears over, in the JSON encoding of
+// `schema.Topology`; households are referred to by their trace IDs. If empty, the
+// network is treated as a copper plate, i.e. with no line constraints or losses.
+var topologyFile = ""
+
+// The seed that all the randomness in a run derives from; every agent draws from a
+// stream of its own, see `agentRand`. Runs with the same seed, configuration, and
+// ledger backend place identical bids.
+var runSeed int64 = 1
+
+// ledgerClient is what the simulation needs from a ledger: the SDK context when
+// running against a Fabric network, or the in-process ledger when running in virtual
+// time; see fabric.go and virtual.go respectively.
+type ledgerClient interface {
+	Invoke(args schema.OpContextInput) ([]byte, schema.TxInfo, error)
+	Query(args schema.OpContextInput) ([]byte, error)
+}
+
 var (
 	err error
 
@@ -42,12 +96,44 @@ var (
 	// Track the duration of a simulation
 	timeStart time.Time
 
-	bidders    [BidderCount]*bidder.Bidder
+	// Set by the `-resume` flag: pick up the last run on the same channel, from the
+	// first slot that the market has not cleared. See `resumeSlot`.
+	resume bool
+	// The first slot of the run; non-zero only when resuming a run
+	firstSlot int
+
+	// Set by the `-sinks` flag: the comma-separated sinks that the results are
+	// written to; see `newSinks`.
+	outputSinks string
+	// Set by the `-metrics-addr` flag: where the live metrics are served; see
+	// `serveMetrics`.
+	metricsAddr string
+	// Set by the `-faults` flag: the file that scripts the faults injected into the
+	// agents' calls; see the `faults` package.
+	faultsFile string
+	faultRules []faults.Rule
+	// Set by the `-deposit` and `-opening-balance` flags: the deposit that every bid
+	// locks in experiments 1 and 3; see `schema.DepositRules`. Off if the amount is 0.
+	depositRules schema.DepositRules
+	// Set by the `-payments` flag: whether the market settles every slot in tokens;
+	// see `schema.PaymentRules`. Set by the `-mint` flag: what the utility mints to
+	// every bidder before the run, as accounts open empty then.
+	payments    bool
+	mintInCents float64
+	// Set by the `-adversaries` flag: how many bidders act on each adversarial
+	// behavior. See `assignBehaviors` for the bidders that do.
+	adversaries string
+	behaviors   map[int]bidder.Behavior
+
+	bidders    []*bidder.Bidder
 	regtor     *regulator.Regulator
-	bNotifiers []*blocknotifier.Notifier
 	sNotifiers []*slotnotifier.Notifier
 
-	sdkctx *blockchain.SDKContext
+	ledger ledgerClient
+	// Decorates what the agents call the ledger through, if set; see `agentClient`
+	injector *faults.Injector
+	// Keeps the virtual clock in step with the agents; nil unless running in virtual time
+	tracker *vclock.Tracker
 
 	// How many blocks constitute a slot? A sensitivity analysis parameter.
 	blocksPerSlot int
@@ -68,8 +154,10 @@ var (
 	// our time-based measurements. In an actual deployment, each agent would use their own keys.
 	privKey *rsa.PrivateKey
 
-	// The original trace is converted into this typed structure for easier processing
-	traceMap map[int][][]float64
+	// The trace is read off disk as the bidders consume it
+	traceStream *trace.Stream
+	// The grid prices that the bidders and the stats collector use
+	gridTariff tariff.Tariff
 
 	// The channel(s) on which notifications are received from the block notifier(s)
 	slotCs []chan int
@@ -79,16 +167,24 @@ var (
 	statsSlotC     chan stats.Slot
 	statsTranC     chan stats.Transaction
 	statsCollector *stats.Collector
+	// The collector appends every stat to it as it comes in
+	journalFile *os.File
 
-	doneC      chan struct{} // Acts as a coordination signal for goroutines
-	once       sync.Once     // Ensures that donec is only closed once.
-	doneStatsC chan struct{} // We want to terminate the stats collector *after* all the stat-submitting goroutines have returned.
+	// Canceling the run's context signals to all agents that they should return.
+	// Use `stop` to do so.
+	runCtx    context.Context
+	cancelRun context.CancelFunc
+	stopOnce  sync.Once // Ensures that only the first `stop` call is recorded
+	stoppedBy string    // Who stopped the run? "main" in the green path
 
 	// Waitgroups for goroutine coordination
-	// - wg1: bidders
-	// - wg2: regulators, block/slot notifiers
-	// - wg3: stats collector
-	wg1, wg2, wg3 sync.WaitGroup
+	// - traceWG: the agents that exit once done with the trace, i.e. bidders and the regulator
+	// - agentsWG: all agents, and whatever drives the slot notifiers
+	traceWG, agentsWG sync.WaitGroup
+
+	// Set by the `-log-level` and `-log-format` flags; see `newLogger`.
+	logLevel, logFormat string
 
-	writer io.Writer // For logging
+	logger  *logging.Logger // The agents derive their loggers from this one
+	mainLog *logging.Logger // For the main thread
 )
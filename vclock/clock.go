@@ -0,0 +1,70 @@
+// Package vclock drives the slot notifiers from a virtual clock, in place of
+// the block notifiers. A slot lasts as long as it takes the agents to act on
+// it: the clock moves on as soon as all the work that its notifications gave
+// rise to has been reported done to its tracker.
+package vclock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kchristidis/island/chaincode/logging"
+)
+
+// Clock posts slot notifications in virtual time.
+type Clock struct {
+	Slots   int      // How many slots to go through, starting from slot 0
+	Tracker *Tracker // Shared with the slot notifiers and the agents
+
+	// Post slot notifications here. The 1st channel feeds the bid/markEnd
+	// slot notifier, the 2nd one (optional) feeds the postKey one.
+	SlotChans []chan int
+
+	Logger *logging.Logger
+}
+
+// New returns a new clock.
+func New(slots int, tracker *Tracker, slotcs []chan int, logger *logging.Logger) *Clock {
+	return &Clock{
+		Slots:   slots,
+		Tracker: tracker,
+
+		SlotChans: slotcs,
+
+		Logger: logger.With(logging.Component("virtual-clock")),
+	}
+}
+
+// ErrStopped is returned when the clock's context is canceled before it has
+// gone through all of its slots.
+var ErrStopped = errors.New("virtual clock stopped")
+
+// Run executes the clock logic. Within every slot, the clock first posts to the
+// bid/markEnd notifier, waits for the agents to finish, and then does the same
+// for the postKey notifier.
+func (c *Clock) Run(ctx context.Context) error {
+	defer c.Logger.Info("exited")
+
+	c.Logger.Info(fmt.Sprintf("running for %d slots", c.Slots))
+
+	for slot := 0; slot < c.Slots; slot++ {
+		for i, slotc := range c.SlotChans {
+			c.Tracker.Add(1) // Marked done by the slot notifier
+			select {
+			case slotc <- slot:
+				c.Logger.Debug(fmt.Sprintf("posted to slot notifier %d", i), logging.Slot(slot))
+			case <-ctx.Done():
+				return ErrStopped
+			}
+
+			select {
+			case <-c.Tracker.Idle():
+			case <-ctx.Done():
+				return ErrStopped
+			}
+		}
+	}
+
+	return nil
+}
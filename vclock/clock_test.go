@@ -0,0 +1,107 @@
+package vclock_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kchristidis/island/chaincode/logging"
+	"github.com/kchristidis/island/vclock"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestTracker(t *testing.T) {
+	tr := vclock.NewTracker()
+
+	idle := func() bool {
+		select {
+		case <-tr.Idle():
+			return true
+		default:
+			return false
+		}
+	}
+
+	require.True(t, idle())
+	tr.Add(2)
+	require.False(t, idle())
+	tr.Done()
+	require.False(t, idle())
+	tr.Done()
+	require.True(t, idle())
+	tr.Add(1)
+	require.False(t, idle())
+	tr.Done()
+	require.True(t, idle())
+
+	require.Panics(t, tr.Done)
+}
+
+func TestClock(t *testing.T) {
+	t.Run("green path", func(t *testing.T) {
+		tr := vclock.NewTracker()
+		slotcs := []chan int{make(chan int), make(chan int)}
+		bfr := gbytes.NewBuffer()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		c := vclock.New(3, tr, slotcs, logging.New(bfr, logging.DebugLevel, logging.Text))
+
+		// Every notification spawns a task that takes a while; the clock should
+		// wait for it before posting the next notification.
+		var mu sync.Mutex
+		var got []string
+		var tasks int
+		for i, name := range []string{"bid", "postKey"} {
+			go func(slotc chan int, name string) {
+				for {
+					select {
+					case slot := <-slotc:
+						mu.Lock()
+						got = append(got, fmt.Sprintf("%s:%d:%d", name, slot, tasks))
+						mu.Unlock()
+
+						tr.Add(1)
+						go func() {
+							time.Sleep(5 * time.Millisecond)
+							mu.Lock()
+							tasks++
+							mu.Unlock()
+							tr.Done()
+						}()
+						tr.Done() // For the notification
+					case <-ctx.Done():
+						return
+					}
+				}
+			}(slotcs[i], name)
+		}
+
+		require.NoError(t, c.Run(ctx))
+		mu.Lock()
+		defer mu.Unlock()
+		require.Equal(t, []string{"bid:0:0", "postKey:0:1", "bid:1:2", "postKey:1:3", "bid:2:4", "postKey:2:5"}, got)
+		require.Contains(t, string(bfr.Contents()), "exited")
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		slotcs := []chan int{make(chan int)} // Nobody is listening
+		bfr := gbytes.NewBuffer()
+		ctx, cancel := context.WithCancel(context.Background())
+
+		c := vclock.New(3, vclock.NewTracker(), slotcs, logging.New(bfr, logging.DebugLevel, logging.Text))
+
+		errc := make(chan error)
+		go func() { errc <- c.Run(ctx) }()
+
+		cancel()
+		g.Eventually(errc, "1s", "50ms").Should(Receive(Equal(vclock.ErrStopped)))
+	})
+}
@@ -0,0 +1,50 @@
+package vclock
+
+import "sync"
+
+// Tracker counts the work that is pending in response to slot notifications.
+// The clock adds to it before it posts a notification, the slot notifiers add
+// to it for every notification they hand out, and the agents add to it for
+// every task they spawn; every one of these is marked done when completed.
+// Unlike a sync.WaitGroup, a tracker can be waited on with a kill switch.
+type Tracker struct {
+	mu      sync.Mutex
+	pending int
+	idle    chan struct{} // Closed when pending drops to zero
+}
+
+// NewTracker returns a new tracker with no pending work.
+func NewTracker() *Tracker {
+	idle := make(chan struct{})
+	close(idle)
+	return &Tracker{idle: idle}
+}
+
+// Add adds delta, which may be negative, to the pending work.
+func (t *Tracker) Add(delta int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	wasIdle := t.pending == 0
+	t.pending += delta
+	switch {
+	case t.pending < 0:
+		panic("vclock: negative tracker count")
+	case t.pending == 0:
+		close(t.idle)
+	case wasIdle:
+		t.idle = make(chan struct{})
+	}
+}
+
+// Done marks a unit of work as completed.
+func (t *Tracker) Done() {
+	t.Add(-1)
+}
+
+// Idle returns a channel that is closed once there is no pending work.
+func (t *Tracker) Idle() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.idle
+}
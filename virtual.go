@@ -0,0 +1,68 @@
+// +build virtual
+
+package main
+
+import (
+	"errors"
+
+	"github.com/kchristidis/island/chaincode/schema"
+	"github.com/kchristidis/island/memledger"
+	"github.com/kchristidis/island/vclock"
+)
+
+// This file wires the simulation to an in-process ledger, driven by a virtual clock;
+// see the `memledger` and `vclock` packages. A slot then lasts as long as it takes the
+// agents to act on it, which makes for fast market-level runs, but the block and latency
+// stats are meaningless. No Fabric network is needed.
+//
+// The in-process ledger links the chaincode's Fabric dependencies, which clash with
+// those of the Fabric SDK, so this mode is selected at build time with `-tags virtual`.
+
+// Whether we are running in virtual time.
+const virtualTime = true
+
+var (
+	memLedger *memledger.Ledger
+	vClock    *vclock.Clock
+)
+
+// setupLedger instantiates the contract in-process with the given init arguments, and
+// sets up the tracker that the agents report to.
+func setupLedger(initArgs [][]byte) (func(), error) {
+	if resume {
+		return nil, errors.New("cannot resume a run in virtual time, as the in-process ledger does not outlive it")
+	}
+
+	var err error
+	if memLedger, err = memledger.New(initArgs, logger); err != nil {
+		return nil, err
+	}
+
+	ledger = memLedger
+	tracker = vclock.NewTracker()
+	return func() {}, nil
+}
+
+// signAs returns a client of the ledger whose calls are signed by the identity
+// of the given agent; see `memledger.Ledger.As`.
+func signAs(agent string) (ledgerClient, error) {
+	client, err := memLedger.As(agent)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// startClock starts the virtual clock that feeds the slot notifiers.
+func startClock() error {
+	// One slot past the trace, so that the regulator clears the last slot
+	vClock = vclock.New(schema.TraceLength+1, tracker, slotCs, logger)
+	agentsWG.Add(1)
+	go func() {
+		if err := vClock.Run(runCtx); err != nil && err != vclock.ErrStopped {
+			stop("virtual-clock")
+		}
+		agentsWG.Done()
+	}()
+	return nil
+}